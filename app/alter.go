@@ -0,0 +1,569 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// executeAlterTable runs an ALTER TABLE. The renames edit the identifiers in the stored SQL
+// text of the table, of its indexes and of the tables whose foreign keys refer to it. ADD
+// COLUMN only edits the text of the table, as records that end before the new column read as
+// its default; DROP COLUMN also rewrites every row without the column.
+func executeAlterTable(pager *Pager, schema *Schema, stmt *AlterTableStmt) error {
+	table := schema.table(stmt.Table)
+	if table == nil && !isSchemaTable(stmt.Table) {
+		return &ErrNoSuchTable{Name: stmt.Table}
+	}
+	if err := checkAlter(schema, table, stmt); err != nil {
+		return err
+	}
+
+	if err := pager.beginWrite(); err != nil {
+		return err
+	}
+	var err error
+	switch stmt.Action {
+	case "RENAME":
+		err = renameTable(pager, schema, table, stmt.NewName)
+	case "RENAME COLUMN":
+		err = renameColumn(pager, table, stmt)
+	case "ADD COLUMN":
+		err = addColumn(pager, table, stmt)
+	case "DROP COLUMN":
+		err = dropColumn(pager, schema, table, stmt)
+	}
+	if err == nil {
+		err = changeSchema(pager)
+	}
+	if err != nil {
+		pager.rollback()
+		return err
+	}
+	return pager.commit()
+}
+
+// checkAlter makes the checks SQLite makes on an ALTER TABLE before changing anything. table
+// is nil for sqlite_schema.
+func checkAlter(schema *Schema, table *Table, stmt *AlterTableStmt) error {
+	if stmt.Action == "RENAME" && (schema.table(stmt.NewName) != nil || schema.index(stmt.NewName) != nil || isSchemaTable(stmt.NewName)) {
+		return &ErrPrepare{Msg: "there is already another table or index with this name: " + stmt.NewName}
+	}
+	if table == nil {
+		return &ErrPrepare{Msg: "table sqlite_master may not be altered"}
+	}
+	if strings.HasPrefix(strings.ToLower(table.Name), "sqlite_") {
+		return &ErrPrepare{Msg: fmt.Sprintf("table %s may not be altered", table.Name)}
+	}
+	def := table.Def
+	if def == nil {
+		return fmt.Errorf("altering table %s is not supported: its definition could not be parsed", table.Name)
+	}
+	switch stmt.Action {
+	case "RENAME":
+		if strings.HasPrefix(strings.ToLower(stmt.NewName), "sqlite_") {
+			return &ErrPrepare{Msg: "object name reserved for internal use: " + stmt.NewName}
+		}
+	case "RENAME COLUMN", "DROP COLUMN":
+		i := table.columnIndex(stmt.Column)
+		if i < 0 {
+			return &ErrPrepare{Msg: fmt.Sprintf("no such column: \"%s\"", stmt.Column), Pos: stmt.ColumnPos}
+		}
+		if stmt.Action == "RENAME COLUMN" {
+			break
+		}
+		column := def.Columns[i]
+		switch {
+		case column.PrimaryKey || inPrimaryKey(def, column.Name):
+			return &ErrPrepare{Msg: fmt.Sprintf("cannot drop PRIMARY KEY column: \"%s\"", column.Name)}
+		case column.Unique:
+			return &ErrPrepare{Msg: fmt.Sprintf("cannot drop UNIQUE column: \"%s\"", column.Name)}
+		case len(def.Columns) == 1:
+			return &ErrPrepare{Msg: fmt.Sprintf("cannot drop column \"%s\": no other columns exist", column.Name)}
+		}
+		if def.WithoutRowid {
+			return fmt.Errorf("dropping a column of a WITHOUT ROWID table is not supported")
+		}
+	case "ADD COLUMN":
+		switch column := stmt.Def; {
+		case table.columnIndex(column.Name) >= 0:
+			return &ErrPrepare{Msg: "duplicate column name: " + column.Name}
+		case column.PrimaryKey:
+			return &ErrPrepare{Msg: "Cannot add a PRIMARY KEY column"}
+		case column.Unique:
+			return &ErrPrepare{Msg: "Cannot add a UNIQUE column"}
+		}
+	}
+	return nil
+}
+
+// inPrimaryKey reports whether the named column is part of the table's PRIMARY KEY constraint.
+func inPrimaryKey(def *CreateTableStmt, name string) bool {
+	for _, constraint := range def.Constraints {
+		if constraint.Kind != "PRIMARY KEY" {
+			continue
+		}
+		for _, key := range constraint.Columns {
+			if strings.EqualFold(key.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameTable renames a table, its automatic indexes and its sqlite_sequence row, and edits
+// every reference to it in the schema. Like SQLite, the new name is always quoted in the SQL.
+func renameTable(pager *Pager, schema *Schema, table *Table, name string) error {
+	old := table.Name
+	err := editSchema(pager, func(object *schemaObject) (bool, error) {
+		changed := false
+		switch {
+		case object.Type == "table" && strings.EqualFold(object.Name, old):
+			object.Name, object.TblName, changed = name, name, true
+		case object.Type == "index" && strings.EqualFold(object.TblName, old):
+			object.TblName, changed = name, true
+			prefix := "sqlite_autoindex_" + old + "_"
+			if len(object.Name) > len(prefix) && strings.EqualFold(object.Name[:len(prefix)], prefix) {
+				object.Name = "sqlite_autoindex_" + name + "_" + object.Name[len(prefix):]
+			}
+		}
+		edit := tableReferences(object.SQL, old)
+		if edit == nil || len(edit.targets) == 0 {
+			return changed, nil
+		}
+		object.SQL = edit.apply(name, true)
+		return true, nil
+	})
+	if err != nil || schema.table("sqlite_sequence") == nil {
+		return err
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: table}
+	rowid, seq, err := writer.sequenceRow()
+	if err != nil || rowid == 0 {
+		return err
+	}
+	record := EncodeRecord([]Value{{Type: ValueText, Text: name}, {Type: ValueInteger, Int: seq}}, pager.encoding, pager.header.SchemaFormat)
+	cell, err := tableLeafCell(pager, rowid, record)
+	if err != nil {
+		return err
+	}
+	return btreeInsert(pager, schema.table("sqlite_sequence").RootPage, cell, rowidComparer(rowid))
+}
+
+// renameColumn renames a column in the definition of its table, in the indexes of the table
+// and in the foreign keys that refer to it.
+func renameColumn(pager *Pager, table *Table, stmt *AlterTableStmt) error {
+	column := table.Columns[table.columnIndex(stmt.Column)].Name
+	return editSchema(pager, func(object *schemaObject) (bool, error) {
+		edit := columnReferences(object.SQL, table.Name, column)
+		if edit == nil || len(edit.targets) == 0 {
+			return false, nil
+		}
+		object.SQL = edit.apply(stmt.NewName, stmt.NewQuoted)
+		if object.Type == "table" && strings.EqualFold(object.Name, table.Name) {
+			if _, err := checkAlteredTable(object.SQL, "rename"); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// addColumn adds a column definition to the SQL text of a table, after the last column. Rows
+// that already exist must be able to take the default of the new column.
+func addColumn(pager *Pager, table *Table, stmt *AlterTableStmt) error {
+	column := stmt.Def
+	empty, err := btreeEmpty(pager, table.RootPage)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		dflt := column.Default
+		if literal, ok := dflt.(*LiteralExpr); ok && literal.Value == nil {
+			dflt = nil
+		}
+		switch {
+		case column.Generated != nil:
+			if column.Stored {
+				return fmt.Errorf("cannot add a STORED column")
+			}
+		case column.NotNull && dflt == nil:
+			return fmt.Errorf("Cannot add a NOT NULL column with default value NULL")
+		case dflt != nil && !isConstantDefault(dflt):
+			return fmt.Errorf("Cannot add a column with non-constant default")
+		}
+	}
+	end := table.Def.ColumnsEnd
+	sql := table.SQL[:end] + ", " + stmt.DefSQL + table.SQL[end:]
+	def, err := checkAlteredTable(sql, "add column")
+	if err != nil {
+		return err
+	}
+	err = editSchema(pager, func(object *schemaObject) (bool, error) {
+		if object.Type != "table" || !strings.EqualFold(object.Name, table.Name) {
+			return false, nil
+		}
+		object.SQL = sql
+		return true, nil
+	})
+	if err != nil || empty {
+		return err
+	}
+
+	//!Like SQLite, the existing rows are checked against every CHECK constraint of the table.
+	altered := tableFromDef(schemaObject{Name: table.Name, RootPage: table.RootPage, SQL: sql}, def)
+	if len(altered.Checks) == 0 {
+		return nil
+	}
+	rowids, records, err := readTable(pager, table.RootPage, map[int64]int64{})
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		ctx := &evalContext{table: altered, values: tableRow(altered, rowids[i], record), rowid: rowids[i], encoding: pager.encoding}
+		for _, check := range altered.Checks {
+			value, err := evalExpr(check.Expr, ctx)
+			if err != nil {
+				return err
+			}
+			if ok, notNull := truth(value); notNull && !ok {
+				return fmt.Errorf("CHECK constraint failed")
+			}
+		}
+	}
+	return nil
+}
+
+// isConstantDefault reports whether the default of an added column is a value SQLite can give
+// the existing rows: a literal, possibly signed, or a bare word taken as a string.
+func isConstantDefault(expr Expr) bool {
+	switch e := expr.(type) {
+	case *LiteralExpr:
+		return true
+	case *UnaryExpr:
+		_, ok := e.Operand.(*LiteralExpr)
+		return ok && (e.Op == "-" || e.Op == "+")
+	case *ColumnExpr:
+		return !strings.HasPrefix(e.Name, "CURRENT_")
+	}
+	return false
+}
+
+// dropColumn removes a column definition from the SQL text of a table and rewrites every row
+// without the column. The indexes and constraints of the table must not refer to it.
+func dropColumn(pager *Pager, schema *Schema, table *Table, stmt *AlterTableStmt) error {
+	i := table.columnIndex(stmt.Column)
+	columns := table.Def.Columns
+	var sql string
+	if i < len(columns)-1 {
+		sql = table.SQL[:columns[i].Pos] + table.SQL[columns[i+1].Pos:]
+	} else {
+		//!The last column goes with the comma before it.
+		start := strings.LastIndexByte(table.SQL[:columns[i].Pos], ',')
+		sql = table.SQL[:start] + table.SQL[table.Def.ColumnsEnd:]
+	}
+	def, err := checkAlteredTable(sql, "drop column")
+	if err != nil {
+		return err
+	}
+	altered := tableFromDef(schemaObject{Name: table.Name, RootPage: table.RootPage, SQL: sql}, def)
+	for _, index := range schema.indexesOn(table.Name) {
+		if err := checkIndexColumns(index, altered); err != nil {
+			return fmt.Errorf("error in index %s after drop column: %v", index.Name, err)
+		}
+	}
+	err = editSchema(pager, func(object *schemaObject) (bool, error) {
+		if object.Type != "table" || !strings.EqualFold(object.Name, table.Name) {
+			return false, nil
+		}
+		object.SQL = sql
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rowids, records, err := readTable(pager, table.RootPage, map[int64]int64{})
+	if err != nil {
+		return err
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: altered}
+	for j, record := range records {
+		row := valuesInterface(record)
+		for len(row) < len(table.Columns) {
+			row = append(row, table.Columns[len(row)].missingValue())
+		}
+		if err := writer.writeCell(slices.Delete(row, i, i+1), rowids[j]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAlteredTable parses the new SQL text of an altered table and makes the checks of
+// CREATE TABLE on it, reporting a problem the way SQLite does after an ALTER TABLE.
+func checkAlteredTable(sql string, action string) (*CreateTableStmt, error) {
+	statement, err := parseStatement(sql)
+	def, ok := statement.(*CreateTableStmt)
+	if err == nil && !ok {
+		err = fmt.Errorf("not a CREATE TABLE statement")
+	}
+	if err == nil {
+		err = checkTableDef(def)
+	}
+	if err != nil {
+		name := ""
+		if def != nil {
+			name = def.Name
+		}
+		return nil, fmt.Errorf("error in table %s after %s: %v", name, action, err)
+	}
+	return def, nil
+}
+
+// checkIndexColumns checks that the keys and the condition of an index only refer to columns
+// of the table.
+func checkIndexColumns(index *Index, table *Table) error {
+	for _, key := range index.Keys {
+		if key.Expr != nil {
+			if err := bindColumns(key.Expr, table, ""); err != nil {
+				return err
+			}
+		} else if table.columnIndex(key.Name) < 0 {
+			return &ErrNoSuchColumn{Name: key.Name}
+		}
+	}
+	return bindColumns(index.Where, table, "")
+}
+
+// editSchema rewrites the rows of sqlite_schema that edit changes.
+func editSchema(pager *Pager, edit func(object *schemaObject) (bool, error)) error {
+	rowids, rows, err := readTable(pager, 1, map[int64]int64{})
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if len(row) < 5 {
+			return corruptError(1, 0, "sqlite_schema row has %d columns", len(row))
+		}
+		object := schemaObject{Type: row[0].Text, Name: row[1].Text, TblName: row[2].Text, RootPage: row[3].Int, SQL: row[4].Text}
+		changed, err := edit(&object)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := writeSchemaObject(pager, rowids[i], object); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sqlEdit replaces identifiers in the SQL text of a schema object, found by their offsets.
+type sqlEdit struct {
+	sql     string
+	tokens  []Token
+	at      map[int]int  //!Index of the token at each offset.
+	targets map[int]bool //!Offsets of the identifiers to replace.
+}
+
+func newSQLEdit(sql string) *sqlEdit {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil
+	}
+	edit := &sqlEdit{sql: sql, tokens: tokens, at: map[int]int{}, targets: map[int]bool{}}
+	for i, tok := range tokens {
+		edit.at[tok.Pos] = i
+	}
+	return edit
+}
+
+func (edit *sqlEdit) mark(pos int) {
+	edit.targets[pos] = true
+}
+
+// markColumns marks the references to the named column of table in expr.
+func (edit *sqlEdit) markColumns(expr Expr, table string, column string) {
+	walkExpr(expr, func(e Expr) error {
+		ref, ok := e.(*ColumnExpr)
+		if !ok || !strings.EqualFold(ref.Name, column) {
+			return nil
+		}
+		if ref.Table == "" {
+			edit.mark(ref.P)
+		} else if i, ok := edit.at[ref.P]; ok && strings.EqualFold(ref.Table, table) && i+2 < len(edit.tokens) {
+			//!P is the offset of the qualifier; the name follows the dot.
+			edit.mark(edit.tokens[i+2].Pos)
+		}
+		return nil
+	})
+}
+
+// markQualifiers marks the table qualifiers of the column references in expr that name table.
+func (edit *sqlEdit) markQualifiers(expr Expr, table string) {
+	walkExpr(expr, func(e Expr) error {
+		if ref, ok := e.(*ColumnExpr); ok && ref.Table != "" && strings.EqualFold(ref.Table, table) {
+			edit.mark(ref.P)
+		}
+		return nil
+	})
+}
+
+// apply returns the SQL text with the marked identifiers replaced by name. Like SQLite, an
+// identifier that was quoted stays quoted, and quoted makes every replacement quoted.
+func (edit *sqlEdit) apply(name string, quoted bool) string {
+	var b strings.Builder
+	last := 0
+	for _, tok := range edit.tokens {
+		if !edit.targets[tok.Pos] {
+			continue
+		}
+		b.WriteString(edit.sql[last:tok.Pos])
+		if quoted || tok.Kind != TokenIdent {
+			b.WriteString(`"` + strings.ReplaceAll(name, `"`, `""`) + `"`)
+		} else {
+			b.WriteString(name)
+		}
+		last = tok.End
+	}
+	b.WriteString(edit.sql[last:])
+	return b.String()
+}
+
+// foreignKeys returns the foreign keys of a table definition.
+func foreignKeys(def *CreateTableStmt) []*ForeignKey {
+	var keys []*ForeignKey
+	for _, column := range def.Columns {
+		if column.References != nil {
+			keys = append(keys, column.References)
+		}
+	}
+	for _, constraint := range def.Constraints {
+		if constraint.ForeignKey != nil {
+			keys = append(keys, constraint.ForeignKey)
+		}
+	}
+	return keys
+}
+
+// tableExprs returns the expressions of a table definition that may refer to its columns.
+func tableExprs(def *CreateTableStmt) []Expr {
+	var exprs []Expr
+	for _, column := range def.Columns {
+		for _, check := range column.Checks {
+			exprs = append(exprs, check.Expr)
+		}
+		exprs = append(exprs, column.Generated)
+	}
+	for _, constraint := range def.Constraints {
+		exprs = append(exprs, constraint.Check.Expr)
+		for _, key := range constraint.Columns {
+			exprs = append(exprs, key.Expr)
+		}
+	}
+	return exprs
+}
+
+// tableReferences finds the references to a table in the SQL text of a schema object. It is
+// nil for text that is not a CREATE TABLE or CREATE INDEX statement.
+func tableReferences(sql string, table string) *sqlEdit {
+	statement, err := parseStatement(sql)
+	if err != nil {
+		return nil
+	}
+	edit := newSQLEdit(sql)
+	if edit == nil {
+		return nil
+	}
+	switch stmt := statement.(type) {
+	case *CreateTableStmt:
+		if strings.EqualFold(stmt.Name, table) {
+			edit.mark(stmt.NamePos)
+			for _, expr := range tableExprs(stmt) {
+				edit.markQualifiers(expr, table)
+			}
+		}
+		for _, fk := range foreignKeys(stmt) {
+			if strings.EqualFold(fk.Table, table) {
+				edit.mark(fk.TablePos)
+			}
+		}
+	case *CreateIndexStmt:
+		if strings.EqualFold(stmt.Table, table) {
+			edit.mark(stmt.TablePos)
+			for _, key := range stmt.Columns {
+				edit.markQualifiers(key.Expr, table)
+			}
+			edit.markQualifiers(stmt.Where, table)
+		}
+	default:
+		return nil
+	}
+	return edit
+}
+
+// columnReferences finds the references to a column of table in the SQL text of a schema
+// object. It is nil for text that is not a CREATE TABLE or CREATE INDEX statement.
+func columnReferences(sql string, table string, column string) *sqlEdit {
+	statement, err := parseStatement(sql)
+	if err != nil {
+		return nil
+	}
+	edit := newSQLEdit(sql)
+	if edit == nil {
+		return nil
+	}
+	switch stmt := statement.(type) {
+	case *CreateTableStmt:
+		if strings.EqualFold(stmt.Name, table) {
+			for _, def := range stmt.Columns {
+				if strings.EqualFold(def.Name, column) {
+					edit.mark(def.Pos)
+				}
+			}
+			for _, constraint := range stmt.Constraints {
+				for _, key := range constraint.Columns {
+					if key.Expr == nil && strings.EqualFold(key.Name, column) {
+						edit.mark(key.Pos)
+					}
+				}
+				if fk := constraint.ForeignKey; fk != nil {
+					for i, name := range fk.Columns {
+						if strings.EqualFold(name, column) {
+							edit.mark(fk.ColumnPos[i])
+						}
+					}
+				}
+			}
+			for _, expr := range tableExprs(stmt) {
+				edit.markColumns(expr, table, column)
+			}
+		}
+		for _, fk := range foreignKeys(stmt) {
+			if !strings.EqualFold(fk.Table, table) {
+				continue
+			}
+			for i, name := range fk.ParentColumns {
+				if strings.EqualFold(name, column) {
+					edit.mark(fk.ParentPos[i])
+				}
+			}
+		}
+	case *CreateIndexStmt:
+		if strings.EqualFold(stmt.Table, table) {
+			for _, key := range stmt.Columns {
+				if key.Expr == nil && strings.EqualFold(key.Name, column) {
+					edit.mark(key.Pos)
+				}
+				edit.markColumns(key.Expr, table, column)
+			}
+			edit.markColumns(stmt.Where, table, column)
+		}
+	default:
+		return nil
+	}
+	return edit
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlterTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string
+		sql   []string
+		want  string
+		code  int
+	}{
+		{"rename table",
+			[]string{"create table t(a, b)", "insert into t values (1, 2)"},
+			[]string{"alter table t rename to u", "select * from u", "select type, name, tbl_name from sqlite_schema"},
+			"1|2 table|u|u", 0},
+		{"rename table with indexes",
+			[]string{"create table t(a, b)", "create index tb on t(b)", "create unique index ta on t(a)",
+				"insert into t values (1, 2)"},
+			[]string{"alter table t rename to u", "select name, tbl_name, sql from sqlite_schema order by name"},
+			"ta|u|CREATE UNIQUE INDEX ta on \"u\"(a) tb|u|CREATE INDEX tb on \"u\"(b) u|u|CREATE TABLE \"u\"(a, b)", 0},
+		{"rename column",
+			[]string{"create table t(a, b)", "create index tb on t(b)", "insert into t values (1, 2)"},
+			[]string{"alter table t rename column b to c", "select sql from sqlite_schema order by name",
+				"select c from t where c = 2"},
+			"CREATE TABLE t(a, c) CREATE INDEX tb on t(c) 2", 0},
+		{"rename column in constraints and partial indexes",
+			[]string{"create table t(a, b check (b > 0))", "create index tab on t(a, b) where b > 1"},
+			[]string{"alter table t rename column b to \"x y\"", "select sql from sqlite_schema order by name"},
+			"CREATE TABLE t(a, \"x y\" check (\"x y\" > 0)) CREATE INDEX tab on t(a, \"x y\") where \"x y\" > 1", 0},
+		{"add column defaults",
+			[]string{"create table t(a, b)", "insert into t values (1, 2)"},
+			[]string{"alter table t add column c integer default 7", "alter table t add d text default 'x' not null",
+				"alter table t add e", "select a, b, c, d, quote(e) from t", "insert into t(a) values (9)",
+				"select * from t where a = 9"},
+			"1|2|7|x|NULL 9||7|x|", 0},
+		{"add column with an expression default",
+			[]string{"create table t(a, b)", "insert into t values (1, 2)"},
+			[]string{"alter table t add column c default (1 + 1)"},
+			"", 1},
+		{"add NOT NULL column without a default",
+			[]string{"create table t(a, b)", "insert into t values (1, 2)"},
+			[]string{"alter table t add column c not null"},
+			"", 1},
+		{"add UNIQUE column",
+			[]string{"create table t(a, b)"},
+			[]string{"alter table t add column c unique"},
+			"", 1},
+		{"add PRIMARY KEY column",
+			[]string{"create table t(a, b)"},
+			[]string{"alter table t add column c integer primary key"},
+			"", 1},
+		{"drop column",
+			[]string{"create table t(a, b, c)", "insert into t values (1, 2, 3)", "create index ta on t(a)"},
+			[]string{"alter table t drop column b", "select * from t",
+				"select sql from sqlite_schema where name = 't'"},
+			"1|3 CREATE TABLE t(a, c)", 0},
+		{"drop indexed column",
+			[]string{"create table t(a, b, c)", "create index tb on t(b)"},
+			[]string{"alter table t drop column b"},
+			"", 1},
+		{"drop PRIMARY KEY column",
+			[]string{"create table t(a primary key, b)"},
+			[]string{"alter table t drop column a"},
+			"", 1},
+		{"drop the only column",
+			[]string{"create table t(a)"},
+			[]string{"alter table t drop column a"},
+			"", 1},
+		{"rename to an existing table",
+			[]string{"create table t(a, b)", "create table u(x)"},
+			[]string{"alter table t rename to u"},
+			"", 1},
+		{"rename to an existing column",
+			[]string{"create table t(a, b)"},
+			[]string{"alter table t rename column a to b"},
+			"", 1},
+		{"rename a missing column",
+			[]string{"create table t(a, b)"},
+			[]string{"alter table t rename column z to y"},
+			"", 1},
+		{"update a short record",
+			[]string{"create table t(a, b)", "insert into t values (1, 2)"},
+			[]string{"alter table t add column c default 5", "update t set b = 3", "select * from t"},
+			"1|3|5", 0},
+		{"rename a referenced table",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p(id))"},
+			[]string{"alter table p rename to q", "select sql from sqlite_schema where name = 'c'"},
+			"CREATE TABLE c(pid references \"q\"(id))", 0},
+		{"rename a referenced column",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p(id))"},
+			[]string{"alter table p rename column id to pk", "select sql from sqlite_schema where name = 'c'"},
+			"CREATE TABLE c(pid references p(pk))", 0},
+		{"index an added column",
+			[]string{"create table t(a, b)", "insert into t values (1, 2), (3, 4)"},
+			[]string{"alter table t add column c default 7", "create index tc on t(c)",
+				"insert into t(a, c) values (5, 8)", "select a from t where c = 7", "select a from t where c = 8"},
+			"1 3 5", 0},
+	}
+	for _, test := range tests {
+		if got, code := runCase(t, test.setup, test.sql...); got != test.want || code != test.code {
+			t.Errorf("%s: got %q, exit code %d; want %q, %d", test.name, got, code, test.want, test.code)
+		}
+	}
+}
+
+func TestAddColumnShortRecords(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(a, b)", "insert into t values (1, 2)", "alter table t add column c default 'x'",
+		"create index tc on t(c)", "insert into t values (3, 4, 'y')")
+	pager, schema, done := openDatabase(t, path)
+	_, records, err := readTable(pager, schema.Tables["t"].RootPage, map[int64]int64{})
+	done()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//!ADD COLUMN leaves the rows before it as they were; readers fill in the default.
+	if len(records) != 2 || len(records[0]) != 2 || len(records[1]) != 3 {
+		t.Fatalf("records: %v", records)
+	}
+	out := runSQL(t, path, "select c from t order by a", "select a from t where c = 'x'")
+	if got := strings.Join(strings.Fields(out), " "); got != "x y 1" {
+		t.Errorf("got %q, want x y 1", got)
+	}
+	checkIntegrity(t, path)
+}
@@ -0,0 +1,799 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// B-tree page types, the first byte of every b-tree page header.
+const (
+	pageIndexInterior = 0x02
+	pageTableInterior = 0x05
+	pageIndexLeaf     = 0x0a
+	pageTableLeaf     = 0x0d
+)
+
+// btreeNode is a b-tree page decoded into its cells. A page is changed by editing its node and
+// writing the node back whole, which also leaves the page without free blocks or fragments.
+type btreeNode struct {
+	pageNo   int64
+	kind     byte
+	cells    [][]byte //!Cell content; on interior pages without the 4-byte left child pointer.
+	children []int64  //!Interior pages only: the left child of each cell, then the right-most child.
+}
+
+func (node *btreeNode) leaf() bool {
+	return node.kind == pageTableLeaf || node.kind == pageIndexLeaf
+}
+
+// headerSize is the size of the page header, without the file header on page one.
+func (node *btreeNode) headerSize() int64 {
+	if node.leaf() {
+		return 8
+	}
+	return 12
+}
+
+// cellCost is the space a cell takes on the page, including its cell pointer.
+func (node *btreeNode) cellCost(cell []byte) int64 {
+	if node.leaf() {
+		return int64(len(cell)) + 2
+	}
+	return int64(len(cell)) + 6
+}
+
+// fits reports whether the node fits on its page.
+func (node *btreeNode) fits(pager *Pager) bool {
+	size := node.headerSize()
+	if node.pageNo == 1 {
+		size += 100
+	}
+	for _, cell := range node.cells {
+		size += node.cellCost(cell)
+	}
+	return size <= pager.usableSize
+}
+
+// cellSize returns the length of the cell at the start of data, an interior cell's child
+// pointer included.
+func cellSize(pager *Pager, kind byte, data []byte) (int64, error) {
+	start := int64(0)
+	if kind == pageTableInterior || kind == pageIndexInterior {
+		start = 4
+	}
+	if int64(len(data)) < start+1 {
+		return 0, fmt.Errorf("cell runs past the end of the page")
+	}
+	payloadSize, n := ReadVarint(data[start:])
+	if n == 0 {
+		return 0, fmt.Errorf("cell runs past the end of the page")
+	}
+	size := start + int64(n)
+	maxLocal := indexMaxLocal(pager.usableSize)
+	switch kind {
+	case pageTableInterior:
+		//!The varint was the key; there is no payload.
+		return size, nil
+	case pageTableLeaf:
+		_, rowidSize := ReadVarint(data[size:])
+		if rowidSize == 0 {
+			return 0, fmt.Errorf("cell runs past the end of the page")
+		}
+		size += int64(rowidSize)
+		maxLocal = tableLeafMaxLocal(pager.usableSize)
+	}
+	if payloadSize > 1000000000 {
+		return 0, fmt.Errorf("payload size %d is too large", payloadSize)
+	}
+	local := localPayloadSize(pager.usableSize, int64(payloadSize), maxLocal)
+	size += local
+	if local < int64(payloadSize) {
+		size += 4
+	}
+	if size > int64(len(data)) {
+		return 0, fmt.Errorf("cell runs past the end of the page")
+	}
+	return size, nil
+}
+
+// readNode reads and decodes a b-tree page.
+func readNode(pager *Pager, pageNo int64) (*btreeNode, error) {
+	page, err := pager.page(pageNo)
+	if err != nil {
+		return nil, err
+	}
+	headerOffset := int64(0)
+	if pageNo == 1 {
+		headerOffset = 100
+	}
+	kind := page[headerOffset]
+	leafType, interiorType := byte(pageTableLeaf), byte(pageTableInterior)
+	if kind == pageIndexLeaf || kind == pageIndexInterior {
+		leafType, interiorType = pageIndexLeaf, pageIndexInterior
+	}
+	page, _, cellPointers, rightChild, err := readBtreePage(pager, pageNo, leafType, interiorType)
+	if err != nil {
+		return nil, err
+	}
+	node := &btreeNode{pageNo: pageNo, kind: kind}
+	for _, pointer := range cellPointers {
+		size, err := cellSize(pager, kind, page[pointer:])
+		if err != nil {
+			return nil, corruptError(pageNo, int64(pointer), "%v", err)
+		}
+		//!Copied, as the page is rewritten from the node.
+		cell := append([]byte(nil), page[pointer:int64(pointer)+size]...)
+		if node.leaf() {
+			node.cells = append(node.cells, cell)
+		} else {
+			node.children = append(node.children, int64(binary.BigEndian.Uint32(cell)))
+			node.cells = append(node.cells, cell[4:])
+		}
+	}
+	if !node.leaf() {
+		node.children = append(node.children, rightChild)
+	}
+	return node, nil
+}
+
+// writeNode lays a node out on its page: the cells are packed against the end of the usable
+// space in order, with no free blocks.
+func writeNode(pager *Pager, node *btreeNode) error {
+	page, err := pager.writablePage(node.pageNo)
+	if err != nil {
+		return err
+	}
+	be := binary.BigEndian
+	header := int64(0)
+	if node.pageNo == 1 {
+		header = 100
+	}
+	clear(page[header:pager.usableSize])
+	page[header] = node.kind
+	be.PutUint16(page[header+3:], uint16(len(node.cells)))
+	content := pager.usableSize
+	pointers := header + node.headerSize()
+	for i, cell := range node.cells {
+		if node.leaf() {
+			content -= int64(len(cell))
+			copy(page[content:], cell)
+		} else {
+			content -= int64(len(cell)) + 4
+			be.PutUint32(page[content:], uint32(node.children[i]))
+			copy(page[content+4:], cell)
+		}
+		be.PutUint16(page[pointers+2*int64(i):], uint16(content))
+	}
+	//!A content area starting at 65536 is stored as 0.
+	be.PutUint16(page[header+5:], uint16(content))
+	if !node.leaf() {
+		be.PutUint32(page[header+8:], uint32(node.children[len(node.children)-1]))
+	}
+	return nil
+}
+
+// cellRowid returns the rowid of a table b-tree cell.
+func cellRowid(node *btreeNode, i int) int64 {
+	cell := node.cells[i]
+	if node.kind == pageTableLeaf {
+		_, n := ReadVarint(cell)
+		cell = cell[n:]
+	}
+	rowid, _ := ReadVarint(cell)
+	return int64(rowid)
+}
+
+// cellRecord decodes the record of an index b-tree cell.
+func cellRecord(pager *Pager, cell []byte) ([]Value, error) {
+	payloadSize, n := ReadVarint(cell)
+	payload, err := cellPayload(pager, cell, int64(n), payloadSize, indexMaxLocal(pager.usableSize))
+	if err != nil {
+		return nil, err
+	}
+	return DecodeRecord(payload, pager.encoding)
+}
+
+// cellComparer compares the key being looked for with the key of cell i of a node: negative
+// when the key sorts before the cell, zero when they are equal.
+type cellComparer func(node *btreeNode, i int) (int, error)
+
+// rowidComparer orders table b-tree cells by rowid.
+func rowidComparer(rowid int64) cellComparer {
+	return func(node *btreeNode, i int) (int, error) {
+		return compareInts(rowid, cellRowid(node, i)), nil
+	}
+}
+
+// indexKeyOrder is how one column of an index key sorts.
+type indexKeyOrder struct {
+	collation string
+	desc      bool
+}
+
+// compareIndexKeys orders two index records: column by column, then by the rowid that ends
+// every entry. A record that runs out of columns first sorts first. Text is compared in the
+// database encoding enc, as SQLite compares the bytes it stores.
+func compareIndexKeys(a []Value, b []Value, order []indexKeyOrder, enc TextEncoding) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		collation, desc := "", false
+		if i < len(order) {
+			collation, desc = order[i].collation, order[i].desc
+		}
+		c := compareValues(a[i].Interface(), b[i].Interface(), collation, enc)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// indexComparer orders index b-tree cells by their whole record.
+func indexComparer(pager *Pager, key []Value, order []indexKeyOrder) cellComparer {
+	return func(node *btreeNode, i int) (int, error) {
+		record, err := cellRecord(pager, node.cells[i])
+		if err != nil {
+			return 0, corruptError(node.pageNo, 0, "%v", err)
+		}
+		return compareIndexKeys(key, record, order, pager.encoding), nil
+	}
+}
+
+// indexPrefixComparer orders index b-tree cells by as many leading columns of their record as
+// key has, so that any entry starting with key compares equal.
+func indexPrefixComparer(pager *Pager, key []Value, order []indexKeyOrder) cellComparer {
+	return func(node *btreeNode, i int) (int, error) {
+		record, err := cellRecord(pager, node.cells[i])
+		if err != nil {
+			return 0, corruptError(node.pageNo, 0, "%v", err)
+		}
+		return compareIndexKeys(key, record[:min(len(key), len(record))], order, pager.encoding), nil
+	}
+}
+
+// search returns the position of the first cell whose key is not below the key compare
+// looks for, and whether that cell's key is equal to it.
+func (node *btreeNode) search(compare cellComparer) (int, bool, error) {
+	lo, hi := 0, len(node.cells)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		c, err := compare(node, mid)
+		if err != nil {
+			return 0, false, err
+		}
+		if c > 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == len(node.cells) {
+		return lo, false, nil
+	}
+	c, err := compare(node, lo)
+	return lo, c == 0, err
+}
+
+// buildCell lays out a cell: prefix, then as much of payload as stays on the page, then the
+// number of the first overflow page holding the rest.
+func buildCell(pager *Pager, prefix []byte, payload []byte, maxLocal int64) ([]byte, error) {
+	local := localPayloadSize(pager.usableSize, int64(len(payload)), maxLocal)
+	cell := append(prefix, payload[:local]...)
+	rest := payload[local:]
+	if len(rest) == 0 {
+		return cell, nil
+	}
+	pageNo, page, err := pager.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	cell = binary.BigEndian.AppendUint32(cell, uint32(pageNo))
+	for {
+		n := copy(page[4:pager.usableSize], rest)
+		rest = rest[n:]
+		if len(rest) == 0 {
+			return cell, nil
+		}
+		next, nextPage, err := pager.allocatePage()
+		if err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(page, uint32(next))
+		page = nextPage
+	}
+}
+
+// tableLeafCell builds the cell storing a row of a table.
+func tableLeafCell(pager *Pager, rowid int64, record []byte) ([]byte, error) {
+	prefix := AppendVarint(AppendVarint(nil, uint64(len(record))), uint64(rowid))
+	return buildCell(pager, prefix, record, tableLeafMaxLocal(pager.usableSize))
+}
+
+// indexCell builds the cell storing an index entry.
+func indexCell(pager *Pager, record []byte) ([]byte, error) {
+	return buildCell(pager, AppendVarint(nil, uint64(len(record))), record, indexMaxLocal(pager.usableSize))
+}
+
+// freeOverflow puts the overflow pages of a cell on the freelist.
+func freeOverflow(pager *Pager, node *btreeNode, cell []byte) error {
+	if node.kind == pageTableInterior {
+		return nil
+	}
+	payloadSize, _ := ReadVarint(cell)
+	maxLocal := indexMaxLocal(pager.usableSize)
+	if node.kind == pageTableLeaf {
+		maxLocal = tableLeafMaxLocal(pager.usableSize)
+	}
+	local := localPayloadSize(pager.usableSize, int64(payloadSize), maxLocal)
+	if local == int64(payloadSize) {
+		return nil
+	}
+	pageNo := int64(binary.BigEndian.Uint32(cell[len(cell)-4:]))
+	for remaining := int64(payloadSize) - local; remaining > 0; remaining -= pager.usableSize - 4 {
+		page, err := pager.page(pageNo)
+		if err != nil {
+			return err
+		}
+		next := int64(binary.BigEndian.Uint32(page))
+		if err := pager.freePage(pageNo); err != nil {
+			return err
+		}
+		pageNo = next
+	}
+	return nil
+}
+
+// btreeSplit is a page created by splitting a node, with the divider that separates it from
+// the page before it in the parent.
+type btreeSplit struct {
+	divider []byte
+	pageNo  int64
+}
+
+// btreeInsert puts cell into the b-tree rooted at root at the position compare finds for it,
+// replacing a cell with an equal key. Pages that overflow are split and the root grows a
+// level when it overflows, so the root page number never changes.
+func btreeInsert(pager *Pager, root int64, cell []byte, compare cellComparer) error {
+	node, err := readNode(pager, root)
+	if err != nil {
+		return err
+	}
+	appended, err := insertInto(pager, node, cell, compare)
+	if err != nil || node.fits(pager) {
+		return err
+	}
+	return deepenRoot(pager, root, node, appended)
+}
+
+// deepenRoot handles a root node that no longer fits its page: everything on it moves to new
+// pages, split as needed, and the root becomes their parent.
+func deepenRoot(pager *Pager, root int64, node *btreeNode, appended bool) error {
+	node.pageNo = 0
+	splits, err := splitNode(pager, node, appended)
+	if err != nil {
+		return err
+	}
+	first, _, err := pager.allocatePage()
+	if err != nil {
+		return err
+	}
+	node.pageNo = first
+	if err := writeNode(pager, node); err != nil {
+		return err
+	}
+	rootNode := &btreeNode{pageNo: root, kind: pageTableInterior, children: []int64{first}}
+	if node.kind == pageIndexLeaf || node.kind == pageIndexInterior {
+		rootNode.kind = pageIndexInterior
+	}
+	for _, split := range splits {
+		rootNode.cells = append(rootNode.cells, split.divider)
+		rootNode.children = append(rootNode.children, split.pageNo)
+	}
+	return writeNode(pager, rootNode)
+}
+
+// insertInto inserts cell below node and leaves node updated in memory. When node still fits
+// its page it is written back; otherwise the caller splits it. appended reports whether the
+// change was at the end of the node, where splits keep the left pages full.
+func insertInto(pager *Pager, node *btreeNode, cell []byte, compare cellComparer) (appended bool, err error) {
+	i, equal, err := node.search(compare)
+	if err != nil {
+		return false, err
+	}
+	if node.leaf() {
+		if equal {
+			if err := freeOverflow(pager, node, node.cells[i]); err != nil {
+				return false, err
+			}
+			node.cells[i] = cell
+		} else {
+			node.cells = append(node.cells[:i], append([][]byte{cell}, node.cells[i:]...)...)
+		}
+		appended = i >= len(node.cells)-1
+	} else if equal && node.kind == pageIndexInterior {
+		//!Index entries are unique, so the entry is already there.
+		return false, nil
+	} else {
+		child, err := readNode(pager, node.children[i])
+		if err != nil {
+			return false, err
+		}
+		childAppended, err := insertInto(pager, child, cell, compare)
+		if err != nil || child.fits(pager) {
+			return false, err
+		}
+		splits, err := splitNode(pager, child, childAppended)
+		if err != nil {
+			return false, err
+		}
+		replaceDividers(node, i, i, splits)
+		appended = childAppended && i == len(node.cells)-len(splits)
+	}
+	if !node.fits(pager) {
+		return appended, nil
+	}
+	return appended, writeNode(pager, node)
+}
+
+// splitNode divides the cells of an overflowing node over as many pages as they need. The
+// node keeps the first part, on its own page unless its pageNo is 0, and the new pages are
+// returned with their dividers. Table leaves stay whole and are divided by a copy of the last
+// rowid on the left; other pages give up a cell as the divider. After an append the left pages
+// are filled up; otherwise the cells are spread evenly.
+func splitNode(pager *Pager, node *btreeNode, appended bool) ([]btreeSplit, error) {
+	promote := node.kind != pageTableLeaf
+	capacity := pager.usableSize - node.headerSize()
+	total := int64(0)
+	for _, cell := range node.cells {
+		total += node.cellCost(cell)
+	}
+	target := capacity
+	if !appended {
+		pages := (total + capacity - 1) / capacity
+		target = (total + pages - 1) / pages
+	}
+
+	type part struct{ start, end int }
+	var parts []part
+	var dividers [][]byte
+	n := len(node.cells)
+	for start := 0; ; {
+		size, end := int64(0), start
+		for end < n && size < target && size+node.cellCost(node.cells[end]) <= capacity {
+			size += node.cellCost(node.cells[end])
+			end++
+		}
+		if end == n {
+			parts = append(parts, part{start, end})
+			break
+		}
+		if promote && end+1 == n {
+			//!Leave a cell for the last page.
+			end--
+		}
+		if end <= start {
+			return nil, corruptError(node.pageNo, 0, "cannot split page")
+		}
+		parts = append(parts, part{start, end})
+		if promote {
+			dividers = append(dividers, node.cells[end])
+			start = end + 1
+		} else {
+			dividers = append(dividers, AppendVarint(nil, uint64(cellRowid(node, end-1))))
+			start = end
+		}
+	}
+
+	nodes := make([]*btreeNode, len(parts))
+	for j, p := range parts {
+		nodes[j] = &btreeNode{kind: node.kind, cells: node.cells[p.start:p.end:p.end]}
+		if !node.leaf() {
+			nodes[j].children = node.children[p.start : p.end+1 : p.end+1]
+		}
+	}
+	splits := make([]btreeSplit, len(parts)-1)
+	for j := 1; j < len(parts); j++ {
+		pageNo, _, err := pager.allocatePage()
+		if err != nil {
+			return nil, err
+		}
+		nodes[j].pageNo = pageNo
+		splits[j-1] = btreeSplit{divider: dividers[j-1], pageNo: pageNo}
+		if err := writeNode(pager, nodes[j]); err != nil {
+			return nil, err
+		}
+	}
+	node.cells, node.children = nodes[0].cells, nodes[0].children
+	if node.pageNo != 0 {
+		return splits, writeNode(pager, node)
+	}
+	return splits, nil
+}
+
+// btreeFind reports whether the b-tree rooted at root has a cell with the key compare looks
+// for.
+func btreeFind(pager *Pager, root int64, compare cellComparer) (bool, error) {
+	for pageNo := root; ; {
+		node, err := readNode(pager, pageNo)
+		if err != nil {
+			return false, err
+		}
+		i, equal, err := node.search(compare)
+		if err != nil || equal && (node.leaf() || node.kind == pageIndexInterior) {
+			return equal, err
+		}
+		if node.leaf() {
+			return false, nil
+		}
+		pageNo = node.children[i]
+	}
+}
+
+// btreeLookup returns the record of a cell with the key compare looks for in an index b-tree,
+// nil when there is none.
+func btreeLookup(pager *Pager, root int64, compare cellComparer) ([]Value, error) {
+	for pageNo := root; ; {
+		node, err := readNode(pager, pageNo)
+		if err != nil {
+			return nil, err
+		}
+		i, equal, err := node.search(compare)
+		if err != nil {
+			return nil, err
+		}
+		if equal {
+			return cellRecord(pager, node.cells[i])
+		}
+		if node.leaf() {
+			return nil, nil
+		}
+		pageNo = node.children[i]
+	}
+}
+
+// maxRowid returns the largest rowid in a table b-tree, 0 when the table is empty.
+func maxRowid(pager *Pager, root int64) (int64, error) {
+	for pageNo := root; ; {
+		node, err := readNode(pager, pageNo)
+		if err != nil {
+			return 0, err
+		}
+		if !node.leaf() {
+			pageNo = node.children[len(node.children)-1]
+			continue
+		}
+		if len(node.cells) == 0 {
+			return 0, nil
+		}
+		return cellRowid(node, len(node.cells)-1), nil
+	}
+}
+
+// underfull reports whether less than a third of the page is in use, the point at which
+// SQLite balances a page with its siblings.
+func (node *btreeNode) underfull(pager *Pager) bool {
+	used := int64(0)
+	for _, cell := range node.cells {
+		used += node.cellCost(cell)
+	}
+	return len(node.cells) == 0 || used < (pager.usableSize-node.headerSize())/3
+}
+
+// btreeDelete removes the cell with the key compare looks for from the b-tree rooted at root
+// and reports whether it was there. Its overflow pages, and pages emptied by merging siblings,
+// go on the freelist; the root keeps its page number.
+func btreeDelete(pager *Pager, root int64, compare cellComparer) (bool, error) {
+	node, err := readNode(pager, root)
+	if err != nil {
+		return false, err
+	}
+	found, err := deleteFrom(pager, node, compare)
+	if err != nil || !found {
+		return found, err
+	}
+	//!A root left with a single child takes over the child's content.
+	for !node.leaf() && len(node.cells) == 0 {
+		child, err := readNode(pager, node.children[0])
+		if err != nil {
+			return false, err
+		}
+		childPage := child.pageNo
+		child.pageNo = root
+		if !child.fits(pager) {
+			//!Only possible on page one, which has less room.
+			break
+		}
+		if err := pager.freePage(childPage); err != nil {
+			return false, err
+		}
+		node = child
+	}
+	if !node.fits(pager) {
+		return true, deepenRoot(pager, root, node, false)
+	}
+	return true, writeNode(pager, node)
+}
+
+// deleteFrom removes the cell compare looks for from below node, leaving node updated in
+// memory for the caller to write or balance.
+func deleteFrom(pager *Pager, node *btreeNode, compare cellComparer) (bool, error) {
+	i, equal, err := node.search(compare)
+	if err != nil {
+		return false, err
+	}
+	if node.leaf() {
+		if !equal {
+			return false, nil
+		}
+		if err := freeOverflow(pager, node, node.cells[i]); err != nil {
+			return false, err
+		}
+		node.cells = append(node.cells[:i], node.cells[i+1:]...)
+		return true, nil
+	}
+	child, err := readNode(pager, node.children[i])
+	if err != nil {
+		return false, err
+	}
+	if equal && node.kind == pageIndexInterior {
+		//!The entry is on this page: replace it with the largest entry of its left subtree.
+		if err := freeOverflow(pager, node, node.cells[i]); err != nil {
+			return false, err
+		}
+		if node.cells[i], err = removeLast(pager, child); err != nil {
+			return false, err
+		}
+	} else if found, err := deleteFrom(pager, child, compare); err != nil || !found {
+		return found, err
+	}
+	return true, balanceChild(pager, node, i, child)
+}
+
+// removeLast takes the last cell out of the subtree below node, which is left updated in
+// memory like deleteFrom leaves it.
+func removeLast(pager *Pager, node *btreeNode) ([]byte, error) {
+	if node.leaf() {
+		last := node.cells[len(node.cells)-1]
+		node.cells = node.cells[:len(node.cells)-1]
+		return last, nil
+	}
+	i := len(node.children) - 1
+	child, err := readNode(pager, node.children[i])
+	if err != nil {
+		return nil, err
+	}
+	last, err := removeLast(pager, child)
+	if err != nil {
+		return nil, err
+	}
+	return last, balanceChild(pager, node, i, child)
+}
+
+// balanceChild writes child i of node after a change. A child that no longer fits is split;
+// an underfull one is merged with a sibling, or shares cells with it when both do not fit on
+// one page. node is updated in memory and left for its own caller.
+func balanceChild(pager *Pager, node *btreeNode, i int, child *btreeNode) error {
+	if !child.fits(pager) {
+		splits, err := splitNode(pager, child, false)
+		if err != nil {
+			return err
+		}
+		replaceDividers(node, i, i, splits)
+		return nil
+	}
+	if !child.underfull(pager) || len(node.children) < 2 {
+		return writeNode(pager, child)
+	}
+
+	//!Join the child with its left sibling, or its right one when it is the first child.
+	left, right := i-1, i
+	if i == 0 {
+		left, right = 0, 1
+	}
+	a, b := child, child
+	var err error
+	if left == i {
+		b, err = readNode(pager, node.children[right])
+	} else {
+		a, err = readNode(pager, node.children[left])
+	}
+	if err != nil {
+		return err
+	}
+	merged := &btreeNode{pageNo: a.pageNo, kind: a.kind}
+	merged.cells = append(merged.cells, a.cells...)
+	if a.kind != pageTableLeaf {
+		//!The divider comes down between the two.
+		merged.cells = append(merged.cells, node.cells[left])
+	}
+	merged.cells = append(merged.cells, b.cells...)
+	merged.children = append(append(merged.children, a.children...), b.children...)
+	if err := pager.freePage(b.pageNo); err != nil {
+		return err
+	}
+	if merged.fits(pager) {
+		replaceDividers(node, left, right, nil)
+		return writeNode(pager, merged)
+	}
+	splits, err := splitNode(pager, merged, false)
+	if err != nil {
+		return err
+	}
+	replaceDividers(node, left, right, splits)
+	return nil
+}
+
+// replaceDividers replaces the dividers between children first and last of node, and the
+// children after first up to last, with the pages of splits.
+func replaceDividers(node *btreeNode, first int, last int, splits []btreeSplit) {
+	cells := append([][]byte(nil), node.cells[:first]...)
+	children := append([]int64(nil), node.children[:first+1]...)
+	for _, split := range splits {
+		cells = append(cells, split.divider)
+		children = append(children, split.pageNo)
+	}
+	node.cells = append(cells, node.cells[last:]...)
+	node.children = append(children, node.children[last+1:]...)
+}
+
+// btreeClear empties the b-tree rooted at root, putting every other page of it, overflow pages
+// included, on the freelist.
+func btreeClear(pager *Pager, root int64) error {
+	node, err := readNode(pager, root)
+	if err != nil {
+		return err
+	}
+	if err := freeSubtrees(pager, node); err != nil {
+		return err
+	}
+	kind := byte(pageTableLeaf)
+	if node.kind == pageIndexLeaf || node.kind == pageIndexInterior {
+		kind = pageIndexLeaf
+	}
+	return writeNode(pager, &btreeNode{pageNo: root, kind: kind})
+}
+
+// freeSubtrees frees the overflow pages of the cells of node and every page below it.
+func freeSubtrees(pager *Pager, node *btreeNode) error {
+	for _, cell := range node.cells {
+		if err := freeOverflow(pager, node, cell); err != nil {
+			return err
+		}
+	}
+	for _, pageNo := range node.children {
+		child, err := readNode(pager, pageNo)
+		if err != nil {
+			return err
+		}
+		if err := freeSubtrees(pager, child); err != nil {
+			return err
+		}
+		if err := pager.freePage(pageNo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// btreeDrop frees every page of a b-tree, its root included.
+func btreeDrop(pager *Pager, root int64) error {
+	node, err := readNode(pager, root)
+	if err != nil {
+		return err
+	}
+	if err := freeSubtrees(pager, node); err != nil {
+		return err
+	}
+	return pager.freePage(root)
+}
+
+// btreeEmpty reports whether a b-tree has no cells.
+func btreeEmpty(pager *Pager, root int64) (bool, error) {
+	node, err := readNode(pager, root)
+	if err != nil {
+		return false, err
+	}
+	return node.leaf() && len(node.cells) == 0, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIndexInsertFollowsTextEncoding(t *testing.T) {
+	tests := []struct {
+		enc  TextEncoding
+		want []string
+	}{
+		{EncodingUTF8, []string{"abc", "héllo", "ñ", "ā", "日本語", "𝄞"}},
+		{EncodingUTF16LE, []string{"ā", "𝄞", "abc", "héllo", "日本語", "ñ"}}, //!01 01, 34 d8, 61 00, 68 00, e5 65, f1 00.
+		{EncodingUTF16BE, []string{"abc", "héllo", "ñ", "ā", "日本語", "𝄞"}}, //!The surrogate pair, d8 34, sorts above 65 e5.
+	}
+	for _, test := range tests {
+		t.Run(test.enc.String(), func(t *testing.T) {
+			path := newDatabase(t)
+			runSQL(t, path, "pragma encoding = '"+test.enc.String()+"'", "create table t(s)", "create index ts on t(s)",
+				"insert into t values ('héllo'), ('日本語'), ('abc')", "insert into t values ('ñ'), ('ā'), ('𝄞')")
+			checkIntegrity(t, path)
+			if got := indexText(t, path, "ts"); !slices.Equal(got, test.want) {
+				t.Errorf("index holds %q, want %q", got, test.want)
+			}
+		})
+	}
+}
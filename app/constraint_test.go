@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConstraints(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string
+		sql   []string
+		want  string
+		code  int
+	}{
+		{"not null",
+			[]string{"create table t(a not null, b)"},
+			[]string{"insert into t(b) values (1)"},
+			"", resultConstraint},
+		{"not null on conflict ignore",
+			[]string{"create table t(a not null on conflict ignore, b)"},
+			[]string{"insert into t values (null, 1), (2, 2)", "select * from t"},
+			"2|2", 0},
+		{"or replace uses the column default for null",
+			[]string{"create table t(a not null default 5, b)"},
+			[]string{"insert or replace into t values (null, 1)", "select * from t"},
+			"5|1", 0},
+		{"unique column",
+			[]string{"create table t(a unique, b)", "insert into t values (1, 'x')"},
+			[]string{"insert into t values (1, 'y')"},
+			"", resultConstraint},
+		{"composite unique allows nulls",
+			[]string{"create table t(a, b, unique (a, b))", "insert into t values (1, 1), (null, 1)"},
+			[]string{"insert into t values (1, 2), (null, 1)", "insert into t values (1, 1)"},
+			"", resultConstraint},
+		{"unique on conflict replace",
+			[]string{"create table t(a unique on conflict replace, b)", "insert into t values (1, 'x'), (2, 'y')"},
+			[]string{"insert into t values (1, 'z')", "select * from t order by a"},
+			"1|z 2|y", 0},
+		{"unique on conflict ignore",
+			[]string{"create table t(a unique on conflict ignore, b)", "insert into t values (1, 'x')"},
+			[]string{"insert into t values (1, 'z'), (2, 'w')", "select * from t order by a"},
+			"1|x 2|w", 0},
+		{"or abort overrides on conflict ignore",
+			[]string{"create table t(a unique on conflict ignore, b)", "insert into t values (1, 'x')"},
+			[]string{"insert or abort into t values (1, 'z')"},
+			"", resultConstraint},
+		{"or replace",
+			[]string{"create table t(a unique, b)", "insert into t values (1, 'x')"},
+			[]string{"insert or replace into t values (1, 'z')", "select * from t"},
+			"1|z", 0},
+		{"or ignore skips the row",
+			[]string{"create table t(a unique, b)", "insert into t values (1, 'x')"},
+			[]string{"insert or ignore into t values (1, 'z'), (2, 'w')", "select * from t order by a"},
+			"1|x 2|w", 0},
+		{"check",
+			[]string{"create table t(a check (a > 0), b, check (b <> a))"},
+			[]string{"insert into t values (1, 2)", "insert into t values (0, 2)"},
+			"", resultConstraint},
+		{"named check",
+			[]string{"create table t(a check (a > 0), b, constraint bna check (b <> a))"},
+			[]string{"insert into t values (2, 2)"},
+			"", resultConstraint},
+		{"check takes no on conflict clause",
+			[]string{},
+			[]string{"create table t(a check (a > 0) on conflict ignore)"},
+			"", 1},
+		{"check with or replace aborts",
+			[]string{"create table t(a check (a > 0))"},
+			[]string{"insert or replace into t values (0)"},
+			"", resultConstraint},
+		{"strict coerces and accepts",
+			[]string{"create table t(a integer, b text, c real, d blob, e any) strict"},
+			[]string{"insert into t values (1, 'x', 2, x'00', 'y')", "insert into t values ('3', 4, 5, null, 6)",
+				"select typeof(a), typeof(b), typeof(c), typeof(e) from t"},
+			"integer|text|real|text integer|text|real|integer", 0},
+		{"strict integer refuses text",
+			[]string{"create table t(a integer) strict"},
+			[]string{"insert into t values ('x')"},
+			"", resultConstraint},
+		{"strict int refuses a fraction",
+			[]string{"create table t(a int) strict"},
+			[]string{"insert into t values (1.5)"},
+			"", resultConstraint},
+		{"strict update",
+			[]string{"create table t(a text) strict", "insert into t values ('x')"},
+			[]string{"update t set a = 1", "select a, typeof(a) from t", "update t set a = x'00'"},
+			"1|text", resultConstraint},
+		{"strict unknown type",
+			[]string{},
+			[]string{"create table t(a foo) strict"},
+			"", 1},
+		{"strict missing type",
+			[]string{},
+			[]string{"create table t(a) strict"},
+			"", 1},
+		{"integer primary key on conflict replace",
+			[]string{"create table t(id integer primary key on conflict replace, b)", "insert into t values (1, 'x')"},
+			[]string{"insert into t values (1, 'y')", "select * from t"},
+			"1|y", 0},
+		{"integer primary key",
+			[]string{"create table t(id integer primary key, b)", "insert into t values (1, 'x')"},
+			[]string{"insert into t values (1, 'y')"},
+			"", resultConstraint},
+		{"integer primary key refuses text",
+			[]string{"create table t(id integer primary key, b)"},
+			[]string{"insert into t values ('abc', 1)"},
+			"", resultMismatch},
+		{"not null in update",
+			[]string{"create table t(a primary key not null, b)", "insert into t values (1, 2)"},
+			[]string{"update t set a = null"},
+			"", resultConstraint},
+		{"update or replace",
+			[]string{"create table t(a unique, b)", "insert into t values (1, 'x'), (2, 'y')"},
+			[]string{"update or replace t set a = 1 where a = 2", "select * from t"},
+			"1|y", 0},
+		{"update or ignore",
+			[]string{"create table t(a unique, b)", "insert into t values (1, 'x'), (2, 'y')"},
+			[]string{"update or ignore t set a = 1", "select * from t order by b"},
+			"1|x 2|y", 0},
+		{"or replace with not null",
+			[]string{"create table t(a unique, b not null)", "insert into t values (1, 'x')"},
+			[]string{"insert or replace into t values (1, null)"},
+			"", resultConstraint},
+		{"unique with collation",
+			[]string{"create table t(a collate nocase unique)", "insert into t values ('A')"},
+			[]string{"insert into t values ('a')"},
+			"", resultConstraint},
+		{"primary key on conflict ignore",
+			[]string{"create table t(a, b, primary key (a, b) on conflict ignore)", "insert into t values (1, 2)"},
+			[]string{"insert into t values (1, 2), (1, 3)", "select count(*) from t"},
+			"2", 0},
+		{"unique checked per row in update",
+			[]string{"create table t(a unique, b)", "insert into t values (1, 'x'), (2, 'y')"},
+			[]string{"update t set a = a + 1", "select * from t order by a"},
+			"", resultConstraint},
+		{"or ignore overrides on conflict fail",
+			[]string{"create table t(a not null on conflict fail, b)"},
+			[]string{"insert or ignore into t values (null, 4), (5, 5)", "select * from t",
+				"insert into t values (6, 6), (null, 7)"},
+			"5|5", resultConstraint},
+	}
+	for _, test := range tests {
+		if got, code := runCase(t, test.setup, test.sql...); got != test.want || code != test.code {
+			t.Errorf("%s: got %q, exit code %d; want %q, %d", test.name, got, code, test.want, test.code)
+		}
+	}
+}
+
+func TestConflictResolution(t *testing.T) {
+	//!Each insert runs in a transaction that already added the row 5, with the row 1 there from
+	//!before it, and collides with that row on its second value. Unlike command line statements,
+	//!a script goes on after the error, which shows what it left behind.
+	tests := []struct {
+		name   string
+		create string
+		sql    string
+		want   string //!The rows after the script commits.
+	}{
+		{"on conflict fail", "create table t(a unique on conflict fail, b)",
+			"insert into t values (2, 'w'), (1, 'z'), (3, 'v')", "1 2 5"},
+		{"on conflict rollback", "create table t(a unique on conflict rollback, b)",
+			"insert into t values (2, 'w'), (1, 'z'), (3, 'v')", "1"},
+		{"or abort overrides on conflict rollback", "create table t(a unique on conflict rollback, b)",
+			"insert or abort into t values (2, 'w'), (1, 'z'), (3, 'v')", "1 5"},
+		{"or fail", "create table t(a unique, b)", "insert or fail into t values (2, 'w'), (1, 'z'), (3, 'v')", "1 2 5"},
+		{"or abort", "create table t(a unique, b)", "insert or abort into t values (2, 'w'), (1, 'z'), (3, 'v')", "1 5"},
+		{"or rollback", "create table t(a unique, b)", "insert or rollback into t values (2, 'w'), (1, 'z'), (3, 'v')",
+			"1"},
+		{"or fail on a check", "create table t(a unique, b check (b <> 'z'))",
+			"insert or fail into t values (2, 'w'), (3, 'z'), (4, 'v')", "1 2 5"},
+	}
+	for _, test := range tests {
+		path := newDatabase(t)
+		runSQL(t, path, test.create, "insert into t values (1, 'x')")
+		var out bytes.Buffer
+		shell := newShell(&out)
+		shell.databaseFilePath = path
+		var code int
+		captureStderr(t, func() {
+			code = shell.run(strings.NewReader("begin;\ninsert into t values (5, 'a');\n" + test.sql +
+				";\ncommit;\nselect a from t order by a;\n"))
+		})
+		if got := strings.Join(strings.Fields(out.String()), " "); got != test.want || code != 1 {
+			t.Errorf("%s: got %q, exit code %d; want %q, 1", test.name, got, code, test.want)
+		}
+		checkIntegrity(t, path)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// strictTypes are the column types a STRICT table accepts.
+var strictTypes = map[string]bool{"INT": true, "INTEGER": true, "REAL": true, "TEXT": true, "BLOB": true, "ANY": true}
+
+// executeCreateTable runs a CREATE TABLE. The table gets an empty root page and a row in
+// sqlite_schema, followed by the automatic indexes of its PRIMARY KEY and UNIQUE constraints
+// and, for the first AUTOINCREMENT table, sqlite_sequence.
+func executeCreateTable(pager *Pager, schema *Schema, stmt *CreateTableStmt) error {
+	if stmt.Select != nil {
+		return fmt.Errorf("CREATE TABLE ... AS SELECT is not supported")
+	}
+	if strings.HasPrefix(strings.ToLower(stmt.Name), "sqlite_") {
+		return &ErrPrepare{Msg: "object name reserved for internal use: " + stmt.Name}
+	}
+	if schema.table(stmt.Name) != nil {
+		if stmt.IfNotExists {
+			return nil
+		}
+		return &ErrPrepare{Msg: fmt.Sprintf("table %s already exists", stmt.Name), Pos: stmt.NamePos}
+	}
+	if schema.index(stmt.Name) != nil {
+		return &ErrPrepare{Msg: "there is already an index named " + stmt.Name}
+	}
+	if err := checkTableDef(stmt); err != nil {
+		return err
+	}
+
+	if err := pager.beginWrite(); err != nil {
+		return err
+	}
+	if err := createTable(pager, schema, stmt); err != nil {
+		pager.rollback()
+		return err
+	}
+	return pager.commit()
+}
+
+// createTable writes the b-trees and sqlite_schema rows of a new table.
+func createTable(pager *Pager, schema *Schema, stmt *CreateTableStmt) error {
+	table := tableFromDef(schemaObject{Type: "table", Name: stmt.Name, TblName: stmt.Name, SQL: stmt.SQL}, stmt)
+	kind := byte(pageTableLeaf)
+	if stmt.WithoutRowid {
+		kind = pageIndexLeaf
+	}
+	root, err := createBtree(pager, kind)
+	if err != nil {
+		return err
+	}
+	objects := []schemaObject{{Type: "table", Name: table.Name, TblName: table.Name, RootPage: root, SQL: stmt.SQL}}
+	for i, key := range table.uniqueKeys() {
+		//!A WITHOUT ROWID table is stored in the b-tree of its primary key.
+		if stmt.WithoutRowid && isPrimaryKey(stmt, key.Columns) {
+			continue
+		}
+		if root, err = createBtree(pager, pageIndexLeaf); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("sqlite_autoindex_%s_%d", table.Name, i+1)
+		objects = append(objects, schemaObject{Type: "index", Name: name, TblName: table.Name, RootPage: root})
+	}
+	if table.Autoincrement && schema.table("sqlite_sequence") == nil {
+		if root, err = createBtree(pager, pageTableLeaf); err != nil {
+			return err
+		}
+		objects = append(objects, schemaObject{Type: "table", Name: "sqlite_sequence", TblName: "sqlite_sequence", RootPage: root, SQL: "CREATE TABLE sqlite_sequence(name,seq)"})
+	}
+	for _, object := range objects {
+		if err := insertSchemaObject(pager, object); err != nil {
+			return err
+		}
+	}
+	return changeSchema(pager)
+}
+
+// isPrimaryKey reports whether key is the PRIMARY KEY of the table.
+func isPrimaryKey(stmt *CreateTableStmt, key []IndexedColumn) bool {
+	var primaryKey []IndexedColumn
+	for _, column := range stmt.Columns {
+		if column.PrimaryKey {
+			primaryKey = []IndexedColumn{{Name: column.Name}}
+		}
+	}
+	for _, constraint := range stmt.Constraints {
+		if constraint.Kind == "PRIMARY KEY" {
+			primaryKey = constraint.Columns
+		}
+	}
+	if len(primaryKey) != len(key) {
+		return false
+	}
+	for i := range key {
+		if !strings.EqualFold(primaryKey[i].Name, key[i].Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTableDef applies the checks SQLite makes on the columns and constraints of a new table,
+// in the order it makes them.
+func checkTableDef(stmt *CreateTableStmt) error {
+	hasPrimaryKey := false
+	addPrimaryKey := func() error {
+		if hasPrimaryKey {
+			return &ErrPrepare{Msg: fmt.Sprintf("table \"%s\" has more than one primary key", stmt.Name)}
+		}
+		hasPrimaryKey = true
+		return nil
+	}
+	hasColumn := func(name string) bool {
+		for _, column := range stmt.Columns {
+			if strings.EqualFold(column.Name, name) {
+				return true
+			}
+		}
+		return false
+	}
+	autoincrement := false
+	for i, column := range stmt.Columns {
+		for _, earlier := range stmt.Columns[:i] {
+			if strings.EqualFold(earlier.Name, column.Name) {
+				return &ErrPrepare{Msg: "duplicate column name: " + column.Name}
+			}
+		}
+		if fk := column.References; fk != nil && len(fk.ParentColumns) > 1 {
+			return &ErrPrepare{Msg: fmt.Sprintf("foreign key on %s should reference only one column of table %s", column.Name, fk.Table), Pos: fk.TablePos}
+		}
+		if !column.PrimaryKey {
+			continue
+		}
+		if err := addPrimaryKey(); err != nil {
+			return err
+		}
+		if column.Autoincrement {
+			if !strings.EqualFold(column.Type, "INTEGER") || column.PrimaryDesc {
+				return &ErrPrepare{Msg: "AUTOINCREMENT is only allowed on an INTEGER PRIMARY KEY"}
+			}
+			autoincrement = true
+		}
+	}
+	for _, constraint := range stmt.Constraints {
+		switch constraint.Kind {
+		case "PRIMARY KEY", "UNIQUE":
+			if constraint.Kind == "PRIMARY KEY" {
+				if err := addPrimaryKey(); err != nil {
+					return err
+				}
+			}
+			for _, key := range constraint.Columns {
+				if key.Expr != nil {
+					return &ErrPrepare{Msg: "expressions prohibited in PRIMARY KEY and UNIQUE constraints"}
+				}
+				if !hasColumn(key.Name) {
+					return &ErrNoSuchColumn{Name: key.Name, Pos: key.Pos}
+				}
+			}
+		case "FOREIGN KEY":
+			fk := constraint.ForeignKey
+			if len(fk.ParentColumns) > 0 && len(fk.ParentColumns) != len(fk.Columns) {
+				return &ErrPrepare{Msg: "number of columns in foreign key does not match the number of columns in the referenced table"}
+			}
+			for _, name := range fk.Columns {
+				if !hasColumn(name) {
+					return &ErrPrepare{Msg: fmt.Sprintf("unknown column \"%s\" in foreign key definition", name)}
+				}
+			}
+		}
+	}
+	if stmt.Strict {
+		for _, column := range stmt.Columns {
+			switch {
+			case column.Type == "":
+				return &ErrPrepare{Msg: fmt.Sprintf("missing datatype for %s.%s", stmt.Name, column.Name)}
+			case !strictTypes[strings.ToUpper(column.Type)]:
+				return &ErrPrepare{Msg: fmt.Sprintf("unknown datatype for %s.%s: \"%s\"", stmt.Name, column.Name, column.Type)}
+			}
+		}
+	}
+	if stmt.WithoutRowid {
+		if autoincrement {
+			return &ErrPrepare{Msg: "AUTOINCREMENT not allowed on WITHOUT ROWID tables"}
+		}
+		if !hasPrimaryKey {
+			return &ErrPrepare{Msg: "PRIMARY KEY missing on table " + stmt.Name}
+		}
+	}
+	//!CHECK constraints and generated columns may refer to any column of the table.
+	table := tableFromDef(schemaObject{Name: stmt.Name}, stmt)
+	var exprs []Expr
+	for _, check := range table.Checks {
+		exprs = append(exprs, check.Expr)
+	}
+	for _, column := range stmt.Columns {
+		if column.Generated != nil {
+			exprs = append(exprs, column.Generated)
+		}
+	}
+	for _, expr := range exprs {
+		if err := bindColumns(expr, table, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeCreateIndex runs a CREATE INDEX. The entries of the existing rows are sorted and then
+// inserted in key order; for a UNIQUE index two equal keys without NULLs fail the statement.
+func executeCreateIndex(pager *Pager, schema *Schema, stmt *CreateIndexStmt) error {
+	table := schema.table(stmt.Table)
+	switch {
+	case isSchemaTable(stmt.Table):
+		return &ErrPrepare{Msg: "table sqlite_master may not be indexed"}
+	case table == nil:
+		return &ErrNoSuchTable{Name: "main." + stmt.Table}
+	case strings.HasPrefix(strings.ToLower(table.Name), "sqlite_"):
+		return &ErrPrepare{Msg: fmt.Sprintf("table %s may not be indexed", table.Name)}
+	case strings.HasPrefix(strings.ToLower(stmt.Name), "sqlite_"):
+		return &ErrPrepare{Msg: "object name reserved for internal use: " + stmt.Name}
+	case schema.table(stmt.Name) != nil:
+		return &ErrPrepare{Msg: "there is already a table named " + stmt.Name}
+	case schema.index(stmt.Name) != nil:
+		if stmt.IfNotExists {
+			return nil
+		}
+		return &ErrPrepare{Msg: fmt.Sprintf("index %s already exists", stmt.Name)}
+	}
+	if _, err := writableTable(schema, table.Name); err != nil {
+		return err
+	}
+	index := &Index{Name: stmt.Name, Table: table.Name, SQL: stmt.SQL, Keys: stmt.Columns, Unique: stmt.Unique, Where: stmt.Where}
+	for _, key := range stmt.Columns {
+		if key.Expr != nil {
+			if err := bindColumns(key.Expr, table, ""); err != nil {
+				return err
+			}
+		} else if table.columnIndex(key.Name) < 0 {
+			return &ErrNoSuchColumn{Name: key.Name, Pos: key.Pos}
+		}
+		index.Columns = append(index.Columns, key.Name)
+	}
+	if stmt.Where != nil {
+		if err := bindColumns(stmt.Where, table, ""); err != nil {
+			return err
+		}
+	}
+
+	rowids, records, err := readTable(pager, table.RootPage, map[int64]int64{})
+	if err != nil {
+		return err
+	}
+	if err := pager.beginWrite(); err != nil {
+		return err
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: table}
+	if index.RootPage, err = createBtree(pager, pageIndexLeaf); err == nil {
+		err = writer.buildIndex(index, rowids, records)
+	}
+	if err == nil {
+		err = insertSchemaObject(pager, schemaObject{Type: "index", Name: index.Name, TblName: table.Name, RootPage: index.RootPage, SQL: index.SQL})
+	}
+	if err == nil {
+		err = changeSchema(pager)
+	}
+	if err != nil {
+		pager.rollback()
+		return err
+	}
+	return pager.commit()
+}
+
+// buildIndex fills the empty b-tree of a new index with the entries of the given rows.
+func (writer *tableWriter) buildIndex(index *Index, rowids []int64, records [][]Value) error {
+	var entries [][]Value
+	for i, record := range records {
+		entry, ok, err := writer.indexEntry(index, tableRow(writer.table, rowids[i], record), rowids[i])
+		if err != nil {
+			return err
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	order := writer.indexOrder(index)
+	slices.SortStableFunc(entries, func(a, b []Value) int {
+		return compareIndexKeys(a, b, order, writer.pager.encoding)
+	})
+	keys := len(index.Keys)
+	for i, entry := range entries {
+		if index.Unique && i > 0 && compareIndexKeys(entries[i-1][:keys], entry[:keys], order, writer.pager.encoding) == 0 && !hasNull(entry[:keys]) {
+			return writer.uniqueError(index)
+		}
+		cell, err := indexCell(writer.pager, EncodeRecord(entry, writer.pager.encoding, writer.pager.header.SchemaFormat))
+		if err != nil {
+			return err
+		}
+		if err := btreeInsert(writer.pager, index.RootPage, cell, indexComparer(writer.pager, entry, order)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueError is the error SQLite reports when a row would duplicate the key of a UNIQUE
+// index: the indexed columns, or the index name when a key is an expression.
+func (writer *tableWriter) uniqueError(index *Index) error {
+	var columns []string
+	for _, key := range index.Keys {
+		if key.Expr != nil {
+			return &ErrResult{Code: resultConstraint, Msg: fmt.Sprintf("UNIQUE constraint failed: index '%s'", index.Name)}
+		}
+		columns = append(columns, writer.table.Name+"."+writer.table.Columns[writer.table.columnIndex(key.Name)].Name)
+	}
+	return &ErrResult{Code: resultConstraint, Msg: "UNIQUE constraint failed: " + strings.Join(columns, ", ")}
+}
+
+// hasNull reports whether any of the values is NULL; such keys never conflict.
+func hasNull(values []Value) bool {
+	for _, v := range values {
+		if v.Type == ValueNull {
+			return true
+		}
+	}
+	return false
+}
+
+// isSchemaTable reports whether name is one of the names of sqlite_schema.
+func isSchemaTable(name string) bool {
+	switch strings.ToLower(name) {
+	case "sqlite_schema", "sqlite_master":
+		return true
+	}
+	return false
+}
+
+// createBtree allocates the root page of a new, empty b-tree.
+func createBtree(pager *Pager, kind byte) (int64, error) {
+	root, _, err := pager.allocatePage()
+	if err != nil {
+		return 0, err
+	}
+	return root, writeNode(pager, &btreeNode{pageNo: root, kind: kind})
+}
+
+// insertSchemaObject adds a row to sqlite_schema after the existing ones.
+func insertSchemaObject(pager *Pager, object schemaObject) error {
+	rowid, err := maxRowid(pager, 1)
+	if err != nil {
+		return err
+	}
+	return writeSchemaObject(pager, rowid+1, object)
+}
+
+// writeSchemaObject stores the sqlite_schema row of an object under rowid, replacing the row
+// there. Automatic indexes have no SQL text and store NULL.
+func writeSchemaObject(pager *Pager, rowid int64, object schemaObject) error {
+	sql := Value{}
+	if object.SQL != "" {
+		sql = Value{Type: ValueText, Text: object.SQL}
+	}
+	record := EncodeRecord([]Value{
+		{Type: ValueText, Text: object.Type},
+		{Type: ValueText, Text: object.Name},
+		{Type: ValueText, Text: object.TblName},
+		{Type: ValueInteger, Int: object.RootPage},
+		sql,
+	}, pager.encoding, pager.header.SchemaFormat)
+	cell, err := tableLeafCell(pager, rowid, record)
+	if err != nil {
+		return err
+	}
+	return btreeInsert(pager, 1, cell, rowidComparer(rowid))
+}
+
+// changeSchema bumps the schema cookie, which tells other connections to reload the schema.
+// The first object created in a database also sets its schema format and text encoding.
+func changeSchema(pager *Pager) error {
+	header, err := pager.writablePage(1)
+	if err != nil {
+		return err
+	}
+	be := binary.BigEndian
+	be.PutUint32(header[40:], be.Uint32(header[40:])+1)
+	if be.Uint32(header[44:]) == 0 {
+		be.PutUint32(header[44:], 4)
+		be.PutUint32(header[56:], uint32(pager.encoding))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCreateIndexFollowsTextEncoding(t *testing.T) {
+	tests := []struct {
+		enc  TextEncoding
+		want []string
+	}{
+		{EncodingUTF8, []string{"Z", "z", "ā", "日本語", "𝄞"}},
+		{EncodingUTF16LE, []string{"ā", "𝄞", "Z", "z", "日本語"}},
+		{EncodingUTF16BE, []string{"Z", "z", "ā", "日本語", "𝄞"}},
+	}
+	for _, test := range tests {
+		t.Run(test.enc.String(), func(t *testing.T) {
+			path := newDatabase(t)
+			runSQL(t, path, "pragma encoding = '"+test.enc.String()+"'", "create table t(s)", "insert into t values ('z'), ('ā'), ('日本語'), ('𝄞'), ('Z')",
+				"create index ts on t(s)", "create index tr on t(s collate rtrim)")
+			checkIntegrity(t, path)
+			if got := indexText(t, path, "ts"); !slices.Equal(got, test.want) {
+				t.Errorf("index holds %q, want %q", got, test.want)
+			}
+		})
+	}
+}
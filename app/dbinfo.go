@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// dbInfoHeaderFields lists the 4 byte big-endian header fields reported by .dbinfo, in the
+// same order and with the same labels the sqlite3 shell uses.
+var dbInfoHeaderFields = []struct {
+	label  string
+	offset int
+}{
+	{"file change counter:", 24},
+	{"database page count:", 28},
+	{"freelist page count:", 36},
+	{"schema cookie:", 40},
+	{"schema format:", 44},
+	{"default cache size:", 48},
+	{"autovacuum top root:", 52},
+	{"incremental vacuum:", 64},
+	{"text encoding:", 56},
+	{"user version:", 60},
+	{"application id:", 68},
+	{"software version:", 96},
+}
+
+// textEncodingNames maps the header text encoding value to the name sqlite3 prints next to it.
+var textEncodingNames = map[uint32]string{
+	1: "utf8",
+	2: "utf16le",
+	3: "utf16be",
+}
+
+// showDbInfo prints the same report as the sqlite3 shell's .dbinfo command.
+func showDbInfo(out io.Writer, pager *Pager) error {
+	page, err := pager.page(1)
+	if err != nil {
+		return err
+	}
+	header := page[:100]
+
+	fmt.Fprintf(out, "%-20s %d\n", "database page size:", pager.pageSize)
+	fmt.Fprintf(out, "%-20s %d\n", "write format:", header[18])
+	fmt.Fprintf(out, "%-20s %d\n", "read format:", header[19])
+	fmt.Fprintf(out, "%-20s %d\n", "reserved bytes:", header[20])
+	for _, field := range dbInfoHeaderFields {
+		val := binary.BigEndian.Uint32(header[field.offset : field.offset+4])
+		fmt.Fprintf(out, "%-20s %d", field.label, val)
+		if name, ok := textEncodingNames[val]; ok && field.offset == 56 {
+			fmt.Fprintf(out, " (%s)", name)
+		}
+		fmt.Fprintln(out)
+	}
+
+	//!Everything below is counted from sqlite_schema, which may span several pages.
+	objects, err := readSchemaObjects(pager)
+	if err != nil {
+		return err
+	}
+	var tablesCount, indexesCount, triggersCount, viewsCount, schemaSize int
+	for _, object := range objects {
+		switch object.Type {
+		case "table":
+			tablesCount++
+		case "index":
+			indexesCount++
+		case "trigger":
+			triggersCount++
+		case "view":
+			viewsCount++
+		}
+		//!Auto indexes have a NULL sql column, which counts as empty.
+		schemaSize += utf8.RuneCountInString(object.SQL)
+	}
+	fmt.Fprintf(out, "%-20s %d\n", "number of tables:", tablesCount)
+	fmt.Fprintf(out, "%-20s %d\n", "number of indexes:", indexesCount)
+	fmt.Fprintf(out, "%-20s %d\n", "number of triggers:", triggersCount)
+	fmt.Fprintf(out, "%-20s %d\n", "number of views:", viewsCount)
+	fmt.Fprintf(out, "%-20s %d\n", "schema size:", schemaSize)
+	//!sqlite3 reports the pager's data version here, which starts at 1 for a fresh connection.
+	fmt.Fprintf(out, "%-20s %d\n", "data version", 1)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+// executeDelete runs a DELETE. Without a WHERE clause the table and its indexes are emptied
+// whole, unless foreign keys are enforced or rows are to be returned; otherwise the matching
+// rows are read first and then deleted one by one. It returns the rows of the RETURNING
+// clause, if any, with the values the rows had.
+func executeDelete(pager *Pager, schema *Schema, stmt *DeleteStmt) ([]string, [][]interface{}, error) {
+	table, err := writableTable(schema, stmt.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := bindWhere(stmt.Where, table); err != nil {
+		return nil, nil, err
+	}
+	returning, err := prepareReturning(table, stmt.Returning)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkForeignKeys(pager, schema, table, nil, true); err != nil {
+		return nil, nil, err
+	}
+	emptyAll := stmt.Where == nil && !pager.foreignKeys && returning == nil
+	var rowids []int64
+	if !emptyAll {
+		if rowids, _, err = matchRows(pager, schema, table, stmt.Where); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := pager.beginWrite(); err != nil {
+		return nil, nil, err
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: table, returning: returning}
+	if emptyAll {
+		roots := []int64{table.RootPage}
+		for _, index := range schema.indexesOn(table.Name) {
+			roots = append(roots, index.RootPage)
+		}
+		for _, root := range roots {
+			if err := btreeClear(pager, root); err != nil {
+				return nil, nil, writer.fail(err)
+			}
+		}
+		return writer.result()
+	}
+	for _, rowid := range rowids {
+		//!Rows are read again, as a foreign key action may have deleted or changed them.
+		row, found, err := writer.readRow(rowid)
+		if err == nil && found {
+			if err = writer.returnRow(row, rowid); err == nil {
+				err = writer.delete(row, rowid)
+			}
+		}
+		if err != nil {
+			return nil, nil, writer.fail(err)
+		}
+	}
+	return writer.result()
+}
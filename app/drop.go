@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// executeDrop runs a DROP TABLE or DROP INDEX. The rows of the dropped objects are deleted
+// from sqlite_schema and their pages go to the freelist; a table takes its indexes and its
+// sqlite_sequence row with it. With foreign keys enforced, a table referred to is emptied
+// first, failing when that leaves child rows without a parent.
+func executeDrop(pager *Pager, schema *Schema, stmt *DropStmt) error {
+	var roots []int64
+	var drops func(object schemaObject) bool
+	var table *Table
+	if stmt.Kind == "TABLE" {
+		table = schema.table(stmt.Name)
+		switch {
+		case isSchemaTable(stmt.Name):
+			return &ErrPrepare{Msg: "table sqlite_master may not be dropped"}
+		case table == nil:
+			if stmt.IfExists {
+				return nil
+			}
+			return &ErrNoSuchTable{Name: stmt.Name}
+		case !mayBeDropped(table.Name):
+			return &ErrPrepare{Msg: fmt.Sprintf("table %s may not be dropped", table.Name)}
+		}
+		roots = append(roots, table.RootPage)
+		for _, index := range schema.indexesOn(table.Name) {
+			roots = append(roots, index.RootPage)
+		}
+		drops = func(object schemaObject) bool {
+			return strings.EqualFold(object.TblName, table.Name)
+		}
+	} else {
+		index := schema.index(stmt.Name)
+		switch {
+		case index == nil:
+			if stmt.IfExists {
+				return nil
+			}
+			return &ErrPrepare{Msg: "no such index: " + stmt.Name}
+		case index.SQL == "":
+			return &ErrPrepare{Msg: "index associated with UNIQUE or PRIMARY KEY constraint cannot be dropped"}
+		}
+		roots = append(roots, index.RootPage)
+		drops = func(object schemaObject) bool {
+			return object.Type == "index" && strings.EqualFold(object.Name, index.Name)
+		}
+	}
+
+	if err := pager.beginWrite(); err != nil {
+		return err
+	}
+	var err error
+	if table != nil && dropsRows(pager, schema, table) {
+		err = emptyDroppedTable(pager, schema, table)
+	}
+	if err == nil {
+		err = deleteSchemaObjects(pager, drops)
+	}
+	if err == nil && table != nil && table.Autoincrement {
+		err = deleteSequence(pager, schema, table)
+	}
+	//!Like SQLite, free the b-tree with the highest root page first.
+	slices.Sort(roots)
+	for i := len(roots) - 1; i >= 0 && err == nil; i-- {
+		err = btreeDrop(pager, roots[i])
+	}
+	if err == nil {
+		err = changeSchema(pager)
+	}
+	if err != nil {
+		pager.rollback()
+		return err
+	}
+	return pager.commit()
+}
+
+// mayBeDropped reports whether a table can be dropped: internal tables other than the
+// statistics tables and sqlite_parameters cannot.
+func mayBeDropped(name string) bool {
+	lower := strings.ToLower(name)
+	if !strings.HasPrefix(lower, "sqlite_") {
+		return true
+	}
+	return strings.HasPrefix(lower, "sqlite_stat") || strings.HasPrefix(lower, "sqlite_parameters")
+}
+
+// deleteSchemaObjects deletes the rows of sqlite_schema that drops selects.
+func deleteSchemaObjects(pager *Pager, drops func(object schemaObject) bool) error {
+	rowids, rows, err := readTable(pager, 1, map[int64]int64{})
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if len(row) < 3 || !drops(schemaObject{Type: row[0].Text, Name: row[1].Text, TblName: row[2].Text}) {
+			continue
+		}
+		if _, err := btreeDelete(pager, 1, rowidComparer(rowids[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSequence removes the sqlite_sequence row of a dropped AUTOINCREMENT table.
+func deleteSequence(pager *Pager, schema *Schema, table *Table) error {
+	writer := &tableWriter{pager: pager, schema: schema, table: table}
+	rowid, _, err := writer.sequenceRow()
+	if err != nil || rowid == 0 {
+		return err
+	}
+	_, err = btreeDelete(pager, schema.table("sqlite_sequence").RootPage, rowidComparer(rowid))
+	return err
+}
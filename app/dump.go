@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dumpDatabase writes the schema and contents of the database as SQL text that stock sqlite3
+// can load back, like the sqlite3 .dump command. When patterns are given only the tables whose
+// name matches one of the LIKE patterns are dumped, together with their indexes and triggers.
+func dumpDatabase(out io.Writer, pager *Pager, schema *Schema, patterns []string) error {
+	objects := schema.Objects
+
+	selected := func(name string) bool {
+		if len(patterns) == 0 {
+			return true
+		}
+		for _, pattern := range patterns {
+			if likeMatch(pattern, name, 0) {
+				return true
+			}
+		}
+		return false
+	}
+
+	w := bufio.NewWriter(out)
+	w.WriteString("PRAGMA foreign_keys=OFF;\n")
+	w.WriteString("BEGIN TRANSACTION;\n")
+
+	//!Tables first in creation order, with sqlite_sequence last so its rows are restored after the
+	//!AUTOINCREMENT tables that recreate it.
+	var tables []schemaObject
+	var sequence *schemaObject
+	for i, object := range objects {
+		if object.Type != "table" || object.SQL == "" || !selected(object.TblName) {
+			continue
+		}
+		if object.Name == "sqlite_sequence" {
+			sequence = &objects[i]
+		} else if !strings.HasPrefix(object.Name, "sqlite_") {
+			tables = append(tables, object)
+		}
+	}
+	if sequence != nil {
+		tables = append(tables, *sequence)
+	}
+
+	for _, table := range tables {
+		if table.Name != "sqlite_sequence" {
+			createSQL := table.SQL
+			//!Like sqlite3, a quoted table name gets IF NOT EXISTS so the dump loads into a database
+			//!where the table already exists.
+			if len(createSQL) > 13 && strings.EqualFold(createSQL[:13], "CREATE TABLE ") && strings.ContainsAny(createSQL[13:14], `'"`) {
+				createSQL = "CREATE TABLE IF NOT EXISTS " + createSQL[13:]
+			}
+			w.WriteString(createSQL + ";\n")
+		}
+
+		parsed := schema.table(table.Name)
+		if parsed == nil {
+			return fmt.Errorf("dumping virtual table %s is not supported", table.Name)
+		}
+
+		ids, rows, err := readTable(pager, table.RootPage, map[int64]int64{})
+		if err != nil {
+			return err
+		}
+		insertPrefix := "INSERT INTO " + quoteIdentifier(table.Name) + " VALUES("
+		for i, record := range rows {
+			//!As SELECT sees it: with the defaults of columns added after the row was written,
+			//!and with REAL columns that store integers as such read back as reals.
+			row := tableRow(parsed, ids[i], record)
+			literals := make([]string, len(row))
+			for j, value := range row {
+				literals[j] = formatSQLLiteral(value)
+			}
+			w.WriteString(insertPrefix + strings.Join(literals, ",") + ");\n")
+		}
+	}
+
+	//!Views, triggers and then indexes, once the tables they depend on hold their rows.
+	for _, objectType := range []string{"view", "trigger", "index"} {
+		for _, object := range objects {
+			if object.Type == objectType && object.SQL != "" && selected(object.TblName) {
+				w.WriteString(object.SQL + ";\n")
+			}
+		}
+	}
+	w.WriteString("COMMIT;\n")
+	return w.Flush()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDumpRealColumns(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(id integer primary key, r real, x)",
+		"insert into t values (1, 3, 0), (2, 0, 1.5), (3, -7, 'a'), (4, null, 2)",
+		"alter table t add column y real default 4")
+	var inserts []string
+	for _, line := range strings.Split(runSQL(t, path, ".dump"), "\n") {
+		if strings.HasPrefix(line, "INSERT") {
+			inserts = append(inserts, line)
+		}
+	}
+	want := []string{
+		"INSERT INTO t VALUES(1,3.0,0,4.0);",
+		"INSERT INTO t VALUES(2,0.0,1.5,4.0);",
+		"INSERT INTO t VALUES(3,-7.0,'a',4.0);",
+		"INSERT INTO t VALUES(4,NULL,2,4.0);",
+	}
+	if strings.Join(inserts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("dump inserts\n%s\nwant\n%s", strings.Join(inserts, "\n"), strings.Join(want, "\n"))
+	}
+}
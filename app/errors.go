@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorrupt reports a database file that does not follow the file format. Page is the page
+// number the problem was found on (0 for the file header) and Offset the byte offset within it.
+type ErrCorrupt struct {
+	Page   int64
+	Offset int64
+	Reason string
+}
+
+func (e *ErrCorrupt) Error() string {
+	return fmt.Sprintf("database disk image is malformed (page %d, offset %d: %s)", e.Page, e.Offset, e.Reason)
+}
+
+// corruptError builds an ErrCorrupt, formatting the reason like fmt.Sprintf.
+func corruptError(page int64, offset int64, format string, args ...interface{}) error {
+	return &ErrCorrupt{Page: page, Offset: offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ErrNotADatabase is returned when the file header is not one this implementation can read.
+// Offset is the header offset of the offending field.
+type ErrNotADatabase struct {
+	Offset int64
+	Reason string
+}
+
+func (e *ErrNotADatabase) Error() string {
+	return fmt.Sprintf("file is not a database (offset %d: %s)", e.Offset, e.Reason)
+}
+
+// ErrCantOpen is returned when the database file cannot be opened. Err is the cause, which
+// sqlite3 does not show.
+type ErrCantOpen struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrCantOpen) Error() string {
+	return fmt.Sprintf("unable to open database \"%s\": unable to open database file", e.Path)
+}
+
+func (e *ErrCantOpen) Unwrap() error {
+	return e.Err
+}
+
+// ErrHotJournal is returned when a crashed writer left a journal that must be rolled back
+// before the database can be read, but the database was opened read-only.
+type ErrHotJournal struct {
+	Path string
+}
+
+func (e *ErrHotJournal) Error() string {
+	return fmt.Sprintf("attempt to write a readonly database (hot journal %s needs to be rolled back)", e.Path)
+}
+
+// ErrPrepare is an error SQLite finds while compiling a statement that needs no type of its
+// own, such as an INSERT supplying the wrong number of values. Err is the error it was found
+// from, if any, which decides the result code. Pos is the offset of the offending name in
+// the statement, 0 when there is none to point at, as no statement starts with a name.
+type ErrPrepare struct {
+	Msg string
+	Err error
+	Pos int
+}
+
+func (e *ErrPrepare) Error() string {
+	return e.Msg
+}
+
+func (e *ErrPrepare) Unwrap() error {
+	return e.Err
+}
+
+// Result codes SQLite reports for errors other than the generic SQLITE_ERROR.
+const (
+	resultBusy       = 5
+	resultLocked     = 6
+	resultReadOnly   = 8
+	resultCorrupt    = 11
+	resultFull       = 13
+	resultConstraint = 19
+	resultMismatch   = 20
+	resultNotADB     = 26
+)
+
+// ErrResult is an error found while running a statement that SQLite reports with a specific
+// result code, such as a constraint violation. The shell shows the code after the message.
+type ErrResult struct {
+	Code int
+	Msg  string
+}
+
+func (e *ErrResult) Error() string {
+	return e.Msg
+}
+
+// ErrConstraint is a constraint violation found while writing a row, with the conflict
+// resolution action it was resolved with, which decides how much of the work before it is
+// undone. Err is the ErrResult reported.
+type ErrConstraint struct {
+	Err    error
+	Action string //!"ABORT", "FAIL" or "ROLLBACK".
+}
+
+func (e *ErrConstraint) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ErrConstraint) Unwrap() error {
+	return e.Err
+}
+
+// resultCode returns the SQLite result code of an error, 1 for the generic SQLITE_ERROR.
+func resultCode(err error) int {
+	var resultErr *ErrResult
+	var corruptErr *ErrCorrupt
+	var notADBErr *ErrNotADatabase
+	switch {
+	case errors.As(err, &resultErr):
+		return resultErr.Code
+	case errors.Is(err, ErrReadOnly):
+		return resultReadOnly
+	case errors.As(err, &corruptErr):
+		return resultCorrupt
+	case errors.As(err, &notADBErr):
+		return resultNotADB
+	}
+	return 1
+}
+
+// ErrNoSuchTable is returned when a statement names a table that is not in sqlite_schema.
+type ErrNoSuchTable struct {
+	Name string
+}
+
+func (e *ErrNoSuchTable) Error() string {
+	return "no such table: " + e.Name
+}
+
+// ErrNoSuchColumn is returned when an expression refers to a column the table does not have.
+type ErrNoSuchColumn struct {
+	Name string
+	Pos  int
+}
+
+func (e *ErrNoSuchColumn) Error() string {
+	return "no such column: " + e.Name
+}
+
+// ErrSyntax is returned by the parser. Near is the text of the offending token, empty at the
+// end of the input.
+type ErrSyntax struct {
+	Near string
+	Pos  int
+	Msg  string
+}
+
+func (e *ErrSyntax) Error() string {
+	if e.Msg != "" {
+		return e.Msg
+	}
+	if e.Near == "" {
+		return "incomplete input"
+	}
+	return fmt.Sprintf("near \"%s\": syntax error", e.Near)
+}
+
+// errorPosition returns the statement offset an error points at, or -1. Like sqlite3, unknown
+// tables are reported without a position.
+func errorPosition(err error) int {
+	var syntaxErr *ErrSyntax
+	var columnErr *ErrNoSuchColumn
+	var prepareErr *ErrPrepare
+	switch {
+	case errors.As(err, &syntaxErr):
+		return syntaxErr.Pos
+	case errors.As(err, &columnErr):
+		return columnErr.Pos
+	case errors.As(err, &prepareErr) && prepareErr.Pos > 0:
+		return prepareErr.Pos
+	}
+	return -1
+}
+
+// isPrepareError reports whether err was found while compiling a statement rather than while
+// running it, which decides how the shell words the message.
+func isPrepareError(err error) bool {
+	var syntaxErr *ErrSyntax
+	var tableErr *ErrNoSuchTable
+	var columnErr *ErrNoSuchColumn
+	var notADatabaseErr *ErrNotADatabase
+	var hotJournalErr *ErrHotJournal
+	var prepareErr *ErrPrepare
+	return errors.As(err, &syntaxErr) || errors.As(err, &tableErr) || errors.As(err, &columnErr) ||
+		errors.As(err, &notADatabaseErr) || errors.As(err, &hotJournalErr) || errors.As(err, &prepareErr)
+}
@@ -0,0 +1,1386 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// evalContext supplies the row an expression is evaluated against.
+type evalContext struct {
+	table      *Table
+	values     []interface{}
+	rowid      int64
+	aggregates map[*FuncExpr]interface{} //!Final aggregate values, set when producing an aggregate row.
+	excluded   *evalContext              //!The row an upsert failed to insert, for "excluded." columns.
+	encoding   TextEncoding              //!The text encoding of the database, which orders BINARY text.
+}
+
+// walkExpr calls fn on expr and every expression nested in it, stopping at the first error.
+func walkExpr(expr Expr, fn func(Expr) error) error {
+	if expr == nil {
+		return nil
+	}
+	if err := fn(expr); err != nil {
+		return err
+	}
+	for _, child := range exprChildren(expr) {
+		if err := walkExpr(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exprChildren lists the operands of an expression in a fixed order, nil standing for an
+// optional one that was not given.
+func exprChildren(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		return []Expr{e.Operand}
+	case *BinaryExpr:
+		return []Expr{e.Left, e.Right}
+	case *LikeExpr:
+		return []Expr{e.Left, e.Pattern, e.Escape}
+	case *InExpr:
+		return append([]Expr{e.Operand}, e.List...)
+	case *BetweenExpr:
+		return []Expr{e.Operand, e.Low, e.High}
+	case *FuncExpr:
+		return e.Args
+	case *CaseExpr:
+		children := []Expr{e.Operand, e.Else}
+		for _, clause := range e.Whens {
+			children = append(children, clause.When, clause.Then)
+		}
+		return children
+	case *CastExpr:
+		return []Expr{e.Operand}
+	case *CollateExpr:
+		return []Expr{e.Operand}
+	}
+	return nil
+}
+
+// bindColumns resolves every column reference in expr against table, which may be nil for a
+// SELECT without FROM. alias is the name the table was given in the FROM clause.
+func bindColumns(expr Expr, table *Table, alias string) error {
+	return walkExpr(expr, func(e Expr) error {
+		if column, ok := e.(*ColumnExpr); ok {
+			return bindColumn(column, table, alias)
+		}
+		return nil
+	})
+}
+
+// bindColumn resolves one column reference like bindColumns.
+func bindColumn(column *ColumnExpr, table *Table, alias string) error {
+	fullName := column.Name
+	if column.Table != "" {
+		fullName = column.Table + "." + column.Name
+	}
+	if table == nil {
+		return &ErrNoSuchColumn{Name: fullName, Pos: column.P}
+	}
+	if column.Table != "" && !tableNameMatches(column.Table, table, alias) {
+		return &ErrNoSuchColumn{Name: fullName, Pos: column.P}
+	}
+	if index := table.columnIndex(column.Name); index >= 0 {
+		column.Index, column.Collate = index, table.Columns[index].Collate
+		return nil
+	}
+	switch strings.ToLower(column.Name) {
+	case "rowid", "oid", "_rowid_":
+		column.Index, column.Collate = -1, ""
+		return nil
+	}
+	return &ErrNoSuchColumn{Name: fullName, Pos: column.P}
+}
+
+// tableNameMatches reports whether a qualifier names the table. Once the table is given an
+// alias, only the alias refers to it.
+func tableNameMatches(qualifier string, table *Table, alias string) bool {
+	if alias != "" {
+		return strings.EqualFold(qualifier, alias)
+	}
+	return strings.EqualFold(qualifier, table.Name)
+}
+
+// aggregateFunctions are the functions that fold a whole group of rows into one value. min
+// and max are only aggregates when called with a single argument.
+var aggregateFunctions = map[string]bool{
+	"count": true, "sum": true, "total": true, "avg": true, "min": true, "max": true, "group_concat": true,
+}
+
+func isAggregate(fn *FuncExpr) bool {
+	if !aggregateFunctions[fn.Name] {
+		return false
+	}
+	return (fn.Name != "min" && fn.Name != "max") || len(fn.Args) == 1
+}
+
+// collectAggregates returns the aggregate calls in expr, rejecting nested aggregates.
+func collectAggregates(expr Expr) ([]*FuncExpr, error) {
+	var found []*FuncExpr
+	err := walkExpr(expr, func(e Expr) error {
+		fn, ok := e.(*FuncExpr)
+		if !ok || !isAggregate(fn) {
+			return nil
+		}
+		for _, arg := range fn.Args {
+			inner, err := collectAggregates(arg)
+			if err != nil {
+				return err
+			}
+			if len(inner) > 0 {
+				return &ErrSyntax{Pos: inner[0].P, Msg: fmt.Sprintf("misuse of aggregate function %s()", inner[0].Name)}
+			}
+		}
+		found = append(found, fn)
+		return nil
+	})
+	return found, err
+}
+
+// valueTypeName is what typeof() reports for a value.
+func valueTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case int64:
+		return "integer"
+	case float64:
+		return "real"
+	case string:
+		return "text"
+	}
+	return "blob"
+}
+
+// typeOrder ranks storage classes in SQLite's sort order: NULL, numbers, text, blobs.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+// compareValues orders two values the way SQLite sorts them, comparing text with the named
+// collation ("" and "BINARY" compare the bytes of the text in the database encoding enc;
+// NOCASE and RTRIM, which SQLite only defines for UTF-8, compare it as UTF-8).
+func compareValues(a interface{}, b interface{}, collation string, enc TextEncoding) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return ta - tb
+	}
+	switch x := a.(type) {
+	case nil:
+		return 0
+	case int64:
+		if y, ok := b.(int64); ok {
+			return compareInts(x, y)
+		}
+		return -compareIntFloat(b.(float64), x)
+	case float64:
+		if y, ok := b.(int64); ok {
+			return compareIntFloat(x, y)
+		}
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		y := b.(string)
+		switch collation {
+		case "NOCASE":
+			return strings.Compare(foldASCIIString(x), foldASCIIString(y))
+		case "RTRIM":
+			return strings.Compare(strings.TrimRight(x, " "), strings.TrimRight(y, " "))
+		}
+		return compareText(x, y, enc)
+	}
+	return bytes.Compare(a.([]byte), b.([]byte))
+}
+
+func compareInts(x int64, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat compares a real with an integer without losing the integer's precision.
+func compareIntFloat(x float64, y int64) int {
+	switch {
+	case math.IsNaN(x):
+		return -1
+	case x < -9223372036854775808.0:
+		return -1
+	case x >= 9223372036854775808.0:
+		return 1
+	}
+	if c := compareInts(int64(x), y); c != 0 {
+		return c
+	}
+	frac := x - math.Trunc(x)
+	switch {
+	case frac > 0:
+		return 1
+	case frac < 0:
+		return -1
+	}
+	return 0
+}
+
+func foldASCIIString(s string) string {
+	return strings.Map(foldASCII, s)
+}
+
+func foldASCII(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+// parseNumericText converts text that is entirely a well-formed number, ignoring surrounding
+// spaces, to an int64 or float64.
+func parseNumericText(s string) (interface{}, bool) {
+	s = strings.Trim(s, " \t\n\r\f\v")
+	if s == "" {
+		return nil, false
+	}
+	body := strings.TrimLeft(s, "+-")
+	if len(s)-len(body) > 1 || body == "" || scanNumber(body) != len(body) || strings.HasPrefix(strings.ToLower(body), "0x") {
+		return nil, false
+	}
+	if !strings.ContainsAny(body, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, true
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !math.IsInf(f, 0) {
+		return nil, false
+	}
+	return f, true
+}
+
+// numericPrefix converts the longest numeric prefix of s, as CAST and arithmetic do; text
+// with no numeric prefix is 0.
+func numericPrefix(s string) interface{} {
+	s = strings.TrimLeft(s, " \t\n\r\f\v")
+	sign := 0
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		sign = 1
+	}
+	n := 0
+	if sign < len(s) && (s[sign] >= '0' && s[sign] <= '9' || s[sign] == '.') && !strings.HasPrefix(strings.ToLower(s[sign:]), "0x") {
+		n = scanNumber(s[sign:])
+	}
+	if n == 0 || s[sign:sign+n] == "." {
+		return int64(0)
+	}
+	if v, ok := parseNumericText(s[:sign+n]); ok {
+		return v
+	}
+	return int64(0)
+}
+
+// toNumeric converts a value for arithmetic.
+func toNumeric(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		return numericPrefix(x)
+	case []byte:
+		return numericPrefix(string(x))
+	}
+	return v
+}
+
+// toInteger converts a value the way CAST(x AS INTEGER) does.
+func toInteger(v interface{}) interface{} {
+	switch x := toNumeric(v).(type) {
+	case float64:
+		return floatToInt(x)
+	case int64:
+		return x
+	}
+	return nil
+}
+
+func floatToInt(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= -9223372036854775808.0:
+		return math.MinInt64
+	case f >= 9223372036854775807.0:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+// toText converts a value to its text representation, or nil for NULL.
+func toText(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return string(x)
+	}
+	return formatValueText(v, "")
+}
+
+// applyAffinity converts a value as it would be when stored in a column of the given affinity.
+func applyAffinity(v interface{}, affinity Affinity) interface{} {
+	switch affinity {
+	case AffinityText:
+		switch v.(type) {
+		case int64, float64:
+			return toText(v)
+		}
+	case AffinityNumeric, AffinityInteger, AffinityReal:
+		if s, ok := v.(string); ok {
+			if n, ok := parseNumericText(s); ok {
+				v = n
+			}
+		}
+		switch x := v.(type) {
+		case float64:
+			if affinity != AffinityReal && x == math.Trunc(x) && math.Abs(x) < 9.2e18 {
+				return int64(x)
+			}
+		case int64:
+			if affinity == AffinityReal {
+				return float64(x)
+			}
+		}
+	}
+	return v
+}
+
+// exprAffinity is the affinity an operand brings to a comparison; ok is false for operands
+// without one, such as literals.
+func exprAffinity(expr Expr, ctx *evalContext) (Affinity, bool) {
+	switch e := expr.(type) {
+	case *ColumnExpr:
+		if e.Index < 0 || ctx.table == nil {
+			return AffinityInteger, true
+		}
+		return ctx.table.Columns[e.Index].Affinity, true
+	case *CastExpr:
+		return typeAffinity(e.Type), true
+	case *CollateExpr:
+		return exprAffinity(e.Operand, ctx)
+	}
+	return AffinityBlob, false
+}
+
+func isNumericAffinity(affinity Affinity) bool {
+	return affinity == AffinityNumeric || affinity == AffinityInteger || affinity == AffinityReal
+}
+
+// comparisonOperands applies SQLite's affinity rules to the two sides of a comparison.
+func comparisonOperands(leftExpr Expr, rightExpr Expr, left interface{}, right interface{}, ctx *evalContext) (interface{}, interface{}) {
+	la, lok := exprAffinity(leftExpr, ctx)
+	ra, rok := exprAffinity(rightExpr, ctx)
+	switch {
+	case lok && isNumericAffinity(la) && !(rok && isNumericAffinity(ra)):
+		right = applyAffinity(right, AffinityNumeric)
+	case rok && isNumericAffinity(ra) && !(lok && isNumericAffinity(la)):
+		left = applyAffinity(left, AffinityNumeric)
+	case lok && la == AffinityText && !rok:
+		right = applyAffinity(right, AffinityText)
+	case rok && ra == AffinityText && !lok:
+		left = applyAffinity(left, AffinityText)
+	}
+	return left, right
+}
+
+// exprCollation returns the collation a comparison of the operands uses: the first one an
+// explicit COLLATE clause gives, else the declared collation of the first operand that is a
+// column, else BINARY ("").
+func exprCollation(exprs ...Expr) string {
+	for _, expr := range exprs {
+		if collate, ok := expr.(*CollateExpr); ok {
+			return collate.Collation
+		}
+	}
+	for _, expr := range exprs {
+		if column, ok := expr.(*ColumnExpr); ok && column.Collate != "" {
+			return column.Collate
+		}
+	}
+	return ""
+}
+
+// truth interprets a value as a boolean; ok is false for NULL.
+func truth(v interface{}) (bool, bool) {
+	switch x := toNumeric(v).(type) {
+	case int64:
+		return x != 0, true
+	case float64:
+		return x != 0, true
+	}
+	return false, false
+}
+
+func boolValue(b bool) interface{} {
+	if b {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// evalExpr evaluates an expression against the current row.
+func evalExpr(expr Expr, ctx *evalContext) (interface{}, error) {
+	switch e := expr.(type) {
+	case *LiteralExpr:
+		return e.Value, nil
+	case *ColumnExpr:
+		if e.Excluded {
+			ctx = ctx.excluded
+		}
+		if e.Index < 0 {
+			return ctx.rowid, nil
+		}
+		if e.Index >= len(ctx.values) {
+			return nil, nil
+		}
+		return ctx.values[e.Index], nil
+	case *CollateExpr:
+		return evalExpr(e.Operand, ctx)
+	case *UnaryExpr:
+		return evalUnary(e, ctx)
+	case *BinaryExpr:
+		return evalBinary(e, ctx)
+	case *LikeExpr:
+		return evalLike(e, ctx)
+	case *InExpr:
+		operand, err := evalExpr(e.Operand, ctx)
+		if err != nil || operand == nil {
+			return nil, err
+		}
+		sawNull := false
+		for _, item := range e.List {
+			v, err := evalExpr(item, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				sawNull = true
+				continue
+			}
+			left, right := comparisonOperands(e.Operand, item, operand, v, ctx)
+			if compareValues(left, right, exprCollation(e.Operand, item), ctx.encoding) == 0 {
+				return boolValue(!e.Not), nil
+			}
+		}
+		if sawNull {
+			return nil, nil
+		}
+		return boolValue(e.Not), nil
+	case *BetweenExpr:
+		low := &BinaryExpr{Op: ">=", Left: e.Operand, Right: e.Low, P: e.P}
+		high := &BinaryExpr{Op: "<=", Left: e.Operand, Right: e.High, P: e.P}
+		var result Expr = &BinaryExpr{Op: "AND", Left: low, Right: high, P: e.P}
+		if e.Not {
+			result = &UnaryExpr{Op: "NOT", Operand: result, P: e.P}
+		}
+		return evalExpr(result, ctx)
+	case *CaseExpr:
+		var operand interface{}
+		if e.Operand != nil {
+			var err error
+			if operand, err = evalExpr(e.Operand, ctx); err != nil {
+				return nil, err
+			}
+		}
+		for _, clause := range e.Whens {
+			when, err := evalExpr(clause.When, ctx)
+			if err != nil {
+				return nil, err
+			}
+			var matched bool
+			if e.Operand != nil {
+				left, right := comparisonOperands(e.Operand, clause.When, operand, when, ctx)
+				matched = operand != nil && when != nil && compareValues(left, right, exprCollation(e.Operand, clause.When), ctx.encoding) == 0
+			} else {
+				matched, _ = truth(when)
+			}
+			if matched {
+				return evalExpr(clause.Then, ctx)
+			}
+		}
+		if e.Else != nil {
+			return evalExpr(e.Else, ctx)
+		}
+		return nil, nil
+	case *CastExpr:
+		v, err := evalExpr(e.Operand, ctx)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return castValue(v, e.Type), nil
+	case *FuncExpr:
+		if isAggregate(e) {
+			if ctx.aggregates == nil {
+				return nil, &ErrSyntax{Pos: e.P, Msg: fmt.Sprintf("misuse of aggregate: %s()", e.Name)}
+			}
+			return ctx.aggregates[e], nil
+		}
+		return evalFunction(e, ctx)
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+// castValue implements CAST(v AS typeName) for a non-NULL value.
+func castValue(v interface{}, typeName string) interface{} {
+	switch typeAffinity(typeName) {
+	case AffinityInteger:
+		return toInteger(v)
+	case AffinityReal:
+		switch x := toNumeric(v).(type) {
+		case int64:
+			return float64(x)
+		case float64:
+			return x
+		}
+	case AffinityText:
+		return toText(v)
+	case AffinityNumeric:
+		n := toNumeric(v)
+		if f, ok := n.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 9.2e18 {
+			return int64(f)
+		}
+		return n
+	case AffinityBlob:
+		switch x := v.(type) {
+		case []byte:
+			return x
+		}
+		return []byte(toText(v).(string))
+	}
+	return v
+}
+
+func evalUnary(e *UnaryExpr, ctx *evalContext) (interface{}, error) {
+	v, err := evalExpr(e.Operand, ctx)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	switch e.Op {
+	case "NOT":
+		b, _ := truth(v)
+		return boolValue(!b), nil
+	case "-":
+		switch x := toNumeric(v).(type) {
+		case int64:
+			if x == math.MinInt64 {
+				return -float64(x), nil
+			}
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+	case "~":
+		return ^toInteger(v).(int64), nil
+	}
+	return v, nil
+}
+
+func evalBinary(e *BinaryExpr, ctx *evalContext) (interface{}, error) {
+	left, err := evalExpr(e.Left, ctx)
+	if err != nil {
+		return nil, err
+	}
+	//!AND and OR short-circuit and use three-valued logic.
+	switch e.Op {
+	case "AND", "OR":
+		lb, lok := truth(left)
+		if lok && lb == (e.Op == "OR") {
+			return boolValue(lb), nil
+		}
+		right, err := evalExpr(e.Right, ctx)
+		if err != nil {
+			return nil, err
+		}
+		rb, rok := truth(right)
+		if rok && rb == (e.Op == "OR") {
+			return boolValue(rb), nil
+		}
+		if !lok || !rok {
+			return nil, nil
+		}
+		return boolValue(lb), nil
+	}
+	right, err := evalExpr(e.Right, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case "IS", "IS NOT":
+		left, right = comparisonOperands(e.Left, e.Right, left, right, ctx)
+		equal := (left == nil && right == nil) ||
+			(left != nil && right != nil && compareValues(left, right, exprCollation(e.Left, e.Right), ctx.encoding) == 0)
+		return boolValue(equal == (e.Op == "IS")), nil
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	switch e.Op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		left, right = comparisonOperands(e.Left, e.Right, left, right, ctx)
+		c := compareValues(left, right, exprCollation(e.Left, e.Right), ctx.encoding)
+		switch e.Op {
+		case "=":
+			return boolValue(c == 0), nil
+		case "!=":
+			return boolValue(c != 0), nil
+		case "<":
+			return boolValue(c < 0), nil
+		case "<=":
+			return boolValue(c <= 0), nil
+		case ">":
+			return boolValue(c > 0), nil
+		}
+		return boolValue(c >= 0), nil
+	case "||":
+		return toText(left).(string) + toText(right).(string), nil
+	case "&", "|", "<<", ">>":
+		return bitwise(e.Op, toInteger(left).(int64), toInteger(right).(int64)), nil
+	}
+	return arithmetic(e.Op, toNumeric(left), toNumeric(right)), nil
+}
+
+func bitwise(op string, a int64, b int64) int64 {
+	switch op {
+	case "&":
+		return a & b
+	case "|":
+		return a | b
+	case ">>":
+		b = -b
+	}
+	//!Shifts by a negative amount go the other way; shifting 64 or more bits saturates.
+	switch {
+	case b >= 64:
+		return 0
+	case b >= 0:
+		return a << uint(b)
+	case b <= -64:
+		if a < 0 {
+			return -1
+		}
+		return 0
+	}
+	return a >> uint(-b)
+}
+
+// arithmetic applies +, -, *, / or % to two numbers. Integer results that overflow become
+// reals, and division by zero is NULL.
+func arithmetic(op string, a interface{}, b interface{}) interface{} {
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		switch op {
+		case "+":
+			if r := x + y; (r > x) == (y > 0) {
+				return r
+			}
+		case "-":
+			if r := x - y; (r < x) == (y > 0) {
+				return r
+			}
+		case "*":
+			if x == 0 || y == 0 {
+				return int64(0)
+			}
+			if r := x * y; r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+				return r
+			}
+		case "/":
+			if y == 0 {
+				return nil
+			}
+			if x == math.MinInt64 && y == -1 {
+				return -float64(x)
+			}
+			return x / y
+		case "%":
+			if y == 0 {
+				return nil
+			}
+			if y == -1 {
+				return int64(0)
+			}
+			return x % y
+		}
+	}
+	fx, fy := toFloat(a), toFloat(b)
+	switch op {
+	case "+":
+		return fx + fy
+	case "-":
+		return fx - fy
+	case "*":
+		return fx * fy
+	case "/":
+		if fy == 0 {
+			return nil
+		}
+		return fx / fy
+	}
+	iy := floatToInt(fy)
+	if iy == 0 {
+		return nil
+	}
+	if iy == -1 {
+		return float64(0)
+	}
+	return math.Mod(fx, float64(iy))
+}
+
+func toFloat(v interface{}) float64 {
+	switch x := v.(type) {
+	case int64:
+		return float64(x)
+	case float64:
+		return x
+	}
+	return 0
+}
+
+func evalLike(e *LikeExpr, ctx *evalContext) (interface{}, error) {
+	left, err := evalExpr(e.Left, ctx)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := evalExpr(e.Pattern, ctx)
+	if err != nil {
+		return nil, err
+	}
+	var escape rune
+	if e.Escape != nil {
+		v, err := evalExpr(e.Escape, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, nil
+		}
+		s := toText(v).(string)
+		if utf8.RuneCountInString(s) != 1 {
+			return nil, fmt.Errorf("ESCAPE expression must be a single character")
+		}
+		escape, _ = utf8.DecodeRuneInString(s)
+	}
+	if left == nil || pattern == nil {
+		return nil, nil
+	}
+	var matched bool
+	if e.Op == "GLOB" {
+		matched = globMatch(toText(pattern).(string), toText(left).(string))
+	} else {
+		matched = likeMatch(toText(pattern).(string), toText(left).(string), escape)
+	}
+	return boolValue(matched != e.Not), nil
+}
+
+// likeMatch reports whether s matches the SQL LIKE pattern, where % matches any run of
+// characters and _ any single character. Like SQLite, ASCII letters match case-insensitively.
+// escape, when non-zero, makes the character following it match literally.
+func likeMatch(pattern string, s string, escape rune) bool {
+	p, str := []rune(pattern), []rune(s)
+	for len(p) > 0 {
+		switch {
+		case p[0] == escape && escape != 0:
+			if len(p) < 2 || len(str) == 0 || foldASCII(p[1]) != foldASCII(str[0]) {
+				return false
+			}
+			p = p[1:]
+		case p[0] == '%':
+			for len(p) > 0 && p[0] == '%' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if likeMatch(string(p), string(str[i:]), escape) {
+					return true
+				}
+			}
+			return false
+		case p[0] == '_':
+			if len(str) == 0 {
+				return false
+			}
+		default:
+			if len(str) == 0 || foldASCII(p[0]) != foldASCII(str[0]) {
+				return false
+			}
+		}
+		p, str = p[1:], str[1:]
+	}
+	return len(str) == 0
+}
+
+// globMatch reports whether s matches the GLOB pattern: * matches any run, ? any character and
+// [...] a character class, all case-sensitively.
+func globMatch(pattern string, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(string(p), string(str[i:])) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			p = p[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			end := 1
+			if end < len(p) && p[end] == '^' {
+				end++
+			}
+			if end < len(p) && p[end] == ']' {
+				end++
+			}
+			for end < len(p) && p[end] != ']' {
+				end++
+			}
+			if end >= len(p) {
+				return false
+			}
+			if !globClassMatch(p[1:end], str[0]) {
+				return false
+			}
+			p = p[end+1:]
+		default:
+			if len(str) == 0 || p[0] != str[0] {
+				return false
+			}
+			p = p[1:]
+		}
+		str = str[1:]
+	}
+	return len(str) == 0
+}
+
+func globClassMatch(class []rune, c rune) bool {
+	invert := len(class) > 0 && class[0] == '^'
+	if invert {
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				matched = true
+			}
+			i += 2
+		} else if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != invert
+}
+
+// scalarFunction describes a built-in function: its argument count bounds (maxArgs -1 means
+// unlimited) and implementation over already evaluated arguments.
+type scalarFunction struct {
+	minArgs int
+	maxArgs int
+	call    func(args []interface{}) (interface{}, error)
+}
+
+var scalarFunctions map[string]scalarFunction
+
+func init() {
+	scalarFunctions = map[string]scalarFunction{
+		"length": {1, 1, func(args []interface{}) (interface{}, error) {
+			switch x := args[0].(type) {
+			case nil:
+				return nil, nil
+			case []byte:
+				return int64(len(x)), nil
+			case string:
+				return int64(utf8.RuneCountInString(x)), nil
+			}
+			return int64(len(toText(args[0]).(string))), nil
+		}},
+		"upper": {1, 1, func(args []interface{}) (interface{}, error) {
+			return mapText(args[0], func(s string) string {
+				return strings.Map(func(r rune) rune {
+					if r >= 'a' && r <= 'z' {
+						return r - 'a' + 'A'
+					}
+					return r
+				}, s)
+			}), nil
+		}},
+		"lower": {1, 1, func(args []interface{}) (interface{}, error) {
+			return mapText(args[0], foldASCIIString), nil
+		}},
+		"abs": {1, 1, func(args []interface{}) (interface{}, error) {
+			switch x := toNumeric(args[0]).(type) {
+			case int64:
+				if x == math.MinInt64 {
+					return nil, fmt.Errorf("integer overflow")
+				}
+				if x < 0 {
+					return -x, nil
+				}
+				return x, nil
+			case float64:
+				return math.Abs(x), nil
+			}
+			return nil, nil
+		}},
+		"coalesce": {2, -1, firstNonNull},
+		"ifnull":   {2, 2, firstNonNull},
+		"nullif": {2, 2, func(args []interface{}) (interface{}, error) {
+			if args[0] != nil && args[1] != nil && compareValues(args[0], args[1], "", EncodingUTF8) == 0 {
+				return nil, nil
+			}
+			return args[0], nil
+		}},
+		"iif": {3, 3, func(args []interface{}) (interface{}, error) {
+			if b, _ := truth(args[0]); b {
+				return args[1], nil
+			}
+			return args[2], nil
+		}},
+		"typeof": {1, 1, func(args []interface{}) (interface{}, error) {
+			return valueTypeName(args[0]), nil
+		}},
+		"substr": {2, 3, substr},
+		"trim": {1, 2, func(args []interface{}) (interface{}, error) {
+			return trimFunction(args, strings.Trim)
+		}},
+		"ltrim": {1, 2, func(args []interface{}) (interface{}, error) {
+			return trimFunction(args, strings.TrimLeft)
+		}},
+		"rtrim": {1, 2, func(args []interface{}) (interface{}, error) {
+			return trimFunction(args, strings.TrimRight)
+		}},
+		"replace": {3, 3, func(args []interface{}) (interface{}, error) {
+			if args[0] == nil || args[1] == nil || args[2] == nil {
+				return nil, nil
+			}
+			s, old := toText(args[0]).(string), toText(args[1]).(string)
+			if old == "" {
+				return s, nil
+			}
+			return strings.ReplaceAll(s, old, toText(args[2]).(string)), nil
+		}},
+		"instr": {2, 2, func(args []interface{}) (interface{}, error) {
+			if args[0] == nil || args[1] == nil {
+				return nil, nil
+			}
+			if haystack, ok := args[0].([]byte); ok {
+				needle, _ := args[1].([]byte)
+				return int64(bytes.Index(haystack, needle) + 1), nil
+			}
+			s, needle := toText(args[0]).(string), toText(args[1]).(string)
+			i := strings.Index(s, needle)
+			if i < 0 {
+				return int64(0), nil
+			}
+			return int64(utf8.RuneCountInString(s[:i]) + 1), nil
+		}},
+		"round": {1, 2, func(args []interface{}) (interface{}, error) {
+			if args[0] == nil || (len(args) > 1 && args[1] == nil) {
+				return nil, nil
+			}
+			digits := int64(0)
+			if len(args) > 1 {
+				digits = toInteger(args[1]).(int64)
+			}
+			if digits < 0 {
+				digits = 0
+			}
+			if digits > 30 {
+				digits = 30
+			}
+			return roundHalfAway(toFloat(toNumeric(args[0])), int(digits)), nil
+		}},
+		"hex": {1, 1, func(args []interface{}) (interface{}, error) {
+			switch x := args[0].(type) {
+			case nil:
+				return "", nil
+			case []byte:
+				return strings.ToUpper(hex.EncodeToString(x)), nil
+			}
+			return strings.ToUpper(hex.EncodeToString([]byte(toText(args[0]).(string)))), nil
+		}},
+		"quote": {1, 1, func(args []interface{}) (interface{}, error) {
+			return formatSQLLiteral(args[0]), nil
+		}},
+		"min": {2, -1, nil}, //!Called by evalFunction, as ordering text depends on the database encoding.
+		"max": {2, -1, nil},
+		"char": {0, -1, func(args []interface{}) (interface{}, error) {
+			var sb strings.Builder
+			for _, arg := range args {
+				if code, ok := toInteger(arg).(int64); ok && code >= 0 && code <= utf8.MaxRune {
+					sb.WriteRune(rune(code))
+				} else {
+					sb.WriteRune(utf8.RuneError)
+				}
+			}
+			return sb.String(), nil
+		}},
+		"unicode": {1, 1, func(args []interface{}) (interface{}, error) {
+			if args[0] == nil {
+				return nil, nil
+			}
+			s := toText(args[0]).(string)
+			if s == "" {
+				return nil, nil
+			}
+			r, _ := utf8.DecodeRuneInString(s)
+			return int64(r), nil
+		}},
+	}
+	scalarFunctions["substring"] = scalarFunctions["substr"]
+}
+
+// roundHalfAway rounds x to the given number of decimal places, rounding halves away from
+// zero. Like SQLite it looks at the exact binary value, so round(2.675, 2) is 2.67.
+func roundHalfAway(x float64, digits int) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) || math.Abs(x) >= 4503599627370496 {
+		return x
+	}
+	text := strings.TrimRight(strconv.FormatFloat(math.Abs(x), 'f', digits+30, 64), "0")
+	intPart, frac, _ := strings.Cut(text, ".")
+	if len(frac) <= digits {
+		return x
+	}
+	kept := []byte(intPart + frac[:digits])
+	if frac[digits] >= '5' {
+		i := len(kept) - 1
+		for ; i >= 0 && kept[i] == '9'; i-- {
+			kept[i] = '0'
+		}
+		if i < 0 {
+			kept = append([]byte{'1'}, kept...)
+		} else {
+			kept[i]++
+		}
+	}
+	point := len(kept) - digits
+	rounded, _ := strconv.ParseFloat(string(kept[:point])+"."+string(kept[point:]), 64)
+	if x < 0 {
+		return -rounded
+	}
+	return rounded
+}
+
+func mapText(v interface{}, fn func(string) string) interface{} {
+	if v == nil {
+		return nil
+	}
+	return fn(toText(v).(string))
+}
+
+func firstNonNull(args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+// extremum returns the smallest (sign -1) or largest (sign 1) argument, or NULL if any
+// argument is NULL. Text is ordered by its bytes in the database encoding enc.
+func extremum(args []interface{}, sign int, enc TextEncoding) interface{} {
+	best := args[0]
+	for _, arg := range args {
+		if arg == nil {
+			return nil
+		}
+		if compareValues(arg, best, "", enc)*sign > 0 {
+			best = arg
+		}
+	}
+	return best
+}
+
+func trimFunction(args []interface{}, trim func(string, string) string) (interface{}, error) {
+	if args[0] == nil || (len(args) > 1 && args[1] == nil) {
+		return nil, nil
+	}
+	cutset := " "
+	if len(args) > 1 {
+		cutset = toText(args[1]).(string)
+	}
+	return trim(toText(args[0]).(string), cutset), nil
+}
+
+// substr follows SQLite's substr(X, Y, Z): Y is 1-based, negative Y counts from the end and
+// a negative Z takes characters before Y. Blobs are sliced by bytes, text by characters.
+func substr(args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg == nil {
+			return nil, nil
+		}
+	}
+	blob, isBlob := args[0].([]byte)
+	var chars []rune
+	length := int64(len(blob))
+	if !isBlob {
+		chars = []rune(toText(args[0]).(string))
+		length = int64(len(chars))
+	}
+	start := toInteger(args[1]).(int64)
+	count := length + 1
+	if len(args) > 2 {
+		count = toInteger(args[2]).(int64)
+	}
+	if start < 0 {
+		start += length
+		if start < 0 {
+			count += start
+			start = 0
+		}
+	} else if start > 0 {
+		start--
+	} else if count > 0 {
+		count--
+	}
+	if count < 0 {
+		start += count
+		count = -count
+		if start < 0 {
+			count += start
+			start = 0
+		}
+	}
+	end := start + count
+	if start > length {
+		start = length
+	}
+	if end > length {
+		end = length
+	}
+	if end < start {
+		end = start
+	}
+	if isBlob {
+		return blob[start:end], nil
+	}
+	return string(chars[start:end]), nil
+}
+
+func evalFunction(fn *FuncExpr, ctx *evalContext) (interface{}, error) {
+	function, ok := scalarFunctions[fn.Name]
+	if !ok {
+		return nil, &ErrSyntax{Pos: fn.P, Msg: "no such function: " + fn.Name}
+	}
+	if fn.Star || len(fn.Args) < function.minArgs || (function.maxArgs >= 0 && len(fn.Args) > function.maxArgs) {
+		return nil, &ErrSyntax{Pos: fn.P, Msg: fmt.Sprintf("wrong number of arguments to function %s()", fn.Name)}
+	}
+	args := make([]interface{}, len(fn.Args))
+	for i, arg := range fn.Args {
+		v, err := evalExpr(arg, ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	switch fn.Name {
+	case "min":
+		return extremum(args, -1, ctx.encoding), nil
+	case "max":
+		return extremum(args, 1, ctx.encoding), nil
+	}
+	return function.call(args)
+}
+
+// checkFunctions reports calls to unknown functions, or with the wrong number of arguments,
+// before any row is read, the way sqlite3 rejects them while preparing the statement.
+func checkFunctions(expr Expr) error {
+	return walkExpr(expr, func(e Expr) error {
+		fn, ok := e.(*FuncExpr)
+		if !ok {
+			return nil
+		}
+		if isAggregate(fn) {
+			if fn.Star && fn.Name != "count" || !fn.Star && fn.Name == "count" && len(fn.Args) > 1 ||
+				!fn.Star && fn.Name != "count" && len(fn.Args) == 0 || fn.Name != "group_concat" && len(fn.Args) > 1 || len(fn.Args) > 2 {
+				return &ErrSyntax{Pos: fn.P, Msg: fmt.Sprintf("wrong number of arguments to function %s()", fn.Name)}
+			}
+			return nil
+		}
+		function, ok := scalarFunctions[fn.Name]
+		if !ok {
+			return &ErrSyntax{Pos: fn.P, Msg: "no such function: " + fn.Name}
+		}
+		if fn.Star || len(fn.Args) < function.minArgs || (function.maxArgs >= 0 && len(fn.Args) > function.maxArgs) {
+			return &ErrSyntax{Pos: fn.P, Msg: fmt.Sprintf("wrong number of arguments to function %s()", fn.Name)}
+		}
+		return nil
+	})
+}
+
+// aggregateState accumulates one aggregate call over the rows of a group.
+type aggregateState struct {
+	fn       *FuncExpr
+	count    int64
+	intSum   int64
+	floatSum float64
+	isFloat  bool
+	overflow bool
+	best     interface{}
+	parts    []string
+	seps     []string
+	seen     map[string]bool //!Values already fed in, for DISTINCT.
+}
+
+func newAggregateState(fn *FuncExpr) *aggregateState {
+	state := &aggregateState{fn: fn}
+	if fn.Distinct {
+		state.seen = map[string]bool{}
+	}
+	return state
+}
+
+// step feeds the current row into the aggregate.
+func (state *aggregateState) step(ctx *evalContext) error {
+	fn := state.fn
+	if fn.Star {
+		state.count++
+		return nil
+	}
+	var args []interface{}
+	for _, arg := range fn.Args {
+		v, err := evalExpr(arg, ctx)
+		if err != nil {
+			return err
+		}
+		args = append(args, v)
+	}
+	v := args[0]
+	if v == nil {
+		return nil
+	}
+	if state.seen != nil {
+		key := formatSQLLiteral(v)
+		if state.seen[key] {
+			return nil
+		}
+		state.seen[key] = true
+	}
+	state.count++
+	switch fn.Name {
+	case "sum", "total", "avg":
+		switch x := toNumeric(v).(type) {
+		case int64:
+			if !state.isFloat {
+				sum := state.intSum + x
+				if (sum > state.intSum) != (x > 0) && x != 0 {
+					state.overflow = true
+				}
+				state.intSum = sum
+			}
+			state.floatSum += float64(x)
+		case float64:
+			state.isFloat = true
+			state.floatSum += x
+		}
+	case "min":
+		if state.best == nil || compareValues(v, state.best, exprCollation(fn.Args[0]), ctx.encoding) < 0 {
+			state.best = v
+		}
+	case "max":
+		if state.best == nil || compareValues(v, state.best, exprCollation(fn.Args[0]), ctx.encoding) > 0 {
+			state.best = v
+		}
+	case "group_concat":
+		sep := ","
+		if len(args) > 1 {
+			sep = ""
+			if args[1] != nil {
+				sep = toText(args[1]).(string)
+			}
+		}
+		state.parts = append(state.parts, toText(v).(string))
+		state.seps = append(state.seps, sep)
+	}
+	return nil
+}
+
+// result is the aggregate's value once every row has been fed in.
+func (state *aggregateState) result() (interface{}, error) {
+	switch state.fn.Name {
+	case "count":
+		return state.count, nil
+	case "sum":
+		if state.count == 0 {
+			return nil, nil
+		}
+		if state.isFloat {
+			return state.floatSum, nil
+		}
+		if state.overflow {
+			return nil, fmt.Errorf("integer overflow")
+		}
+		return state.intSum, nil
+	case "total":
+		return state.floatSum, nil
+	case "avg":
+		if state.count == 0 {
+			return nil, nil
+		}
+		return state.floatSum / float64(state.count), nil
+	case "min", "max":
+		return state.best, nil
+	case "group_concat":
+		if len(state.parts) == 0 {
+			return nil, nil
+		}
+		var sb strings.Builder
+		for i, part := range state.parts {
+			if i > 0 {
+				sb.WriteString(state.seps[i])
+			}
+			sb.WriteString(part)
+		}
+		return sb.String(), nil
+	}
+	return nil, nil
+}
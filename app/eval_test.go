@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestCompareValuesTextEncoding(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		collation string
+		enc       TextEncoding
+		want      int
+	}{
+		{"z", "ā", "", EncodingUTF8, -1},
+		{"z", "ā", "", EncodingUTF16BE, -1},
+		{"z", "ā", "", EncodingUTF16LE, 1}, //!7a 00 against 01 01.
+		{"ĀĀ", "ā", "BINARY", EncodingUTF16LE, -1},
+		{"日本語", "ÿ", "", EncodingUTF16LE, -1},
+		{"\U0001D11E", "￿", "", EncodingUTF8, 1},
+		{"\U0001D11E", "￿", "", EncodingUTF16BE, -1}, //!A surrogate pair sorts below U+E000.
+		{"z", "ā", "RTRIM", EncodingUTF16LE, -1},     //!RTRIM and NOCASE compare UTF-8.
+		{"Z", "ā", "NOCASE", EncodingUTF16LE, -1},
+		{"abc", "abc", "", EncodingUTF16LE, 0},
+	}
+	for _, test := range tests {
+		if got := compareValues(test.a, test.b, test.collation, test.enc); sign(got) != test.want {
+			t.Errorf("compareValues(%q, %q, %q, %v) = %d, want %d", test.a, test.b, test.collation, test.enc, got, test.want)
+		}
+	}
+}
+
+func sign(c int) int {
+	switch {
+	case c < 0:
+		return -1
+	case c > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ErrCrashed is returned by every operation of a FaultVFS from its crash point on.
+var ErrCrashed = errors.New("simulated crash")
+
+// ErrWriteFailed is returned by the write a FaultVFS is set to fail.
+var ErrWriteFailed = errors.New("simulated write error")
+
+// FaultVFS is an in-memory VFS that simulates losing power, to test that a crash at any sync
+// point leaves a database that can be recovered. Data written to a file only becomes durable
+// when the file is synced. The sync numbered CrashAt (counting from 1) fails instead of
+// completing, as does every operation after it, and Crash then decides what survived the
+// power loss. Separately, the write numbered FailWrite fails on its own, as a full disk would
+// fail it, leaving the operations around it to succeed.
+type FaultVFS struct {
+	mu        sync.Mutex
+	files     map[string]*faultData
+	CrashAt   int //!0 never crashes.
+	Syncs     int //!Syncs completed so far.
+	FailWrite int //!0 never fails a write.
+	Writes    int //!Writes attempted so far.
+	crashed   bool
+}
+
+// faultData is one file of a FaultVFS: its content as seen by readers, and as last synced.
+type faultData struct {
+	data    []byte
+	durable []byte
+}
+
+func NewFaultVFS() *FaultVFS {
+	return &FaultVFS{files: map[string]*faultData{}}
+}
+
+// Store replaces the named file with durable content.
+func (vfs *FaultVFS) Store(name string, data []byte) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	vfs.files[name] = &faultData{data: append([]byte(nil), data...), durable: append([]byte(nil), data...)}
+}
+
+// Load returns the current content of the named file, nil when it does not exist.
+func (vfs *FaultVFS) Load(name string) []byte {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	if file, ok := vfs.files[name]; ok {
+		return append([]byte(nil), file.data...)
+	}
+	return nil
+}
+
+// Crash reverts every file to its durable content and lets operations succeed again, with no
+// crash point set. With keepUnsynced, the writes that were not synced are kept instead, as if
+// the system had flushed them just before losing power: between the two, a transaction must
+// survive both extremes.
+func (vfs *FaultVFS) Crash(keepUnsynced bool) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	for _, file := range vfs.files {
+		if keepUnsynced {
+			file.durable = append([]byte(nil), file.data...)
+		} else {
+			file.data = append([]byte(nil), file.durable...)
+		}
+	}
+	vfs.crashed, vfs.CrashAt, vfs.Syncs = false, 0, 0
+}
+
+func (vfs *FaultVFS) Open(name string, flags OpenFlags) (File, error) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	if vfs.crashed {
+		return nil, ErrCrashed
+	}
+	file, ok := vfs.files[name]
+	if !ok {
+		if flags&OpenCreate == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		//!Creating a file is a change to its directory, which this VFS treats as durable.
+		file = &faultData{}
+		vfs.files[name] = file
+	}
+	return &faultFile{vfs: vfs, content: file, readOnly: flags&OpenReadWrite == 0}, nil
+}
+
+func (vfs *FaultVFS) Delete(name string) error {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	if vfs.crashed {
+		return ErrCrashed
+	}
+	if _, ok := vfs.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(vfs.files, name)
+	return nil
+}
+
+func (vfs *FaultVFS) Exists(name string) (bool, error) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	if vfs.crashed {
+		return false, ErrCrashed
+	}
+	_, ok := vfs.files[name]
+	return ok, nil
+}
+
+// faultFile is a handle on a file of a FaultVFS.
+type faultFile struct {
+	vfs      *FaultVFS
+	content  *faultData
+	readOnly bool
+	lock     LockLevel
+}
+
+func (file *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	file.vfs.mu.Lock()
+	defer file.vfs.mu.Unlock()
+	if file.vfs.crashed {
+		return 0, ErrCrashed
+	}
+	return readAtBytes(file.content.data, p, off)
+}
+
+func (file *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	file.vfs.mu.Lock()
+	defer file.vfs.mu.Unlock()
+	if file.vfs.crashed {
+		return 0, ErrCrashed
+	}
+	if file.readOnly {
+		return 0, ErrReadOnly
+	}
+	if file.vfs.Writes++; file.vfs.Writes == file.vfs.FailWrite {
+		return 0, ErrWriteFailed
+	}
+	if end := off + int64(len(p)); end > int64(len(file.content.data)) {
+		grown := make([]byte, end)
+		copy(grown, file.content.data)
+		file.content.data = grown
+	}
+	return copy(file.content.data[off:], p), nil
+}
+
+func (file *faultFile) Size() (int64, error) {
+	file.vfs.mu.Lock()
+	defer file.vfs.mu.Unlock()
+	if file.vfs.crashed {
+		return 0, ErrCrashed
+	}
+	return int64(len(file.content.data)), nil
+}
+
+func (file *faultFile) Sync() error {
+	vfs := file.vfs
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	if vfs.crashed || (vfs.CrashAt > 0 && vfs.Syncs+1 >= vfs.CrashAt) {
+		vfs.crashed = true
+		return ErrCrashed
+	}
+	vfs.Syncs++
+	file.content.durable = append([]byte(nil), file.content.data...)
+	return nil
+}
+
+func (file *faultFile) Truncate(size int64) error {
+	file.vfs.mu.Lock()
+	defer file.vfs.mu.Unlock()
+	if file.vfs.crashed {
+		return ErrCrashed
+	}
+	if file.readOnly {
+		return ErrReadOnly
+	}
+	if size < int64(len(file.content.data)) {
+		file.content.data = file.content.data[:size:size]
+	} else {
+		grown := make([]byte, size)
+		copy(grown, file.content.data)
+		file.content.data = grown
+	}
+	return nil
+}
+
+func (file *faultFile) Lock(level LockLevel) error {
+	if level > file.lock {
+		file.lock = level
+	}
+	return nil
+}
+
+func (file *faultFile) Unlock(level LockLevel) error {
+	if level < file.lock {
+		file.lock = level
+	}
+	return nil
+}
+
+func (file *faultFile) CheckReservedLock() (bool, error) {
+	return file.lock >= LockReserved, nil
+}
+
+func (file *faultFile) Close() error {
+	return nil
+}
+
+// clone returns a FaultVFS holding a copy of the files of vfs, with no crash point set.
+func (vfs *FaultVFS) clone() *FaultVFS {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	copied := NewFaultVFS()
+	for name, file := range vfs.files {
+		copied.files[name] = &faultData{data: bytes.Clone(file.data), durable: bytes.Clone(file.durable)}
+	}
+	return copied
+}
+
+// TestCrashRecovery crashes a transaction at each of its syncs in turn, losing or keeping the
+// writes that were not synced, and checks that the database then holds the rows from either
+// before or after the transaction and is intact.
+func TestCrashRecovery(t *testing.T) {
+	long := strings.Repeat("z", 1500) //!Long enough for overflow pages and page splits.
+	transaction := []string{
+		"begin",
+		"insert into t(b) values ('" + long + "'), ('w')",
+		"update t set b = b || 'x' where a = 1",
+		"insert into t(b) values ('" + long + "y')",
+		"commit",
+	}
+	const before, after = "2|2", "5|3005"
+	for _, mode := range []string{"delete", "wal"} {
+		t.Run(mode, func(t *testing.T) {
+			base := NewFaultVFS()
+			RegisterVFS("fault", base)
+			if _, code := runShell("test.db", "fault", "pragma journal_mode = "+mode,
+				"create table t(a integer primary key, b text)", "create index tb on t(b)",
+				"insert into t(b) values ('u'), ('v')"); code != 0 {
+				t.Fatalf("setup: exit code %d", code)
+			}
+			for crashAt := 1; ; crashAt++ {
+				committed := false
+				for _, keepUnsynced := range []bool{false, true} {
+					vfs := base.clone()
+					vfs.CrashAt = crashAt
+					RegisterVFS("fault", vfs)
+					_, code := runShell("test.db", "fault", transaction...)
+					crashed := vfs.crashed
+					committed = !crashed
+					if !crashed && code != 0 {
+						t.Fatalf("crash at %d: exit code %d without a crash", crashAt, code)
+					}
+					vfs.Crash(keepUnsynced)
+					out, code := runShell("test.db", "fault", "select count(*), sum(length(b)) from t")
+					if code != 0 {
+						t.Fatalf("crash at %d, keep %v: reopening: exit code %d", crashAt, keepUnsynced, code)
+					}
+					got := strings.TrimSpace(out)
+					if got != after && (got != before || !crashed) {
+						t.Errorf("crash at %d, keep %v: table holds %s", crashAt, keepUnsynced, got)
+					}
+					checkIntegrity(t, vfs.writeTo(t))
+				}
+				if committed {
+					if crashAt == 1 {
+						t.Fatal("the transaction never synced")
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestFailedCommit fails each write of a commit in turn and checks that the transaction is
+// rolled back whole, without leaving a hot journal behind a connection that is still running.
+func TestFailedCommit(t *testing.T) {
+	base := NewFaultVFS()
+	RegisterVFS("fault", base)
+	if _, code := runShell("test.db", "fault", "create table t(a integer primary key, b text)",
+		"create index tb on t(b)", "insert into t(b) values ('u'), ('v')"); code != 0 {
+		t.Fatalf("setup: exit code %d", code)
+	}
+	long := strings.Repeat("z", 1500)
+	for failWrite := 1; ; failWrite++ {
+		vfs := base.clone()
+		vfs.FailWrite = failWrite
+		RegisterVFS("fault", vfs)
+		_, code := runShell("test.db", "fault", "begin", "insert into t(b) values ('"+long+"'), ('w')",
+			"update t set b = b || 'x' where a = 1", "commit")
+		if vfs.Writes < failWrite {
+			if code != 0 {
+				t.Fatalf("exit code %d without a failed write", code)
+			}
+			break
+		}
+		if code == 0 {
+			t.Fatalf("write %d failed, but the commit succeeded", failWrite)
+		}
+		if vfs.Load("test.db-journal") != nil {
+			t.Errorf("write %d failed: the journal was left behind", failWrite)
+		}
+		vfs.FailWrite = 0
+		if out, _ := runShell("test.db", "fault", "select count(*), sum(length(b)) from t"); strings.TrimSpace(out) != "2|2" {
+			t.Errorf("write %d failed: table holds %s", failWrite, strings.TrimSpace(out))
+		}
+		checkIntegrity(t, vfs.writeTo(t))
+	}
+}
+
+// writeTo copies the files of vfs to the test's temporary directory and returns the path of
+// the database.
+func (vfs *FaultVFS) writeTo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, file := range vfs.files {
+		if err := os.WriteFile(filepath.Join(dir, name), file.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "test.db")
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Foreign keys are enforced like SQLite does it, by counting violations while rows are
+// written. A child row written without a parent adds one and removing it takes that back;
+// removing a parent key adds one for each child row still referring to it, and writing the
+// key again takes those back. A statement fails when it leaves immediate violations behind;
+// deferred ones are counted for the transaction and fail its COMMIT.
+
+// foreignKeyRef is a foreign key of the child table.
+type foreignKeyRef struct {
+	child *Table
+	fk    *ForeignKey
+}
+
+// referencesTo lists the foreign keys referring to the named table, in schema order.
+func (schema *Schema) referencesTo(name string) []foreignKeyRef {
+	var refs []foreignKeyRef
+	for _, object := range schema.Objects {
+		child := schema.table(object.Name)
+		if object.Type != "table" || child == nil {
+			continue
+		}
+		for _, fk := range child.ForeignKeys {
+			if strings.EqualFold(fk.Table, name) {
+				refs = append(refs, foreignKeyRef{child: child, fk: fk})
+			}
+		}
+	}
+	return refs
+}
+
+// parentKey is the key of the parent table a foreign key refers to.
+type parentKey struct {
+	table   *Table
+	index   *Index //!The UNIQUE index on the parent columns, nil when they are the rowid.
+	columns []int  //!The parent column of each child column.
+}
+
+// parentColumns names the parent columns of a foreign key: those it lists, or else the
+// PRIMARY KEY of the parent table.
+func parentColumns(parent *Table, fk *ForeignKey) []string {
+	if len(fk.ParentColumns) > 0 {
+		return fk.ParentColumns
+	}
+	if parent.RowidAlias >= 0 {
+		return []string{parent.Columns[parent.RowidAlias].Name}
+	}
+	for _, column := range parent.Def.Columns {
+		if column.PrimaryKey {
+			return []string{column.Name}
+		}
+	}
+	for _, constraint := range parent.Def.Constraints {
+		if constraint.Kind == "PRIMARY KEY" {
+			var names []string
+			for _, key := range constraint.Columns {
+				names = append(names, key.Name)
+			}
+			return names
+		}
+	}
+	return nil
+}
+
+// resolveParent finds the key a foreign key of child refers to. It is nil when the parent
+// table does not exist, and an error when the parent columns are neither its INTEGER PRIMARY
+// KEY nor covered by a UNIQUE index with their collations.
+func resolveParent(schema *Schema, child *Table, fk *ForeignKey) (*parentKey, error) {
+	parent := schema.table(fk.Table)
+	if parent == nil || parent.Def == nil {
+		return nil, nil
+	}
+	mismatch := &ErrPrepare{Msg: fmt.Sprintf("foreign key mismatch - \"%s\" referencing \"%s\"", child.Name, fk.Table)}
+	names := parentColumns(parent, fk)
+	if len(names) != len(fk.Columns) {
+		return nil, mismatch
+	}
+	key := &parentKey{table: parent}
+	for _, name := range names {
+		i := parent.columnIndex(name)
+		if i < 0 {
+			return nil, mismatch
+		}
+		key.columns = append(key.columns, i)
+	}
+	if len(key.columns) == 1 && key.columns[0] == parent.RowidAlias {
+		return key, nil
+	}
+	for _, index := range schema.indexesOn(parent.Name) {
+		if index.Unique && index.Where == nil && key.coveredBy(index) {
+			key.index = index
+			return key, nil
+		}
+	}
+	return nil, mismatch
+}
+
+// coveredBy reports whether the keys of an index are the parent columns, in any order, each
+// with the collation of its column.
+func (key *parentKey) coveredBy(index *Index) bool {
+	if len(index.Keys) != len(key.columns) {
+		return false
+	}
+	collation := func(name string) string {
+		if name == "" {
+			return "BINARY"
+		}
+		return strings.ToUpper(name)
+	}
+	for _, indexKey := range index.Keys {
+		column := key.table.columnIndex(indexKey.Name)
+		if indexKey.Expr != nil || column < 0 || key.position(column) < 0 {
+			return false
+		}
+		declared := key.table.Columns[column].Collate
+		if indexKey.Collate != "" && collation(indexKey.Collate) != collation(declared) {
+			return false
+		}
+	}
+	return true
+}
+
+// position returns which child column refers to the given parent column, or -1.
+func (key *parentKey) position(column int) int {
+	for i, c := range key.columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// values returns the parent key of a parent row. ok is false when part of it is NULL, or
+// there is no row.
+func (key *parentKey) values(row []interface{}) (values []interface{}, ok bool) {
+	if row == nil {
+		return nil, false
+	}
+	for _, column := range key.columns {
+		if row[column] == nil {
+			return nil, false
+		}
+		values = append(values, row[column])
+	}
+	return values, true
+}
+
+// childValues returns the child columns of a foreign key in a row of child. ok is false when
+// one of them is NULL, which leaves the row out of the constraint.
+func childValues(child *Table, fk *ForeignKey, row []interface{}) (values []interface{}, ok bool) {
+	for _, name := range fk.Columns {
+		value := row[child.columnIndex(name)]
+		if value == nil {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// exists reports whether the parent table has a row with the key the child values refer to.
+// The values are compared with the affinity of the parent columns.
+func (key *parentKey) exists(pager *Pager, schema *Schema, values []interface{}) (bool, error) {
+	parent := key.table
+	converted := make([]interface{}, len(values))
+	for i, value := range values {
+		converted[i] = applyAffinity(value, parent.Columns[key.columns[i]].Affinity)
+	}
+	if key.index == nil {
+		rowid, ok := converted[0].(int64)
+		if !ok {
+			return false, nil
+		}
+		return btreeFind(pager, parent.RootPage, rowidComparer(rowid))
+	}
+	entry := make([]Value, len(key.index.Keys))
+	for j, indexKey := range key.index.Keys {
+		entry[j] = valueOf(converted[key.position(parent.columnIndex(indexKey.Name))])
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: parent}
+	record, err := btreeLookup(pager, key.index.RootPage, indexPrefixComparer(pager, entry, writer.indexOrder(key.index)))
+	return record != nil, err
+}
+
+// children returns the rows of child that refer to the parent key values through fk. They are
+// compared with the affinity and collation of the parent columns.
+func (key *parentKey) children(pager *Pager, child *Table, fk *ForeignKey, values []interface{}) ([]int64, [][]interface{}, error) {
+	if child.Def.WithoutRowid {
+		return nil, nil, fmt.Errorf("foreign keys of a WITHOUT ROWID table are not supported")
+	}
+	rowids, records, err := readTable(pager, child.RootPage, map[int64]int64{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var matchIds []int64
+	var matches [][]interface{}
+	for i, record := range records {
+		row := tableRow(child, rowids[i], record)
+		childKey, ok := childValues(child, fk, row)
+		for j := 0; ok && j < len(childKey); j++ {
+			column := key.table.Columns[key.columns[j]]
+			ok = compareValues(applyAffinity(childKey[j], column.Affinity), values[j], column.Collate, EncodingUTF8) == 0
+		}
+		if ok {
+			matchIds = append(matchIds, rowids[i])
+			matches = append(matches, row)
+		}
+	}
+	return matchIds, matches, nil
+}
+
+// checkForeignKeys makes the checks SQLite makes while compiling a statement that writes to
+// table with foreign keys enforced: the parent of every foreign key the statement may
+// violate must exist and have a usable key. assigned marks the columns an UPDATE sets and
+// is nil for INSERT and DELETE. parents is whether the keys referring to the table matter,
+// as they do when rows are removed or changed, or when an INSERT writes several rows or may
+// replace some; a single row inserted only matters to deferred keys. Like SQLite, every key
+// referring to the table must then be usable as soon as any foreign key is involved, even
+// one the statement leaves alone.
+func checkForeignKeys(pager *Pager, schema *Schema, table *Table, assigned []bool, parents bool) error {
+	if !pager.foreignKeys {
+		return nil
+	}
+	involved := false
+	for _, fk := range table.ForeignKeys {
+		if !columnsAssigned(table, fk.Columns, assigned) {
+			continue
+		}
+		involved = true
+		key, err := resolveParent(schema, table, fk)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return &ErrNoSuchTable{Name: "main." + fk.Table}
+		}
+	}
+	refs := schema.referencesTo(table.Name)
+	for _, ref := range refs {
+		if parents {
+			involved = involved || columnsAssigned(table, parentColumns(table, ref.fk), assigned)
+		} else if ref.fk.Deferred {
+			if _, err := resolveParent(schema, ref.child, ref.fk); err != nil {
+				return err
+			}
+		}
+	}
+	if !parents || !involved {
+		return nil
+	}
+	for _, ref := range refs {
+		if _, err := resolveParent(schema, ref.child, ref.fk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnsAssigned reports whether an UPDATE sets any of the named columns. Every column is
+// taken as set when assigned is nil.
+func columnsAssigned(table *Table, names []string, assigned []bool) bool {
+	if assigned == nil {
+		return true
+	}
+	for _, name := range names {
+		if i := table.columnIndex(name); i >= 0 && assigned[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// countViolations adds n to the violations of a foreign key: to those of the statement when
+// it is immediate, of the transaction when it is deferred.
+func (writer *tableWriter) countViolations(fk *ForeignKey, n int64) {
+	if fk.Deferred {
+		writer.pager.fkDeferred += n
+	} else {
+		writer.pager.fkViolations += n
+	}
+}
+
+// violations returns the count a violation of the foreign key goes to. Work that could only
+// take violations back is skipped while it is zero.
+func (writer *tableWriter) violations(fk *ForeignKey) int64 {
+	if fk.Deferred {
+		return writer.pager.fkDeferred
+	}
+	return writer.pager.fkViolations
+}
+
+// foreignKeysBefore counts the foreign key violations the old version of a changed row takes
+// away or leaves behind, before the change is made. old is the row deleted or updated under
+// rowid, nil for an INSERT; row the row written, nil for a DELETE. assigned marks the columns
+// an UPDATE sets.
+func (writer *tableWriter) foreignKeysBefore(old []interface{}, row []interface{}, rowid int64, assigned []bool) error {
+	pager, schema, table := writer.pager, writer.schema, writer.table
+	if !pager.foreignKeys {
+		return nil
+	}
+	//!A child row without a parent takes its violation with it.
+	for _, fk := range table.ForeignKeys {
+		if old == nil || writer.violations(fk) == 0 || !columnsAssigned(table, fk.Columns, assigned) {
+			continue
+		}
+		values, ok := childValues(table, fk, old)
+		if !ok {
+			continue
+		}
+		key, err := resolveParent(schema, table, fk)
+		if err != nil && writer.dropping {
+			continue
+		} else if err != nil {
+			return err
+		}
+		found := false
+		if key != nil {
+			if found, err = key.exists(pager, schema, values); err != nil {
+				return err
+			}
+		}
+		if !found {
+			writer.countViolations(fk, -1)
+		}
+	}
+	//!Child rows are left without a parent by the old key.
+	for _, ref := range schema.referencesTo(table.Name) {
+		if old == nil || !columnsAssigned(table, parentColumns(table, ref.fk), assigned) {
+			continue
+		}
+		key, err := resolveParent(schema, ref.child, ref.fk)
+		if err != nil && writer.dropping {
+			continue
+		} else if err != nil {
+			return err
+		}
+		values, ok := key.values(old)
+		if !ok {
+			continue
+		}
+		rowids, _, err := key.children(pager, ref.child, ref.fk, values)
+		if err != nil {
+			return err
+		}
+		n := int64(len(rowids))
+		//!A deleted row referring to its own key goes with it.
+		if row == nil && ref.child == table && slices.Contains(rowids, rowid) {
+			n--
+		}
+		writer.countViolations(ref.fk, n)
+	}
+	return nil
+}
+
+// foreignKeysAfter does the foreign key work due once a row change is made, row now being
+// stored under rowid: a new child row without a parent is a violation, child rows referring
+// to a new parent key are no longer, and the ON DELETE or ON UPDATE actions are taken on the
+// child rows referring to a parent key that was removed or changed.
+func (writer *tableWriter) foreignKeysAfter(old []interface{}, row []interface{}, rowid int64, assigned []bool) error {
+	pager, schema, table := writer.pager, writer.schema, writer.table
+	if !pager.foreignKeys {
+		return nil
+	}
+	for _, fk := range table.ForeignKeys {
+		if row == nil || !columnsAssigned(table, fk.Columns, assigned) {
+			continue
+		}
+		values, ok := childValues(table, fk, row)
+		if !ok {
+			continue
+		}
+		key, err := resolveParent(schema, table, fk)
+		if err != nil && writer.dropping {
+			continue
+		} else if err != nil {
+			return err
+		}
+		found := false
+		if key != nil {
+			if found, err = key.exists(pager, schema, values); err != nil {
+				return err
+			}
+		}
+		if !found {
+			writer.countViolations(fk, 1)
+		}
+	}
+	//!Child rows without a parent get one back from the new key. An inserted row referring
+	//!to its own key was never counted.
+	for _, ref := range schema.referencesTo(table.Name) {
+		if row == nil || writer.violations(ref.fk) == 0 || !columnsAssigned(table, parentColumns(table, ref.fk), assigned) {
+			continue
+		}
+		//!Only statements removing rows fail on a key that cannot be used, as they did already.
+		key, _ := resolveParent(schema, ref.child, ref.fk)
+		if key == nil {
+			continue
+		}
+		values, ok := key.values(row)
+		if !ok {
+			continue
+		}
+		rowids, _, err := key.children(pager, ref.child, ref.fk, values)
+		if err != nil {
+			return err
+		}
+		n := int64(len(rowids))
+		if old == nil && ref.child == table && slices.Contains(rowids, rowid) {
+			n--
+		}
+		writer.countViolations(ref.fk, -n)
+	}
+	if old == nil {
+		return nil
+	}
+	for _, ref := range schema.referencesTo(table.Name) {
+		action := ref.fk.OnDelete
+		if row != nil {
+			action = ref.fk.OnUpdate
+		}
+		if action == "" || !columnsAssigned(table, parentColumns(table, ref.fk), assigned) {
+			continue
+		}
+		key, err := resolveParent(schema, ref.child, ref.fk)
+		if err != nil && writer.dropping {
+			continue
+		} else if err != nil {
+			return err
+		}
+		values, ok := key.values(old)
+		if !ok {
+			continue
+		}
+		var newValues []interface{}
+		if row != nil {
+			//!Like SQLite, an UPDATE that leaves the key as it was takes no action.
+			newValues = make([]interface{}, len(key.columns))
+			changed := false
+			for i, column := range key.columns {
+				newValues[i] = row[column]
+				changed = changed || (row[column] == nil) != (old[column] == nil) || compareValues(row[column], old[column], "", EncodingUTF8) != 0
+			}
+			if !changed {
+				continue
+			}
+		}
+		if err := writer.takeAction(ref, key, action, values, newValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeAction applies the ON DELETE or ON UPDATE action of a foreign key to the child rows
+// referring to the parent key values. newValues is the key the parent row was updated to, nil
+// when it was deleted.
+func (writer *tableWriter) takeAction(ref foreignKeyRef, key *parentKey, action string, values []interface{}, newValues []interface{}) error {
+	rowids, _, err := key.children(writer.pager, ref.child, ref.fk, values)
+	if err != nil || len(rowids) == 0 {
+		return err
+	}
+	if action == "RESTRICT" {
+		return violation("ABORT", "FOREIGN KEY constraint failed")
+	}
+	child := &tableWriter{pager: writer.pager, schema: writer.schema, table: ref.child}
+	targets := make([]int, len(ref.fk.Columns))
+	set := make([]Assignment, len(ref.fk.Columns))
+	for i, name := range ref.fk.Columns {
+		targets[i] = ref.child.columnIndex(name)
+		column := ref.child.Columns[targets[i]]
+		var value interface{}
+		switch {
+		case action == "CASCADE" && newValues != nil:
+			value = newValues[i]
+		case action == "SET DEFAULT":
+			if value, err = columnDefault(column); err != nil {
+				return err
+			}
+		}
+		set[i] = Assignment{Column: column.Name, Expr: &LiteralExpr{Value: value}}
+	}
+	for _, rowid := range rowids {
+		//!An earlier action may have removed the row already.
+		row, found, err := child.readRow(rowid)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if action == "CASCADE" && newValues == nil {
+			err = child.delete(row, rowid)
+		} else {
+			err = child.update(row, rowid, targets, set, "", nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foreignKeyError is the error of a statement leaving foreign key violations behind: immediate
+// ones, or deferred ones when no transaction was begun to settle them in.
+func (pager *Pager) foreignKeyError() error {
+	if pager.fkViolations > 0 || (!pager.explicit && pager.fkDeferred > 0) {
+		return &ErrResult{Code: resultConstraint, Msg: "FOREIGN KEY constraint failed"}
+	}
+	return nil
+}
+
+// dropsRows reports whether DROP TABLE deletes the rows of the table first, as SQLite does
+// with foreign keys enforced when other tables refer to it or it has deferred foreign keys.
+func dropsRows(pager *Pager, schema *Schema, table *Table) bool {
+	if !pager.foreignKeys {
+		return false
+	}
+	for _, fk := range table.ForeignKeys {
+		if fk.Deferred {
+			return true
+		}
+	}
+	return len(schema.referencesTo(table.Name)) > 0
+}
+
+// emptyDroppedTable deletes the rows of a table DROP TABLE is about to drop, taking the ON
+// DELETE actions, so that child rows left without a parent are violations. Like SQLite, a
+// table only referring to others is left alone while no deferred violation could be settled,
+// and foreign keys whose parent key cannot be used are skipped rather than failing the DROP.
+func emptyDroppedTable(pager *Pager, schema *Schema, table *Table) error {
+	if len(schema.referencesTo(table.Name)) == 0 && pager.fkDeferred == 0 {
+		return nil
+	}
+	if table.Def.WithoutRowid {
+		return fmt.Errorf("dropping a WITHOUT ROWID table with foreign keys enforced is not supported")
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: table, dropping: true}
+	rowids, _, err := readTable(pager, table.RootPage, map[int64]int64{})
+	if err != nil {
+		return err
+	}
+	for _, rowid := range rowids {
+		row, found, err := writer.readRow(rowid)
+		if err == nil && found {
+			err = writer.delete(row, rowid)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return pager.foreignKeyError()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForeignKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string
+		sql   []string
+		want  string
+		code  int
+	}{
+		{"foreign_key_list",
+			[]string{"create table p(id integer primary key, k text unique)",
+				"create table c(id integer primary key, pid int references p(id) on delete cascade on update set null, pk text, foreign key (pk) references p(k) deferrable initially deferred)"},
+			[]string{"pragma foreign_key_list(c)"},
+			"0|0|p|pk|k|NO ACTION|NO ACTION|NONE 1|0|p|pid|id|SET NULL|CASCADE|NONE", 0},
+		{"foreign_key_list of the parent's key",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p on delete cascade)",
+				"create table g(cid references c(pid) on delete cascade)"},
+			[]string{"pragma foreign_key_list(g)"},
+			"0|0|c|cid|pid|NO ACTION|CASCADE|NONE", 0},
+		{"foreign_key_check",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p)", "insert into p values (1)",
+				"insert into c values (1), (2), (null)"},
+			[]string{"pragma foreign_key_check"},
+			"c|2|p|0", 0},
+		{"foreign_key_check of a table",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p)", "create table d(x references p(id))",
+				"insert into c values (2)", "insert into d values (3)"},
+			[]string{"pragma foreign_key_check(d)"},
+			"d|1|p|0", 0},
+		{"off by default",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p)"},
+			[]string{"pragma foreign_keys", "insert into c values (1)", "pragma foreign_keys = on", "pragma foreign_keys"},
+			"0 1", 0},
+		{"insert without a parent",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p)"},
+			[]string{"pragma foreign_keys = on", "insert into c values (1)"},
+			"", resultConstraint},
+		{"on delete cascade",
+			[]string{"create table p(id integer primary key, v)", "create table c(pid references p on delete cascade)",
+				"insert into p values (1, 'a'), (2, 'b')", "insert into c values (1), (1), (2)"},
+			[]string{"pragma foreign_keys = on", "delete from p where id = 1", "select group_concat(pid) from c"},
+			"2", 0},
+		{"cascade through two tables",
+			[]string{"create table p(id integer primary key, v)", "create table c(id integer primary key, pid references p on delete cascade)",
+				"create table g(cid references c on delete cascade)", "insert into p values (1, 1)", "insert into c values (10, 1)",
+				"insert into g values (10)"},
+			[]string{"pragma foreign_keys = on", "delete from p", "select count(*) from c", "select count(*) from g"},
+			"0 0", 0},
+		{"on delete set null",
+			[]string{"create table p(id integer primary key, v)", "create table c(pid references p on delete set null)",
+				"insert into p values (1, 'a'), (2, 'b')", "insert into c values (1), (2)"},
+			[]string{"pragma foreign_keys = on", "delete from p where id = 1", "select quote(pid) from c order by rowid"},
+			"NULL 2", 0},
+		{"on delete set default",
+			[]string{"create table p(id integer primary key, v)", "create table c(pid default 2 references p on delete set default)",
+				"insert into p values (1, 'a'), (2, 'b')", "insert into c values (1), (2)"},
+			[]string{"pragma foreign_keys = on", "delete from p where id = 1", "select pid from c order by rowid"},
+			"2 2", 0},
+		{"on delete restrict",
+			[]string{"create table p(id integer primary key, v)", "create table c(pid references p on delete restrict)",
+				"insert into p values (1, 'a')", "insert into c values (1)"},
+			[]string{"pragma foreign_keys = on", "delete from p where id = 1"},
+			"", resultConstraint},
+		{"no action",
+			[]string{"create table p(id integer primary key, v)", "create table c(pid references p)", "insert into p values (1, 'a')",
+				"insert into c values (1)"},
+			[]string{"pragma foreign_keys = on", "delete from p where id = 1"},
+			"", resultConstraint},
+		{"on update cascade",
+			[]string{"create table p(k text primary key)", "create table c(pk references p on update cascade)",
+				"insert into p values ('a'), ('b')", "insert into c values ('a'), ('b'), ('a')"},
+			[]string{"pragma foreign_keys = on", "update p set k = 'z' where k = 'a'", "select group_concat(pk) from c"},
+			"z,b,z", 0},
+		{"on update set null",
+			[]string{"create table p(k text primary key)", "create table c(pk references p on update set null)",
+				"insert into p values ('a')", "insert into c values ('a')"},
+			[]string{"pragma foreign_keys = on", "update p set k = 'z'", "select quote(pk) from c"},
+			"NULL", 0},
+		{"deferred until commit",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p deferrable initially deferred)"},
+			[]string{"pragma foreign_keys = on", "begin", "insert into c values (1)", "insert into p values (1)", "commit",
+				"select count(*) from c"},
+			"1", 0},
+		{"deferred violation at commit",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p deferrable initially deferred)"},
+			[]string{"pragma foreign_keys = on", "begin", "insert into c values (1)", "commit"},
+			"", resultConstraint},
+		{"deferred violation undone",
+			[]string{"create table p(id integer primary key)", "create table c(pid references p deferrable initially deferred)"},
+			[]string{"pragma foreign_keys = on", "begin", "insert into c values (1)", "delete from c", "commit",
+				"select count(*) from c"},
+			"0", 0},
+	}
+	for _, test := range tests {
+		if got, code := runCase(t, test.setup, test.sql...); got != test.want || code != test.code {
+			t.Errorf("%s: got %q, exit code %d; want %q, %d", test.name, got, code, test.want, test.code)
+		}
+	}
+}
+
+func TestForeignKeyCheckReadOnly(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table p(id integer primary key)", "create table c(pid references p)", "insert into c values (5)")
+	var out strings.Builder
+	shell := newShell(&out)
+	shell.databaseFilePath, shell.readOnly = path, true
+	shell.commands = []string{"pragma foreign_key_check", "pragma foreign_key_list(c)"}
+	if code := shell.run(strings.NewReader("")); code != 0 || out.String() != "c|1|p|0\n0|0|p|pid||NO ACTION|NO ACTION|NONE\n" {
+		t.Errorf("read-only: %q, exit code %d", out.String(), code)
+	}
+}
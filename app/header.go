@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// headerMagic starts every SQLite 3 database file.
+const headerMagic = "SQLite format 3\x00"
+
+// DatabaseHeader is the decoded 100-byte header at the start of the database file.
+type DatabaseHeader struct {
+	PageSize            int64 //!The stored value 1 is decoded as 65536.
+	WriteVersion        uint8 //!1 for a rollback journal, 2 for WAL.
+	ReadVersion         uint8
+	ReservedSpace       uint8 //!Bytes at the end of each page set aside for extensions.
+	MaxPayloadFraction  uint8
+	MinPayloadFraction  uint8
+	LeafPayloadFraction uint8
+	FileChangeCounter   uint32
+	PageCount           uint32 //!Only trusted when VersionValidFor equals FileChangeCounter.
+	FreelistTrunk       uint32
+	FreelistCount       uint32
+	SchemaCookie        uint32
+	SchemaFormat        uint32
+	DefaultCacheSize    int32
+	LargestRootPage     uint32 //!Non-zero in auto-vacuum databases.
+	TextEncoding        TextEncoding
+	UserVersion         int32
+	IncrementalVacuum   uint32
+	ApplicationID       int32
+	VersionValidFor     uint32
+	SQLiteVersion       uint32
+}
+
+// newDatabaseHeader returns the header of a new database, which has no pages yet: SQLite's
+// defaults, until the first write creates page one.
+func newDatabaseHeader() *DatabaseHeader {
+	return &DatabaseHeader{PageSize: 4096, WriteVersion: 1, ReadVersion: 1, MaxPayloadFraction: 64,
+		MinPayloadFraction: 32, LeafPayloadFraction: 32, SchemaFormat: 4, TextEncoding: EncodingUTF8}
+}
+
+// parseDatabaseHeader decodes and validates a database header. Values this implementation
+// cannot read are reported as ErrNotADatabase, like SQLite does.
+func parseDatabaseHeader(raw []byte) (*DatabaseHeader, error) {
+	if len(raw) < 100 {
+		return nil, corruptError(0, 0, "file is shorter than the database header")
+	}
+	if string(raw[:16]) != headerMagic {
+		return nil, &ErrNotADatabase{Offset: 0, Reason: "bad magic string"}
+	}
+	be := binary.BigEndian
+	header := &DatabaseHeader{
+		PageSize:            int64(be.Uint16(raw[16:18])),
+		WriteVersion:        raw[18],
+		ReadVersion:         raw[19],
+		ReservedSpace:       raw[20],
+		MaxPayloadFraction:  raw[21],
+		MinPayloadFraction:  raw[22],
+		LeafPayloadFraction: raw[23],
+		FileChangeCounter:   be.Uint32(raw[24:28]),
+		PageCount:           be.Uint32(raw[28:32]),
+		FreelistTrunk:       be.Uint32(raw[32:36]),
+		FreelistCount:       be.Uint32(raw[36:40]),
+		SchemaCookie:        be.Uint32(raw[40:44]),
+		SchemaFormat:        be.Uint32(raw[44:48]),
+		DefaultCacheSize:    int32(be.Uint32(raw[48:52])),
+		LargestRootPage:     be.Uint32(raw[52:56]),
+		TextEncoding:        TextEncoding(be.Uint32(raw[56:60])),
+		UserVersion:         int32(be.Uint32(raw[60:64])),
+		IncrementalVacuum:   be.Uint32(raw[64:68]),
+		ApplicationID:       int32(be.Uint32(raw[68:72])),
+		VersionValidFor:     be.Uint32(raw[92:96]),
+		SQLiteVersion:       be.Uint32(raw[96:100]),
+	}
+	if header.PageSize == 1 {
+		header.PageSize = 65536
+	}
+
+	switch {
+	case header.PageSize < 512 || header.PageSize > 65536 || header.PageSize&(header.PageSize-1) != 0:
+		return nil, &ErrNotADatabase{Offset: 16, Reason: "invalid page size"}
+	case header.ReadVersion < 1 || header.ReadVersion > 2:
+		return nil, &ErrNotADatabase{Offset: 19, Reason: "unsupported read format version"}
+	case header.WriteVersion < 1:
+		return nil, &ErrNotADatabase{Offset: 18, Reason: "invalid write format version"}
+	case header.MaxPayloadFraction != 64 || header.MinPayloadFraction != 32 || header.LeafPayloadFraction != 32:
+		return nil, &ErrNotADatabase{Offset: 21, Reason: "invalid payload fractions"}
+	case header.PageSize-int64(header.ReservedSpace) < 480:
+		return nil, &ErrNotADatabase{Offset: 20, Reason: "reserved space leaves less than 480 usable bytes"}
+	case header.SchemaFormat > 4:
+		return nil, &ErrNotADatabase{Offset: 44, Reason: "unsupported schema format"}
+	case header.TextEncoding > EncodingUTF16BE:
+		return nil, corruptError(0, 56, "invalid text encoding %d", header.TextEncoding)
+	}
+	//!A new, empty database has no encoding yet; it gets the default of UTF-8.
+	if header.TextEncoding == 0 {
+		header.TextEncoding = EncodingUTF8
+	}
+	return header, nil
+}
+
+// pageCount is the size of the database in pages. The header's count is used when it was
+// written by a version of SQLite that maintains it, otherwise it is derived from the file size.
+func (header *DatabaseHeader) pageCount(fileSize int64) int64 {
+	if header.PageCount != 0 && header.VersionValidFor == header.FileChangeCounter {
+		return int64(header.PageCount)
+	}
+	return fileSize / header.PageSize
+}
+
+// readOnly reports whether the file format only allows reading: a write version newer than
+// this implementation knows still leaves the database readable.
+func (header *DatabaseHeader) readOnly() bool {
+	return header.WriteVersion > 2
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// validHeader returns the header of a database with 4096-byte pages, a change counter of 3
+// and a page count of 2 that is valid for it.
+func validHeader() []byte {
+	raw := make([]byte, 100)
+	be := binary.BigEndian
+	copy(raw, headerMagic)
+	be.PutUint16(raw[16:], 4096)
+	raw[18], raw[19] = 1, 1
+	raw[21], raw[22], raw[23] = 64, 32, 32
+	be.PutUint32(raw[24:], 3)
+	be.PutUint32(raw[28:], 2)
+	be.PutUint32(raw[44:], 4)
+	be.PutUint32(raw[56:], uint32(EncodingUTF8))
+	be.PutUint32(raw[92:], 3)
+	return raw
+}
+
+func TestParseDatabaseHeader(t *testing.T) {
+	pageSize := func(n uint16) func([]byte) {
+		return func(raw []byte) { binary.BigEndian.PutUint16(raw[16:], n) }
+	}
+	set := func(offset int, b byte) func([]byte) {
+		return func(raw []byte) { raw[offset] = b }
+	}
+	tests := []struct {
+		name     string
+		change   func([]byte)
+		pageSize int64
+		offset   int64 //!Of the field an ErrNotADatabase names, -1 for a corrupt header, 0 when valid.
+	}{
+		{"valid", func([]byte) {}, 4096, 0},
+		{"512", pageSize(512), 512, 0},
+		{"32768", pageSize(32768), 32768, 0},
+		{"65536", pageSize(1), 65536, 0}, //!Stored as 1, as it does not fit in two bytes.
+		{"256", pageSize(256), 0, 16},
+		{"1000", pageSize(1000), 0, 16},
+		{"0", pageSize(0), 0, 16},
+		{"bad magic", set(15, 'x'), 0, 0},
+		{"read version 3", set(19, 3), 0, 19},
+		{"write version 0", set(18, 0), 0, 18},
+		{"write version 3", set(18, 3), 4096, 0}, //!Still readable.
+		{"max fraction", set(21, 65), 0, 21},
+		{"min fraction", set(22, 31), 0, 21},
+		{"leaf fraction", set(23, 0), 0, 21},
+		{"reserved space", func(raw []byte) { pageSize(512)(raw); raw[20] = 33 }, 0, 20},
+		{"schema format 5", set(47, 5), 0, 44},
+		{"encoding 4", set(59, 4), 0, -1},
+	}
+	for _, test := range tests {
+		raw := validHeader()
+		test.change(raw)
+		header, err := parseDatabaseHeader(raw)
+		var notADB *ErrNotADatabase
+		var corrupt *ErrCorrupt
+		switch {
+		case test.pageSize != 0:
+			if err != nil || header.PageSize != test.pageSize {
+				t.Errorf("%s: page size %v, %v, want %d", test.name, header, err, test.pageSize)
+			}
+		case test.offset == -1:
+			if !errors.As(err, &corrupt) {
+				t.Errorf("%s: %v, want a corrupt header", test.name, err)
+			}
+		case !errors.As(err, &notADB) || notADB.Offset != test.offset:
+			t.Errorf("%s: %v, want not a database at offset %d", test.name, err, test.offset)
+		}
+	}
+
+	if _, err := parseDatabaseHeader(validHeader()[:99]); err == nil {
+		t.Error("a short header parsed")
+	}
+	raw := validHeader()
+	raw[59] = 0 //!No encoding yet.
+	if header, err := parseDatabaseHeader(raw); err != nil || header.TextEncoding != EncodingUTF8 {
+		t.Errorf("no encoding: %v, %v, want UTF-8", header, err)
+	}
+
+	//!The page count is only trusted while it was written with the current change counter.
+	header, _ := parseDatabaseHeader(validHeader())
+	if n := header.pageCount(5 * 4096); n != 2 {
+		t.Errorf("page count valid for the change counter: %d, want 2", n)
+	}
+	header.FileChangeCounter++
+	if n := header.pageCount(5 * 4096); n != 5 {
+		t.Errorf("stale page count: %d, want 5 from the file size", n)
+	}
+}
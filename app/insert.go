@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// executeInsert runs an INSERT. The rows are written in one write transaction, committed when
+// every row is in and rolled back on the first error. It returns the rows of the RETURNING
+// clause, if any, as they were inserted or updated by an upsert.
+func executeInsert(pager *Pager, schema *Schema, stmt *InsertStmt) ([]string, [][]interface{}, error) {
+	table, err := writableTable(schema, stmt.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//!targets maps each supplied value to a column, -1 standing for the rowid.
+	var targets []int
+	defaultValues := stmt.Values == nil && stmt.Select == nil
+	if stmt.Columns == nil && !defaultValues {
+		for i := range table.Columns {
+			targets = append(targets, i)
+		}
+	}
+	for _, name := range stmt.Columns {
+		i := table.columnIndex(name)
+		if i < 0 {
+			switch strings.ToLower(name) {
+			case "rowid", "oid", "_rowid_":
+				i = -1
+			default:
+				return nil, nil, &ErrPrepare{Msg: fmt.Sprintf("table %s has no column named %s", table.Name, name)}
+			}
+		}
+		targets = append(targets, i)
+	}
+
+	several := len(stmt.Values) > 1 || stmt.Select != nil || mayReplace(schema, table, stmt.OrAction)
+	for _, upsert := range stmt.Upserts {
+		//!DO UPDATE may change a parent key.
+		several = several || upsert.Set != nil
+	}
+	if err := checkForeignKeys(pager, schema, table, nil, several); err != nil {
+		return nil, nil, err
+	}
+	rows, err := insertRows(pager, schema, stmt, table, len(targets))
+	if err != nil {
+		return nil, nil, err
+	}
+	upserts, err := prepareUpserts(schema, table, stmt.Upserts)
+	if err != nil {
+		return nil, nil, err
+	}
+	returning, err := prepareReturning(table, stmt.Returning)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := pager.beginWrite(); err != nil {
+		return nil, nil, err
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: table, upserts: upserts, returning: returning}
+	for _, supplied := range rows {
+		row, rowid, err := writer.newRow(targets, supplied)
+		if err == nil {
+			err = writer.insert(row, rowid, stmt.OrAction)
+		}
+		if err != nil {
+			return nil, nil, writer.fail(err)
+		}
+	}
+	return writer.result()
+}
+
+// mayReplace reports whether an INSERT resolves a conflict over the rowid or a UNIQUE index
+// with REPLACE, deleting the row in the way.
+func mayReplace(schema *Schema, table *Table, orAction string) bool {
+	if conflictAction(orAction, table.RowidAction) == "REPLACE" {
+		return true
+	}
+	for _, index := range schema.indexesOn(table.Name) {
+		if index.Unique && conflictAction(orAction, index.Action) == "REPLACE" {
+			return true
+		}
+	}
+	return false
+}
+
+// insertRows evaluates the rows an INSERT supplies, each of which must have count values.
+func insertRows(pager *Pager, schema *Schema, stmt *InsertStmt, table *Table, count int) ([][]interface{}, error) {
+	if stmt.Select != nil {
+		columns, rows, err := executeSelect(pager, schema, stmt.Select)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) != count {
+			return nil, valueCountError(stmt, table, count, len(columns))
+		}
+		return rows, nil
+	}
+	if stmt.Values == nil {
+		//!DEFAULT VALUES
+		return [][]interface{}{nil}, nil
+	}
+	rows := make([][]interface{}, len(stmt.Values))
+	for i, exprs := range stmt.Values {
+		if len(exprs) != len(stmt.Values[0]) {
+			return nil, &ErrPrepare{Msg: "all VALUES must have the same number of terms"}
+		}
+		if len(exprs) != count {
+			return nil, valueCountError(stmt, table, count, len(exprs))
+		}
+		for _, expr := range exprs {
+			if err := bindColumns(expr, nil, ""); err != nil {
+				return nil, err
+			}
+			if err := checkFunctions(expr); err != nil {
+				return nil, err
+			}
+		}
+		for _, expr := range exprs {
+			value, err := evalExpr(expr, &evalContext{encoding: pager.encoding})
+			if err != nil {
+				return nil, err
+			}
+			rows[i] = append(rows[i], value)
+		}
+	}
+	return rows, nil
+}
+
+// valueCountError words a mismatch between the values supplied and the columns to fill like
+// SQLite does.
+func valueCountError(stmt *InsertStmt, table *Table, columns int, values int) error {
+	if stmt.Columns == nil {
+		return &ErrPrepare{Msg: fmt.Sprintf("table %s has %d columns but %d values were supplied", table.Name, columns, values)}
+	}
+	return &ErrPrepare{Msg: fmt.Sprintf("%d values for %d columns", values, columns)}
+}
+
+// columnDefault evaluates the DEFAULT clause of a column, NULL when it has none.
+func columnDefault(column Column) (interface{}, error) {
+	if name, ok := column.Default.(*ColumnExpr); ok {
+		now := time.Now().UTC()
+		switch name.Name {
+		case "CURRENT_TIMESTAMP":
+			return now.Format("2006-01-02 15:04:05"), nil
+		case "CURRENT_DATE":
+			return now.Format("2006-01-02"), nil
+		case "CURRENT_TIME":
+			return now.Format("15:04:05"), nil
+		}
+	}
+	if column.Default == nil {
+		return nil, nil
+	}
+	return evalExpr(column.Default, &evalContext{})
+}
+
+// newRow builds a full row from the values supplied for targets, filling the other columns
+// with their defaults and applying column affinity. rowid is nil when it is to be chosen.
+func (writer *tableWriter) newRow(targets []int, supplied []interface{}) ([]interface{}, interface{}, error) {
+	table := writer.table
+	row := make([]interface{}, len(table.Columns))
+	for i, column := range table.Columns {
+		value, err := columnDefault(column)
+		if err != nil {
+			return nil, nil, err
+		}
+		row[i] = value
+	}
+	var rowid interface{}
+	for i, target := range targets {
+		if target < 0 {
+			rowid = supplied[i]
+		} else {
+			row[target] = supplied[i]
+		}
+	}
+	for i, column := range table.Columns {
+		row[i] = applyAffinity(row[i], column.Affinity)
+	}
+	//!A value given for the INTEGER PRIMARY KEY column is the rowid.
+	if table.RowidAlias >= 0 && row[table.RowidAlias] != nil {
+		rowid = row[table.RowidAlias]
+	}
+	if rowid != nil {
+		switch v := applyAffinity(rowid, AffinityInteger).(type) {
+		case int64:
+			rowid = v
+		default:
+			return nil, nil, &ErrResult{Code: resultMismatch, Msg: "datatype mismatch"}
+		}
+	}
+	return row, rowid, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newDatabase creates an empty file in the test's temporary directory, which opens as a new
+// database: the first statements run on it can still choose its encoding and page size.
+func newDatabase(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runSQL runs statements on the database at path the way the command line does, failing the
+// test on any error, and returns what they printed.
+func runSQL(t *testing.T, path string, statements ...string) string {
+	t.Helper()
+	out, code := runShell(path, "", statements...)
+	if code != 0 {
+		t.Fatalf("%q: exit code %d", statements, code)
+	}
+	return out
+}
+
+// runShell runs statements on the database at path, opened with the named VFS, and returns
+// what they printed and the exit code.
+func runShell(path string, vfsName string, statements ...string) (string, int) {
+	var out bytes.Buffer
+	shell := newShell(&out)
+	shell.databaseFilePath = path
+	shell.vfsName = vfsName
+	shell.commands = statements
+	shell.bail = true
+	code := shell.run(strings.NewReader(""))
+	return out.String(), code
+}
+
+// runCase runs setup on a new database, then statements with sqlite3's errors kept off stderr,
+// and returns what the statements printed, with runs of white space as one space, and the
+// exit code.
+func runCase(t *testing.T, setup []string, statements ...string) (string, int) {
+	t.Helper()
+	path := newDatabase(t)
+	runSQL(t, path, setup...)
+	var out string
+	var code int
+	captureStderr(t, func() { out, code = runShell(path, "", statements...) })
+	return strings.Join(strings.Fields(out), " "), code
+}
+
+// openDatabase opens the database at path read-only and starts a read. Its SHARED lock keeps
+// writers out until the returned function ends the read and closes the database.
+func openDatabase(t *testing.T, path string) (*Pager, *Schema, func()) {
+	t.Helper()
+	vfs, err := findVFS("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager, err := openPager(vfs, path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pager.refresh(); err != nil {
+		pager.Close()
+		t.Fatal(err)
+	}
+	done := func() {
+		pager.endRead()
+		pager.Close()
+	}
+	schema, err := loadSchema(pager)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	return pager, schema, done
+}
+
+// checkIntegrity checks that every index of the database at path holds an entry for each row
+// of its table and nothing else, in b-tree order. When sqlite3 is installed, its
+// integrity_check must pass as well.
+func checkIntegrity(t *testing.T, path string) {
+	t.Helper()
+	pager, schema, done := openDatabase(t, path)
+	defer done()
+	for _, index := range schema.Indexes {
+		table := schema.Tables[strings.ToLower(index.Table)]
+		writer := &tableWriter{pager: pager, schema: schema, table: table}
+		rowids, records, err := readTable(pager, table.RootPage, map[int64]int64{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]int{}
+		for i, record := range records {
+			entry, ok, err := writer.indexEntry(index, tableRow(table, rowids[i], record), rowids[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				want[formatRecord(entry)]++
+			}
+		}
+		entries, err := indexEntries(pager, index.RootPage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := writer.indexOrder(index)
+		for i, entry := range entries {
+			if i > 0 && compareIndexKeys(entries[i-1], entry, order, pager.encoding) >= 0 {
+				t.Errorf("index %s: %s is out of order after %s", index.Name, formatRecord(entry), formatRecord(entries[i-1]))
+			}
+			key := formatRecord(entry)
+			if want[key] == 0 {
+				t.Errorf("index %s: entry %s has no row", index.Name, key)
+			}
+			want[key]--
+		}
+		for key, n := range want {
+			if n > 0 {
+				t.Errorf("index %s: row %s is missing", index.Name, key)
+			}
+		}
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return
+	}
+	out, err := exec.Command("sqlite3", path, "pragma integrity_check").CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "ok" {
+		t.Errorf("sqlite3 integrity_check: %s %v", out, err)
+	}
+}
+
+// indexEntries returns the records of an index b-tree in key order.
+func indexEntries(pager *Pager, pageNo int64) ([][]Value, error) {
+	node, err := readNode(pager, pageNo)
+	if err != nil {
+		return nil, err
+	}
+	var entries [][]Value
+	for i, cell := range node.cells {
+		if !node.leaf() {
+			children, err := indexEntries(pager, node.children[i])
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, children...)
+		}
+		record, err := cellRecord(pager, cell)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, record)
+	}
+	if !node.leaf() {
+		children, err := indexEntries(pager, node.children[len(node.cells)])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, children...)
+	}
+	return entries, nil
+}
+
+func formatRecord(record []Value) string {
+	values := make([]string, len(record))
+	for i, value := range record {
+		values[i] = formatSQLLiteral(value.Interface())
+	}
+	return "(" + strings.Join(values, ",") + ")"
+}
+
+// indexText returns the text of the first column of every entry of the named index, in the
+// order the b-tree holds them.
+func indexText(t *testing.T, path string, name string) []string {
+	t.Helper()
+	pager, schema, done := openDatabase(t, path)
+	defer done()
+	for _, index := range schema.Indexes {
+		if index.Name != name {
+			continue
+		}
+		entries, err := indexEntries(pager, index.RootPage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text []string
+		for _, entry := range entries {
+			text = append(text, entry[0].Text)
+		}
+		return text
+	}
+	t.Fatalf("no index %s", name)
+	return nil
+}
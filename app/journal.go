@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"slices"
+)
+
+// journalMagic starts every header of a valid rollback journal. A journal whose header was
+// zeroed or truncated belongs to a transaction that committed.
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+const journalHeaderSize = 28 //!The used part of a header; each header fills a whole sector.
+
+// journalSectorSize is the sector size written in the journals this implementation creates.
+const journalSectorSize = 512
+
+// journalRecord is the original content of one page, saved before a transaction changed it.
+type journalRecord struct {
+	pageNo int64
+	data   []byte
+}
+
+// journalChecksum is the checksum stored after each page of the journal: the nonce from the
+// segment header plus every 200th byte of the page, counting down from the end.
+func journalChecksum(nonce uint32, data []byte) uint32 {
+	sum := nonce
+	for i := len(data) - 200; i > 0; i -= 200 {
+		sum += uint32(data[i])
+	}
+	return sum
+}
+
+// superJournal returns the name of the super-journal recorded at the end of a journal by a
+// transaction spanning several databases, or "" when there is none.
+func superJournal(journal File, size int64) (string, error) {
+	if size < 16 {
+		return "", nil
+	}
+	trailer := make([]byte, 16)
+	if _, err := journal.ReadAt(trailer, size-16); err != nil {
+		return "", err
+	}
+	nameLen := int64(binary.BigEndian.Uint32(trailer))
+	if !bytes.Equal(trailer[8:], journalMagic) || nameLen == 0 || nameLen > size-20 {
+		return "", nil
+	}
+	name := make([]byte, nameLen)
+	if _, err := journal.ReadAt(name, size-16-nameLen); err != nil {
+		return "", err
+	}
+	//!The stored checksum is the sum of the name bytes.
+	sum := uint32(0)
+	for _, b := range name {
+		sum += uint32(b)
+	}
+	if sum != binary.BigEndian.Uint32(trailer[4:]) {
+		return "", nil
+	}
+	return string(bytes.TrimRight(name, "\x00")), nil
+}
+
+// readJournal reads the page images of a rollback journal and the size in pages the database
+// had before the transaction. hot is false when the journal holds no transaction to undo.
+// Like SQLite, playback stops at the first record that is torn or fails its checksum: the
+// pages after it were never written to the database.
+func readJournal(journal File, pageSize int64) (records []journalRecord, dbSize int64, hot bool, err error) {
+	size, err := journal.Size()
+	if err != nil || size < journalHeaderSize {
+		return nil, 0, false, err
+	}
+	be := binary.BigEndian
+	header := make([]byte, journalHeaderSize)
+	recordSize := pageSize + 8
+	for offset := int64(0); offset+journalHeaderSize <= size; {
+		if _, err := journal.ReadAt(header, offset); err != nil && err != io.EOF {
+			return nil, 0, false, err
+		}
+		if !bytes.Equal(header[:8], journalMagic) {
+			break
+		}
+		count := int64(be.Uint32(header[8:]))
+		nonce := be.Uint32(header[12:])
+		sectorSize := int64(be.Uint32(header[20:]))
+		if sectorSize < 32 || sectorSize > 65536 || sectorSize&(sectorSize-1) != 0 || int64(be.Uint32(header[24:])) != pageSize {
+			if offset == 0 {
+				return nil, 0, false, corruptError(0, 0, "invalid rollback journal header")
+			}
+			break
+		}
+		if offset == 0 {
+			dbSize = int64(be.Uint32(header[16:]))
+			hot = true
+		}
+		offset += sectorSize
+		//!A count of 0xffffffff means the journal was not synced before the count was known.
+		if count == 0xffffffff {
+			count = (size - offset) / recordSize
+		}
+		for ; count > 0; count-- {
+			if offset+recordSize > size {
+				return records, dbSize, hot, nil
+			}
+			record := make([]byte, recordSize)
+			if _, err := journal.ReadAt(record, offset); err != nil && err != io.EOF {
+				return nil, 0, false, err
+			}
+			offset += recordSize
+			pageNo := int64(be.Uint32(record))
+			data := record[4 : 4+pageSize]
+			if pageNo == 0 || journalChecksum(nonce, data) != be.Uint32(record[4+pageSize:]) {
+				return records, dbSize, hot, nil
+			}
+			records = append(records, journalRecord{pageNo: pageNo, data: data})
+		}
+		//!The next segment starts on a sector boundary.
+		offset = (offset + sectorSize - 1) / sectorSize * sectorSize
+	}
+	return records, dbSize, hot, nil
+}
+
+// recoverJournal rolls back the transaction of a hot -journal file left by a writer that
+// crashed, under an EXCLUSIVE lock: it writes the saved pages back, truncates the database to its size before the
+// transaction and deletes the journal. It reports whether the database was changed.
+func (pager *Pager) recoverJournal() (bool, error) {
+	journalPath := pager.path + "-journal"
+	exists, err := pager.vfs.Exists(journalPath)
+	if err != nil || !exists {
+		return false, err
+	}
+	//!While a writer holds RESERVED or more, its journal is in use rather than hot.
+	if reserved, err := pager.file.CheckReservedLock(); err != nil || reserved {
+		return false, err
+	}
+	journal, err := pager.vfs.Open(journalPath, OpenReadOnly)
+	if err != nil {
+		return false, err
+	}
+	defer journal.Close()
+	size, err := journal.Size()
+	if err != nil {
+		return false, err
+	}
+	//!A journal pointing at a super-journal that is gone belongs to a committed transaction.
+	//!Like SQLite, a connection that can write deletes it.
+	if name, err := superJournal(journal, size); err != nil {
+		return false, err
+	} else if name != "" {
+		exists, err := pager.vfs.Exists(name)
+		if err != nil || pager.readOnly && !exists {
+			return false, err
+		}
+		if !exists {
+			if err := pager.lockDatabase(LockExclusive); err != nil {
+				return false, err
+			}
+			defer pager.file.Unlock(LockShared)
+			return false, pager.vfs.Delete(journalPath)
+		}
+	}
+	records, dbSize, hot, err := readJournal(journal, pager.pageSize)
+	if err != nil || !hot {
+		return false, err
+	}
+	if pager.readOnly {
+		return false, &ErrHotJournal{Path: journalPath}
+	}
+
+	if err := pager.lockDatabase(LockExclusive); err != nil {
+		return false, err
+	}
+	defer pager.file.Unlock(LockShared)
+	for _, record := range records {
+		if _, err := pager.file.WriteAt(record.data, getPageOffset(record.pageNo, pager.pageSize)); err != nil {
+			return false, err
+		}
+	}
+	if err := pager.file.Truncate(dbSize * pager.pageSize); err != nil {
+		return false, err
+	}
+	if err := pager.file.Sync(); err != nil {
+		return false, err
+	}
+	return true, pager.vfs.Delete(journalPath)
+}
+
+// writeJournal saves the content before the transaction of every changed page that existed
+// then to the -journal file. As SQLite does on file systems without safe appends, the records
+// are synced under a header that counts none of them, and only then is the count written and
+// synced: a journal cut short by a crash never holds a record that is not intact.
+func (pager *Pager) writeJournal() error {
+	journal, err := pager.vfs.Open(pager.path+"-journal", OpenReadWrite|OpenCreate)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	if err := journal.Truncate(0); err != nil {
+		return err
+	}
+	be := binary.BigEndian
+	nonce := rand.Uint32()
+	header := make([]byte, journalSectorSize)
+	copy(header, journalMagic)
+	be.PutUint32(header[12:], nonce)
+	be.PutUint32(header[16:], uint32(pager.initialSize))
+	be.PutUint32(header[20:], journalSectorSize)
+	be.PutUint32(header[24:], uint32(pager.pageSize))
+	if _, err := journal.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	var pageNos []int64
+	for pageNo := range pager.original {
+		if _, ok := pager.dirty[pageNo]; ok {
+			pageNos = append(pageNos, pageNo)
+		}
+	}
+	slices.Sort(pageNos)
+	record := make([]byte, pager.pageSize+8)
+	offset := int64(journalSectorSize)
+	for _, pageNo := range pageNos {
+		data := pager.original[pageNo]
+		be.PutUint32(record, uint32(pageNo))
+		copy(record[4:], data)
+		be.PutUint32(record[4+pager.pageSize:], journalChecksum(nonce, data))
+		if _, err := journal.WriteAt(record, offset); err != nil {
+			return err
+		}
+		offset += int64(len(record))
+	}
+	if err := journal.Sync(); err != nil {
+		return err
+	}
+	be.PutUint32(header[8:], uint32(len(pageNos)))
+	if _, err := journal.WriteAt(header[:journalHeaderSize], 0); err != nil {
+		return err
+	}
+	return journal.Sync()
+}
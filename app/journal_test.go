@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+// journalBytes builds a rollback journal for a database of dbSize pages holding records, with
+// count as the record count of its header.
+func journalBytes(pageSize int64, dbSize int64, count uint32, records []journalRecord) []byte {
+	const nonce = 0x9e3779b9
+	be := binary.BigEndian
+	header := make([]byte, journalSectorSize)
+	copy(header, journalMagic)
+	be.PutUint32(header[8:], count)
+	be.PutUint32(header[12:], nonce)
+	be.PutUint32(header[16:], uint32(dbSize))
+	be.PutUint32(header[20:], journalSectorSize)
+	be.PutUint32(header[24:], uint32(pageSize))
+	journal := header
+	for _, record := range records {
+		raw := make([]byte, pageSize+8)
+		be.PutUint32(raw, uint32(record.pageNo))
+		copy(raw[4:], record.data)
+		be.PutUint32(raw[4+pageSize:], journalChecksum(nonce, record.data))
+		journal = append(journal, raw...)
+	}
+	return journal
+}
+
+// withSuperJournal appends the trailer naming a super-journal that a transaction spanning
+// several databases leaves at the end of each of their journals.
+func withSuperJournal(journal []byte, name string) []byte {
+	sum := uint32(0)
+	for _, b := range []byte(name) {
+		sum += uint32(b)
+	}
+	trailer := binary.BigEndian.AppendUint32(nil, uint32(len(name)))
+	trailer = binary.BigEndian.AppendUint32(trailer, sum)
+	return append(append(append(journal, name...), trailer...), journalMagic...)
+}
+
+func TestReadJournal(t *testing.T) {
+	const pageSize = 512
+	records := []journalRecord{
+		{2, bytes.Repeat([]byte{'a'}, pageSize)},
+		{3, bytes.Repeat([]byte{'b'}, pageSize)},
+		{5, bytes.Repeat([]byte{'c'}, pageSize)},
+	}
+	whole := journalBytes(pageSize, 4, 3, records)
+	tests := []struct {
+		name    string
+		journal []byte
+		records int
+		hot     bool
+	}{
+		{"whole", whole, 3, true},
+		{"count not synced", journalBytes(pageSize, 4, 0xffffffff, records), 3, true},
+		{"count 0", journalBytes(pageSize, 4, 0, records), 0, true},
+		{"torn record", whole[:len(whole)-100], 2, true},
+		{"unsynced count, torn record", journalBytes(pageSize, 4, 0xffffffff, records)[:len(whole)-100], 2, true},
+		{"bad checksum", func() []byte {
+			journal := bytes.Clone(whole)
+			journal[journalSectorSize+pageSize+8+4+pageSize-200] ^= 1 //!A byte of the second page the checksum covers.
+			return journal
+		}(), 1, true},
+		{"zeroed header", append(make([]byte, journalHeaderSize), whole[journalHeaderSize:]...), 0, false},
+		{"short header", whole[:journalHeaderSize-1], 0, false},
+	}
+	for _, test := range tests {
+		vfs := NewMemVFS()
+		vfs.Store("test.db-journal", test.journal)
+		file, _ := vfs.Open("test.db-journal", OpenReadOnly)
+		got, dbSize, hot, err := readJournal(file, pageSize)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(got) != test.records || hot != test.hot || (hot && dbSize != 4) {
+			t.Errorf("%s: %d records, hot %v, size %d; want %d, %v, 4", test.name, len(got), hot, dbSize, test.records, test.hot)
+		}
+		for i, record := range got {
+			if record.pageNo != records[i].pageNo || !bytes.Equal(record.data, records[i].data) {
+				t.Errorf("%s: record %d is of page %d", test.name, i, record.pageNo)
+			}
+		}
+	}
+
+	//!A journal written for another page size, or with a bad sector size, cannot be played back.
+	vfs := NewMemVFS()
+	vfs.Store("test.db-journal", whole)
+	file, _ := vfs.Open("test.db-journal", OpenReadOnly)
+	var corrupt *ErrCorrupt
+	if _, _, _, err := readJournal(file, 1024); !errors.As(err, &corrupt) {
+		t.Errorf("other page size: %v, want a corrupt journal", err)
+	}
+}
+
+func TestHotJournalRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    uint32
+		super    string //!Name of the super-journal recorded in the journal.
+		hasSuper bool   //!The super-journal exists.
+		want     string
+	}{
+		{"journal", 0, "", false, "1"},
+		{"count not synced", 0xffffffff, "", false, "1"},
+		{"super-journal", 0, "test.db-mj01", true, "1"},
+		{"super-journal gone", 0, "test.db-mj01", false, "1,2"}, //!Its transaction committed, and the journal is stale.
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := newDatabase(t)
+			runSQL(t, path, "create table t(a)", "insert into t values (1)")
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runSQL(t, path, "insert into t values (2)")
+
+			//!The journal a writer that crashed after writing the new rows would leave.
+			const pageSize = 4096
+			var records []journalRecord
+			for pageNo := int64(1); pageNo*pageSize <= int64(len(before)); pageNo++ {
+				records = append(records, journalRecord{pageNo, before[(pageNo-1)*pageSize : pageNo*pageSize]})
+			}
+			count := test.count
+			if count == 0 {
+				count = uint32(len(records))
+			}
+			journal := journalBytes(pageSize, int64(len(before))/pageSize, count, records)
+			if test.super != "" {
+				journal = withSuperJournal(journal, strings.Replace(path, "test.db", test.super, 1))
+				if test.hasSuper {
+					os.WriteFile(strings.Replace(path, "test.db", test.super, 1), []byte(path+"\x00"), 0o644)
+				}
+			}
+			if err := os.WriteFile(path+"-journal", journal, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			//!A read-only connection cannot roll the transaction back, so it cannot read.
+			vfs, _ := findVFS("")
+			pager, err := openPager(vfs, path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = pager.refresh()
+			pager.endRead()
+			pager.Close()
+			var hot *ErrHotJournal
+			if hotJournal := errors.As(err, &hot); hotJournal != (test.want == "1") || !hotJournal && err != nil {
+				t.Errorf("read-only refresh: %v", err)
+			}
+
+			if got := strings.TrimSpace(runSQL(t, path, "select group_concat(a) from t")); got != test.want {
+				t.Errorf("rows: %q, want %q", got, test.want)
+			}
+			if _, err := os.Stat(path + "-journal"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("journal left behind: %v", err)
+			}
+			if after, _ := os.ReadFile(path); test.want == "1" && !bytes.Equal(after, before) {
+				t.Error("the database differs from before the transaction")
+			}
+			checkIntegrity(t, path)
+		})
+	}
+}
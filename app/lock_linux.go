@@ -0,0 +1,12 @@
+//go:build linux
+
+package main
+
+// On Linux the locks are open file description locks. They conflict with the POSIX locks of
+// sqlite3 processes as usual, but belong to the open file rather than to the process: two
+// connections of one process exclude each other too, and closing one file does not drop the
+// locks another holds.
+const (
+	setLockCommand = 37 //!F_OFD_SETLK
+	getLockCommand = 36 //!F_OFD_GETLK
+)
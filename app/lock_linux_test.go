@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openLockFile opens the database at path for a connection of its own.
+func openLockFile(t *testing.T, path string) File {
+	t.Helper()
+	file, err := OSVFS{}.Open(path, OpenReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestLockEscalation(t *testing.T) {
+	path := newDatabase(t)
+	reader, writer, late := openLockFile(t, path), openLockFile(t, path), openLockFile(t, path)
+	steps := []struct {
+		file  File
+		level LockLevel
+		busy  bool
+	}{
+		{reader, LockShared, false},
+		{writer, LockShared, false},
+		{writer, LockReserved, false},
+		{reader, LockReserved, true}, //!One writer at a time.
+		{writer, LockExclusive, true},
+		{late, LockShared, true}, //!The writer now holds PENDING, which keeps new readers out.
+		{reader, LockShared, false},
+	}
+	for i, step := range steps {
+		err := step.file.Lock(step.level)
+		if busy := errors.Is(err, ErrBusy); busy != step.busy || (err != nil && !busy) {
+			t.Fatalf("step %d: Lock(%d) = %v, want busy %v", i, step.level, err, step.busy)
+		}
+	}
+	if err := reader.Unlock(LockNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Lock(LockExclusive); err != nil {
+		t.Fatalf("EXCLUSIVE once the reader left: %v", err)
+	}
+	if reserved, err := late.CheckReservedLock(); err != nil || !reserved {
+		t.Errorf("CheckReservedLock = %v, %v, want true", reserved, err)
+	}
+	if err := writer.Unlock(LockNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := late.Lock(LockShared); err != nil {
+		t.Errorf("SHARED once the writer left: %v", err)
+	}
+}
+
+func TestBusyConnections(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(a)")
+
+	//!A reader keeps a writer from committing, and the failed commit leaves no hot journal for
+	//!a read-only connection to trip on.
+	_, _, done := openDatabase(t, path)
+	if _, code := runShell(path, "", "insert into t values (1)"); code != resultBusy {
+		t.Errorf("insert under a reader: exit code %d, want %d", code, resultBusy)
+	}
+	done()
+	if _, err := os.Stat(path + "-journal"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal after the failed commit: %v", err)
+	}
+	_, _, done = openDatabase(t, path)
+	done()
+	if got := strings.TrimSpace(runSQL(t, path, "select count(*) from t")); got != "0" {
+		t.Errorf("rows after the failed insert: %s", got)
+	}
+
+	//!With a busy timeout, the writer waits for the reader to leave.
+	_, _, done = openDatabase(t, path)
+	time.AfterFunc(50*time.Millisecond, done)
+	if out, code := runShell(path, "", ".timeout 2000", "insert into t values (2)"); code != 0 {
+		t.Errorf("insert waiting for a reader: exit code %d, output %q", code, out)
+	}
+
+	//!A writer in an open transaction holds RESERVED, keeping other writers out but not readers.
+	vfs, _ := findVFS("")
+	pager, err := openPager(vfs, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+	if _, err := pager.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.beginTransaction("IMMEDIATE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, code := runShell(path, "", "insert into t values (3)"); code != resultBusy {
+		t.Errorf("insert while another connection writes: exit code %d, want %d", code, resultBusy)
+	}
+	if got := strings.TrimSpace(runSQL(t, path, "select a from t")); got != "2" {
+		t.Errorf("read while another connection writes: %q, want 2", got)
+	}
+	if err := pager.endTransaction(false); err != nil {
+		t.Fatal(err)
+	}
+	pager.endRead()
+	runSQL(t, path, "insert into t values (3)")
+	checkIntegrity(t, path)
+}
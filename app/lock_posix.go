@@ -0,0 +1,12 @@
+//go:build unix && !linux
+
+package main
+
+import "syscall"
+
+// Elsewhere the locks are classic POSIX locks, which belong to the process: they keep other
+// processes out, but not other connections of this one.
+const (
+	setLockCommand = syscall.F_SETLK
+	getLockCommand = syscall.F_GETLK
+)
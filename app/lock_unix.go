@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockRange takes or releases a POSIX advisory lock on n bytes of file at offset, the locks
+// SQLite coordinates processes with. It does not wait: a lock another process, or another
+// open file where setLockCommand allows, holds returns ErrBusy.
+func lockRange(file *os.File, mode RangeLock, offset int64, n int64) error {
+	lock := syscall.Flock_t{Whence: io.SeekStart, Start: offset, Len: n}
+	switch mode {
+	case RangeUnlocked:
+		lock.Type = syscall.F_UNLCK
+	case RangeShared:
+		lock.Type = syscall.F_RDLCK
+	case RangeExclusive:
+		lock.Type = syscall.F_WRLCK
+	}
+	err := syscall.FcntlFlock(file.Fd(), setLockCommand, &lock)
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return ErrBusy
+	}
+	return err
+}
+
+// rangeLocked reports whether another process, or another open file where getLockCommand
+// allows, holds a lock on any of n bytes of file at offset.
+func rangeLocked(file *os.File, offset int64, n int64) (bool, error) {
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart, Start: offset, Len: n}
+	if err := syscall.FcntlFlock(file.Fd(), getLockCommand, &lock); err != nil {
+		return false, err
+	}
+	return lock.Type != syscall.F_UNLCK, nil
+}
@@ -1,123 +1,28 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
+	"flag"
 	"fmt"
-	"log"
-	"math"
+	"io"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
 	// Available if you need it!
 	// "github.com/xwb1989/sqlparser"
 )
 
-// ParsedSQL holds the extracted data from the SQL query.
-type ParsedSQLQuery struct {
-	Columns   []string
-	Table     string
-	Condition string
-}
-
-// parseSQL extracts columns, table, and where condition from an SQL query.
-func parseSQL(query string) ParsedSQLQuery {
-	// Use case-insensitive regex patterns.
-	selectRegex := regexp.MustCompile(`(?i)\bSELECT\s+(.+?)\s+\bFROM\b`)
-	fromRegex := regexp.MustCompile(`(?i)\bFROM\s+(\w+)\b`)
-	whereRegex := regexp.MustCompile(`(?i)\bWHERE\s+(.+)`)
-
-	// Match the query against each regex pattern.
-	columnsMatch := selectRegex.FindStringSubmatch(query)
-	tableMatch := fromRegex.FindStringSubmatch(query)
-	whereMatch := whereRegex.FindStringSubmatch(query)
-
-	// Extract and clean the columns.
-	var columns []string
-	if len(columnsMatch) > 1 {
-		columns = strings.Split(columnsMatch[1], ",")
-		for i := range columns {
-			columns[i] = strings.TrimSpace(columns[i])
-		}
-	}
-
-	// Extract the table name.
-	table := ""
-	if len(tableMatch) > 1 {
-		table = tableMatch[1]
-	}
-
-	// Extract the WHERE condition.
-	condition := ""
-	if len(whereMatch) > 1 {
-		condition = strings.TrimSpace(whereMatch[1])
-	}
-
-	return ParsedSQLQuery{
-		Columns:   columns,
-		Table:     table,
-		Condition: condition,
-	}
-}
-
-// Function to extract table details from a SQL schema string.
-func getTableDetailsFromSQLSchemaTable(sql string) (string, []string, []string, string) {
-	// Updated regex to handle escaped table names and complex column definitions.
-	re := regexp.MustCompile(`(?i)CREATE\s+TABLE\s+"?(\w+)"?\s*\(([^)]+)\)`)
-	match := re.FindStringSubmatch(sql)
-	if len(match) < 3 {
-		log.Fatal("Failed to parse the SQL statement.")
-	}
-
-	// Extract table name and columns string.
-	tableName := match[1]
-	columnsStr := match[2]
-
-	// Split columns by commas but handle cases where commas appear inside column definitions.
-	columns := splitColumnsByComma(columnsStr)
-
-	var colNames []string
-	var colContentTypes []string
-
-	var autoincrementedKey string;
-
-	// Iterate over columns to extract column names and types.
-	for _, col := range columns {
-		col = strings.TrimSpace(col) // Trim spaces around the column.
-		parts := strings.Fields(col) // Split by spaces into name, type, and constraints.
-
-		if len(parts) >= 2 {
-			columnName := parts[0] // First part is the column name.
-			dataType := parts[1]   // Second part is the data type.
-
-			// Collect column names and data types.
-			colNames = append(colNames, columnName)
-			colContentTypes = append(colContentTypes, dataType)
-		}
-
-		for _, part := range parts {
-			if(part == "autoincrement") {
-				autoincrementedKey = parts[0];
-				break;
-			}
-		}
-	}
-
-	return tableName, colNames, colContentTypes, autoincrementedKey
-}
-
 // Helper function to split columns while handling commas inside definitions.
 func splitColumnsByComma(columnsStr string) []string {
 	var columns []string
 	var currentColumn strings.Builder
 	inQuotes := false
+	depth := 0
 
 	for _, char := range columnsStr {
 		switch char {
 		case ',':
-			if inQuotes {
-				currentColumn.WriteRune(char) // Keep comma if inside quotes.
+			if inQuotes || depth > 0 {
+				currentColumn.WriteRune(char) // Keep comma if inside quotes or parentheses, e.g. DECIMAL(10,2).
 			} else {
 				columns = append(columns, currentColumn.String())
 				currentColumn.Reset()
@@ -125,6 +30,16 @@ func splitColumnsByComma(columnsStr string) []string {
 		case '"':
 			inQuotes = !inQuotes // Toggle the inQuotes flag.
 			currentColumn.WriteRune(char)
+		case '(':
+			if !inQuotes {
+				depth++
+			}
+			currentColumn.WriteRune(char)
+		case ')':
+			if !inQuotes {
+				depth--
+			}
+			currentColumn.WriteRune(char)
 		default:
 			currentColumn.WriteRune(char)
 		}
@@ -146,10 +61,15 @@ func getPageOffset(pageno int64, pageSize int64) int64 {
 //!For using the go inbuild function binary.Varint, we need to send least significant numbers first and then the most significant ones.
 //!But in out case, we have most significant ones first and then least. So here using the custom method.
 
-//!Not taking care of negative value. In the documentation it is given that take care of negative values as well.
+//!The first eight bytes give 7 bits each while their high bit is set; a ninth byte gives all
+//!8 of its bits. Signed values such as negative rowids are the two's complement, so callers
+//!convert the result with int64(). Returns 0 bytes read when buf ends inside the varint.
 func ReadVarint(buf []byte) (uint64, int) {
 	var x uint64
 	for i, b := range buf {
+		if i == 8 {
+			return x << 8 | uint64(b), 9
+		}
 		x = (x << 7) | uint64(b & 0x7F)
 		if b & 0x80 == 0 {
 			return x, i + 1
@@ -158,298 +78,305 @@ func ReadVarint(buf []byte) (uint64, int) {
 	return 0, 0
 }
 
-func interpretBytes(serialType int64, raw []byte) (int64, interface{}) {	
-	switch serialType {
-	case 0:
-		return 0, nil
-	case 1:
-		val8 := int8(raw[0]);
-		return 1, int64(val8);
-	case 2:
-		val16 := int16(binary.BigEndian.Uint16(raw[:2]))
-		return 2, int64(val16);
-	case 3:
-		value32:= int32(raw[0]) << 16 | int32(raw[1]) << 8 | int32(raw[2]);
-		if value32 & 0x800000 != 0 {
-			value32 |= -1 << 24	//!Since it is a 2 compliments integer, taking care of negative values.
-		}
-		return 3, int64(value32);
-	case 4:
-		val32 := int32(binary.BigEndian.Uint32(raw[:4]))
-		return 4, int64(val32);
-	case 5:
-		val48 := int64(raw[0])<<40 | int64(raw[1])<<32 | int64(raw[2])<<24 | int64(raw[3])<<16 | int64(raw[4])<<8 | int64(raw[5])
-
-		// Check if the sign bit (48th bit) is set
-		if val48 & 0x800000000000 != 0 {
-			val48 |= -1 << 48
-		}
-		return 6, val48;
-	case 6:
-		val64 := int64(binary.BigEndian.Uint64(raw[:8]))
-		return 8, int64(val64);
-	case 7:
-		floatVal64 := math.Float64frombits(binary.BigEndian.Uint64(raw[:8]));
-		return 8, floatVal64;
-	case 8:
-		return 0, int64(0)
-	case 9:
-		return 0, int64(0)
-	default:
-		if serialType%2 == 0 && serialType >= 12 {
-			serialBytesCount := (serialType - 12) / 2
-			return serialBytesCount, raw[0:serialBytesCount];
-		} else if serialType%2 != 0 && serialType > 13 {
-			serialBytesCount := (serialType - 13) / 2
-			localString := string(raw[0:serialBytesCount])
-			return serialBytesCount, localString;
-		}
+//!Number of bytes PutVarint uses for v.
+func VarintLen(v uint64) int {
+	if v >> 56 != 0 {
+		return 9
+	}
+	n := 1;
+	for v >>= 7; v != 0; v >>= 7 {
+		n++;
 	}
-	return -1, nil // Adding a return statement in case none of the cases match
+	return n
 }
 
-//!Record belongs to one row from the table containing all the cols
-func parseRecord(recordRaw []byte) ([]int64, []interface{}) {
-	//!Record is basically each row of the table or index.
-	totalBytesCountHeader, hSize := ReadVarint(recordRaw);
-
-	var currOffset int64;
-	currOffset = int64(hSize);
-	
-	var colsSerialTypes []int64
-	var colContents []interface{}
-
-	contentBytesOffset := int64(totalBytesCountHeader);
-
-	for currOffset < int64(totalBytesCountHeader) {
-		serialTypeU, bytesRead := ReadVarint(recordRaw[currOffset : totalBytesCountHeader]);
-		serialType := int64(serialTypeU);
-		bytesReadForContent, contentValue := interpretBytes(serialType, recordRaw[contentBytesOffset:])	
-		currOffset += int64(bytesRead);
-		contentBytesOffset += int64(bytesReadForContent);
-		colsSerialTypes = append(colsSerialTypes, serialType);
-		colContents = append(colContents, contentValue);
-	}
-	return colsSerialTypes, colContents
+//!Writes v to the start of buf, which must have room for VarintLen(v) bytes, and returns the
+//!number of bytes written.
+func PutVarint(buf []byte, v uint64) int {
+	n := VarintLen(v);
+	if n == 9 {
+		buf[8] = byte(v);
+		v >>= 8;
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v & 0x7F) | 0x80;
+			v >>= 7;
+		}
+		return 9
+	}
+	for i := n - 1; i >= 0; i-- {
+		buf[i] = byte(v & 0x7F) | 0x80;
+		v >>= 7;
+	}
+	buf[n - 1] &= 0x7F;
+	return n
 }
 
+//!Appends the varint encoding of v to buf.
+func AppendVarint(buf []byte, v uint64) []byte {
+	var encoded [9]byte
+	n := PutVarint(encoded[:], v);
+	return append(buf, encoded[:n]...)
+}
+
+//!Payload thresholds from the file format: a payload up to maxLocal bytes is kept whole on
+//!the page, a larger one keeps a part on the page and continues on overflow pages.
+func tableLeafMaxLocal(usableSize int64) int64 {
+	return usableSize - 35;
+}
+
+func indexMaxLocal(usableSize int64) int64 {
+	return (usableSize - 12) * 64 / 255 - 23;
+}
+
+//!Number of payload bytes stored on the page itself; the rest goes to overflow pages.
+func localPayloadSize(usableSize int64, payloadSize int64, maxLocal int64) int64 {
+	if payloadSize <= maxLocal {
+		return payloadSize
+	}
+	minLocal := (usableSize - 12) * 32 / 255 - 23;
+	localSize := minLocal + (payloadSize - minLocal) % (usableSize - 4);
+	if localSize > maxLocal {
+		localSize = minLocal;
+	}
+	return localSize
+}
 
-func readIndexInteriorCell(pageBytes []byte, cellOffset uint16) (int64, []int64, []interface{}) {
+//!Returns the payload of a cell, following its chain of overflow pages when it does not fit in
+//!the page.
+func cellPayload(pager *Pager, pageBytes []byte, payloadOffset int64, payloadSize uint64, maxLocal int64) ([]byte, error) {
+	usableSize := pager.usableSize;
+	if payloadSize > 1000000000 {
+		return nil, fmt.Errorf("payload size %d is too large", payloadSize)
+	}
+	localSize := localPayloadSize(usableSize, int64(payloadSize), maxLocal);
+	if localSize == int64(payloadSize) {
+		if payloadOffset + localSize > int64(len(pageBytes)) {
+			return nil, fmt.Errorf("payload of %d bytes does not fit in the page", payloadSize)
+		}
+		return pageBytes[payloadOffset : payloadOffset + localSize], nil
+	}
+
+	if payloadOffset + localSize + 4 > int64(len(pageBytes)) {
+		return nil, fmt.Errorf("local payload of %d bytes does not fit in the page", localSize)
+	}
+	payload := make([]byte, 0, payloadSize);
+	payload = append(payload, pageBytes[payloadOffset : payloadOffset + localSize]...);
+	overflowPageNo := int64(binary.BigEndian.Uint32(pageBytes[payloadOffset + localSize:]));
+	for uint64(len(payload)) < payloadSize {
+		if overflowPageNo == 0 {
+			return nil, fmt.Errorf("overflow chain ends after %d of %d payload bytes", len(payload), payloadSize)
+		}
+		overflowPage, err := pager.page(overflowPageNo);
+		if err != nil {
+			return nil, err
+		}
+		//!Each overflow page starts with the number of the next one.
+		chunk := payloadSize - uint64(len(payload));
+		if chunk > uint64(usableSize - 4) {
+			chunk = uint64(usableSize - 4);
+		}
+		payload = append(payload, overflowPage[4 : 4 + chunk]...);
+		overflowPageNo = int64(binary.BigEndian.Uint32(overflowPage[:4]));
+	}
+	return payload, nil
+}
+
+func readIndexInteriorCell(pager *Pager, pageBytes []byte, cellOffset uint16) (int64, []Value, error) {
+	if int(cellOffset) + 4 > len(pageBytes) {
+		return 0, nil, fmt.Errorf("cell starts past the end of the page")
+	}
 	pagePtrBytes := pageBytes[cellOffset: cellOffset + 4];
 	leftPointer := int64(binary.BigEndian.Uint32(pagePtrBytes));
 	cellOffset += 4; //Add size of left page.
-	//payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset : cellOffset + 9]);
-	payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset : ]);	
-	currOffset := cellOffset + uint16(sizeBytesRead);
-	currCellPayloadBytes := pageBytes[int64(currOffset) : int64(currOffset) + int64(payloadSizeInBytes)];
-
-	//!Parse this record
-	cellColsSerialType, cellColsContent := parseRecord(currCellPayloadBytes);
-	return leftPointer, cellColsSerialType, cellColsContent	
+	cellColsContent, err := readIndexLeafCell(pager, pageBytes, cellOffset)
+	return leftPointer, cellColsContent, err
 }
 
 
-func readIndexLeafCell(pageBytes []byte, cellOffset uint16) ([]int64, []interface{}) {
-	//payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset : cellOffset + 9]);
+func readIndexLeafCell(pager *Pager, pageBytes []byte, cellOffset uint16) ([]Value, error) {
+	if int(cellOffset) >= len(pageBytes) {
+		return nil, fmt.Errorf("cell starts past the end of the page")
+	}
 	payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset :]);	
-	currOffset := cellOffset + uint16(sizeBytesRead);
-	currCellPayloadBytes := pageBytes[int64(currOffset) : int64(currOffset) + int64(payloadSizeInBytes)];
+	if sizeBytesRead == 0 {
+		return nil, fmt.Errorf("cell header runs past the end of the page")
+	}
+	currOffset := int64(cellOffset) + int64(sizeBytesRead);
+	currCellPayloadBytes, err := cellPayload(pager, pageBytes, currOffset, payloadSizeInBytes, indexMaxLocal(pager.usableSize))
+	if err != nil {
+		return nil, err
+	}
 
 	//!Parse this record
-	cellColsSerialType, cellColsContent := parseRecord(currCellPayloadBytes);
-	return cellColsSerialType, cellColsContent
+	return DecodeRecord(currCellPayloadBytes, pager.encoding);
 }
 
 //!Assuming it is cell of type ==> Table B-Tree Leaf Cell:
-//!Also assumption is that there is no overflow
-//!Does go pass value by reference or by value. Look into it.
-func readTableLeafCell(pageBytes []byte, cellOffset uint16) (int64, []int64, []interface{}) {
-	payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset : cellOffset + 9]);
-	//payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset :]);	
-	currOffset := cellOffset + uint16(sizeBytesRead);
-	id, rowIdBytesRead := ReadVarint(pageBytes[currOffset : currOffset + 9]);
-	//id, rowIdBytesRead := ReadVarint(pageBytes[currOffset : ]);
-	currOffset += uint16(rowIdBytesRead);
-	currCellPayloadBytes := pageBytes[int64(currOffset) : int64(currOffset) + int64(payloadSizeInBytes)];
+func readTableLeafCell(pager *Pager, pageBytes []byte, cellOffset uint16) (int64, []Value, error) {
+	if int(cellOffset) >= len(pageBytes) {
+		return 0, nil, fmt.Errorf("cell starts past the end of the page")
+	}
+	payloadSizeInBytes, sizeBytesRead := ReadVarint(pageBytes[cellOffset :]);
+	currOffset := int64(cellOffset) + int64(sizeBytesRead);
+	id, rowIdBytesRead := ReadVarint(pageBytes[currOffset : ]);
+	if sizeBytesRead == 0 || rowIdBytesRead == 0 {
+		return 0, nil, fmt.Errorf("cell header runs past the end of the page")
+	}
+	currOffset += int64(rowIdBytesRead);
+	currCellPayloadBytes, err := cellPayload(pager, pageBytes, currOffset, payloadSizeInBytes, tableLeafMaxLocal(pager.usableSize))
+	if err != nil {
+		return 0, nil, err
+	}
 
 	//!Parse this record
-	cellColsSerialType, cellColsContent := parseRecord(currCellPayloadBytes);
-	return int64(id), cellColsSerialType, cellColsContent
+	cellColsContent, err := DecodeRecord(currCellPayloadBytes, pager.encoding);
+	return int64(id), cellColsContent, err
 }
 
-//!Code for reading index
-//!Never call this on first page:
-func readIndex(databaseFile *os.File, pageSize int64, indexRootPageNo int64, equalCondition string) ([]int64) {
-
-	indexPageOffset := getPageOffset(indexRootPageNo, int64(pageSize));
-	currPageBytes := make([]byte, int64(pageSize));
-	databaseFile.ReadAt(currPageBytes, indexPageOffset);
+//!Reads a b-tree page and checks its type. Returns the usable part of the page, the offset of the
+//!page header (100 on page one), the cell pointers and the right-most child page for interior pages.
+func readBtreePage(pager *Pager, pageNo int64, leafType byte, interiorType byte) ([]byte, int64, []uint16, int64, error) {
+	currPageBytes, err := pager.page(pageNo);
+	if err != nil {
+		return nil, 0, nil, 0, err
+	}
+	//!The reserved bytes at the end of each page belong to extensions, never to cells.
+	pageSize := pager.usableSize;
+	currPageBytes = currPageBytes[:pageSize];
 
-	currOffset := int64(0);
+	//!Skip the fileHeader in case of page one.
+	var fileHeaderOffset int64
+	if(pageNo == 1) {
+		fileHeaderOffset = 100;
+	}
+	currOffset := fileHeaderOffset;
 	pageHeaderType := currPageBytes[currOffset]
 	cellsCount := int64(binary.BigEndian.Uint16(currPageBytes[currOffset + 3 : currOffset + 5]))
 
 	var rightmostChildPageNo int64;
 	var pageHeaderSizeInBytes int64;
 
-	if pageHeaderType == 0x02 {	//!Interior index
+	if pageHeaderType == interiorType {
 		pageHeaderSizeInBytes = int64(12)
 		rightmostChildPageNo = int64(binary.BigEndian.Uint32(currPageBytes[currOffset + 8: currOffset + 12])) 
-
-	} else if pageHeaderType == 0x0a {
+	} else if pageHeaderType == leafType {
 		pageHeaderSizeInBytes = int64(8)	
 	} else {
-		fmt.Println("Error, not correct type");
+		return nil, 0, nil, 0, corruptError(pageNo, currOffset, "unexpected page type 0x%02x", pageHeaderType)
 	}
 
 	currOffset += pageHeaderSizeInBytes;
+	if currOffset + 2 * cellsCount > pageSize {
+		return nil, 0, nil, 0, corruptError(pageNo, fileHeaderOffset + 3, "cell count %d does not fit in the page", cellsCount)
+	}
 	//!Get pointers to all the cells.
 	cellPointers := make([]uint16, cellsCount);
-	for i := int64(0); i < cellsCount; i++ { 		//!2 bytes is the cell size		
+	for i := int64(0); i < cellsCount; i++ { 		//!2 bytes is the cell size
 		cellPointers[i] = binary.BigEndian.Uint16(currPageBytes[currOffset + 2 * i : currOffset + 2 * (i + 1)])
+		if int64(cellPointers[i]) < currOffset + 2 * cellsCount || int64(cellPointers[i]) >= pageSize {
+			return nil, 0, nil, 0, corruptError(pageNo, currOffset + 2 * i, "cell pointer %d out of range", cellPointers[i])
+		}
 	}
+	return currPageBytes, fileHeaderOffset, cellPointers, rightmostChildPageNo, nil
+}
 
-	//!This is a leaf page. Get the matching one and return.
+//!Code for reading index
+//!Returns the rowids of every index entry whose first column equals key under collation.
+func readIndex(pager *Pager, indexRootPageNo int64, key interface{}, collation string) ([]int64, error) {
+	currPageBytes, _, cellPointers, rightmostChildPageNo, err := readBtreePage(pager, indexRootPageNo, 0x0a, 0x02);
+	if err != nil {
+		return nil, err
+	}
 
 	//!If leaf, directly fetch the content and return, if not recurse.
-	if(pageHeaderType == 0x0a) {	//!Leaf page
+	if(rightmostChildPageNo == 0) {	//!Leaf page
 		var outputKeys []int64;
 		for _, cellPointer := range cellPointers {
-			serialType, cellColsContent := readIndexLeafCell(currPageBytes, cellPointer)
-			if(serialType[0] != 0) {
-				if(cellColsContent[0].(string) == equalCondition) {
-					ok := cellColsContent[1].(int64);				
-					outputKeys = append(outputKeys, ok);
-				}
+			cellColsContent, err := readIndexLeafCell(pager, currPageBytes, cellPointer)
+			if err != nil {
+				return nil, corruptError(indexRootPageNo, int64(cellPointer), "%v", err)
+			}
+			if len(cellColsContent) < 2 {
+				return nil, corruptError(indexRootPageNo, int64(cellPointer), "index record has %d columns", len(cellColsContent))
+			}
+			if(compareValues(cellColsContent[0].Interface(), key, collation, pager.encoding) == 0) {
+				outputKeys = append(outputKeys, cellColsContent[len(cellColsContent) - 1].Int);
 			}
 		}
-		return outputKeys;
+		return outputKeys, nil;
 	}
 
 	var outKeys []int64;
-	
-
-	var cellConds []string;
-	var cellKeys []int64;
-	var cellLeftPageNos []int64;
 
 	for _, cellPointer := range cellPointers {
-		leftChildPageNo, serialType, cellColsContent := readIndexInteriorCell(currPageBytes, cellPointer);
-		var condName  string;
-		if(serialType[0] == 0) {
-			condName = "nil"
-		} else {
-			condName = cellColsContent[0].(string);
+		leftChildPageNo, cellColsContent, err := readIndexInteriorCell(pager, currPageBytes, cellPointer);
+		if err != nil {
+			return nil, corruptError(indexRootPageNo, int64(cellPointer), "%v", err)
+		}
+		if len(cellColsContent) < 2 {
+			return nil, corruptError(indexRootPageNo, int64(cellPointer), "index record has %d columns", len(cellColsContent))
+		}
+		currOutKeys, err := readIndex(pager, leftChildPageNo, key, collation);
+		if err != nil {
+			return nil, err
 		}
-		cellKey := cellColsContent[1].(int64);
-		cellConds = append(cellConds, condName);
-		cellKeys = append(cellKeys, cellKey);
-		cellLeftPageNos = append(cellLeftPageNos, leftChildPageNo);
-	}
-
-	// var rids []int64;
-	for iCell, childPageNo := range cellLeftPageNos {
-		currOutKeys := readIndex(databaseFile, pageSize, childPageNo, equalCondition);
 		outKeys = append(outKeys, currOutKeys...);
-		if(cellConds[iCell] == equalCondition) {
-			outKeys = append(outKeys, cellKeys[iCell]);
+		if(compareValues(cellColsContent[0].Interface(), key, collation, pager.encoding) == 0) {
+			outKeys = append(outKeys, cellColsContent[len(cellColsContent) - 1].Int);
 		}
 	}
 
-	currOutKeys := readIndex(databaseFile, pageSize, rightmostChildPageNo, equalCondition);
+	currOutKeys, err := readIndex(pager, rightmostChildPageNo, key, collation);
+	if err != nil {
+		return nil, err
+	}
 	outKeys = append(outKeys, currOutKeys...);
 
-	return outKeys;
+	return outKeys, nil;
 }
 
 //!Code for reading inedx ends.
-func ConsiderInterval(leftKey int64, rightKey int64, table map[int64]int64) bool {
-	if(leftKey == -1) {
-		for k , _ := range table {
-			if (k <= rightKey) {
-				return true;
-			}
-		}
-	} else if(rightKey == -1) {
-		for k , _ := range table {
-			if (k > leftKey) {
-				return true;
-			}
+//!Whether any wanted key lies in (leftKey, rightKey]. A nil bound is open, for the left-most and
+//!right-most children: rowids can be negative, so no key value can stand for "unbounded".
+func ConsiderInterval(leftKey *int64, rightKey *int64, table map[int64]int64) bool {
+	for k , _ := range table {
+		if (leftKey == nil || k > *leftKey) && (rightKey == nil || k <= *rightKey) {
+			return true;
 		}
-	} else {
-		for k , _ := range table {
-			if (k <= rightKey && k > leftKey) {
-				return true;
-			}
-		}		
-
 	}
 	return false
 }
 
 
-func readTable(databaseFile *os.File, pageSize int64 , tableRootPageNo int64, toFetchKeyMaps map[int64]int64) ([]int64, []int64, [][]interface{}) {
-	tablePageOffset := getPageOffset(tableRootPageNo, int64(pageSize));
-	currPageBytes := make([]byte, int64(pageSize));
-	databaseFile.ReadAt(currPageBytes, tablePageOffset);
-
-	firstPage := false;
-	if(tableRootPageNo == 1) {
-		firstPage = true;
-	}
-
-	//!Skip the fileHeader in case of page one.
-	var fileHeaderOffset int64
-	fileHeaderOffset = 0;
-	if(firstPage) {
-		fileHeaderOffset = 100;
-	}
-	currOffset := fileHeaderOffset;
-	pageHeaderType := currPageBytes[currOffset]
-	cellsCount := int64(binary.BigEndian.Uint16(currPageBytes[currOffset + 3 : currOffset + 5]))
-
-	var rightmostChildPageNo int64;
-	var pageHeaderSizeInBytes int64;
-
-	if pageHeaderType == 0x05 {
-		pageHeaderSizeInBytes = int64(12)
-		rightmostChildPageNo = int64(binary.BigEndian.Uint32(currPageBytes[currOffset + 8: currOffset + 12])) 
-
-	} else if pageHeaderType == 0x0d {
-		pageHeaderSizeInBytes = int64(8)	
-	} else {
-		fmt.Println("Error, not correct type");
-	}
-
-	currOffset += pageHeaderSizeInBytes;
-	//!Get pointers to all the cells.
-	cellPointers := make([]uint16, cellsCount);
-	for i := int64(0); i < cellsCount; i++ { 		//!2 bytes is the cell size
-		cellPointers[i] = binary.BigEndian.Uint16(currPageBytes[currOffset + 2 * i : currOffset + 2 * (i + 1)])
+func readTable(pager *Pager, tableRootPageNo int64, toFetchKeyMaps map[int64]int64) ([]int64, [][]Value, error) {
+	currPageBytes, _, cellPointers, rightmostChildPageNo, err := readBtreePage(pager, tableRootPageNo, 0x0d, 0x05);
+	if err != nil {
+		return nil, nil, err
 	}
+	cellsCount := int64(len(cellPointers));
 
 	//!If leaf, directly fetch the content and return, if not recurse.
-	if(pageHeaderType == 0x0d) {
-		var colRows [][]interface{};
-		var colSerial []int64;
+	if(rightmostChildPageNo == 0) {
+		var colRows [][]Value;
 		var ids []int64;
 		for _, cellPointer := range cellPointers {
-			id, colSerialTypes, cellColsContent := readTableLeafCell(currPageBytes, cellPointer);
+			id, cellColsContent, err := readTableLeafCell(pager, currPageBytes, cellPointer);
+			if err != nil {
+				return nil, nil, corruptError(tableRootPageNo, int64(cellPointer), "%v", err)
+			}
 						
 			if(len(toFetchKeyMaps) != 0) {
 				_, yes := toFetchKeyMaps[id];
 				if(yes) {
 					colRows = append(colRows, cellColsContent);
-					colSerial = colSerialTypes	//!Would be and should be same for every row.
 					ids = append(ids, id);
 				}				
 			} else {
 				colRows = append(colRows, cellColsContent);
-				colSerial = colSerialTypes	//!Would be and should be same for every row.
 				ids = append(ids, id);
 			}
 		}
-		return ids, colSerial, colRows;
+		return ids, colRows, nil;
 	}
 
 	//!Interior thing, get page no of children
@@ -466,311 +393,168 @@ func readTable(databaseFile *os.File, pageSize int64 , tableRootPageNo int64, to
 		}		
 	}
 	for i, cellPointer := range cellPointers {
+		if int64(cellPointer) + 5 > pager.usableSize {
+			return nil, nil, corruptError(tableRootPageNo, int64(cellPointer), "interior cell past the end of the page")
+		}
 		pagePtrBytes := currPageBytes[cellPointer: cellPointer + 4];
 		childrenPageNos[i] = int64(binary.BigEndian.Uint32(pagePtrBytes));
 
 		rowIdBytes := currPageBytes[cellPointer + 4 : ];
-		interiorRowId, _ := ReadVarint(rowIdBytes);	//!Not needed rn!!
-		cellKeys[i] = int64(interiorRowId);
+		interiorRowId, n := ReadVarint(rowIdBytes);
+		if n == 0 {
+			return nil, nil, corruptError(tableRootPageNo, int64(cellPointer), "interior cell key runs past the end of the page")
+		}
+		cellKeys[i] = int64(interiorRowId);	//!Two's complement, so negative rowids order correctly.
 	} 	
 
 	if(len(toFetchKeyMaps) != 0) {
 		for intIndex, _ := range toConsiderIntervals {
-			if(intIndex == 0) {	//!Left most interval
-				if(ConsiderInterval(int64(-1), cellKeys[intIndex], toFetchKeyMaps)) {
-					toConsiderIntervals[intIndex] = true;
-				}
-			} else if (intIndex == int(cellsCount)) {
-				if(ConsiderInterval(cellKeys[intIndex - 1], int64(-1), toFetchKeyMaps)) {
-					toConsiderIntervals[intIndex] = true;
-				}
-			} else {
-				if(ConsiderInterval(cellKeys[intIndex - 1], cellKeys[intIndex], toFetchKeyMaps)) {
-					toConsiderIntervals[intIndex] = true;
-				}
+			var leftKey, rightKey *int64;
+			if(intIndex > 0) {
+				leftKey = &cellKeys[intIndex - 1];
+			}
+			if(intIndex < int(cellsCount)) {	//!The right-most interval has no upper bound.
+				rightKey = &cellKeys[intIndex];
+			}
+			if(ConsiderInterval(leftKey, rightKey, toFetchKeyMaps)) {
+				toConsiderIntervals[intIndex] = true;
 			}
 		}
 	}
 
 	childrenPageNos[cellsCount] = rightmostChildPageNo;
 
-	var colRows [][]interface{};
-	var colSerial []int64;
+	var colRows [][]Value;
 	var rids []int64;
 	for intIndex, intSelection := range toConsiderIntervals {
 		if(intSelection) {
 			childPageNo := childrenPageNos[intIndex];
-			ids, serialTypes, rowsContaingCols := readTable(databaseFile, pageSize, childPageNo, toFetchKeyMaps);
+			ids, rowsContaingCols, err := readTable(pager, childPageNo, toFetchKeyMaps);
+			if err != nil {
+				return nil, nil, err
+			}
 			colRows = append(colRows, rowsContaingCols...)
-			colSerial = serialTypes;	//!Assuming they are all same.
 			rids = append(rids, ids...)
 		}
 	}
-	return rids, colSerial, colRows;
+	return rids, colRows, nil;
 }
 
-// Usage: your_program.sh sample.db .dbinfo
+// Usage: your_program.sh [OPTIONS] sample.db [COMMAND...]
 func main() {
-	databaseFilePath := os.Args[1]
-	commandRead := os.Args[2]
-
-	command := commandRead;
-	comPrefix := strings.HasPrefix(command, "SELECT") || strings.HasPrefix(command, "select");
-	if(comPrefix) {
-		command = "SELECT";
-	}
-
-
-
-	switch command {
-	case ".dbinfo":
-		//!Get databse page size and get the number of tables in database
-
-
-		databaseFile, err := os.Open(databaseFilePath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		header := make([]byte, 100)
-
-		_, err = databaseFile.Read(header)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		var pageSize uint16
-		if err := binary.Read(bytes.NewReader(header[16:18]), binary.BigEndian, &pageSize); err != nil {
-			fmt.Println("Failed to read integer:", err)
-			return
-		}
-		// You can use print statements as follows for debugging, they'll be visible when running tests.
-		//fmt.Println("Logs from your program will appear here!")
-
-		// Uncomment this to pass the first stage
-		fmt.Printf("database page size: %v\n", pageSize)
-
-		//!By default it is page 1 is at offset zero and contains sqlite_schema.
-		//!var default offset with the size 100
-
-		pageHeader := make([]byte, 12)
-		_, err = databaseFile.Read(pageHeader)
-		if err != nil {
-			log.Fatal(err)
-		}
-		
-		var cellsCount uint16;
-		if err := binary.Read(bytes.NewReader(pageHeader[3:5]), binary.BigEndian, &cellsCount); err != nil {
-			fmt.Println("Failed to get cell count:", err)
-			return
-		}
-
-		intCellCount := int(cellsCount);
-		// Logging the cell count, which is same as tables count in this case, since we don't have other things like index, views, triggers etc.
-		fmt.Printf("number of tables: %v", intCellCount)
-
-
-		databaseFile.Close();
-
-	case ".tables":
-
-		databaseFile, err := os.Open(databaseFilePath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		header := make([]byte, 100)
-		_, err = databaseFile.Read(header)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		var pageSize uint16
-		if err := binary.Read(bytes.NewReader(header[16:18]), binary.BigEndian, &pageSize); err != nil {
-			fmt.Println("Failed to read integer:", err)
-			return
+	shell := newShell(os.Stdout)
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard) //!Errors are reported here, once, rather than by Parse.
+	if err := shell.parseArgs(flags, os.Args[1:]); err != nil {
+		flags.SetOutput(os.Stderr)
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		flags.Usage()
+		if err == flag.ErrHelp {
+			os.Exit(0)
 		}
+		os.Exit(1)
+	}
+	os.Exit(shell.run(os.Stdin))
+}
 
-		pageBytes := make([]byte, int64(pageSize));
-		databaseFile.ReadAt(pageBytes, 0);
-
-		ftable := make(map[int64]int64);
-		_, _, rowsContainingCols := readTable(databaseFile, int64(pageSize), int64(1), ftable)
-	
-		var names []string;
-		for _, row := range rowsContainingCols {
-			//!Schema table consists of type, name, tbl_name, ... ...
-			nameCol := row[1].(string);
-			names = append(names, nameCol);
-		}
+//!Runs one dot command or one SQL statement.
+func (shell *Shell) runCommand(commandRead string) error {
+	settings := shell.settings;
 
-		for _, name := range names {
-			fmt.Println(name);
+	if(strings.HasPrefix(commandRead, ".")) {
+		command := strings.Fields(commandRead)[0];
+		handled, err := shell.runOutputCommand(commandRead);
+		if(handled || err != nil) {
+			return err;
 		}
-		databaseFile.Close();
-	case "SELECT":		
-
-		//!Processing the input query
-	    ps := parseSQL(commandRead);
-
-		Q_tableName := ps.Table;
-		whereCondition := ps.Condition;
-		_ = whereCondition;
 
-		//!Interpet sql_schema table to get table details:
-		databaseFile, err := os.Open(databaseFilePath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		header := make([]byte, 100)
-		_, err = databaseFile.Read(header)
-		if err != nil {
-			log.Fatal(err)
-		}
-		var pageSize uint16
-		if err := binary.Read(bytes.NewReader(header[16:18]), binary.BigEndian, &pageSize); err != nil {
-			fmt.Println("Failed to read integer:", err)
-			return
-		}
-		pageBytes := make([]byte, int64(pageSize));
-		databaseFile.ReadAt(pageBytes, 0);
-
-		//!Reading and interpretting sql schema table
-		ftable := make(map[int64]int64);
-		_, _, rowsContainingCols := readTable(databaseFile, int64(pageSize), int64(1), ftable);
-		//fmt.Println("Following are the table count: ", len(rowsContainingCols), "\n", rowsContainingCols);	//!For debugging.
-		var qTablePageNo int64;
-		var sqlStr string;		
-		pageFound := false;	
-
-		for _, row := range rowsContainingCols {
-			//!Schema table consists of type, name, tbl_name, ... ...
-			nameCol := row[2].(string);	//!Should be two
-			typeCol := row[0].(string);
-			if(typeCol == "table" && nameCol == Q_tableName) {
-				qTablePageNo = row[3].(int64);
-				sqlStr = row[4].(string);
-				pageFound = true;		
-				break;
+		switch command {
+		case ".dbinfo":
+			pager, _, err := shell.database()
+			if err != nil {
+				return err
 			}
-		}
-		if(!pageFound) {
-			fmt.Println("Error, table not found");
-		}
-
-
-		//fmt.Println(sqlStr);
-
-		_, colNames, _, autoincrementedKey := getTableDetailsFromSQLSchemaTable(sqlStr)
-		//!Mapping table colummn names to index
-		nameToInt := make(map[string]int)
-		for i, cn := range colNames {
-			nameToInt[cn] = i;
-		}
-
-
-		//!Assuming simple conditions for where without AND and other things.
-		var ccns []string;
-		if(ps.Condition != "") {
-			colAndCond := strings.Split(ps.Condition, "=");
-
-			for _, cc := range colAndCond {
-				// cc = strings.Fields(cc)[0];
-				// cc = strings.Trim(cc, "'")
-				cc = strings.Trim(cc, " ")
-				cc = strings.Trim(cc, "'")
-				cc = strings.Trim(cc, " ")
-				ccns = append(ccns, cc);
-			}	
-		}
-
+			return showDbInfo(shell.out, pager)
 
+		case ".dump":
+			pager, schema, err := shell.database()
+			if err != nil {
+				return err
+			}
+			return dumpDatabase(shell.out, pager, schema, splitDotCommandArgs(commandRead)[1:])
 
-		//!Find the index table if available
-		index_table_available := false;
-		var indexPageNo int64;
-		for _, row := range rowsContainingCols {
-			//!Schema table consists of type, name, tbl_name, ... ...
-			nameCol := row[2].(string);	//!Should be two
-			typeCol := row[0].(string);
-			if(typeCol == "index" && nameCol == Q_tableName) {
-				indexPageNo = row[3].(int64);
-				index_table_available = true;		
-				break;
+		case ".stats":
+			pager, _, err := shell.database()
+			if err != nil {
+				return err
 			}
-		}
-		
-		//!We are assuming type of index table is according to our search query:
-		//!Index table not available, do the whole search and apply WHERE clause afterwards,
-		var ids []int64
-		var qTableRows [][]interface{};
-		if(!index_table_available) {
-			ftable := make(map[int64]int64);
-			ids, _, qTableRows = readTable(databaseFile, int64(pageSize),qTablePageNo, ftable);	//!Assuming everything to be string for simplicity
-		} else {			
-			foundKeys := readIndex(databaseFile, int64(pageSize), indexPageNo, ccns[1]);
-			//fmt.Println(foundKeys);
-			ftable := make(map[int64]int64);
-			for _, key := range foundKeys {
-				ftable[key] = key;
+			fmt.Fprintf(shell.out, "%-36s %d\n", "Page cache hits:", pager.Hits);
+			fmt.Fprintf(shell.out, "%-36s %d\n", "Page cache misses:", pager.Misses);
+			return nil
+
+		case ".tables":
+			_, schema, err := shell.database()
+			if err != nil {
+				return err
 			}
-			//!This will definitely be sorted because of the way I am populating these:w
-			//fmt.Println(foundKeys, ftable);
-			ids, _, qTableRows = readTable(databaseFile, int64(pageSize),qTablePageNo, ftable);	//!Assuming everything to be string for simplicity
-		}
-			
-		var keepRows [][]interface{};
-		var keepRowsIds []int64;
-		for it, allCols := range qTableRows {
-			if(len(ccns) != 0) {
-				var colDetail string;
-				switch v := allCols[nameToInt[ccns[0]]].(type) {
-				case string:
-					colDetail = v;
-				default:
-					continue;	//!If not a string, skipping for now.
-				}
-				if colDetail == ccns[1]	{
-					//fmt.Println(it, allCols);
-					keepRows = append(keepRows, allCols);
-					if(len(ids) != 0) {
-						keepRowsIds = append(keepRowsIds, ids[it]);
-					}
-				}
-			} else {
-				keepRows = append(keepRows, allCols);				
-				if(len(ids) != 0) {
-					keepRowsIds = append(keepRowsIds, ids[it]);
-				}
-			}
-		}
-	
-		//!See if it is only asking for count
-		if len(ps.Columns) == 1 && (strings.HasPrefix(ps.Columns[0], "COUNT(") ||  strings.HasPrefix(ps.Columns[0], "count(")){
-			fmt.Println(len(keepRows));
-		} else {
-		//!Extract relevant cols:
-			for iRow, allCols := range keepRows {				
-				var outString string;
-				for jin, col := range ps.Columns {
-					if(jin != 0) {
-						outString += "|"
-					}
-					var colContent string;
-					if(col == autoincrementedKey){
-						colContent = strconv.Itoa(int(keepRowsIds[iRow]))
-					} else {
-						colContent = allCols[nameToInt[col]].(string);
-					}
-					//currSerialType := colSerialType[nameToInt[col]];
-					outString += colContent;
-				}
-				fmt.Println(outString);
+			for _, object := range schema.Objects {
+				fmt.Fprintln(shell.out, object.Name);
 			}
+			return nil
 		}
-		databaseFile.Close();
+		return fmt.Errorf("unknown command or invalid arguments:  \"%s\". Enter \".help\" for help", strings.TrimPrefix(command, "."))
+	}
+
+	//!Processing the input query
+	statement, err := parseStatement(commandRead)
+	if err != nil {
+		return err
+	}
+
+	//!The pager and the parsed sql_schema table are kept between statements.
+	pager, schema, err := shell.database()
+	if err != nil {
+		return err
+	}
+
+	var columns []string
+	var rows [][]interface{}
+	switch stmt := statement.(type) {
+	case *SelectStmt:
+		columns, rows, err = executeSelect(pager, schema, stmt)
+	case *PragmaStmt:
+		columns, rows, err = executePragma(pager, schema, stmt)
+	case *InsertStmt:
+		columns, rows, err = executeInsert(pager, schema, stmt)
+	case *UpdateStmt:
+		columns, rows, err = executeUpdate(pager, schema, stmt)
+	case *DeleteStmt:
+		columns, rows, err = executeDelete(pager, schema, stmt)
+	case *TransactionStmt:
+		err = executeTransaction(pager, stmt)
+		//!A rolled back transaction may have changed the schema.
+		if(stmt.Kind == "ROLLBACK") {
+			shell.schema = nil;
+		}
+	case *CreateTableStmt:
+		err = executeCreateTable(pager, schema, stmt);
+		shell.schema = nil;
+	case *CreateIndexStmt:
+		err = executeCreateIndex(pager, schema, stmt);
+		shell.schema = nil;
+	case *DropStmt:
+		err = executeDrop(pager, schema, stmt);
+		shell.schema = nil;
+	case *AlterTableStmt:
+		err = executeAlterTable(pager, schema, stmt);
+		shell.schema = nil;
 	default:
-		fmt.Println("Unknown command", command)
-		os.Exit(1)
+		return fmt.Errorf("unsupported statement")
+	}
+	if err != nil {
+		return err
 	}
+	return writeResult(shell.out, settings, columns, rows)
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestVarintNineBytes(t *testing.T) {
+	tests := []struct {
+		v       uint64
+		encoded []byte
+	}{
+		{1<<56 - 1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}}, //!The largest value that fits in eight bytes.
+		{1 << 56, []byte{0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},
+		{1<<56 + 0xab, []byte{0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0xab}}, //!The ninth byte keeps all 8 bits.
+		{math.MaxInt64, []byte{0xbf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{1 << 63, []byte{0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},        //!math.MinInt64.
+		{math.MaxUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}, //!-1.
+	}
+	for _, test := range tests {
+		if n := VarintLen(test.v); n != len(test.encoded) {
+			t.Errorf("VarintLen(%#x) = %d, want %d", test.v, n, len(test.encoded))
+		}
+		if got := AppendVarint(nil, test.v); !bytes.Equal(got, test.encoded) {
+			t.Errorf("AppendVarint(%#x) = % x, want % x", test.v, got, test.encoded)
+		}
+		//!A ninth byte with its high bit set does not continue the varint.
+		v, n := ReadVarint(append(test.encoded, 0x01))
+		if v != test.v || n != len(test.encoded) {
+			t.Errorf("ReadVarint(% x) = %#x, %d, want %#x, %d", test.encoded, v, n, test.v, len(test.encoded))
+		}
+		if v, n := ReadVarint(test.encoded[:len(test.encoded)-1]); v != 0 || n != 0 {
+			t.Errorf("ReadVarint of truncated % x = %#x, %d, want 0, 0", test.encoded, v, n)
+		}
+	}
+}
+
+func FuzzVarint(f *testing.F) {
+	for _, seed := range []int64{0, 1 << 7, 1<<56 - 1, 1 << 56, math.MaxInt64, -1, math.MinInt64} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		v := uint64(seed)
+		buf := make([]byte, 10)
+		n := PutVarint(buf, v)
+		if n != VarintLen(v) || n < 1 || n > 9 {
+			t.Fatalf("PutVarint(%#x) wrote %d bytes, VarintLen says %d", v, n, VarintLen(v))
+		}
+		if n < 9 && n > 1 && buf[0] == 0x80 {
+			t.Fatalf("PutVarint(%#x) = % x is not the shortest encoding", v, buf[:n])
+		}
+		if got, read := ReadVarint(buf[:n]); got != v || read != n {
+			t.Fatalf("ReadVarint(% x) = %#x, %d, want %#x, %d", buf[:n], got, read, v, n)
+		}
+		if got, read := ReadVarint(buf[:n-1]); got != 0 || read != 0 {
+			t.Fatalf("ReadVarint of truncated % x = %#x, %d, want 0, 0", buf[:n], got, read)
+		}
+	})
+}
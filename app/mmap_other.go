@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile is not available on this platform, so the pager always reads pages with ReadAt.
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory-mapped I/O is not supported on this platform")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,91 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMmapRemapOnGrowth(t *testing.T) {
+	path := newDatabase(t)
+	long := "'" + strings.Repeat("x", 3000) + "'"
+	runSQL(t, path, "create table t(s)", "insert into t values ("+long+"), ("+long+")")
+
+	vfs, _ := findVFS("")
+	pager, err := openPager(vfs, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+	if _, err := pager.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.setMmapSize(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	count, err := pager.pageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(pager.mapped)) != count*pager.pageSize {
+		t.Fatalf("mapped %d bytes of %d pages", len(pager.mapped), count)
+	}
+
+	//!Mapped pages are slices of the mapping, and do not go through the cache.
+	misses := pager.Misses
+	last, err := pager.page(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &last[0] != &pager.mapped[(count-1)*pager.pageSize] || pager.Misses != misses {
+		t.Errorf("page %d was not read from the mapping", count)
+	}
+	content := append([]byte(nil), last...)
+
+	//!Another connection grows the file; reading past the mapping maps the new pages, and the
+	//!old mapping stays valid for the pages handed out from it.
+	pager.endRead()
+	runSQL(t, path, "insert into t values ("+long+"), ("+long+"), ("+long+")")
+	if _, err := pager.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	grown, err := pager.pageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grown <= count {
+		t.Fatalf("page count %d after the insert, was %d", grown, count)
+	}
+	if _, err := pager.page(grown); err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(pager.mapped)) != grown*pager.pageSize || len(pager.retired) == 0 {
+		t.Errorf("after growth: mapped %d bytes of %d pages, %d retired mappings", len(pager.mapped), grown, len(pager.retired))
+	}
+	if !bytes.Equal(last, content) {
+		t.Errorf("page %d handed out from the old mapping changed", count)
+	}
+
+	//!Pages past mmap_size are read into the cache.
+	if err := pager.setMmapSize(pager.pageSize); err != nil {
+		t.Fatal(err)
+	}
+	misses = pager.Misses
+	if _, err := pager.page(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(pager.mapped) != int(pager.pageSize) || pager.Misses != misses+1 {
+		t.Errorf("mmap_size of one page: mapped %d bytes, %d misses", len(pager.mapped), pager.Misses-misses)
+	}
+	pager.endRead()
+
+	//!Writes through a connection with a mapping are seen by it and leave a sound file.
+	out := runSQL(t, path, "pragma mmap_size = 1048576", "insert into t values ("+long+")",
+		"delete from t where rowid = 1", "select count(*), sum(length(s)) from t")
+	if got := strings.Fields(out); len(got) == 0 || got[len(got)-1] != "5|15000" {
+		t.Errorf("after writes with mmap: %q", out)
+	}
+	checkIntegrity(t, path)
+}
@@ -0,0 +1,749 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// OutputSettings is the part of the shell state that controls how result rows are printed.
+// It is changed by .mode, .headers, .separator, .width and .nullvalue and by the matching
+// command line flags.
+type OutputSettings struct {
+	Mode         string
+	Headers      bool
+	HeadersSet   bool //!True once .headers or -header/-noheader was used explicitly.
+	ColSeparator string
+	RowSeparator string
+	NullValue    string
+	Widths       []int
+	InsertTable  string //!Table name used by the insert mode.
+}
+
+// ResultWriter formats the result set of one statement. WriteHeader is called with the column
+// names before the first row, WriteRow once per row and Flush after the last row, even when
+// there are no rows and so no header.
+type ResultWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(row []interface{}) error
+	Flush() error
+}
+
+// ResultWriterFactory builds a ResultWriter printing to out with the given settings.
+type ResultWriterFactory func(out *bufio.Writer, settings *OutputSettings) ResultWriter
+
+type outputMode struct {
+	factory      ResultWriterFactory
+	colSeparator string
+	rowSeparator string
+	headersOn    bool //!Turn headers on when the mode is selected, unless set explicitly.
+}
+
+var outputModes = map[string]outputMode{}
+
+// RegisterOutputMode makes a new output mode available to .mode and the command line flags.
+// colSeparator and rowSeparator are the defaults installed when the mode is selected.
+func RegisterOutputMode(name string, factory ResultWriterFactory, colSeparator string, rowSeparator string, headersOn bool) {
+	outputModes[name] = outputMode{factory, colSeparator, rowSeparator, headersOn}
+}
+
+// outputModeNames returns the registered mode names in sorted order.
+func outputModeNames() []string {
+	var names []string
+	for name := range outputModes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterOutputMode("list", newListWriter, "|", "\n", false)
+	RegisterOutputMode("tabs", newListWriter, "\t", "\n", false)
+	RegisterOutputMode("ascii", newListWriter, "\x1f", "\x1e", false)
+	RegisterOutputMode("csv", newCSVWriter, ",", "\r\n", false)
+	RegisterOutputMode("quote", newQuoteWriter, ",", "\n", false)
+	RegisterOutputMode("insert", newInsertWriter, ",", "\n", false)
+	RegisterOutputMode("json", newJSONWriter, ",", "\n", false)
+	RegisterOutputMode("line", newLineWriter, "|", "\n", false)
+	RegisterOutputMode("html", newHTMLWriter, "|", "\n", false)
+	RegisterOutputMode("column", newColumnWriter, "  ", "\n", true)
+	RegisterOutputMode("table", newTableWriter, "|", "\n", true)
+	RegisterOutputMode("markdown", newMarkdownWriter, "|", "\n", true)
+	RegisterOutputMode("box", newBoxWriter, "|", "\n", true)
+}
+
+func defaultOutputSettings() *OutputSettings {
+	settings := &OutputSettings{InsertTable: "table"}
+	settings.SetMode("list")
+	return settings
+}
+
+// SetMode switches to the named output mode and installs its default separators.
+func (settings *OutputSettings) SetMode(name string) error {
+	mode, ok := outputModes[name]
+	if !ok {
+		return fmt.Errorf("mode should be one of: %s", strings.Join(outputModeNames(), " "))
+	}
+	settings.Mode = name
+	settings.ColSeparator = mode.colSeparator
+	settings.RowSeparator = mode.rowSeparator
+	if mode.headersOn && !settings.HeadersSet {
+		settings.Headers = true
+	}
+	return nil
+}
+
+// NewResultWriter returns a writer for the currently selected mode.
+func (settings *OutputSettings) NewResultWriter(out *bufio.Writer) ResultWriter {
+	return outputModes[settings.Mode].factory(out, settings)
+}
+
+// formatReal renders a REAL the way sqlite3 converts it to text ("%!.15g").
+func formatReal(f float64) string {
+	if f == 0 {
+		f = 0 //!SQLite never shows negative zero.
+	}
+	if math.IsInf(f, 1) {
+		return "Inf"
+	} else if math.IsInf(f, -1) {
+		return "-Inf"
+	}
+	return withDecimalPoint(strconv.FormatFloat(f, 'g', 15, 64))
+}
+
+// formatRealLiteral renders a REAL with the shortest representation that reads back to the
+// same float64, for output meant to be loaded again.
+func formatRealLiteral(f float64) string {
+	if f == 0 {
+		f = 0 //!SQLite never shows negative zero.
+	}
+	if math.IsInf(f, 1) {
+		return "9.0e+999"
+	} else if math.IsInf(f, -1) {
+		return "-9.0e+999"
+	}
+	return withDecimalPoint(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// withDecimalPoint makes sure a formatted float reads back as a REAL, e.g. "2" => "2.0".
+func withDecimalPoint(s string) string {
+	if strings.ContainsAny(s, ".n") { //!"n" covers NaN.
+		return s
+	}
+	if e := strings.IndexByte(s, 'e'); e >= 0 {
+		return s[:e] + ".0" + s[e:]
+	}
+	return s + ".0"
+}
+
+// formatValueText converts a column value to the text sqlite3 would print for it.
+func formatValueText(value interface{}, nullValue string) string {
+	switch v := value.(type) {
+	case nil:
+		return nullValue
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatReal(v)
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// formatSQLLiteral renders a column value as an SQL literal.
+func formatSQLLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatRealLiteral(v)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case []byte:
+		return "X'" + strings.ToUpper(hex.EncodeToString(v)) + "'"
+	}
+	return fmt.Sprint(value)
+}
+
+// quoteIdentifier quotes a table or column name only when sqlite3 would need it to.
+func quoteIdentifier(name string) string {
+	plain := name != ""
+	for i, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			plain = false
+			break
+		}
+	}
+	if plain && !isSQLKeyword(name) {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlKeywords are the words that must be quoted when used as identifiers in generated SQL.
+var sqlKeywords = map[string]bool{
+	"ABORT": true, "ACTION": true, "ADD": true, "AFTER": true, "ALL": true, "ALTER": true,
+	"ANALYZE": true, "AND": true, "AS": true, "ASC": true, "ATTACH": true, "AUTOINCREMENT": true,
+	"BEFORE": true, "BEGIN": true, "BETWEEN": true, "BY": true, "CASCADE": true, "CASE": true,
+	"CAST": true, "CHECK": true, "COLLATE": true, "COLUMN": true, "COMMIT": true, "CONFLICT": true,
+	"CONSTRAINT": true, "CREATE": true, "CROSS": true, "CURRENT_DATE": true, "CURRENT_TIME": true,
+	"CURRENT_TIMESTAMP": true, "DATABASE": true, "DEFAULT": true, "DEFERRABLE": true,
+	"DEFERRED": true, "DELETE": true, "DESC": true, "DETACH": true, "DISTINCT": true, "DROP": true,
+	"EACH": true, "ELSE": true, "END": true, "ESCAPE": true, "EXCEPT": true, "EXCLUSIVE": true,
+	"EXISTS": true, "EXPLAIN": true, "FAIL": true, "FOR": true, "FOREIGN": true, "FROM": true,
+	"FULL": true, "GLOB": true, "GROUP": true, "HAVING": true, "IF": true, "IGNORE": true,
+	"IMMEDIATE": true, "IN": true, "INDEX": true, "INDEXED": true, "INITIALLY": true, "INNER": true,
+	"INSERT": true, "INSTEAD": true, "INTERSECT": true, "INTO": true, "IS": true, "ISNULL": true,
+	"JOIN": true, "KEY": true, "LEFT": true, "LIKE": true, "LIMIT": true, "MATCH": true,
+	"NATURAL": true, "NO": true, "NOT": true, "NOTNULL": true, "NULL": true, "OF": true,
+	"OFFSET": true, "ON": true, "OR": true, "ORDER": true, "OUTER": true, "PLAN": true,
+	"PRAGMA": true, "PRIMARY": true, "QUERY": true, "RAISE": true, "RECURSIVE": true,
+	"REFERENCES": true, "REGEXP": true, "REINDEX": true, "RELEASE": true, "RENAME": true,
+	"REPLACE": true, "RESTRICT": true, "RETURNING": true, "RIGHT": true, "ROLLBACK": true,
+	"ROW": true, "SAVEPOINT": true, "SELECT": true, "SET": true, "TABLE": true, "TEMP": true,
+	"TEMPORARY": true, "THEN": true, "TO": true, "TRANSACTION": true, "TRIGGER": true,
+	"UNION": true, "UNIQUE": true, "UPDATE": true, "USING": true, "VACUUM": true, "VALUES": true,
+	"VIEW": true, "VIRTUAL": true, "WHEN": true, "WHERE": true, "WITH": true, "WITHOUT": true,
+}
+
+func isSQLKeyword(word string) bool {
+	return sqlKeywords[strings.ToUpper(word)]
+}
+
+// listWriter prints values separated by the column separator (list, tabs and ascii modes).
+type listWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+}
+
+func newListWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &listWriter{out, settings}
+}
+
+func (w *listWriter) WriteHeader(columns []string) error {
+	if !w.settings.Headers {
+		return nil
+	}
+	_, err := w.out.WriteString(strings.Join(columns, w.settings.ColSeparator) + w.settings.RowSeparator)
+	return err
+}
+
+func (w *listWriter) WriteRow(row []interface{}) error {
+	fields := make([]string, len(row))
+	for i, value := range row {
+		fields[i] = formatValueText(value, w.settings.NullValue)
+	}
+	_, err := w.out.WriteString(strings.Join(fields, w.settings.ColSeparator) + w.settings.RowSeparator)
+	return err
+}
+
+func (w *listWriter) Flush() error { return nil }
+
+// csvWriter prints RFC 4180 style rows, quoting fields only when needed.
+type csvWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+}
+
+func newCSVWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &csvWriter{out, settings}
+}
+
+func (w *csvWriter) field(s string, isNull bool) string {
+	if isNull {
+		return w.settings.NullValue
+	}
+	needsQuote := s == "" || strings.Contains(s, w.settings.ColSeparator)
+	for _, c := range []byte(s) {
+		if c <= 0x20 || c == '"' || c >= 0x7f {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func (w *csvWriter) writeFields(fields []string) error {
+	_, err := w.out.WriteString(strings.Join(fields, w.settings.ColSeparator) + w.settings.RowSeparator)
+	return err
+}
+
+func (w *csvWriter) WriteHeader(columns []string) error {
+	if !w.settings.Headers {
+		return nil
+	}
+	fields := make([]string, len(columns))
+	for i, name := range columns {
+		fields[i] = w.field(name, false)
+	}
+	return w.writeFields(fields)
+}
+
+func (w *csvWriter) WriteRow(row []interface{}) error {
+	fields := make([]string, len(row))
+	for i, value := range row {
+		fields[i] = w.field(formatValueText(value, ""), value == nil)
+	}
+	return w.writeFields(fields)
+}
+
+func (w *csvWriter) Flush() error { return nil }
+
+// quoteWriter prints every value as an SQL literal.
+type quoteWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+}
+
+func newQuoteWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &quoteWriter{out, settings}
+}
+
+func (w *quoteWriter) WriteHeader(columns []string) error {
+	if !w.settings.Headers {
+		return nil
+	}
+	fields := make([]string, len(columns))
+	for i, name := range columns {
+		fields[i] = formatSQLLiteral(name)
+	}
+	_, err := w.out.WriteString(strings.Join(fields, w.settings.ColSeparator) + w.settings.RowSeparator)
+	return err
+}
+
+func (w *quoteWriter) WriteRow(row []interface{}) error {
+	fields := make([]string, len(row))
+	for i, value := range row {
+		fields[i] = formatSQLLiteral(value)
+	}
+	_, err := w.out.WriteString(strings.Join(fields, w.settings.ColSeparator) + w.settings.RowSeparator)
+	return err
+}
+
+func (w *quoteWriter) Flush() error { return nil }
+
+// insertWriter prints one INSERT statement per row.
+type insertWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+	columns  []string
+}
+
+func newInsertWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &insertWriter{out: out, settings: settings}
+}
+
+func (w *insertWriter) WriteHeader(columns []string) error {
+	w.columns = columns
+	return nil
+}
+
+func (w *insertWriter) WriteRow(row []interface{}) error {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO " + quoteIdentifier(w.settings.InsertTable))
+	if w.settings.Headers {
+		quoted := make([]string, len(w.columns))
+		for i, name := range w.columns {
+			quoted[i] = quoteIdentifier(name)
+		}
+		sb.WriteString("(" + strings.Join(quoted, ",") + ")")
+	}
+	fields := make([]string, len(row))
+	for i, value := range row {
+		fields[i] = formatSQLLiteral(value)
+	}
+	sb.WriteString(" VALUES(" + strings.Join(fields, ",") + ");" + w.settings.RowSeparator)
+	_, err := w.out.WriteString(sb.String())
+	return err
+}
+
+func (w *insertWriter) Flush() error { return nil }
+
+// jsonWriter prints the result as a JSON array of objects keyed by column name.
+type jsonWriter struct {
+	out     *bufio.Writer
+	columns []string
+	rows    int
+}
+
+func newJSONWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &jsonWriter{out: out}
+}
+
+func jsonString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\b':
+			sb.WriteString(`\b`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < 0x20:
+			fmt.Fprintf(&sb, `\u%04x`, r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func (w *jsonWriter) WriteHeader(columns []string) error {
+	w.columns = columns
+	return nil
+}
+
+func (w *jsonWriter) WriteRow(row []interface{}) error {
+	var sb strings.Builder
+	if w.rows == 0 {
+		sb.WriteString("[{")
+	} else {
+		sb.WriteString(",\n{")
+	}
+	for i, value := range row {
+		if i != 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(jsonString(w.columns[i]) + ":")
+		switch v := value.(type) {
+		case nil:
+			sb.WriteString("null")
+		case int64:
+			sb.WriteString(strconv.FormatInt(v, 10))
+		case float64:
+			sb.WriteString(formatRealLiteral(v))
+		default:
+			sb.WriteString(jsonString(formatValueText(value, "")))
+		}
+	}
+	sb.WriteByte('}')
+	w.rows++
+	_, err := w.out.WriteString(sb.String())
+	return err
+}
+
+func (w *jsonWriter) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	_, err := w.out.WriteString("]\n")
+	return err
+}
+
+// lineWriter prints one "column = value" line per column, with a blank line between rows. Like
+// sqlite3, names are right-aligned to the longest one, counted in bytes, and to at least 5.
+type lineWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+	columns  []string
+	width    int
+	rows     int
+}
+
+func newLineWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &lineWriter{out: out, settings: settings, width: 5}
+}
+
+func (w *lineWriter) WriteHeader(columns []string) error {
+	w.columns = columns
+	for _, name := range columns {
+		w.width = max(w.width, len(name))
+	}
+	return nil
+}
+
+func (w *lineWriter) WriteRow(row []interface{}) error {
+	if w.rows != 0 {
+		w.out.WriteString("\n")
+	}
+	for i, value := range row {
+		name := w.columns[i]
+		fmt.Fprintf(w.out, "%s%s = %s\n", strings.Repeat(" ", w.width-len(name)), name, formatValueText(value, w.settings.NullValue))
+	}
+	w.rows++
+	return nil
+}
+
+func (w *lineWriter) Flush() error { return nil }
+
+// htmlWriter prints table rows for embedding in an HTML <table>.
+type htmlWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+}
+
+func newHTMLWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &htmlWriter{out, settings}
+}
+
+var htmlEscaper = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", `"`, "&quot;", "'", "&#39;")
+
+func (w *htmlWriter) writeCells(tag string, cells []string) error {
+	w.out.WriteString("<TR>")
+	for _, cell := range cells {
+		fmt.Fprintf(w.out, "<%s>%s</%s>\n", tag, htmlEscaper.Replace(cell), tag)
+	}
+	_, err := w.out.WriteString("</TR>\n")
+	return err
+}
+
+func (w *htmlWriter) WriteHeader(columns []string) error {
+	if !w.settings.Headers {
+		return nil
+	}
+	return w.writeCells("TH", columns)
+}
+
+func (w *htmlWriter) WriteRow(row []interface{}) error {
+	cells := make([]string, len(row))
+	for i, value := range row {
+		cells[i] = formatValueText(value, w.settings.NullValue)
+	}
+	return w.writeCells("TD", cells)
+}
+
+func (w *htmlWriter) Flush() error { return nil }
+
+// columnarStyle describes the decorations of the aligned output modes.
+type columnarStyle struct {
+	showHeaderAlways bool
+	//!Border pieces: left edge, column separator and right edge for cell lines, then the same
+	//!three plus the fill character for the top, header separator, row separator and bottom lines.
+	left, sep, right                   string
+	topLeft, topSep, topRight          string
+	midLeft, midSep, midRight          string
+	bottomLeft, bottomSep, bottomRight string
+	fill                               string
+	hasTop, hasBottom, centerHeaders   bool
+}
+
+var (
+	columnStyle   = columnarStyle{left: "", sep: "  ", right: "", midLeft: "", midSep: "  ", midRight: "", fill: "-"}
+	tableStyle    = columnarStyle{showHeaderAlways: true, left: "| ", sep: " | ", right: " |", topLeft: "+-", topSep: "-+-", topRight: "-+", midLeft: "+-", midSep: "-+-", midRight: "-+", bottomLeft: "+-", bottomSep: "-+-", bottomRight: "-+", fill: "-", hasTop: true, hasBottom: true, centerHeaders: true}
+	markdownStyle = columnarStyle{showHeaderAlways: true, left: "| ", sep: " | ", right: " |", midLeft: "|-", midSep: "-|-", midRight: "-|", fill: "-", centerHeaders: true}
+	boxStyle      = columnarStyle{showHeaderAlways: true, left: "│ ", sep: " │ ", right: " │", topLeft: "┌─", topSep: "─┬─", topRight: "─┐", midLeft: "├─", midSep: "─┼─", midRight: "─┤", bottomLeft: "└─", bottomSep: "─┴─", bottomRight: "─┘", fill: "─", hasTop: true, hasBottom: true, centerHeaders: true}
+)
+
+// columnarWriter buffers the whole result so it can size every column before printing
+// (column, table, markdown and box modes).
+type columnarWriter struct {
+	out      *bufio.Writer
+	settings *OutputSettings
+	style    columnarStyle
+	columns  []string
+	rows     [][]string
+}
+
+func newColumnWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &columnarWriter{out: out, settings: settings, style: columnStyle}
+}
+
+func newTableWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &columnarWriter{out: out, settings: settings, style: tableStyle}
+}
+
+func newMarkdownWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &columnarWriter{out: out, settings: settings, style: markdownStyle}
+}
+
+func newBoxWriter(out *bufio.Writer, settings *OutputSettings) ResultWriter {
+	return &columnarWriter{out: out, settings: settings, style: boxStyle}
+}
+
+func (w *columnarWriter) WriteHeader(columns []string) error {
+	w.columns = columns
+	return nil
+}
+
+func (w *columnarWriter) WriteRow(row []interface{}) error {
+	cells := make([]string, len(row))
+	for i, value := range row {
+		cells[i] = formatValueText(value, w.settings.NullValue)
+	}
+	w.rows = append(w.rows, cells)
+	return nil
+}
+
+// wrapCell splits a cell into lines of at most width characters, breaking on newlines too.
+func wrapCell(cell string, width int) []string {
+	var lines []string
+	for _, line := range strings.Split(cell, "\n") {
+		runes := []rune(line)
+		for len(runes) > width && width > 0 {
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+// padCell pads s to width characters, on the left when rightAlign is set.
+func padCell(s string, width int, rightAlign bool) string {
+	padding := width - utf8.RuneCountInString(s)
+	if padding <= 0 {
+		return s
+	}
+	if rightAlign {
+		return strings.Repeat(" ", padding) + s
+	}
+	return s + strings.Repeat(" ", padding)
+}
+
+func (w *columnarWriter) Flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+
+	//!Explicit .width values win, zero or missing widths fit the widest header or value.
+	widths := make([]int, len(w.columns))
+	rightAlign := make([]bool, len(w.columns))
+	fixed := make([]bool, len(w.columns))
+	for i := range w.columns {
+		if i < len(w.settings.Widths) && w.settings.Widths[i] != 0 {
+			widths[i] = w.settings.Widths[i]
+			if widths[i] < 0 {
+				widths[i] = -widths[i]
+				rightAlign[i] = true
+			}
+			fixed[i] = true
+			continue
+		}
+		widths[i] = utf8.RuneCountInString(w.columns[i])
+		for _, row := range w.rows {
+			for _, line := range strings.Split(row[i], "\n") {
+				if n := utf8.RuneCountInString(line); n > widths[i] {
+					widths[i] = n
+				}
+			}
+		}
+	}
+
+	//!Cells longer than their column are wrapped onto extra lines.
+	wrapped := make([][][]string, len(w.rows))
+	multiLine := false
+	for r, row := range w.rows {
+		wrapped[r] = make([][]string, len(row))
+		for i, cell := range row {
+			if fixed[i] {
+				wrapped[r][i] = wrapCell(cell, widths[i])
+			} else {
+				wrapped[r][i] = strings.Split(cell, "\n")
+			}
+			if len(wrapped[r][i]) > 1 {
+				multiLine = true
+			}
+		}
+	}
+
+	style := w.style
+	rule := func(left, sep, right string) {
+		parts := make([]string, len(widths))
+		for i, width := range widths {
+			parts[i] = strings.Repeat(style.fill, width)
+		}
+		w.out.WriteString(left + strings.Join(parts, sep) + right + "\n")
+	}
+	line := func(cells []string, center bool) {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			if center {
+				padding := widths[i] - utf8.RuneCountInString(cell)
+				parts[i] = strings.Repeat(" ", padding/2) + cell + strings.Repeat(" ", padding-padding/2)
+			} else {
+				parts[i] = padCell(cell, widths[i], rightAlign[i])
+			}
+		}
+		w.out.WriteString(style.left + strings.Join(parts, style.sep) + style.right + "\n")
+	}
+
+	if style.hasTop {
+		rule(style.topLeft, style.topSep, style.topRight)
+	}
+	if style.showHeaderAlways || w.settings.Headers {
+		header := make([]string, len(w.columns))
+		for i, name := range w.columns {
+			header[i] = wrapCell(name, widths[i])[0]
+		}
+		line(header, style.centerHeaders)
+		rule(style.midLeft, style.midSep, style.midRight)
+	}
+	for r, cells := range wrapped {
+		if r != 0 && multiLine {
+			if style.hasTop {
+				rule(style.midLeft, style.midSep, style.midRight)
+			} else if style.left == "" {
+				w.out.WriteString("\n")
+			}
+		}
+		height := 0
+		for _, cellLines := range cells {
+			if len(cellLines) > height {
+				height = len(cellLines)
+			}
+		}
+		for l := 0; l < height; l++ {
+			parts := make([]string, len(cells))
+			for i, cellLines := range cells {
+				if l < len(cellLines) {
+					parts[i] = cellLines[l]
+				}
+			}
+			line(parts, false)
+		}
+	}
+	if style.hasBottom {
+		rule(style.bottomLeft, style.bottomSep, style.bottomRight)
+	}
+	return nil
+}
+
+// writeResult prints a complete result set through the selected output mode. As in sqlite3,
+// the header comes with the first row: a result without rows prints nothing, and a statement
+// without result columns does not print a blank line.
+func writeResult(out io.Writer, settings *OutputSettings, columns []string, rows [][]interface{}) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	buffered := bufio.NewWriter(out)
+	writer := settings.NewResultWriter(buffered)
+	for i, row := range rows {
+		if i == 0 {
+			if err := writer.WriteHeader(columns); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestLineWriterPadsNames(t *testing.T) {
+	tests := []struct {
+		columns []string
+		row     []interface{}
+		want    string
+	}{
+		{[]string{"a"}, []interface{}{int64(1)}, "    a = 1\n"}, //!At least 5 wide.
+		{[]string{"a", "abcdefg"}, []interface{}{int64(1), nil}, "      a = 1\nabcdefg = \n"},
+		{[]string{"a", "ééé"}, []interface{}{"x", 2.5}, "     a = x\nééé = 2.5\n"}, //!Widths count bytes.
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		out := bufio.NewWriter(&buf)
+		w := newLineWriter(out, defaultOutputSettings())
+		if err := w.WriteHeader(test.columns); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteRow(test.row); err != nil {
+			t.Fatal(err)
+		}
+		w.Flush()
+		out.Flush()
+		if buf.String() != test.want {
+			t.Errorf("%q: got %q, want %q", test.columns, buf.String(), test.want)
+		}
+	}
+}
+
+func TestWriteResultHeaderWithFirstRow(t *testing.T) {
+	for _, mode := range outputModeNames() {
+		settings := defaultOutputSettings()
+		if err := settings.SetMode(mode); err != nil {
+			t.Fatal(err)
+		}
+		settings.Headers = true
+		tests := []struct {
+			columns []string
+			rows    [][]interface{}
+			empty   bool
+		}{
+			{[]string{"a", "b"}, nil, true},
+			{nil, nil, true}, //!A statement without result columns, such as CREATE TABLE.
+			{[]string{"a", "b"}, [][]interface{}{{int64(1), "x"}}, false},
+		}
+		for _, test := range tests {
+			var buf bytes.Buffer
+			if err := writeResult(&buf, settings, test.columns, test.rows); err != nil {
+				t.Fatal(err)
+			}
+			if empty := buf.Len() == 0; empty != test.empty {
+				t.Errorf("mode %s, columns %q, %d rows: printed %q", mode, test.columns, len(test.rows), buf.String())
+			}
+		}
+	}
+}
+
+func TestModeWithoutArgument(t *testing.T) {
+	path := newDatabase(t)
+	out := runSQL(t, path, ".mode", ".mode csv", ".mode")
+	if want := "current output mode: list\ncurrent output mode: csv\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,901 @@
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// defaultCacheSize is SQLite's default cache_size: a negative value is a budget in KiB rather
+// than a number of pages.
+const defaultCacheSize = -2000
+
+// defaultAutoCheckpoint is SQLite's default wal_autocheckpoint, in frames.
+const defaultAutoCheckpoint = 1000
+
+// maxMmapSize is the largest mmap_size accepted, the same limit SQLite builds with on Linux.
+const maxMmapSize = 0x7fff0000
+
+// sqliteVersionNumber is recorded in the header of every database this implementation writes,
+// as the version of the library that last changed the file.
+const sqliteVersionNumber = 3050002
+
+// pendingByte is the offset of the byte range SQLite uses for file locks. The page holding it
+// is never used for content.
+const pendingByte = 0x40000000
+
+// Pager owns the database file and is the only way B-tree code reads pages. Recently used
+// pages are kept in an LRU cache bounded by cache_size; Hits and Misses count lookups that were
+// and were not served from it. When mmap_size is set, pages within the first mmap_size bytes
+// of the file are instead handed out as zero-copy slices of a read-only memory map. In WAL
+// mode, pages committed to the -wal file but not yet checkpointed are read from there.
+type Pager struct {
+	vfs           VFS
+	path          string
+	file          File
+	readOnly      bool      //!Opened read-only, so a hot journal cannot be rolled back.
+	empty         bool      //!The file had no header when last read: a new database, whose layout the first write fixes.
+	wal           *walIndex //!Nil unless the database is in WAL mode and has a log.
+	header        *DatabaseHeader
+	pageSize      int64
+	usableSize    int64 //!Page size less the reserved bytes at the end of each page.
+	cacheSize     int64 //!As set with PRAGMA cache_size.
+	maxPages      int
+	lru           *list.List //!Most recently used first; elements hold *cachedPage.
+	pages         map[int64]*list.Element
+	changeCounter uint32
+	encoding      TextEncoding
+	mmapSize      int64            //!As set with PRAGMA mmap_size; 0 disables mapping.
+	mapped        []byte           //!Current mapping, a whole number of pages.
+	retired       [][]byte         //!Earlier mappings, kept until Close as pages handed out may point into them.
+	dirty         map[int64][]byte //!Pages changed by the open write transaction; nil outside one.
+	original      map[int64][]byte //!Content before the transaction of the changed pages, for the journal.
+	dbSize        int64            //!Size in pages as of the open write transaction.
+	initialSize   int64            //!Size in pages when the write transaction started.
+	explicit      bool             //!A transaction was opened with BEGIN and lasts until COMMIT or ROLLBACK.
+	savepoint     map[int64][]byte //!Content before the running statement of the pages it changed, nil for pages it made dirty.
+	savepointSize int64
+	busyTimeout   int64 //!Milliseconds to wait for a lock, as set with PRAGMA busy_timeout.
+	shm           File  //!The -shm file while the database is in WAL mode.
+	readMark      int   //!The read mark of the wal-index this connection holds a lock on, -1 for none.
+	walWriter     bool  //!This connection holds the WAL write lock.
+	checkpointAt  int64 //!As set with PRAGMA wal_autocheckpoint, in frames; 0 turns automatic checkpoints off.
+	foreignKeys   bool  //!As set with PRAGMA foreign_keys.
+	fkViolations  int64 //!Immediate foreign key violations left by the running statement.
+	fkDeferred    int64 //!Deferred foreign key violations left by the open transaction.
+	fkSavepoint   int64 //!fkDeferred before the running statement.
+	Hits          int64
+	Misses        int64
+}
+
+type cachedPage struct {
+	pageNo int64
+	data   []byte
+}
+
+// readDatabaseHeader reads and validates the header at the start of file. Like SQLite, it
+// takes an empty file for a new database, which has the default header until it is written.
+func readDatabaseHeader(file File) (*DatabaseHeader, error) {
+	raw := make([]byte, 100)
+	if n, err := file.ReadAt(raw, 0); err != nil && !(err == io.EOF && n == len(raw)) {
+		if err == io.EOF && n == 0 {
+			return newDatabaseHeader(), nil
+		}
+		if err == io.EOF {
+			return nil, corruptError(0, 0, "file is shorter than the database header")
+		}
+		return nil, err
+	}
+	return parseDatabaseHeader(raw)
+}
+
+// openPager opens the database file through vfs and reads its header. Unless readOnly is set
+// the file is opened for writing when possible, so that the first refresh can roll back a hot
+// journal, and created when it does not exist.
+func openPager(vfs VFS, databaseFilePath string, readOnly bool) (*Pager, error) {
+	var file File
+	err := ErrReadOnly
+	if !readOnly {
+		file, err = vfs.Open(databaseFilePath, OpenReadWrite|OpenCreate)
+	}
+	if err != nil {
+		readOnly = true
+		file, err = vfs.Open(databaseFilePath, OpenReadOnly)
+	}
+	if err != nil {
+		return nil, &ErrCantOpen{Path: databaseFilePath, Err: err}
+	}
+	header, err := readDatabaseHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size, err := file.Size()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pager := &Pager{
+		vfs:           vfs,
+		path:          databaseFilePath,
+		file:          file,
+		readOnly:      readOnly,
+		empty:         size == 0,
+		header:        header,
+		pageSize:      header.PageSize,
+		usableSize:    header.PageSize - int64(header.ReservedSpace),
+		lru:           list.New(),
+		pages:         map[int64]*list.Element{},
+		changeCounter: header.FileChangeCounter,
+		encoding:      header.TextEncoding,
+		checkpointAt:  defaultAutoCheckpoint,
+		readMark:      -1,
+	}
+	pager.setCacheSize(defaultCacheSize)
+	return pager, nil
+}
+
+func (pager *Pager) Close() error {
+	//!Like SQLite, a transaction still open is rolled back.
+	pager.rollbackTransaction()
+	pager.endRead()
+	pager.closeShm()
+	for _, mapping := range append(pager.retired, pager.mapped) {
+		if mapping != nil {
+			unmapFile(mapping)
+		}
+	}
+	pager.mapped, pager.retired = nil, nil
+	if pager.wal != nil {
+		pager.wal.file.Close()
+		pager.wal = nil
+	}
+	return pager.file.Close()
+}
+
+// walFrame returns where the newest committed version of a page is in the log, if it is there.
+// A connection holding read mark 0 reads the database file alone.
+func (pager *Pager) walFrame(pageNo int64) (int64, bool) {
+	if pager.wal == nil || pager.readMark == 0 {
+		return 0, false
+	}
+	offset, ok := pager.wal.frames[pageNo]
+	return offset, ok
+}
+
+// syncWAL indexes the -wal file again when it appeared, changed or went away since it was last
+// read, and reports whether it did. In WAL mode it then locks a read mark of the wal-index,
+// which keeps the frames this connection reads from being overwritten until endRead; the log
+// is not read again while the mark is held.
+func (pager *Pager) syncWAL() (bool, error) {
+	if pager.readMark >= 0 {
+		return false, nil
+	}
+	changed, err := pager.loadWAL()
+	if err != nil || pager.wal == nil {
+		return changed, err
+	}
+	reread, err := pager.lockReadMark()
+	return changed || reread, err
+}
+
+// loadWAL opens and indexes the -wal file of a database in WAL mode, or reads it again when it
+// changed, and reports whether the index changed.
+func (pager *Pager) loadWAL() (bool, error) {
+	walPath := pager.path + "-wal"
+	//!Only a database in WAL mode has its content in the log; a stale one is ignored.
+	if pager.header.ReadVersion != 2 {
+		pager.closeShm()
+		if pager.wal == nil {
+			return false, nil
+		}
+		pager.wal.file.Close()
+		pager.wal = nil
+		return true, nil
+	}
+	if pager.wal != nil {
+		if changed, err := pager.wal.changed(); err != nil || !changed {
+			return false, err
+		}
+		wal, err := readWAL(pager.wal.file, pager.pageSize)
+		if err == nil {
+			err = pager.openShm(wal)
+		}
+		if err != nil {
+			return false, err
+		}
+		pager.wal = wal
+		return true, nil
+	}
+	//!Like SQLite, a connection that can write creates the log when it does not exist yet.
+	err := ErrReadOnly
+	var file File
+	if !pager.readOnly {
+		file, err = pager.vfs.Open(walPath, OpenReadWrite|OpenCreate)
+	}
+	if err != nil {
+		if exists, err := pager.vfs.Exists(walPath); err != nil || !exists {
+			return false, err
+		}
+		if file, err = pager.vfs.Open(walPath, OpenReadOnly); err != nil {
+			return false, err
+		}
+	}
+	wal, err := readWAL(file, pager.pageSize)
+	if err == nil {
+		err = pager.openShm(wal)
+	}
+	if err != nil {
+		file.Close()
+		return false, err
+	}
+	pager.wal = wal
+	return true, nil
+}
+
+// readHeader reads the database header from the newest committed version of page one.
+func (pager *Pager) readHeader() (*DatabaseHeader, error) {
+	offset, ok := pager.walFrame(1)
+	if !ok {
+		return readDatabaseHeader(pager.file)
+	}
+	raw := make([]byte, 100)
+	if _, err := pager.wal.file.ReadAt(raw, offset); err != nil {
+		return nil, err
+	}
+	return parseDatabaseHeader(raw)
+}
+
+// setMmapSize limits how much of the file is memory mapped, like PRAGMA mmap_size. A negative
+// size restores the default of 0, which turns mapping off.
+func (pager *Pager) setMmapSize(n int64) error {
+	if n < 0 {
+		n = 0
+	}
+	if n > maxMmapSize {
+		n = maxMmapSize
+	}
+	pager.mmapSize = n
+	return pager.remap()
+}
+
+// remap maps as many whole pages of the file as mmap_size allows. The previous mapping is
+// retired rather than unmapped. When the file cannot be mapped, mapping is turned off and
+// pages are read with ReadAt.
+func (pager *Pager) remap() error {
+	length := int64(0)
+	//!Only files on the local file system can be mapped.
+	osf, isOSFile := pager.file.(*osFile)
+	if !isOSFile {
+		pager.mmapSize = 0
+	}
+	if pager.mmapSize > 0 {
+		size, err := pager.file.Size()
+		if err != nil {
+			return err
+		}
+		length = size
+		if length > pager.mmapSize {
+			length = pager.mmapSize
+		}
+		length -= length % pager.pageSize
+	}
+	if length == int64(len(pager.mapped)) {
+		return nil
+	}
+	if pager.mapped != nil {
+		pager.retired = append(pager.retired, pager.mapped)
+		pager.mapped = nil
+	}
+	if length > 0 {
+		mapping, err := mapFile(osf.f, length)
+		if err != nil {
+			pager.mmapSize = 0
+			return nil
+		}
+		pager.mapped = mapping
+	}
+	return nil
+}
+
+// setCacheSize bounds the cache like PRAGMA cache_size: n pages when n is positive, or as many
+// pages as fit in -n KiB when it is negative.
+func (pager *Pager) setCacheSize(n int64) {
+	pager.cacheSize = n
+	pages := n
+	if n < 0 {
+		pages = -n * 1024 / pager.pageSize
+	}
+	//!Like SQLite, a tiny cache still holds a few pages so a B-tree descent can make progress.
+	if pages < 10 {
+		pages = 10
+	}
+	pager.maxPages = int(pages)
+	pager.evict()
+}
+
+func (pager *Pager) evict() {
+	for pager.lru.Len() > pager.maxPages {
+		oldest := pager.lru.Back()
+		pager.lru.Remove(oldest)
+		delete(pager.pages, oldest.Value.(*cachedPage).pageNo)
+	}
+}
+
+// page returns the content of a page. The slice is shared with the cache and must not be
+// modified.
+func (pager *Pager) page(pageNo int64) ([]byte, error) {
+	if pageNo < 1 {
+		return nil, corruptError(pageNo, 0, "invalid page number")
+	}
+	if data, ok := pager.dirty[pageNo]; ok {
+		return data, nil
+	}
+	//!Until its first write, a new database reads as the page one that write will give it.
+	if pageNo == 1 && pager.empty && pager.dirty == nil {
+		data := make([]byte, pager.pageSize)
+		pager.initPageOne(data)
+		return data, nil
+	}
+	file, offset := pager.file, getPageOffset(pageNo, pager.pageSize)
+	walOffset, inWAL := pager.walFrame(pageNo)
+	if inWAL {
+		file, offset = pager.wal.file, walOffset
+	} else {
+		//!A page past the end of the mapping may be in a part of the file written since it was made.
+		if offset+pager.pageSize > int64(len(pager.mapped)) && offset+pager.pageSize <= pager.mmapSize {
+			if err := pager.remap(); err != nil {
+				return nil, err
+			}
+		}
+		if offset+pager.pageSize <= int64(len(pager.mapped)) {
+			return pager.mapped[offset : offset+pager.pageSize : offset+pager.pageSize], nil
+		}
+	}
+	if element, ok := pager.pages[pageNo]; ok {
+		pager.Hits++
+		pager.lru.MoveToFront(element)
+		return element.Value.(*cachedPage).data, nil
+	}
+	pager.Misses++
+	data := make([]byte, pager.pageSize)
+	if _, err := file.ReadAt(data, offset); err != nil {
+		if err == io.EOF {
+			return nil, corruptError(pageNo, 0, "page is past the end of the file")
+		}
+		return nil, err
+	}
+	pager.pages[pageNo] = pager.lru.PushFront(&cachedPage{pageNo, data})
+	pager.evict()
+	return data, nil
+}
+
+// busyDelays are the pauses between attempts to take a lock, in milliseconds, as SQLite's
+// default busy handler spaces them.
+var busyDelays = []int64{1, 2, 5, 10, 15, 20, 25, 25, 25, 50, 50, 100}
+
+// retryBusy calls try until it does not fail with ErrBusy, or busy_timeout has run out.
+func (pager *Pager) retryBusy(try func() error) error {
+	waited := int64(0)
+	for i := 0; ; i++ {
+		err := try()
+		if !errors.Is(err, ErrBusy) || waited >= pager.busyTimeout {
+			return err
+		}
+		delay := min(busyDelays[min(i, len(busyDelays)-1)], pager.busyTimeout-waited)
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+		waited += delay
+	}
+}
+
+// lockDatabase raises the lock on the database file to level, waiting for other connections
+// to release theirs for up to busy_timeout.
+func (pager *Pager) lockDatabase(level LockLevel) error {
+	return pager.retryBusy(func() error { return pager.file.Lock(level) })
+}
+
+// errStaleRead is returned when a statement had to wait for another connection to finish
+// writing before it could write, and that connection changed the database: what the statement
+// read is stale, and it must be run again.
+var errStaleRead = &ErrResult{Code: resultBusy, Msg: "database is locked"}
+
+// lockReserved raises the SHARED lock taken for reading to RESERVED, which one connection at a
+// time holds while it writes. The connection holding it needs every SHARED lock gone to commit,
+// so like SQLite the SHARED lock is dropped while waiting, except in a transaction opened with
+// BEGIN, which fails at once instead. When the database changed meanwhile, errStaleRead is
+// returned.
+func (pager *Pager) lockReserved() error {
+	err := pager.file.Lock(LockReserved)
+	if !errors.Is(err, ErrBusy) || pager.explicit || pager.busyTimeout == 0 {
+		return err
+	}
+	counter := pager.changeCounter
+	pager.endRead()
+	err = pager.retryBusy(func() error {
+		err := pager.file.Lock(LockShared)
+		if err == nil {
+			err = pager.file.Lock(LockReserved)
+		}
+		if err != nil {
+			pager.file.Unlock(LockNone)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	raw := make([]byte, 4)
+	_, err = pager.file.ReadAt(raw, 24)
+	if err == nil && binary.BigEndian.Uint32(raw) != counter {
+		err = errStaleRead
+	}
+	if err == nil {
+		var journal bool
+		if journal, err = pager.vfs.Exists(pager.path + "-journal"); err == nil && journal {
+			err = errStaleRead
+		}
+	}
+	if err != nil {
+		pager.file.Unlock(LockShared)
+	}
+	return err
+}
+
+// refresh starts a read: it takes a SHARED lock on the database, rolls back a hot journal,
+// checks the file change counter in the header and the -wal file, and drops every cached page
+// when another process has modified the database since it was last read. It reports whether
+// the cache was dropped. The locks are held until endRead.
+func (pager *Pager) refresh() (bool, error) {
+	//!Nobody else can change the database while this connection is writing to it.
+	if pager.dirty != nil {
+		return false, nil
+	}
+	if err := pager.lockDatabase(LockShared); err != nil {
+		return false, err
+	}
+	rolledBack, err := pager.recoverJournal()
+	if err != nil {
+		return false, err
+	}
+	walChanged, err := pager.syncWAL()
+	if err != nil {
+		return false, err
+	}
+	counter := make([]byte, 4)
+	if _, err := pager.file.ReadAt(counter, 24); err != nil && !(err == io.EOF && pager.empty) {
+		return false, err
+	}
+	if !rolledBack && !walChanged && binary.BigEndian.Uint32(counter) == pager.changeCounter {
+		return false, nil
+	}
+	header, err := pager.readHeader()
+	if err != nil {
+		return false, err
+	}
+	if pager.empty {
+		//!Another connection created the database, choosing its layout.
+		pager.setLayout(header.PageSize, header.PageSize-int64(header.ReservedSpace), header.TextEncoding)
+		pager.empty = false
+	} else if header.PageSize != pager.pageSize || header.PageSize-int64(header.ReservedSpace) != pager.usableSize || header.TextEncoding != pager.encoding {
+		return false, corruptError(0, 16, "page layout or text encoding changed while the database was open")
+	}
+	modeChanged := header.ReadVersion != pager.header.ReadVersion
+	pager.header = header
+	pager.changeCounter = binary.BigEndian.Uint32(counter)
+	//!Another connection switched the journal mode, so the log is read again under the new one.
+	if modeChanged {
+		pager.unlockReadMark()
+		if _, err := pager.syncWAL(); err != nil {
+			return false, err
+		}
+	}
+	pager.lru.Init()
+	pager.pages = map[int64]*list.Element{}
+	//!The file may have grown or shrunk.
+	return true, pager.remap()
+}
+
+// endRead ends a read outside a transaction, releasing the locks refresh took so that other
+// connections can write.
+func (pager *Pager) endRead() {
+	if pager.dirty != nil || pager.explicit {
+		return
+	}
+	pager.unlockReadMark()
+	pager.file.Unlock(LockNone)
+}
+
+// pageCount is the number of pages in the database.
+func (pager *Pager) pageCount() (int64, error) {
+	if pager.dirty != nil {
+		return pager.dbSize, nil
+	}
+	if pager.wal != nil && pager.wal.dbSize > 0 && pager.readMark != 0 {
+		return pager.wal.dbSize, nil
+	}
+	size, err := pager.file.Size()
+	if err != nil {
+		return 0, err
+	}
+	return pager.header.pageCount(size), nil
+}
+
+// beginWrite starts a statement that writes, and the write transaction it belongs to when
+// none is open. Changed pages stay in memory until commitTransaction writes them all, or
+// rollbackTransaction drops them; the changes of the statement alone can be undone until
+// commit or rollback ends it.
+func (pager *Pager) beginWrite() error {
+	if pager.dirty == nil {
+		if pager.readOnly || pager.header.readOnly() {
+			return ErrReadOnly
+		}
+		if pager.wal != nil {
+			if err := pager.beginWALWrite(); err != nil {
+				return err
+			}
+		} else if err := pager.lockReserved(); err != nil {
+			return err
+		}
+		count, err := pager.pageCount()
+		if err != nil {
+			pager.rollbackTransaction()
+			return err
+		}
+		pager.dirty = map[int64][]byte{}
+		pager.original = map[int64][]byte{}
+		pager.dbSize, pager.initialSize = count, count
+		//!A new database gets its first page with its first write.
+		if count == 0 {
+			page, err := pager.writablePage(1)
+			if err != nil {
+				pager.rollbackTransaction()
+				return err
+			}
+			pager.initPageOne(page)
+			pager.dbSize = 1
+		}
+	}
+	pager.savepoint = map[int64][]byte{}
+	pager.savepointSize = pager.dbSize
+	pager.fkViolations, pager.fkSavepoint = 0, pager.fkDeferred
+	return nil
+}
+
+// initPageOne writes page one of a new database: the header, with the page size and text
+// encoding chosen for it, and an empty sqlite_schema table. commitTransaction fills in the
+// change counter and page count.
+func (pager *Pager) initPageOne(page []byte) {
+	be := binary.BigEndian
+	copy(page, headerMagic)
+	if pager.pageSize == 65536 {
+		be.PutUint16(page[16:], 1)
+	} else {
+		be.PutUint16(page[16:], uint16(pager.pageSize))
+	}
+	page[18], page[19] = 1, 1
+	page[20] = byte(pager.pageSize - pager.usableSize)
+	page[21], page[22], page[23] = 64, 32, 32
+	be.PutUint32(page[44:], 4) //!Schema format.
+	be.PutUint32(page[56:], uint32(pager.encoding))
+	page[100] = pageTableLeaf
+	be.PutUint16(page[105:], uint16(pager.usableSize)) //!Cell content area, empty.
+}
+
+// setLayout sets the page size, usable size and text encoding of a database that has no pages
+// yet.
+func (pager *Pager) setLayout(pageSize int64, usableSize int64, encoding TextEncoding) {
+	pager.pageSize, pager.usableSize, pager.encoding = pageSize, usableSize, encoding
+	pager.header.PageSize, pager.header.ReservedSpace, pager.header.TextEncoding = pageSize, uint8(pageSize-usableSize), encoding
+	pager.setCacheSize(pager.cacheSize)
+}
+
+// writablePage returns a page for changing in the open write transaction. Pages past the end
+// of the database start out zeroed.
+func (pager *Pager) writablePage(pageNo int64) ([]byte, error) {
+	data, ok := pager.dirty[pageNo]
+	if pager.savepoint != nil {
+		if _, saved := pager.savepoint[pageNo]; !saved {
+			var before []byte
+			if ok {
+				before = append([]byte(nil), data...)
+			}
+			pager.savepoint[pageNo] = before
+		}
+	}
+	if ok {
+		return data, nil
+	}
+	data = make([]byte, pager.pageSize)
+	if pageNo <= pager.dbSize {
+		page, err := pager.page(pageNo)
+		if err != nil {
+			return nil, err
+		}
+		copy(data, page)
+		if _, saved := pager.original[pageNo]; !saved && pageNo <= pager.initialSize {
+			pager.original[pageNo] = append([]byte(nil), page...)
+		}
+	}
+	pager.dirty[pageNo] = data
+	return data, nil
+}
+
+// allocatePage returns a zeroed page for new content, reusing a page from the freelist when
+// there is one and growing the database otherwise.
+func (pager *Pager) allocatePage() (int64, []byte, error) {
+	header, err := pager.writablePage(1)
+	if err != nil {
+		return 0, nil, err
+	}
+	be := binary.BigEndian
+	pageNo := pager.dbSize + 1
+	if trunkNo := int64(be.Uint32(header[32:])); trunkNo != 0 {
+		if trunkNo > pager.dbSize {
+			return 0, nil, corruptError(1, 32, "freelist trunk page %d is past the end of the database", trunkNo)
+		}
+		trunk, err := pager.writablePage(trunkNo)
+		if err != nil {
+			return 0, nil, err
+		}
+		//!Take the last leaf of the first trunk page, or the trunk page itself once it has none.
+		if leaves := int64(be.Uint32(trunk[4:])); leaves > 0 {
+			if 8+4*leaves > pager.usableSize {
+				return 0, nil, corruptError(trunkNo, 4, "freelist trunk page has %d leaves", leaves)
+			}
+			pageNo = int64(be.Uint32(trunk[4+4*leaves:]))
+			be.PutUint32(trunk[4:], uint32(leaves-1))
+		} else {
+			pageNo = trunkNo
+			copy(header[32:36], trunk[:4])
+		}
+		if pageNo < 2 || pageNo > pager.dbSize {
+			return 0, nil, corruptError(trunkNo, 0, "freelist page %d is out of range", pageNo)
+		}
+		be.PutUint32(header[36:], be.Uint32(header[36:])-1)
+	} else if pageNo == pendingByte/pager.pageSize+1 {
+		pageNo++
+	}
+	data, err := pager.writablePage(pageNo)
+	if err != nil {
+		return 0, nil, err
+	}
+	clear(data)
+	pager.dbSize = max(pager.dbSize, pageNo)
+	return pageNo, data, nil
+}
+
+// commit ends the running statement, keeping its changes. Outside a transaction opened with
+// BEGIN, it also commits the write transaction.
+func (pager *Pager) commit() error {
+	pager.savepoint = nil
+	if pager.explicit {
+		return nil
+	}
+	return pager.commitTransaction()
+}
+
+// rollback ends the running statement, undoing its changes. Outside a transaction opened with
+// BEGIN, that is the whole write transaction.
+func (pager *Pager) rollback() {
+	if !pager.explicit {
+		pager.rollbackTransaction()
+		return
+	}
+	for pageNo, before := range pager.savepoint {
+		if before == nil {
+			delete(pager.dirty, pageNo)
+		} else {
+			pager.dirty[pageNo] = before
+		}
+	}
+	if pager.savepoint != nil {
+		pager.dbSize = pager.savepointSize
+		pager.fkDeferred = pager.fkSavepoint
+	}
+	pager.savepoint = nil
+}
+
+// commitTransaction makes the changes of the open write transaction durable. In rollback
+// journal mode it follows SQLite's protocol: the original content of the changed pages is
+// synced to the -journal file, the pages are written to the database and synced, and deleting
+// the journal commits. A crash before the journal is deleted leaves it hot, and the next
+// reader rolls the database back with it. In WAL mode the pages are appended to the log
+// instead, and a checkpoint runs once the log holds wal_autocheckpoint frames. The header's
+// change counter and page count are updated on the way, in WAL mode only when page one
+// changed, as SQLite does.
+func (pager *Pager) commitTransaction() error {
+	if pager.dirty == nil {
+		return nil
+	}
+	defer pager.rollbackTransaction()
+	//!A transaction that changed nothing has nothing to write.
+	if len(pager.dirty) == 0 {
+		return nil
+	}
+	walMode := pager.wal != nil
+	var parsed *DatabaseHeader
+	if _, ok := pager.dirty[1]; ok || !walMode {
+		header, err := pager.writablePage(1)
+		if err != nil {
+			return err
+		}
+		be := binary.BigEndian
+		counter := be.Uint32(header[24:]) + 1
+		be.PutUint32(header[24:], counter)
+		be.PutUint32(header[28:], uint32(pager.dbSize))
+		be.PutUint32(header[92:], counter)
+		be.PutUint32(header[96:], sqliteVersionNumber)
+		if parsed, err = parseDatabaseHeader(header[:100]); err != nil {
+			return err
+		}
+	}
+	pageNos := make([]int64, 0, len(pager.dirty))
+	for pageNo := range pager.dirty {
+		pageNos = append(pageNos, pageNo)
+	}
+	slices.Sort(pageNos)
+
+	if walMode {
+		if err := pager.writeWAL(pageNos); err != nil {
+			return err
+		}
+	} else if err := pager.writeDatabase(pageNos); err != nil {
+		return err
+	}
+	//!Cached copies of the changed pages are now stale.
+	for _, pageNo := range pageNos {
+		if element, ok := pager.pages[pageNo]; ok {
+			element.Value.(*cachedPage).data = pager.dirty[pageNo]
+		}
+	}
+	if parsed != nil {
+		pager.header, pager.empty = parsed, false
+		//!In WAL mode the database file keeps its counter until a checkpoint.
+		if !walMode {
+			pager.changeCounter = parsed.FileChangeCounter
+		}
+	}
+	pager.rollbackTransaction()
+	if walMode && pager.checkpointAt > 0 && int64(len(pager.wal.pages)) >= pager.checkpointAt {
+		_, _, _, err := pager.checkpoint(checkpointPassive)
+		return err
+	}
+	return nil
+}
+
+// writeDatabase writes the changed pages to the database file in rollback journal mode, under
+// the protection of the -journal. A failure must not leave a hot journal behind a connection
+// that is still running, or the next reader would refuse or repeat the rollback: like SQLite's
+// pager_end_transaction, the pages already written are put back from the originals kept in
+// memory, and the journal is deleted. Only when that fails too does the journal stay, for the
+// next reader to roll back.
+func (pager *Pager) writeDatabase(pageNos []int64) (err error) {
+	journalPath := pager.path + "-journal"
+	written := false
+	defer func() {
+		if err == nil || (written && pager.restoreOriginal() != nil) {
+			return
+		}
+		pager.vfs.Delete(journalPath)
+	}()
+	if err := pager.writeJournal(); err != nil {
+		return err
+	}
+	if err := pager.lockDatabase(LockExclusive); err != nil {
+		return err
+	}
+	written = true
+	for _, pageNo := range pageNos {
+		if _, err := pager.file.WriteAt(pager.dirty[pageNo], getPageOffset(pageNo, pager.pageSize)); err != nil {
+			return err
+		}
+	}
+	if err := pager.file.Sync(); err != nil {
+		return err
+	}
+	return pager.vfs.Delete(journalPath)
+}
+
+// restoreOriginal undoes writeDatabase: it writes back the content the changed pages had
+// before the transaction and truncates the database to its size then.
+func (pager *Pager) restoreOriginal() error {
+	for pageNo, data := range pager.original {
+		if _, err := pager.file.WriteAt(data, getPageOffset(pageNo, pager.pageSize)); err != nil {
+			return err
+		}
+	}
+	if err := pager.file.Truncate(pager.initialSize * pager.pageSize); err != nil {
+		return err
+	}
+	return pager.file.Sync()
+}
+
+// rollbackTransaction ends the open write transaction, dropping the pages it changed. The
+// locks for reading are kept until endRead.
+func (pager *Pager) rollbackTransaction() {
+	pager.explicit = false
+	pager.savepoint = nil
+	pager.fkDeferred = 0
+	pager.dirty, pager.original = nil, nil
+	pager.endWALWrite()
+	pager.file.Unlock(LockShared)
+}
+
+// beginTransaction opens a transaction that lasts until endTransaction. An IMMEDIATE or
+// EXCLUSIVE transaction starts writing at once, taking the locks for it; a DEFERRED one with
+// its first change.
+func (pager *Pager) beginTransaction(mode string) error {
+	if pager.explicit {
+		return fmt.Errorf("cannot start a transaction within a transaction")
+	}
+	if mode == "IMMEDIATE" || mode == "EXCLUSIVE" {
+		if err := pager.beginWrite(); err != nil {
+			return err
+		}
+		pager.savepoint = nil
+	}
+	//!In rollback journal mode, an EXCLUSIVE transaction also keeps readers out.
+	if mode == "EXCLUSIVE" && pager.wal == nil {
+		if err := pager.lockDatabase(LockExclusive); err != nil {
+			pager.rollbackTransaction()
+			return err
+		}
+	}
+	pager.explicit = true
+	return nil
+}
+
+// endTransaction ends the transaction opened by beginTransaction, with COMMIT or ROLLBACK.
+func (pager *Pager) endTransaction(commit bool) error {
+	if !pager.explicit {
+		if commit {
+			return fmt.Errorf("cannot commit - no transaction is active")
+		}
+		return fmt.Errorf("cannot rollback - no transaction is active")
+	}
+	if !commit {
+		pager.rollbackTransaction()
+		return nil
+	}
+	//!The transaction stays open, so the violations can still be fixed.
+	if pager.fkDeferred > 0 {
+		return &ErrResult{Code: resultConstraint, Msg: "FOREIGN KEY constraint failed"}
+	}
+	pager.explicit = false
+	return pager.commitTransaction()
+}
+
+// freePage puts a page that is no longer used on the freelist, as a leaf of the first trunk
+// page when it has room and as a new trunk page otherwise.
+func (pager *Pager) freePage(pageNo int64) error {
+	header, err := pager.writablePage(1)
+	if err != nil {
+		return err
+	}
+	be := binary.BigEndian
+	be.PutUint32(header[36:], be.Uint32(header[36:])+1)
+	if trunkNo := int64(be.Uint32(header[32:])); trunkNo != 0 {
+		trunk, err := pager.writablePage(trunkNo)
+		if err != nil {
+			return err
+		}
+		//!SQLite leaves a few slots unused so that older versions can read the list.
+		if leaves := int64(be.Uint32(trunk[4:])); leaves < pager.usableSize/4-8 {
+			be.PutUint32(trunk[8+4*leaves:], uint32(pageNo))
+			be.PutUint32(trunk[4:], uint32(leaves+1))
+			return nil
+		}
+	}
+	trunk, err := pager.writablePage(pageNo)
+	if err != nil {
+		return err
+	}
+	clear(trunk)
+	copy(trunk[:4], header[32:36])
+	be.PutUint32(header[32:], uint32(pageNo))
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNewDatabase(t *testing.T) {
+	//!Opening a missing file read-only does not create it.
+	path := filepath.Join(t.TempDir(), "new.db")
+	shell := newShell(&strings.Builder{})
+	shell.databaseFilePath, shell.readOnly, shell.commands = path, true, []string{"select 1"}
+	captureStderr(t, func() {
+		if code := shell.run(strings.NewReader("")); code != 1 {
+			t.Errorf("read-only open of a missing file: exit code %d, want 1", code)
+		}
+	})
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read-only open created the file: %v", err)
+	}
+
+	//!Opened for writing, it is created empty, and reads as a database without tables until the
+	//!first write, before which its page size and encoding can still be chosen. Each step is a
+	//!connection of its own.
+	steps := []struct {
+		sql  []string
+		want string
+		size int64
+	}{
+		{[]string{"select 1"}, "1", 0},
+		{[]string{"select count(*) from sqlite_schema", "pragma page_count", "pragma encoding"}, "0 0 UTF-8", 0},
+		{[]string{"pragma page_size = 1000", "pragma page_size"}, "4096", 0}, //!Not a power of two.
+		{[]string{"pragma page_size = 1024", "pragma encoding = 'utf16be'", "create table t(a)",
+			"insert into t values ('x')", "select a from t"}, "x", 2048},
+		{[]string{"pragma page_size = 512", "pragma encoding = 'UTF-8'", "pragma page_size", "pragma encoding"},
+			"1024 UTF-16be", 2048},
+	}
+	for _, step := range steps {
+		got := strings.Join(strings.Fields(runSQL(t, path, step.sql...)), " ")
+		if got != step.want {
+			t.Errorf("%q: got %q, want %q", step.sql, got, step.want)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != step.size {
+			t.Fatalf("%q: file is %v, %v, want %d bytes", step.sql, info, err, step.size)
+		}
+	}
+	checkIntegrity(t, path)
+
+	captureStderr(t, func() {
+		if _, code := runShell(filepath.Join(t.TempDir(), "latin1.db"), "", "pragma encoding = 'latin1'"); code != 1 {
+			t.Errorf("unsupported encoding: exit code %d, want 1", code)
+		}
+	})
+}
+
+func TestPageCacheLRU(t *testing.T) {
+	path := newDatabase(t)
+	var values []string
+	for i := 0; i < 50; i++ {
+		values = append(values, "('"+strings.Repeat(strconv.Itoa(i%10), 2000)+"')")
+	}
+	runSQL(t, path, "create table t(s)", "insert into t values "+strings.Join(values, ", "))
+	pager, _, done := openDatabase(t, path)
+	defer done()
+	if count, err := pager.pageCount(); err != nil || count < 20 {
+		t.Fatalf("page count %d, %v", count, err)
+	}
+	pager.lru.Init()
+	pager.pages = map[int64]*list.Element{}
+	pager.Hits, pager.Misses = 0, 0
+
+	//!A page read again moves to the front, and a miss evicts the least recently used one.
+	pager.setCacheSize(5) //!Raised to the minimum of 10 pages.
+	reads := []struct {
+		pageNo int64
+		hit    bool
+	}{
+		{1, false}, {2, false}, {3, false}, {4, false}, {5, false},
+		{6, false}, {7, false}, {8, false}, {9, false}, {10, false},
+		{1, true},
+		{11, false}, //!Evicts 2.
+		{2, false},  //!Evicts 3.
+		{1, true},
+		{3, false}, //!Evicts 4.
+		{10, true},
+	}
+	for i, read := range reads {
+		hits := pager.Hits
+		if _, err := pager.page(read.pageNo); err != nil {
+			t.Fatal(err)
+		}
+		if hit := pager.Hits > hits; hit != read.hit {
+			t.Errorf("read %d of page %d: hit %v, want %v", i, read.pageNo, hit, read.hit)
+		}
+	}
+	if pager.Hits != 3 || pager.Misses != 13 || pager.lru.Len() != 10 {
+		t.Errorf("%d hits, %d misses, %d cached; want 3, 13, 10", pager.Hits, pager.Misses, pager.lru.Len())
+	}
+
+	//!Shrinking the cache keeps the most recently used pages. A negative size is in KiB.
+	pager.setCacheSize(-80)
+	for pageNo := int64(1); pageNo <= 20; pageNo++ {
+		if _, err := pager.page(pageNo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pager.lru.Len() != 20 {
+		t.Errorf("cache_size -80: %d pages cached, want 20", pager.lru.Len())
+	}
+	pager.setCacheSize(12)
+	for pageNo := int64(1); pageNo <= 20; pageNo++ {
+		if _, cached := pager.pages[pageNo]; cached != (pageNo > 8) {
+			t.Errorf("cache_size 12: page %d cached %v", pageNo, cached)
+		}
+	}
+}
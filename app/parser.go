@@ -0,0 +1,1822 @@
+package main
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Expr is a node of a parsed SQL expression. Pos is the byte offset of the expression in the
+// statement text, used to point error messages at it.
+type Expr interface {
+	Pos() int
+}
+
+type LiteralExpr struct {
+	Value interface{} //!nil, int64, float64, string or []byte.
+	P     int
+}
+
+type ColumnExpr struct {
+	Table    string //!Optional table qualifier.
+	Name     string
+	P        int
+	Index    int    //!Set by bindColumns: position of the column in its table, -1 for the rowid.
+	Collate  string //!Set by bindColumns: the declared collation of the column, "" for BINARY.
+	Excluded bool   //!Refers to the row an upsert failed to insert, as "excluded.name".
+}
+
+type UnaryExpr struct {
+	Op      string //!"-", "+", "~" or "NOT".
+	Operand Expr
+	P       int
+}
+
+type BinaryExpr struct {
+	Op          string //!Operators as written, with "IS NOT" for the negated IS.
+	Left, Right Expr
+	P           int
+}
+
+type LikeExpr struct {
+	Op      string //!"LIKE" or "GLOB".
+	Not     bool
+	Left    Expr
+	Pattern Expr
+	Escape  Expr
+	P       int
+}
+
+type InExpr struct {
+	Operand Expr
+	List    []Expr
+	Not     bool
+	P       int
+}
+
+type BetweenExpr struct {
+	Operand   Expr
+	Low, High Expr
+	Not       bool
+	P         int
+}
+
+type FuncExpr struct {
+	Name     string
+	Args     []Expr
+	Star     bool //!count(*)
+	Distinct bool
+	P        int
+}
+
+type WhenClause struct {
+	When, Then Expr
+}
+
+type CaseExpr struct {
+	Operand Expr //!Optional, for the "CASE x WHEN ..." form.
+	Whens   []WhenClause
+	Else    Expr
+	P       int
+}
+
+type CastExpr struct {
+	Operand Expr
+	Type    string
+	P       int
+}
+
+type CollateExpr struct {
+	Operand   Expr
+	Collation string
+	P         int
+}
+
+func (e *LiteralExpr) Pos() int { return e.P }
+func (e *ColumnExpr) Pos() int  { return e.P }
+func (e *UnaryExpr) Pos() int   { return e.P }
+func (e *BinaryExpr) Pos() int  { return e.P }
+func (e *LikeExpr) Pos() int    { return e.P }
+func (e *InExpr) Pos() int      { return e.P }
+func (e *BetweenExpr) Pos() int { return e.P }
+func (e *FuncExpr) Pos() int    { return e.P }
+func (e *CaseExpr) Pos() int    { return e.P }
+func (e *CastExpr) Pos() int    { return e.P }
+func (e *CollateExpr) Pos() int { return e.P }
+
+// Statement is a parsed SQL statement.
+type Statement interface {
+	statementNode()
+}
+
+// ResultColumn is one entry of a SELECT list. Text is the expression as written, which is
+// what sqlite3 uses as the column name when there is no alias.
+type ResultColumn struct {
+	Expr  Expr
+	Alias string
+	Star  bool   //!"*" or "table.*"
+	Table string //!Qualifier of "table.*".
+	Text  string
+}
+
+type TableRef struct {
+	Name  string
+	Alias string
+	P     int
+}
+
+type OrderingTerm struct {
+	Expr Expr
+	Desc bool
+}
+
+type SelectStmt struct {
+	Distinct bool
+	Columns  []ResultColumn
+	From     *TableRef //!nil for a SELECT without FROM.
+	Where    Expr
+	OrderBy  []OrderingTerm
+	Limit    Expr
+	Offset   Expr
+}
+
+// PragmaStmt is "PRAGMA name", "PRAGMA name = value" or "PRAGMA name(value)".
+type PragmaStmt struct {
+	Name  string
+	Value Expr //!nil when the pragma is queried.
+}
+
+// InsertStmt is "INSERT INTO table [(columns)]" followed by VALUES rows, a SELECT or DEFAULT
+// VALUES, then any upsert clauses and a RETURNING clause.
+type InsertStmt struct {
+	OrAction  string //!Conflict resolution from "INSERT OR ..." or "REPLACE", upper case; "" for none.
+	Table     string
+	TableP    int
+	Columns   []string //!nil when no column list is given.
+	Values    [][]Expr
+	Select    *SelectStmt
+	Upserts   []Upsert
+	Returning []ResultColumn
+}
+
+// Upsert is "ON CONFLICT [(columns) [WHERE expr]] DO NOTHING" or "... DO UPDATE SET column =
+// expr, ... [WHERE expr]". Only the last clause of an INSERT may leave out the target.
+type Upsert struct {
+	Target      []IndexedColumn //!nil when no target is given.
+	TargetWhere Expr
+	Set         []Assignment //!nil for DO NOTHING.
+	Where       Expr
+}
+
+// Assignment is one "column = expr" of an UPDATE or of an upsert.
+type Assignment struct {
+	Column  string
+	ColumnP int
+	Expr    Expr
+}
+
+// UpdateStmt is "UPDATE [OR action] table SET column = expr, ... [WHERE expr] [RETURNING
+// columns]".
+type UpdateStmt struct {
+	OrAction  string
+	Table     string
+	TableP    int
+	Set       []Assignment
+	Where     Expr
+	Returning []ResultColumn
+}
+
+// DeleteStmt is "DELETE FROM table [WHERE expr] [RETURNING columns]".
+type DeleteStmt struct {
+	Table     string
+	TableP    int
+	Where     Expr
+	Returning []ResultColumn
+}
+
+// TransactionStmt is "BEGIN [DEFERRED | IMMEDIATE | EXCLUSIVE]", "COMMIT" (or "END") or
+// "ROLLBACK", each optionally followed by TRANSACTION.
+type TransactionStmt struct {
+	Kind string //!BEGIN, COMMIT or ROLLBACK.
+	Mode string //!For BEGIN, upper case; "" when not given.
+}
+
+// IndexedColumn is one key column of an index or of a PRIMARY KEY or UNIQUE constraint. Expr
+// is set instead of Name for an index on an expression.
+type IndexedColumn struct {
+	Name    string
+	Expr    Expr
+	Collate string
+	Desc    bool
+	Pos     int //!Offset of the key in the statement.
+}
+
+// ForeignKey is a REFERENCES clause. Columns are the child columns: those listed by a table
+// constraint, or the column a column constraint is on.
+type ForeignKey struct {
+	Columns       []string
+	Table         string
+	ParentColumns []string //!Empty when the parent's primary key is meant.
+	OnDelete      string   //!Upper case action, "" for NO ACTION.
+	OnUpdate      string
+	Deferred      bool  //!DEFERRABLE INITIALLY DEFERRED.
+	ColumnPos     []int //!Offsets of the names in Columns, ParentColumns and of Table.
+	ParentPos     []int
+	TablePos      int
+}
+
+// ColumnDef is one column of a CREATE TABLE statement.
+type ColumnDef struct {
+	Name          string
+	Pos           int //!Offset of the name in the statement.
+	Type          string
+	PrimaryKey    bool
+	PrimaryDesc   bool
+	Autoincrement bool
+	NotNull       bool
+	Unique        bool
+	Default       Expr
+	Collate       string
+	Checks        []Check
+	References    *ForeignKey
+	Generated     Expr
+	Stored        bool              //!The generated column is stored in the record.
+	OnConflict    map[string]string //!Constraint ("PRIMARY KEY", "NOT NULL", "UNIQUE") to its ON CONFLICT action.
+}
+
+// Check is a CHECK constraint. Name is what a violation reports: the constraint name, or the
+// text of the expression for an unnamed constraint.
+type Check struct {
+	Name string
+	Expr Expr
+}
+
+// TableConstraint is a PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY clause after the columns.
+type TableConstraint struct {
+	Kind       string //!"PRIMARY KEY", "UNIQUE", "CHECK" or "FOREIGN KEY".
+	Name       string //!From CONSTRAINT name, "" when there is none.
+	Columns    []IndexedColumn
+	Check      Check
+	ForeignKey *ForeignKey
+	OnConflict string
+}
+
+// CreateTableStmt is a CREATE TABLE statement. SQL is the text SQLite keeps in sqlite_schema
+// for it: "CREATE TABLE" followed by the statement from the table name on.
+type CreateTableStmt struct {
+	Name         string
+	NamePos      int
+	SQL          string
+	IfNotExists  bool
+	Columns      []ColumnDef
+	Constraints  []TableConstraint
+	ColumnsEnd   int //!Offset of the comma before the table constraints, or of the closing ")".
+	WithoutRowid bool
+	Strict       bool
+	Select       *SelectStmt //!For CREATE TABLE ... AS SELECT.
+}
+
+// CreateIndexStmt is a CREATE INDEX statement, SQL its text as kept in sqlite_schema.
+type CreateIndexStmt struct {
+	Name        string
+	NamePos     int
+	SQL         string
+	Unique      bool
+	IfNotExists bool
+	Table       string
+	TablePos    int
+	Columns     []IndexedColumn
+	Where       Expr
+}
+
+// DropStmt is "DROP TABLE" or "DROP INDEX", optionally with IF EXISTS.
+type DropStmt struct {
+	Kind     string //!TABLE or INDEX.
+	Name     string
+	IfExists bool
+}
+
+// AlterTableStmt is an ALTER TABLE statement. Action is "RENAME", "RENAME COLUMN", "ADD
+// COLUMN" or "DROP COLUMN". Column is the column renamed or dropped, NewName the new name of
+// the table or column and Def the added column, whose text is DefSQL.
+type AlterTableStmt struct {
+	Table     string
+	Action    string
+	Column    string
+	ColumnPos int
+	NewName   string
+	NewQuoted bool //!The new name was quoted, which makes it replace every reference quoted.
+	Def       *ColumnDef
+	DefSQL    string
+}
+
+func (*SelectStmt) statementNode()      {}
+func (*PragmaStmt) statementNode()      {}
+func (*InsertStmt) statementNode()      {}
+func (*UpdateStmt) statementNode()      {}
+func (*DeleteStmt) statementNode()      {}
+func (*TransactionStmt) statementNode() {}
+func (*CreateTableStmt) statementNode() {}
+func (*CreateIndexStmt) statementNode() {}
+func (*DropStmt) statementNode()        {}
+func (*AlterTableStmt) statementNode()  {}
+
+// parser is a recursive descent parser over the tokens of one statement.
+type parser struct {
+	sql    string
+	tokens []Token
+	pos    int
+}
+
+// parseStatement parses exactly one SQL statement, optionally followed by a semicolon.
+func parseStatement(sql string) (Statement, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{sql: sql, tokens: tokens}
+	statement, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptOp(";")
+	if p.peek().Kind != TokenEOF {
+		return nil, p.errorAt(p.peek())
+	}
+	return statement, nil
+}
+
+func (p *parser) parseStatement() (Statement, error) {
+	tok := p.peek()
+	switch {
+	case tok.isKeyword("SELECT"):
+		return p.parseSelect()
+	case tok.isKeyword("PRAGMA"):
+		return p.parsePragma()
+	case tok.isKeyword("INSERT") || tok.isKeyword("REPLACE"):
+		return p.parseInsert()
+	case tok.isKeyword("UPDATE"):
+		return p.parseUpdate()
+	case tok.isKeyword("DELETE"):
+		return p.parseDelete()
+	case tok.isKeyword("CREATE"):
+		return p.parseCreate()
+	case tok.isKeyword("DROP"):
+		return p.parseDrop()
+	case tok.isKeyword("ALTER"):
+		return p.parseAlter()
+	case tok.isKeyword("BEGIN") || tok.isKeyword("COMMIT") || tok.isKeyword("END") || tok.isKeyword("ROLLBACK"):
+		return p.parseTransaction()
+	}
+	return nil, p.errorAt(tok)
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(n int) Token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// lastEnd is the end offset of the most recently consumed token.
+func (p *parser) lastEnd() int {
+	if p.pos == 0 {
+		return 0
+	}
+	return p.tokens[p.pos-1].End
+}
+
+// errorAt builds the syntax error sqlite3 reports for an unexpected token.
+func (p *parser) errorAt(tok Token) error {
+	return &ErrSyntax{Near: p.sql[tok.Pos:tok.End], Pos: tok.Pos}
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.peek().isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorAt(p.peek())
+	}
+	return nil
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if tok := p.peek(); tok.Kind == TokenOperator && tok.Text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorAt(p.peek())
+	}
+	return nil
+}
+
+// reservedWords may not be used as bare identifiers because they would make the grammar
+// ambiguous.
+var reservedWords = map[string]bool{
+	"ALL": true, "ALTER": true, "AND": true, "AS": true, "BETWEEN": true, "BY": true, "CASE": true,
+	"CHECK": true, "COLLATE": true, "CONSTRAINT": true, "CREATE": true, "DEFAULT": true,
+	"DELETE": true, "DISTINCT": true, "DROP": true, "ELSE": true, "END": true, "ESCAPE": true,
+	"EXCEPT": true, "EXISTS": true, "FOREIGN": true, "FROM": true, "GLOB": true, "GROUP": true,
+	"HAVING": true, "IN": true, "INDEX": true, "INSERT": true, "INTERSECT": true, "INTO": true,
+	"IS": true, "ISNULL": true, "JOIN": true, "LIKE": true, "LIMIT": true, "NOT": true,
+	"NOTNULL": true, "NULL": true, "OFFSET": true, "ON": true, "OR": true, "ORDER": true,
+	"PRIMARY": true, "REFERENCES": true, "RETURNING": true, "SELECT": true, "SET": true,
+	"TABLE": true, "THEN": true, "UNION": true, "UNIQUE": true, "UPDATE": true, "USING": true,
+	"VALUES": true, "WHEN": true, "WHERE": true,
+}
+
+// tableConstraintWords start a table constraint rather than a column definition.
+var tableConstraintWords = map[string]bool{"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "CHECK": true, "FOREIGN": true}
+
+// parseName reads an identifier, bare or quoted.
+func (p *parser) parseName() (string, Token, error) {
+	tok := p.peek()
+	if tok.Kind == TokenQuotedIdent || (tok.Kind == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)]) {
+		p.pos++
+		return tok.Text, tok, nil
+	}
+	//!Like SQLite, a string literal is accepted where an identifier is expected.
+	if tok.Kind == TokenString {
+		p.pos++
+		return tok.Text, tok, nil
+	}
+	return "", tok, p.errorAt(tok)
+}
+
+func (p *parser) parseSelect() (*SelectStmt, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &SelectStmt{}
+	if p.acceptKeyword("DISTINCT") {
+		stmt.Distinct = true
+	} else {
+		p.acceptKeyword("ALL")
+	}
+
+	for {
+		column, err := p.parseResultColumn()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, column)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if p.acceptKeyword("FROM") {
+		name, tok, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.From = &TableRef{Name: name, P: tok.Pos}
+		if p.acceptKeyword("AS") {
+			if stmt.From.Alias, _, err = p.parseName(); err != nil {
+				return nil, err
+			}
+		} else if tok := p.peek(); tok.Kind == TokenQuotedIdent || (tok.Kind == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)]) {
+			stmt.From.Alias, _, _ = p.parseName()
+		}
+	}
+
+	var err error
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			term := OrderingTerm{}
+			if term.Expr, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("DESC") {
+				term.Desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, term)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if stmt.Limit, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if stmt.Offset, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		} else if p.acceptOp(",") {
+			//!"LIMIT offset, count"
+			stmt.Offset = stmt.Limit
+			if stmt.Limit, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parsePragma() (*PragmaStmt, error) {
+	p.pos++ //!PRAGMA
+	name, _, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	//!Only the main database exists, so a "main." prefix is dropped.
+	if p.acceptOp(".") {
+		if name, _, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	stmt := &PragmaStmt{Name: strings.ToLower(name)}
+	closing := ""
+	if p.acceptOp("=") {
+	} else if p.acceptOp("(") {
+		closing = ")"
+	} else {
+		return stmt, nil
+	}
+	//!Values may be bare words, as in "PRAGMA journal_mode = WAL".
+	if tok := p.peek(); tok.Kind == TokenIdent || tok.Kind == TokenQuotedIdent {
+		p.pos++
+		stmt.Value = &LiteralExpr{Value: tok.Text, P: tok.Pos}
+	} else if stmt.Value, err = p.parseUnary(); err != nil {
+		return nil, err
+	}
+	if closing != "" {
+		if err := p.expectOp(closing); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseResultColumn() (ResultColumn, error) {
+	if p.acceptOp("*") {
+		return ResultColumn{Star: true, Text: "*"}, nil
+	}
+	if tok := p.peek(); (tok.Kind == TokenIdent || tok.Kind == TokenQuotedIdent) &&
+		p.peekAt(1).Text == "." && p.peekAt(2).Text == "*" {
+		p.pos += 3
+		return ResultColumn{Star: true, Table: tok.Text, Text: tok.Text + ".*"}, nil
+	}
+
+	start := p.peek().Pos
+	expr, err := p.parseExpr()
+	if err != nil {
+		return ResultColumn{}, err
+	}
+	column := ResultColumn{Expr: expr, Text: p.sql[start:p.lastEnd()]}
+	if p.acceptKeyword("AS") {
+		if column.Alias, _, err = p.parseName(); err != nil {
+			return ResultColumn{}, err
+		}
+	} else if tok := p.peek(); tok.Kind == TokenQuotedIdent || tok.Kind == TokenString ||
+		(tok.Kind == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)]) {
+		column.Alias, _, _ = p.parseName()
+	}
+	return column, nil
+}
+
+// parseExpr parses an expression using SQLite's operator precedence, from OR (loosest) down
+// to the unary operators.
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("OR") {
+		tok := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "OR", Left: left, Right: right, P: tok.Pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("AND") {
+		tok := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "AND", Left: left, Right: right, P: tok.Pos}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if tok := p.peek(); tok.isKeyword("NOT") {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "NOT", Operand: operand, P: tok.Pos}, nil
+	}
+	return p.parseEquality()
+}
+
+// parseEquality handles the operators sharing the precedence of "=": IS, IN, LIKE, GLOB,
+// BETWEEN and the NULL tests.
+func (p *parser) parseEquality() (Expr, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		not := false
+		if tok.isKeyword("NOT") {
+			following := p.peekAt(1)
+			if following.isKeyword("NULL") {
+				p.pos += 2
+				left = &BinaryExpr{Op: "IS NOT", Left: left, Right: &LiteralExpr{P: following.Pos}, P: tok.Pos}
+				continue
+			}
+			if !(following.isKeyword("IN") || following.isKeyword("LIKE") || following.isKeyword("GLOB") || following.isKeyword("BETWEEN")) {
+				return left, nil
+			}
+			p.pos++
+			not = true
+			tok = p.peek()
+		}
+		switch {
+		case tok.Kind == TokenOperator && (tok.Text == "=" || tok.Text == "==" || tok.Text == "!=" || tok.Text == "<>"):
+			p.pos++
+			right, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			op := tok.Text
+			if op == "==" {
+				op = "="
+			} else if op == "<>" {
+				op = "!="
+			}
+			left = &BinaryExpr{Op: op, Left: left, Right: right, P: tok.Pos}
+		case tok.isKeyword("IS"):
+			p.pos++
+			op := "IS"
+			if p.acceptKeyword("NOT") {
+				op = "IS NOT"
+			}
+			right, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			left = &BinaryExpr{Op: op, Left: left, Right: right, P: tok.Pos}
+		case tok.isKeyword("ISNULL") || tok.isKeyword("NOTNULL"):
+			p.pos++
+			op := "IS"
+			if tok.isKeyword("NOTNULL") {
+				op = "IS NOT"
+			}
+			left = &BinaryExpr{Op: op, Left: left, Right: &LiteralExpr{P: tok.Pos}, P: tok.Pos}
+		case tok.isKeyword("IN"):
+			p.pos++
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			in := &InExpr{Operand: left, Not: not, P: tok.Pos}
+			if !p.acceptOp(")") {
+				if in.List, err = p.parseExprList(); err != nil {
+					return nil, err
+				}
+				if err := p.expectOp(")"); err != nil {
+					return nil, err
+				}
+			}
+			left = in
+		case tok.isKeyword("LIKE") || tok.isKeyword("GLOB"):
+			p.pos++
+			pattern, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			like := &LikeExpr{Op: strings.ToUpper(tok.Text), Not: not, Left: left, Pattern: pattern, P: tok.Pos}
+			if p.acceptKeyword("ESCAPE") {
+				if like.Escape, err = p.parseComparison(); err != nil {
+					return nil, err
+				}
+			}
+			left = like
+		case tok.isKeyword("BETWEEN"):
+			p.pos++
+			low, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("AND"); err != nil {
+				return nil, err
+			}
+			high, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			left = &BetweenExpr{Operand: left, Low: low, High: high, Not: not, P: tok.Pos}
+		default:
+			return left, nil
+		}
+	}
+}
+
+// parseBinaryLevel parses a left-associative chain of the given operators over operands
+// parsed by operand.
+func (p *parser) parseBinaryLevel(ops []string, operand func() (Expr, error)) (Expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		matched := false
+		for _, op := range ops {
+			if tok.Kind == TokenOperator && tok.Text == op {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: tok.Text, Left: left, Right: right, P: tok.Pos}
+	}
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	return p.parseBinaryLevel([]string{"<", "<=", ">", ">="}, p.parseBitwise)
+}
+
+func (p *parser) parseBitwise() (Expr, error) {
+	return p.parseBinaryLevel([]string{"&", "|", "<<", ">>"}, p.parseAdditive)
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	return p.parseBinaryLevel([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	return p.parseBinaryLevel([]string{"*", "/", "%"}, p.parseConcat)
+}
+
+func (p *parser) parseConcat() (Expr, error) {
+	return p.parseBinaryLevel([]string{"||"}, p.parseUnary)
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	if tok.Kind == TokenOperator && (tok.Text == "-" || tok.Text == "+" || tok.Text == "~") {
+		p.pos++
+		//!-9223372036854775808 is the one integer literal whose magnitude does not fit an int64.
+		if number := p.peek(); tok.Text == "-" && number.Kind == TokenNumber && number.Text == "9223372036854775808" {
+			p.pos++
+			return &LiteralExpr{Value: int64(-9223372036854775808), P: tok.Pos}, nil
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		//!Fold "-literal" into a negative literal.
+		if literal, ok := operand.(*LiteralExpr); ok && tok.Text == "-" {
+			switch v := literal.Value.(type) {
+			case int64:
+				return &LiteralExpr{Value: -v, P: tok.Pos}, nil
+			case float64:
+				return &LiteralExpr{Value: -v, P: tok.Pos}, nil
+			}
+		}
+		return &UnaryExpr{Op: tok.Text, Operand: operand, P: tok.Pos}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (Expr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("COLLATE") {
+		tok := p.next()
+		collation, _, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		expr = &CollateExpr{Operand: expr, Collation: strings.ToUpper(collation), P: tok.Pos}
+	}
+	return expr, nil
+}
+
+func (p *parser) parseExprList() ([]Expr, error) {
+	var list []Expr
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, expr)
+		if !p.acceptOp(",") {
+			return list, nil
+		}
+	}
+}
+
+// parseNumber converts a numeric literal to an int64 when it fits, and a float64 otherwise.
+func parseNumber(text string) interface{} {
+	if len(text) > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		u, err := strconv.ParseUint(text[2:], 16, 64)
+		if err == nil {
+			return int64(u)
+		}
+	}
+	if !strings.ContainsAny(text, ".eE") {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i
+		}
+	}
+	f, _ := strconv.ParseFloat(text, 64)
+	return f
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	switch tok.Kind {
+	case TokenNumber:
+		p.pos++
+		return &LiteralExpr{Value: parseNumber(tok.Text), P: tok.Pos}, nil
+	case TokenString:
+		p.pos++
+		return &LiteralExpr{Value: tok.Text, P: tok.Pos}, nil
+	case TokenBlob:
+		p.pos++
+		blob, _ := hex.DecodeString(tok.Text)
+		if blob == nil {
+			blob = []byte{}
+		}
+		return &LiteralExpr{Value: blob, P: tok.Pos}, nil
+	case TokenOperator:
+		if tok.Text == "(" {
+			p.pos++
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	case TokenQuotedIdent, TokenIdent:
+		switch {
+		case tok.isKeyword("NULL"):
+			p.pos++
+			return &LiteralExpr{Value: nil, P: tok.Pos}, nil
+		case tok.isKeyword("CASE"):
+			return p.parseCase()
+		case tok.isKeyword("CAST") && p.peekAt(1).Text == "(":
+			return p.parseCast()
+		case tok.Kind == TokenIdent && reservedWords[strings.ToUpper(tok.Text)]:
+			return nil, p.errorAt(tok)
+		}
+		p.pos++
+		if tok.Kind == TokenIdent && p.peek().Text == "(" && p.peek().Kind == TokenOperator {
+			return p.parseFunction(tok)
+		}
+		if p.peek().Text == "." && p.peek().Kind == TokenOperator {
+			p.pos++
+			name, _, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			return &ColumnExpr{Table: tok.Text, Name: name, P: tok.Pos}, nil
+		}
+		if tok.Kind == TokenIdent && (strings.EqualFold(tok.Text, "TRUE") || strings.EqualFold(tok.Text, "FALSE")) {
+			return &LiteralExpr{Value: map[bool]int64{true: 1, false: 0}[strings.EqualFold(tok.Text, "TRUE")], P: tok.Pos}, nil
+		}
+		return &ColumnExpr{Name: tok.Text, P: tok.Pos}, nil
+	}
+	return nil, p.errorAt(tok)
+}
+
+func (p *parser) parseFunction(name Token) (Expr, error) {
+	p.pos++ //!"("
+	fn := &FuncExpr{Name: strings.ToLower(name.Text), P: name.Pos}
+	if p.acceptOp(")") {
+		return fn, nil
+	}
+	if p.acceptOp("*") {
+		fn.Star = true
+		return fn, p.expectOp(")")
+	}
+	fn.Distinct = p.acceptKeyword("DISTINCT")
+	args, err := p.parseExprList()
+	if err != nil {
+		return nil, err
+	}
+	fn.Args = args
+	return fn, p.expectOp(")")
+}
+
+func (p *parser) parseCase() (Expr, error) {
+	tok := p.next()
+	expr := &CaseExpr{P: tok.Pos}
+	var err error
+	if !p.peek().isKeyword("WHEN") {
+		if expr.Operand, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.acceptKeyword("WHEN") {
+		var clause WhenClause
+		if clause.When, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		if clause.Then, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		expr.Whens = append(expr.Whens, clause)
+	}
+	if len(expr.Whens) == 0 {
+		return nil, p.errorAt(p.peek())
+	}
+	if p.acceptKeyword("ELSE") {
+		if expr.Else, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return expr, p.expectKeyword("END")
+}
+
+func (p *parser) parseCast() (Expr, error) {
+	tok := p.next()
+	p.pos++ //!"("
+	operand, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	typeName, err := p.parseTypeName()
+	if err != nil {
+		return nil, err
+	}
+	return &CastExpr{Operand: operand, Type: typeName, P: tok.Pos}, p.expectOp(")")
+}
+
+// parseTypeName reads a declared type such as "VARCHAR(10)" or "UNSIGNED BIG INT".
+func (p *parser) parseTypeName() (string, error) {
+	var words []string
+	for {
+		tok := p.peek()
+		if tok.Kind != TokenIdent && tok.Kind != TokenQuotedIdent {
+			break
+		}
+		if tok.Kind == TokenIdent && reservedWords[strings.ToUpper(tok.Text)] {
+			break
+		}
+		if tok.isKeyword("GENERATED") && p.peekAt(1).isKeyword("ALWAYS") {
+			break
+		}
+		words = append(words, tok.Text)
+		p.pos++
+	}
+	if len(words) == 0 {
+		return "", p.errorAt(p.peek())
+	}
+	typeName := strings.Join(words, " ")
+	if p.peek().Text == "(" && p.peek().Kind == TokenOperator {
+		start := p.peek().Pos
+		for !p.acceptOp(")") {
+			if p.next().Kind == TokenEOF {
+				return "", p.errorAt(p.peek())
+			}
+		}
+		typeName += p.sql[start:p.lastEnd()]
+	}
+	return typeName, nil
+}
+
+// conflictActions are the resolutions allowed in ON CONFLICT and INSERT OR clauses.
+var conflictActions = map[string]bool{"ROLLBACK": true, "ABORT": true, "FAIL": true, "IGNORE": true, "REPLACE": true}
+
+func (p *parser) parseConflictAction() (string, error) {
+	tok := p.next()
+	action := strings.ToUpper(tok.Text)
+	if tok.Kind != TokenIdent || !conflictActions[action] {
+		return "", p.errorAt(tok)
+	}
+	return action, nil
+}
+
+// parseTableName reads a table name, dropping a "main." schema prefix.
+func (p *parser) parseTableName() (string, Token, error) {
+	name, tok, err := p.parseName()
+	if err != nil {
+		return "", tok, err
+	}
+	if p.acceptOp(".") {
+		return p.parseName()
+	}
+	return name, tok, nil
+}
+
+// parseNameList reads a parenthesized list of names and returns them with their offsets.
+func (p *parser) parseNameList() ([]string, []int, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	var positions []int
+	for {
+		name, tok, err := p.parseName()
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		positions = append(positions, tok.Pos)
+		if !p.acceptOp(",") {
+			return names, positions, p.expectOp(")")
+		}
+	}
+}
+
+func (p *parser) parseInsert() (*InsertStmt, error) {
+	stmt := &InsertStmt{}
+	if p.next().isKeyword("REPLACE") {
+		stmt.OrAction = "REPLACE"
+	} else if p.acceptKeyword("OR") {
+		action, err := p.parseConflictAction()
+		if err != nil {
+			return nil, err
+		}
+		stmt.OrAction = action
+	}
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	name, tok, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table, stmt.TableP = name, tok.Pos
+	if p.peek().Text == "(" && p.peek().Kind == TokenOperator {
+		if stmt.Columns, _, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.acceptKeyword("DEFAULT"):
+		if err := p.expectKeyword("VALUES"); err != nil {
+			return nil, err
+		}
+		stmt.Returning, err = p.parseReturning()
+		return stmt, err
+	case p.peek().isKeyword("SELECT"):
+		if stmt.Select, err = p.parseSelect(); err != nil {
+			return nil, err
+		}
+		//!Like SQLite, ON right after a FROM clause starts a join constraint, not an upsert.
+		if s := stmt.Select; s.From != nil && s.Where == nil && s.OrderBy == nil && s.Limit == nil && p.acceptKeyword("ON") {
+			if _, err := p.parseExpr(); err != nil {
+				return nil, err
+			}
+			return nil, p.errorAt(p.peek())
+		}
+	default:
+		if err := p.expectKeyword("VALUES"); err != nil {
+			return nil, err
+		}
+		for {
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			row, err := p.parseExprList()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			stmt.Values = append(stmt.Values, row)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	for p.peek().isKeyword("ON") {
+		//!A clause without a target catches every conflict, so none may follow it.
+		if n := len(stmt.Upserts); n > 0 && stmt.Upserts[n-1].Target == nil {
+			return nil, p.errorAt(p.peek())
+		}
+		upsert, err := p.parseUpsert()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Upserts = append(stmt.Upserts, upsert)
+	}
+	stmt.Returning, err = p.parseReturning()
+	return stmt, err
+}
+
+func (p *parser) parseUpsert() (Upsert, error) {
+	var upsert Upsert
+	p.pos++ //!ON
+	if err := p.expectKeyword("CONFLICT"); err != nil {
+		return upsert, err
+	}
+	var err error
+	if p.peek().Text == "(" && p.peek().Kind == TokenOperator {
+		if upsert.Target, err = p.parseIndexedColumns(); err != nil {
+			return upsert, err
+		}
+		if p.acceptKeyword("WHERE") {
+			if upsert.TargetWhere, err = p.parseExpr(); err != nil {
+				return upsert, err
+			}
+		}
+	}
+	if err := p.expectKeyword("DO"); err != nil {
+		return upsert, err
+	}
+	if p.acceptKeyword("NOTHING") {
+		return upsert, nil
+	}
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return upsert, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return upsert, err
+	}
+	if upsert.Set, err = p.parseAssignments(); err != nil {
+		return upsert, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if upsert.Where, err = p.parseExpr(); err != nil {
+			return upsert, err
+		}
+	}
+	return upsert, nil
+}
+
+// parseReturning reads an optional "RETURNING column, ..." clause.
+func (p *parser) parseReturning() ([]ResultColumn, error) {
+	if !p.acceptKeyword("RETURNING") {
+		return nil, nil
+	}
+	var columns []ResultColumn
+	for {
+		column, err := p.parseResultColumn()
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+		if !p.acceptOp(",") {
+			return columns, nil
+		}
+	}
+}
+
+func (p *parser) parseUpdate() (*UpdateStmt, error) {
+	p.pos++ //!UPDATE
+	stmt := &UpdateStmt{}
+	if p.acceptKeyword("OR") {
+		action, err := p.parseConflictAction()
+		if err != nil {
+			return nil, err
+		}
+		stmt.OrAction = action
+	}
+	name, tok, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table, stmt.TableP = name, tok.Pos
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	if stmt.Set, err = p.parseAssignments(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	stmt.Returning, err = p.parseReturning()
+	return stmt, err
+}
+
+// parseAssignments reads the "column = expr, ..." list of an UPDATE or upsert.
+func (p *parser) parseAssignments() ([]Assignment, error) {
+	var set []Assignment
+	for {
+		assignment := Assignment{}
+		column, tok, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		assignment.Column, assignment.ColumnP = column, tok.Pos
+		if err := p.expectOp("="); err != nil {
+			return nil, err
+		}
+		if assignment.Expr, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		set = append(set, assignment)
+		if !p.acceptOp(",") {
+			return set, nil
+		}
+	}
+}
+
+func (p *parser) parseDelete() (*DeleteStmt, error) {
+	p.pos++ //!DELETE
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	name, tok, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &DeleteStmt{Table: name, TableP: tok.Pos}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	stmt.Returning, err = p.parseReturning()
+	return stmt, err
+}
+
+func (p *parser) parseTransaction() (*TransactionStmt, error) {
+	stmt := &TransactionStmt{Kind: strings.ToUpper(p.next().Text)}
+	if stmt.Kind == "END" {
+		stmt.Kind = "COMMIT"
+	}
+	if stmt.Kind == "BEGIN" {
+		for _, mode := range []string{"DEFERRED", "IMMEDIATE", "EXCLUSIVE"} {
+			if p.acceptKeyword(mode) {
+				stmt.Mode = mode
+				break
+			}
+		}
+	}
+	p.acceptKeyword("TRANSACTION")
+	return stmt, nil
+}
+
+func (p *parser) parseCreate() (Statement, error) {
+	p.pos++ //!CREATE
+	unique := p.acceptKeyword("UNIQUE")
+	switch {
+	case p.acceptKeyword("INDEX"):
+		stmt, err := p.parseCreateIndex(unique)
+		if err != nil {
+			return nil, err
+		}
+		prefix := "CREATE INDEX "
+		if unique {
+			prefix = "CREATE UNIQUE INDEX "
+		}
+		stmt.SQL = prefix + p.sql[stmt.NamePos:p.lastEnd()]
+		return stmt, nil
+	case !unique && p.acceptKeyword("TABLE"):
+		stmt, err := p.parseCreateTable()
+		if err != nil {
+			return nil, err
+		}
+		stmt.SQL = "CREATE TABLE " + p.sql[stmt.NamePos:p.lastEnd()]
+		return stmt, nil
+	}
+	return nil, p.errorAt(p.peek())
+}
+
+func (p *parser) parseDrop() (*DropStmt, error) {
+	p.pos++ //!DROP
+	stmt := &DropStmt{}
+	switch tok := p.next(); {
+	case tok.isKeyword("TABLE") || tok.isKeyword("INDEX"):
+		stmt.Kind = strings.ToUpper(tok.Text)
+	default:
+		return nil, p.errorAt(tok)
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfExists = true
+	}
+	var err error
+	stmt.Name, _, err = p.parseTableName()
+	return stmt, err
+}
+
+func (p *parser) parseAlter() (*AlterTableStmt, error) {
+	p.pos++ //!ALTER
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	stmt := &AlterTableStmt{}
+	var err error
+	if stmt.Table, _, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	switch tok := p.next(); {
+	case tok.isKeyword("RENAME"):
+		if !p.acceptKeyword("TO") {
+			p.acceptKeyword("COLUMN")
+			stmt.Action = "RENAME COLUMN"
+			stmt.ColumnPos = p.peek().Pos
+			if stmt.Column, _, err = p.parseName(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("TO"); err != nil {
+				return nil, err
+			}
+		} else {
+			stmt.Action = "RENAME"
+		}
+		stmt.NewQuoted = p.peek().Kind == TokenQuotedIdent || p.peek().Kind == TokenString
+		stmt.NewName, _, err = p.parseName()
+	case tok.isKeyword("ADD"):
+		stmt.Action = "ADD COLUMN"
+		p.acceptKeyword("COLUMN")
+		start := p.peek().Pos
+		var column ColumnDef
+		if column, err = p.parseColumnDef(); err != nil {
+			return nil, err
+		}
+		stmt.Def, stmt.DefSQL = &column, p.sql[start:p.lastEnd()]
+	case tok.isKeyword("DROP"):
+		stmt.Action = "DROP COLUMN"
+		p.acceptKeyword("COLUMN")
+		stmt.ColumnPos = p.peek().Pos
+		stmt.Column, _, err = p.parseName()
+	default:
+		return nil, p.errorAt(tok)
+	}
+	return stmt, err
+}
+
+func (p *parser) parseIfNotExists() (bool, error) {
+	if !p.acceptKeyword("IF") {
+		return false, nil
+	}
+	if err := p.expectKeyword("NOT"); err != nil {
+		return false, err
+	}
+	return true, p.expectKeyword("EXISTS")
+}
+
+func (p *parser) parseCreateTable() (*CreateTableStmt, error) {
+	stmt := &CreateTableStmt{}
+	var err error
+	if stmt.IfNotExists, err = p.parseIfNotExists(); err != nil {
+		return nil, err
+	}
+	var name Token
+	if stmt.Name, name, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	stmt.NamePos = name.Pos
+	if p.acceptKeyword("AS") {
+		stmt.Select, err = p.parseSelect()
+		return stmt, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		if tok := p.peek(); tok.Kind == TokenIdent && tableConstraintWords[strings.ToUpper(tok.Text)] {
+			break
+		}
+		column, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, column)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	stmt.ColumnsEnd = p.peek().Pos
+	if tok := p.peek(); tok.Kind != TokenOperator || tok.Text != ")" {
+		stmt.ColumnsEnd = p.tokens[p.pos-1].Pos
+	}
+	for len(stmt.Columns) > 0 && !p.acceptOp(")") {
+		constraint, err := p.parseTableConstraint()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Constraints = append(stmt.Constraints, constraint)
+		//!The comma between table constraints is optional.
+		p.acceptOp(",")
+	}
+	if len(stmt.Columns) == 0 {
+		return nil, p.errorAt(p.peek())
+	}
+	for {
+		switch {
+		case p.acceptKeyword("WITHOUT"):
+			if tok := p.next(); !tok.isKeyword("ROWID") {
+				return nil, p.errorAt(tok)
+			}
+			stmt.WithoutRowid = true
+		case p.acceptKeyword("STRICT"):
+			stmt.Strict = true
+		default:
+			return stmt, nil
+		}
+		if !p.acceptOp(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) parseColumnDef() (ColumnDef, error) {
+	column := ColumnDef{OnConflict: map[string]string{}, Pos: p.peek().Pos}
+	var err error
+	if column.Name, _, err = p.parseName(); err != nil {
+		return column, err
+	}
+	if tok := p.peek(); tok.Kind == TokenQuotedIdent || (tok.Kind == TokenIdent && !reservedWords[strings.ToUpper(tok.Text)] &&
+		!(tok.isKeyword("GENERATED") && p.peekAt(1).isKeyword("ALWAYS"))) {
+		if column.Type, err = p.parseTypeName(); err != nil {
+			return column, err
+		}
+	}
+	//!A constraint name applies to the constraints after it, up to the next name.
+	var name string
+	for {
+		if p.acceptKeyword("CONSTRAINT") {
+			if name, _, err = p.parseName(); err != nil {
+				return column, err
+			}
+		}
+		tok := p.peek()
+		switch {
+		case p.acceptKeyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return column, err
+			}
+			column.PrimaryKey = true
+			if p.acceptKeyword("DESC") {
+				column.PrimaryDesc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			if err := p.parseOnConflict(column.OnConflict, "PRIMARY KEY"); err != nil {
+				return column, err
+			}
+			column.Autoincrement = p.acceptKeyword("AUTOINCREMENT")
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return column, err
+			}
+			column.NotNull = true
+			if err := p.parseOnConflict(column.OnConflict, "NOT NULL"); err != nil {
+				return column, err
+			}
+		case p.acceptKeyword("NULL"):
+			if err := p.parseOnConflict(column.OnConflict, "NULL"); err != nil {
+				return column, err
+			}
+		case p.acceptKeyword("UNIQUE"):
+			column.Unique = true
+			if err := p.parseOnConflict(column.OnConflict, "UNIQUE"); err != nil {
+				return column, err
+			}
+		case p.acceptKeyword("CHECK"):
+			check, err := p.parseCheck(name)
+			if err != nil {
+				return column, err
+			}
+			column.Checks = append(column.Checks, check)
+		case p.acceptKeyword("DEFAULT"):
+			if column.Default, err = p.parseDefault(); err != nil {
+				return column, err
+			}
+		case p.acceptKeyword("COLLATE"):
+			collation, _, err := p.parseName()
+			if err != nil {
+				return column, err
+			}
+			column.Collate = strings.ToUpper(collation)
+		case p.acceptKeyword("REFERENCES"):
+			if column.References, err = p.parseReferences(); err != nil {
+				return column, err
+			}
+			column.References.Columns, column.References.ColumnPos = []string{column.Name}, []int{column.Pos}
+		case tok.isKeyword("GENERATED") || tok.isKeyword("AS"):
+			if p.acceptKeyword("GENERATED") {
+				if err := p.expectKeyword("ALWAYS"); err != nil {
+					return column, err
+				}
+			}
+			if err := p.expectKeyword("AS"); err != nil {
+				return column, err
+			}
+			if column.Generated, err = p.parseParenExpr(); err != nil {
+				return column, err
+			}
+			if column.Stored = p.acceptKeyword("STORED"); !column.Stored {
+				p.acceptKeyword("VIRTUAL")
+			}
+		default:
+			return column, nil
+		}
+	}
+}
+
+// parseOnConflict reads an optional "ON CONFLICT action" after the named constraint.
+func (p *parser) parseOnConflict(actions map[string]string, constraint string) error {
+	if !p.acceptKeyword("ON") {
+		return nil
+	}
+	if err := p.expectKeyword("CONFLICT"); err != nil {
+		return err
+	}
+	action, err := p.parseConflictAction()
+	if err != nil {
+		return err
+	}
+	actions[constraint] = action
+	return nil
+}
+
+// parseCheck reads the parenthesized expression of a CHECK constraint with the given name.
+// Unnamed, it is known by the expression text between the parentheses.
+func (p *parser) parseCheck(name string) (Check, error) {
+	start := p.peek().Pos
+	expr, err := p.parseParenExpr()
+	if err != nil {
+		return Check{}, err
+	}
+	if name == "" {
+		name = strings.TrimSpace(p.sql[start+1 : p.lastEnd()-1])
+	}
+	return Check{Name: name, Expr: expr}, nil
+}
+
+func (p *parser) parseParenExpr() (Expr, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return expr, p.expectOp(")")
+}
+
+// parseDefault reads a DEFAULT value: a literal, a signed number, a parenthesized expression
+// or a bare word such as CURRENT_TIMESTAMP.
+func (p *parser) parseDefault() (Expr, error) {
+	tok := p.peek()
+	switch {
+	case tok.Kind == TokenOperator && tok.Text == "(":
+		return p.parseParenExpr()
+	case tok.Kind == TokenOperator && (tok.Text == "-" || tok.Text == "+"):
+		return p.parseUnary()
+	case tok.Kind == TokenIdent && !tok.isKeyword("NULL") && !reservedWords[strings.ToUpper(tok.Text)]:
+		p.pos++
+		if strings.EqualFold(tok.Text, "TRUE") || strings.EqualFold(tok.Text, "FALSE") {
+			return &LiteralExpr{Value: boolValue(strings.EqualFold(tok.Text, "TRUE")), P: tok.Pos}, nil
+		}
+		return &ColumnExpr{Name: strings.ToUpper(tok.Text), P: tok.Pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parseReferences() (*ForeignKey, error) {
+	fk := &ForeignKey{TablePos: p.peek().Pos}
+	var err error
+	if fk.Table, _, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if p.peek().Text == "(" && p.peek().Kind == TokenOperator {
+		if fk.ParentColumns, fk.ParentPos, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		switch {
+		case p.acceptKeyword("ON"):
+			event := p.next()
+			if !event.isKeyword("DELETE") && !event.isKeyword("UPDATE") {
+				return nil, p.errorAt(event)
+			}
+			var action string
+			switch tok := p.next(); {
+			case tok.isKeyword("CASCADE") || tok.isKeyword("RESTRICT"):
+				action = strings.ToUpper(tok.Text)
+			case tok.isKeyword("SET"):
+				value := p.next()
+				if !value.isKeyword("NULL") && !value.isKeyword("DEFAULT") {
+					return nil, p.errorAt(value)
+				}
+				action = "SET " + strings.ToUpper(value.Text)
+			case tok.isKeyword("NO"):
+				if value := p.next(); !value.isKeyword("ACTION") {
+					return nil, p.errorAt(value)
+				}
+			default:
+				return nil, p.errorAt(tok)
+			}
+			if event.isKeyword("DELETE") {
+				fk.OnDelete = action
+			} else {
+				fk.OnUpdate = action
+			}
+		case p.acceptKeyword("MATCH"):
+			if _, _, err := p.parseName(); err != nil {
+				return nil, err
+			}
+		case p.peek().isKeyword("DEFERRABLE") || (p.peek().isKeyword("NOT") && p.peekAt(1).isKeyword("DEFERRABLE")):
+			notDeferrable := p.acceptKeyword("NOT")
+			p.pos++ //!DEFERRABLE
+			deferred := false
+			if p.acceptKeyword("INITIALLY") {
+				tok := p.next()
+				if !tok.isKeyword("DEFERRED") && !tok.isKeyword("IMMEDIATE") {
+					return nil, p.errorAt(tok)
+				}
+				deferred = tok.isKeyword("DEFERRED")
+			}
+			fk.Deferred = deferred && !notDeferrable
+		default:
+			return fk, nil
+		}
+	}
+}
+
+func (p *parser) parseIndexedColumns() ([]IndexedColumn, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var columns []IndexedColumn
+	for {
+		column := IndexedColumn{Pos: p.peek().Pos}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		//!The COLLATE clause is parsed as part of the expression.
+		if collate, ok := expr.(*CollateExpr); ok {
+			column.Collate = collate.Collation
+			expr = collate.Operand
+		}
+		if name, ok := expr.(*ColumnExpr); ok && name.Table == "" {
+			column.Name = name.Name
+		} else if literal, ok := expr.(*LiteralExpr); ok && literal.Value != nil {
+			//!A quoted name in single quotes is still taken as a column name.
+			if s, isText := literal.Value.(string); isText {
+				column.Name = s
+			} else {
+				column.Expr = expr
+			}
+		} else {
+			column.Expr = expr
+		}
+		if p.acceptKeyword("DESC") {
+			column.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		columns = append(columns, column)
+		if !p.acceptOp(",") {
+			return columns, p.expectOp(")")
+		}
+	}
+}
+
+func (p *parser) parseTableConstraint() (TableConstraint, error) {
+	var constraint TableConstraint
+	var err error
+	if p.acceptKeyword("CONSTRAINT") {
+		if constraint.Name, _, err = p.parseName(); err != nil {
+			return constraint, err
+		}
+	}
+	switch tok := p.next(); {
+	case tok.isKeyword("PRIMARY") || tok.isKeyword("UNIQUE"):
+		constraint.Kind = "UNIQUE"
+		if tok.isKeyword("PRIMARY") {
+			if err := p.expectKeyword("KEY"); err != nil {
+				return constraint, err
+			}
+			constraint.Kind = "PRIMARY KEY"
+		}
+		if constraint.Columns, err = p.parseIndexedColumns(); err != nil {
+			return constraint, err
+		}
+		actions := map[string]string{}
+		if err := p.parseOnConflict(actions, constraint.Kind); err != nil {
+			return constraint, err
+		}
+		constraint.OnConflict = actions[constraint.Kind]
+	case tok.isKeyword("CHECK"):
+		constraint.Kind = "CHECK"
+		constraint.Check, err = p.parseCheck(constraint.Name)
+	case tok.isKeyword("FOREIGN"):
+		constraint.Kind = "FOREIGN KEY"
+		if err := p.expectKeyword("KEY"); err != nil {
+			return constraint, err
+		}
+		columns, positions, err := p.parseNameList()
+		if err != nil {
+			return constraint, err
+		}
+		if err := p.expectKeyword("REFERENCES"); err != nil {
+			return constraint, err
+		}
+		if constraint.ForeignKey, err = p.parseReferences(); err != nil {
+			return constraint, err
+		}
+		constraint.ForeignKey.Columns, constraint.ForeignKey.ColumnPos = columns, positions
+	default:
+		return constraint, p.errorAt(tok)
+	}
+	return constraint, err
+}
+
+func (p *parser) parseCreateIndex(unique bool) (*CreateIndexStmt, error) {
+	stmt := &CreateIndexStmt{Unique: unique}
+	var err error
+	if stmt.IfNotExists, err = p.parseIfNotExists(); err != nil {
+		return nil, err
+	}
+	var name Token
+	if stmt.Name, name, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	stmt.NamePos = name.Pos
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	stmt.TablePos = p.peek().Pos
+	if stmt.Table, _, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if stmt.Columns, err = p.parseIndexedColumns(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
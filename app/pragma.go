@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// executePragma runs a PRAGMA. Like SQLite, unknown pragmas are ignored and return nothing.
+func executePragma(pager *Pager, schema *Schema, stmt *PragmaStmt) ([]string, [][]interface{}, error) {
+	var value interface{}
+	if stmt.Value != nil {
+		var err error
+		if value, err = evalExpr(stmt.Value, &evalContext{encoding: pager.encoding}); err != nil {
+			return nil, nil, err
+		}
+	}
+	switch stmt.Name {
+	case "cache_size":
+		if stmt.Value == nil {
+			return []string{"cache_size"}, [][]interface{}{{pager.cacheSize}}, nil
+		}
+		n, _ := toInteger(value).(int64)
+		pager.setCacheSize(n)
+	case "mmap_size":
+		if stmt.Value != nil {
+			n, _ := toInteger(value).(int64)
+			if err := pager.setMmapSize(n); err != nil {
+				return nil, nil, err
+			}
+		}
+		return []string{"mmap_size"}, [][]interface{}{{pager.mmapSize}}, nil
+	case "encoding":
+		if stmt.Value == nil {
+			return []string{"encoding"}, [][]interface{}{{pager.encoding.String()}}, nil
+		}
+		name, _ := toText(value).(string)
+		encoding, ok := parseEncoding(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported encoding: %s", name)
+		}
+		//!The encoding can only be chosen before the database is created.
+		if pager.empty && pager.dirty == nil {
+			pager.setLayout(pager.pageSize, pager.usableSize, encoding)
+		}
+	case "user_version", "application_id", "schema_version", "freelist_count", "page_count":
+		//!Header fields can only be read for now.
+		if stmt.Value != nil {
+			break
+		}
+		var v int64
+		switch stmt.Name {
+		case "user_version":
+			v = int64(pager.header.UserVersion)
+		case "application_id":
+			v = int64(pager.header.ApplicationID)
+		case "schema_version":
+			v = int64(pager.header.SchemaCookie)
+		case "freelist_count":
+			v = int64(pager.header.FreelistCount)
+		case "page_count":
+			count, err := pager.pageCount()
+			if err != nil {
+				return nil, nil, err
+			}
+			v = count
+		}
+		return []string{stmt.Name}, [][]interface{}{{v}}, nil
+	case "journal_mode":
+		if stmt.Value == nil {
+			return []string{"journal_mode"}, [][]interface{}{{pager.journalMode()}}, nil
+		}
+		name, _ := toText(value).(string)
+		mode, err := pager.setJournalMode(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"journal_mode"}, [][]interface{}{{mode}}, nil
+	case "wal_checkpoint":
+		mode := checkpointPassive
+		if name, ok := toText(value).(string); ok {
+			switch upper := strings.ToUpper(name); upper {
+			case checkpointFull, checkpointRestart, checkpointTruncate:
+				mode = upper
+			}
+		}
+		busy, log, checkpointed, err := pager.checkpoint(mode)
+		if err != nil {
+			return nil, nil, err
+		}
+		flag := int64(0)
+		if busy {
+			flag = 1
+		}
+		return []string{"busy", "log", "checkpointed"}, [][]interface{}{{flag, log, checkpointed}}, nil
+	case "wal_autocheckpoint":
+		if stmt.Value != nil {
+			n, _ := toInteger(value).(int64)
+			pager.checkpointAt = max(n, 0)
+		}
+		return []string{"wal_autocheckpoint"}, [][]interface{}{{pager.checkpointAt}}, nil
+	case "busy_timeout":
+		if stmt.Value != nil {
+			n, _ := toInteger(value).(int64)
+			pager.busyTimeout = max(n, 0)
+		}
+		return []string{"timeout"}, [][]interface{}{{pager.busyTimeout}}, nil
+	case "foreign_keys":
+		if stmt.Value == nil {
+			enabled := int64(0)
+			if pager.foreignKeys {
+				enabled = 1
+			}
+			return []string{"foreign_keys"}, [][]interface{}{{enabled}}, nil
+		}
+		//!Like SQLite, enforcement cannot be switched inside a transaction.
+		if !pager.explicit {
+			pager.foreignKeys = pragmaBool(value)
+		}
+	case "foreign_key_list":
+		name, _ := toText(value).(string)
+		table := schema.table(name)
+		if stmt.Value == nil || table == nil {
+			break
+		}
+		var rows [][]interface{}
+		//!SQLite numbers the foreign keys from the last one declared.
+		for id := int64(0); id < int64(len(table.ForeignKeys)); id++ {
+			fk := table.ForeignKeys[len(table.ForeignKeys)-1-int(id)]
+			for seq, column := range fk.Columns {
+				var to interface{}
+				if seq < len(fk.ParentColumns) {
+					to = fk.ParentColumns[seq]
+				}
+				rows = append(rows, []interface{}{id, int64(seq), fk.Table, column, to, foreignKeyAction(fk.OnUpdate), foreignKeyAction(fk.OnDelete), "NONE"})
+			}
+		}
+		return []string{"id", "seq", "table", "from", "to", "on_update", "on_delete", "match"}, rows, nil
+	case "foreign_key_check":
+		name, _ := toText(value).(string)
+		rows, err := foreignKeyCheck(pager, schema, stmt.Value, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"table", "rowid", "parent", "fkid"}, rows, nil
+	case "page_size":
+		if stmt.Value == nil {
+			return []string{"page_size"}, [][]interface{}{{pager.pageSize}}, nil
+		}
+		//!Like the encoding, the page size is chosen before the database is created. Changing it
+		//!later needs a VACUUM, which is not supported, so the setting is then ignored.
+		n, _ := toInteger(value).(int64)
+		if pager.empty && pager.dirty == nil && n >= 512 && n <= 65536 && n&(n-1) == 0 {
+			pager.setLayout(n, n, pager.encoding)
+		}
+	}
+	return nil, nil, nil
+}
+
+// pragmaBool reads the value of a boolean pragma: a number, or one of the words SQLite
+// accepts for on and off.
+func pragmaBool(value interface{}) bool {
+	if text, ok := value.(string); ok {
+		switch strings.ToLower(text) {
+		case "on", "yes", "true":
+			return true
+		case "off", "no", "false":
+			return false
+		}
+	}
+	n, _ := toInteger(value).(int64)
+	return n != 0
+}
+
+// foreignKeyAction names an ON DELETE or ON UPDATE action the way foreign_key_list shows it.
+func foreignKeyAction(action string) string {
+	if action == "" {
+		return "NO ACTION"
+	}
+	return action
+}
+
+// foreignKeyCheck lists the rows violating a foreign key, of the named table when arg is
+// given and otherwise of every table. Like SQLite, tables are visited from the last created
+// and their foreign keys from the last declared, each in rowid order.
+func foreignKeyCheck(pager *Pager, schema *Schema, arg Expr, name string) ([][]interface{}, error) {
+	var tables []*Table
+	if arg != nil {
+		table := schema.table(name)
+		if table == nil {
+			return nil, &ErrNoSuchTable{Name: name}
+		}
+		tables = append(tables, table)
+	} else {
+		for i := len(schema.Objects) - 1; i >= 0; i-- {
+			if table := schema.table(schema.Objects[i].Name); schema.Objects[i].Type == "table" && table != nil {
+				tables = append(tables, table)
+			}
+		}
+	}
+	//!Every parent key is resolved first, so that a mismatch fails before any row is listed.
+	keys := map[*ForeignKey]*parentKey{}
+	for _, table := range tables {
+		for _, fk := range table.ForeignKeys {
+			key, err := resolveParent(schema, table, fk)
+			if err != nil {
+				return nil, err
+			}
+			keys[fk] = key
+		}
+	}
+	var rows [][]interface{}
+	for _, table := range tables {
+		if len(table.ForeignKeys) == 0 {
+			continue
+		}
+		rowids, records, err := readTable(pager, table.RootPage, map[int64]int64{})
+		if err != nil {
+			return nil, err
+		}
+		for id := range table.ForeignKeys {
+			fk := table.ForeignKeys[len(table.ForeignKeys)-1-id]
+			for i, record := range records {
+				values, ok := childValues(table, fk, tableRow(table, rowids[i], record))
+				if !ok {
+					continue
+				}
+				found := false
+				if key := keys[fk]; key != nil {
+					if found, err = key.exists(pager, schema, values); err != nil {
+						return nil, err
+					}
+				}
+				if !found {
+					rows = append(rows, []interface{}{table.Name, rowids[i], fk.Table, int64(id)})
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// parseEncoding reads the name of a text encoding the way PRAGMA encoding accepts it. UTF-16
+// alone means the byte order of the machine, little-endian on those Go runs on here.
+func parseEncoding(name string) (TextEncoding, bool) {
+	switch strings.ToUpper(name) {
+	case "UTF-8", "UTF8":
+		return EncodingUTF8, true
+	case "UTF-16", "UTF16", "UTF-16LE", "UTF16LE":
+		return EncodingUTF16LE, true
+	case "UTF-16BE", "UTF16BE":
+		return EncodingUTF16BE, true
+	}
+	return 0, false
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ValueType is the storage class of a value held in a record.
+type ValueType int
+
+const (
+	ValueNull ValueType = iota
+	ValueInteger
+	ValueReal
+	ValueText
+	ValueBlob
+)
+
+// Value is one column of a record. Only the field matching Type is meaningful.
+type Value struct {
+	Type ValueType
+	Int  int64
+	Real float64
+	Text string
+	Blob []byte
+}
+
+// Interface returns the value in the form the expression evaluator works with: nil, int64,
+// float64, string or []byte.
+func (v Value) Interface() interface{} {
+	switch v.Type {
+	case ValueInteger:
+		return v.Int
+	case ValueReal:
+		return v.Real
+	case ValueText:
+		return v.Text
+	case ValueBlob:
+		return v.Blob
+	}
+	return nil
+}
+
+// valueOf is the inverse of Interface.
+func valueOf(x interface{}) Value {
+	switch x := x.(type) {
+	case int64:
+		return Value{Type: ValueInteger, Int: x}
+	case float64:
+		return Value{Type: ValueReal, Real: x}
+	case string:
+		return Value{Type: ValueText, Text: x}
+	case []byte:
+		return Value{Type: ValueBlob, Blob: x}
+	}
+	return Value{}
+}
+
+// valuesInterface converts a decoded record for the evaluator.
+func valuesInterface(values []Value) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v.Interface()
+	}
+	return out
+}
+
+// integerSizes is the number of content bytes of serial types 1 to 6.
+var integerSizes = [...]int64{1: 1, 2: 2, 3: 3, 4: 4, 5: 6, 6: 8}
+
+// serialTypeSize is the number of content bytes used by a serial type, -1 for the reserved
+// types 10 and 11.
+func serialTypeSize(serialType int64) int64 {
+	switch {
+	case serialType >= 1 && serialType <= 6:
+		return integerSizes[serialType]
+	case serialType == 0 || serialType == 8 || serialType == 9:
+		return 0
+	case serialType == 7:
+		return 8
+	case serialType >= 12:
+		return (serialType - 12) / 2
+	}
+	return -1
+}
+
+// decodeValue decodes the content of one column, which starts raw.
+func decodeValue(serialType int64, raw []byte, encoding TextEncoding) (Value, int64, error) {
+	size := serialTypeSize(serialType)
+	if size < 0 {
+		return Value{}, 0, fmt.Errorf("invalid serial type %d", serialType)
+	}
+	if int64(len(raw)) < size {
+		return Value{}, 0, fmt.Errorf("serial type %d needs %d bytes, %d left in record", serialType, size, len(raw))
+	}
+	switch {
+	case serialType == 0:
+		return Value{}, 0, nil
+	case serialType <= 6:
+		//!Big-endian two's complement: sign-extend the first byte, then shift in the rest.
+		n := int64(int8(raw[0]))
+		for _, b := range raw[1:size] {
+			n = n<<8 | int64(b)
+		}
+		return Value{Type: ValueInteger, Int: n}, size, nil
+	case serialType == 7:
+		return Value{Type: ValueReal, Real: math.Float64frombits(binary.BigEndian.Uint64(raw))}, 8, nil
+	case serialType == 8 || serialType == 9:
+		return Value{Type: ValueInteger, Int: serialType - 8}, 0, nil
+	case serialType%2 == 0:
+		return Value{Type: ValueBlob, Blob: raw[:size]}, size, nil
+	}
+	return Value{Type: ValueText, Text: decodeText(raw[:size], encoding)}, size, nil
+}
+
+// DecodeRecord decodes a record: a varint header size, one varint serial type per column, then
+// the column contents. Text is decoded from the database encoding; blobs share raw.
+func DecodeRecord(raw []byte, encoding TextEncoding) ([]Value, error) {
+	headerSize, n := ReadVarint(raw)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(raw)) {
+		return nil, fmt.Errorf("record header size %d out of range", headerSize)
+	}
+	var values []Value
+	content := raw[headerSize:]
+	for offset := uint64(n); offset < headerSize; {
+		serialType, n := ReadVarint(raw[offset:headerSize])
+		if n == 0 {
+			return nil, fmt.Errorf("truncated serial type in record header")
+		}
+		offset += uint64(n)
+		if serialType > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid serial type %d", serialType)
+		}
+		value, size, err := decodeValue(int64(serialType), content, encoding)
+		if err != nil {
+			return nil, err
+		}
+		content = content[size:]
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// encodeValue returns the serial type of v and its content. Integers use the smallest width
+// that holds them, and 0 and 1 the content-less types 8 and 9 when the schema format, which
+// introduced them in format 4, allows it.
+func encodeValue(v Value, encoding TextEncoding, schemaFormat uint32) (int64, []byte) {
+	switch v.Type {
+	case ValueInteger:
+		if (v.Int == 0 || v.Int == 1) && schemaFormat >= 4 {
+			return 8 + v.Int, nil
+		}
+		for serialType := int64(1); serialType <= 6; serialType++ {
+			size := integerSizes[serialType]
+			bits := uint(8*size - 1)
+			if size == 8 || (v.Int >= -1<<bits && v.Int < 1<<bits) {
+				var content [8]byte
+				binary.BigEndian.PutUint64(content[:], uint64(v.Int))
+				return serialType, content[8-size:]
+			}
+		}
+	case ValueReal:
+		content := make([]byte, 8)
+		binary.BigEndian.PutUint64(content, math.Float64bits(v.Real))
+		return 7, content
+	case ValueText:
+		content := encodeText(v.Text, encoding)
+		return int64(len(content))*2 + 13, content
+	case ValueBlob:
+		return int64(len(v.Blob))*2 + 12, v.Blob
+	}
+	return 0, nil
+}
+
+// EncodeRecord is the inverse of DecodeRecord, for a database of the given schema format.
+func EncodeRecord(values []Value, encoding TextEncoding, schemaFormat uint32) []byte {
+	var types, content []byte
+	for _, v := range values {
+		serialType, data := encodeValue(v, encoding, schemaFormat)
+		types = AppendVarint(types, uint64(serialType))
+		content = append(content, data...)
+	}
+	//!The header size counts its own varint, whose length may depend on the size.
+	headerSize := len(types) + 1
+	for headerSize != len(types)+VarintLen(uint64(headerSize)) {
+		headerSize = len(types) + VarintLen(uint64(headerSize))
+	}
+	record := AppendVarint(make([]byte, 0, headerSize+len(content)), uint64(headerSize))
+	record = append(record, types...)
+	return append(record, content...)
+}
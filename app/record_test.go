@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// record builds a record from its serial types and content, the header size varint first.
+func record(types []uint64, content ...byte) []byte {
+	var header []byte
+	for _, serialType := range types {
+		header = AppendVarint(header, serialType)
+	}
+	raw := AppendVarint(nil, uint64(len(header)+1))
+	raw = append(raw, header...)
+	return append(raw, content...)
+}
+
+func TestDecodeRecordSerialTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		enc  TextEncoding
+		want []Value
+	}{
+		{"null", record([]uint64{0}), EncodingUTF8, []Value{{}}},
+		{"int8", record([]uint64{1, 1}, 0x7f, 0x80), EncodingUTF8, []Value{{Type: ValueInteger, Int: 127}, {Type: ValueInteger, Int: -128}}},
+		{"int16", record([]uint64{2}, 0x80, 0x00), EncodingUTF8, []Value{{Type: ValueInteger, Int: math.MinInt16}}},
+		{"int24", record([]uint64{3, 3}, 0xff, 0xff, 0xfe, 0x7f, 0xff, 0xff), EncodingUTF8, []Value{{Type: ValueInteger, Int: -2}, {Type: ValueInteger, Int: 1<<23 - 1}}},
+		{"int32", record([]uint64{4}, 0x80, 0, 0, 0), EncodingUTF8, []Value{{Type: ValueInteger, Int: math.MinInt32}}},
+		{"int48", record([]uint64{5, 5}, 0x80, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), EncodingUTF8, []Value{{Type: ValueInteger, Int: -1 << 47}, {Type: ValueInteger, Int: -1}}},
+		{"int64", record([]uint64{6, 6}, 0x80, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), EncodingUTF8, []Value{{Type: ValueInteger, Int: math.MinInt64}, {Type: ValueInteger, Int: math.MaxInt64}}},
+		{"real", record([]uint64{7}, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0), EncodingUTF8, []Value{{Type: ValueReal, Real: 1.5}}},
+		{"zero and one", record([]uint64{8, 9}), EncodingUTF8, []Value{{Type: ValueInteger, Int: 0}, {Type: ValueInteger, Int: 1}}},
+		{"empty blob and text", record([]uint64{12, 13}), EncodingUTF8, []Value{{Type: ValueBlob, Blob: []byte{}}, {Type: ValueText}}},
+		{"blob", record([]uint64{18}, 1, 2, 3), EncodingUTF8, []Value{{Type: ValueBlob, Blob: []byte{1, 2, 3}}}},
+		{"text", record([]uint64{19, 15}, 'a', 'b', 'c', 'd'), EncodingUTF8, []Value{{Type: ValueText, Text: "abc"}, {Type: ValueText, Text: "d"}}},
+		{"utf-16le", record([]uint64{33}, 'h', 0, 0xe9, 0, 0xe5, 0x65, 0x34, 0xd8, 0x1e, 0xdd), EncodingUTF16LE, []Value{{Type: ValueText, Text: "hé日\U0001D11E"}}},
+		{"utf-16be", record([]uint64{21}, 0, 'h', 0x65, 0xe5), EncodingUTF16BE, []Value{{Type: ValueText, Text: "h日"}}},
+	}
+	for _, test := range tests {
+		got, err := DecodeRecord(test.raw, test.enc)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: DecodeRecord(% x) = %v, want %v", test.name, test.raw, got, test.want)
+		}
+	}
+}
+
+func TestDecodeRecordErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"reserved type 10", record([]uint64{10})},
+		{"reserved type 11", record([]uint64{1, 11}, 5)},
+		{"int past the end", record([]uint64{4}, 0, 0, 0)},
+		{"text past the end", record([]uint64{19}, 'a', 'b')},
+		{"header size past the end", []byte{5, 1}},
+		{"header size inside its own varint", []byte{0}},
+		{"truncated serial type", []byte{2, 0x81}},
+		{"empty", nil},
+	}
+	for _, test := range tests {
+		if values, err := DecodeRecord(test.raw, EncodingUTF8); err == nil {
+			t.Errorf("%s: DecodeRecord(% x) = %v, want an error", test.name, test.raw, values)
+		}
+	}
+}
+
+// TestRecordLongHeader covers headers whose size no longer fits a one-byte varint, which
+// makes the size count one more byte of itself.
+func TestRecordLongHeader(t *testing.T) {
+	for _, columns := range []int{126, 127, 128, 200} {
+		values := make([]Value, columns)
+		values[columns-1] = Value{Type: ValueText, Text: strings.Repeat("x", 100)} //!Serial type 213 takes two bytes.
+		raw := EncodeRecord(values, EncodingUTF8, 4)
+		headerSize, n := ReadVarint(raw)
+		if want := uint64(columns + 1 + n); headerSize != want {
+			t.Errorf("%d columns: header size %d, want %d", columns, headerSize, want)
+		}
+		if columns+2 > 127 && n != 2 {
+			t.Errorf("%d columns: header size takes %d bytes, want 2", columns, n)
+		}
+		got, err := DecodeRecord(raw, EncodingUTF8)
+		if err != nil || !reflect.DeepEqual(got, values) {
+			t.Errorf("%d columns: round trip gave %v, %v", columns, got, err)
+		}
+	}
+}
+
+func TestEncodeRecordSchemaFormat(t *testing.T) {
+	values := []Value{{Type: ValueInteger, Int: 0}, {Type: ValueInteger, Int: 1}, {Type: ValueInteger, Int: 2}}
+	for _, test := range []struct {
+		format uint32
+		want   []byte
+	}{
+		{4, []byte{4, 8, 9, 1, 2}},
+		{1, []byte{4, 1, 1, 1, 0, 1, 2}}, //!Types 8 and 9 did not exist before format 4.
+		{3, []byte{4, 1, 1, 1, 0, 1, 2}},
+	} {
+		if got := EncodeRecord(values, EncodingUTF8, test.format); !bytes.Equal(got, test.want) {
+			t.Errorf("format %d: EncodeRecord = % x, want % x", test.format, got, test.want)
+		}
+	}
+}
+
+func FuzzRecord(f *testing.F) {
+	f.Add(int64(0), 0.0, "", []byte(nil), uint8(1), uint8(4))
+	f.Add(int64(1), -0.5, "héllo", []byte{0}, uint8(2), uint8(4))
+	f.Add(int64(-129), math.Inf(1), "日本語\U0001D11E", []byte{1, 2}, uint8(3), uint8(1))
+	f.Add(int64(math.MinInt64), math.SmallestNonzeroFloat64, strings.Repeat("z", 300), make([]byte, 200), uint8(2), uint8(4))
+	f.Add(int64(1<<47), math.NaN(), "a\x00b", []byte("blob"), uint8(1), uint8(3))
+	f.Fuzz(func(t *testing.T, i int64, r float64, s string, b []byte, encoding uint8, format uint8) {
+		enc := TextEncoding(encoding%3 + 1)
+		if enc != EncodingUTF8 && !utf8.ValidString(s) {
+			s = strings.ToValidUTF8(s, "�") //!UTF-16 cannot hold invalid UTF-8.
+		}
+		if b == nil {
+			b = []byte{}
+		}
+		values := []Value{
+			{},
+			{Type: ValueInteger, Int: i},
+			{Type: ValueReal, Real: r},
+			{Type: ValueText, Text: s},
+			{Type: ValueBlob, Blob: b},
+			{Type: ValueInteger, Int: i & 1},
+		}
+		raw := EncodeRecord(values, enc, uint32(format%5))
+		got, err := DecodeRecord(raw, enc)
+		if err != nil {
+			t.Fatalf("DecodeRecord(% x): %v", raw, err)
+		}
+		if len(got) != len(values) {
+			t.Fatalf("decoded %d values, want %d", len(got), len(values))
+		}
+		for k, want := range values {
+			v := got[k]
+			same := v.Type == want.Type
+			switch want.Type {
+			case ValueInteger:
+				same = same && v.Int == want.Int
+			case ValueReal:
+				same = same && math.Float64bits(v.Real) == math.Float64bits(want.Real)
+			case ValueText:
+				same = same && v.Text == want.Text
+			case ValueBlob:
+				same = same && bytes.Equal(v.Blob, want.Blob)
+			}
+			if !same {
+				t.Fatalf("value %d: encoded %v, decoded %v", k, want, v)
+			}
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+// prepareReturning binds the RETURNING clause of an INSERT, UPDATE or DELETE on table and
+// names its columns like those of a SELECT. It is nil when the statement has no such clause.
+func prepareReturning(table *Table, resultColumns []ResultColumn) ([]outputColumn, error) {
+	var columns []outputColumn
+	for _, resultColumn := range resultColumns {
+		if resultColumn.Star {
+			if resultColumn.Table != "" {
+				return nil, &ErrPrepare{Msg: `RETURNING may not use "TABLE.*" wildcards`}
+			}
+			for i, column := range table.Columns {
+				columns = append(columns, outputColumn{column.Name, &ColumnExpr{Name: column.Name, Index: i, Collate: column.Collate}})
+			}
+			continue
+		}
+		if err := bindColumns(resultColumn.Expr, table, ""); err != nil {
+			return nil, err
+		}
+		if err := checkRowExpr(resultColumn.Expr); err != nil {
+			return nil, err
+		}
+		columns = append(columns, outputColumn{resultColumnName(resultColumn, table), resultColumn.Expr})
+	}
+	return columns, nil
+}
+
+// returnRow evaluates the RETURNING clause for a row the statement wrote, or is about to
+// delete, under rowid. Rows changed by foreign key actions or deleted by REPLACE are not
+// returned.
+func (writer *tableWriter) returnRow(row []interface{}, rowid int64) error {
+	if writer.returning == nil {
+		return nil
+	}
+	ctx := &evalContext{table: writer.table, values: row, rowid: rowid, encoding: writer.pager.encoding}
+	values := make([]interface{}, len(writer.returning))
+	for i, column := range writer.returning {
+		value, err := evalExpr(column.expr, ctx)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	writer.returned = append(writer.returned, values)
+	return nil
+}
+
+// result finishes the statement and returns the column names and rows of its RETURNING
+// clause. Like SQLite, the rows are only handed out once the whole statement succeeded.
+func (writer *tableWriter) result() ([]string, [][]interface{}, error) {
+	if err := writer.finish(); err != nil {
+		return nil, nil, err
+	}
+	if writer.returning == nil {
+		return nil, nil, nil
+	}
+	names := make([]string, len(writer.returning))
+	for i, column := range writer.returning {
+		names[i] = column.name
+	}
+	return names, writer.returned, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Affinity is the type affinity of a column, derived from its declared type.
+type Affinity int
+
+const (
+	AffinityBlob Affinity = iota //!Also used for "no affinity".
+	AffinityText
+	AffinityNumeric
+	AffinityInteger
+	AffinityReal
+)
+
+// typeAffinity applies SQLite's rules for deriving an affinity from a declared type name.
+func typeAffinity(declaredType string) Affinity {
+	upper := strings.ToUpper(declaredType)
+	switch {
+	case strings.Contains(upper, "INT"):
+		return AffinityInteger
+	case strings.Contains(upper, "CHAR") || strings.Contains(upper, "CLOB") || strings.Contains(upper, "TEXT"):
+		return AffinityText
+	case upper == "" || strings.Contains(upper, "BLOB"):
+		return AffinityBlob
+	case strings.Contains(upper, "REAL") || strings.Contains(upper, "FLOA") || strings.Contains(upper, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// schemaObject is one row of sqlite_schema.
+type schemaObject struct {
+	Type     string
+	Name     string
+	TblName  string
+	RootPage int64
+	SQL      string
+}
+
+type Column struct {
+	Name          string
+	Type          string
+	Affinity      Affinity
+	Default       Expr   //!nil for a NULL default.
+	Collate       string //!Declared collation, "" for BINARY.
+	NotNull       bool
+	NotNullAction string //!ON CONFLICT action of the NOT NULL constraint, "" for the default.
+}
+
+// missingValue is the value of the column in a record that ends before it, one written
+// before ALTER TABLE ADD COLUMN added the column: its DEFAULT, with its affinity applied.
+func (column Column) missingValue() interface{} {
+	if column.Default == nil {
+		return nil
+	}
+	value, err := evalExpr(column.Default, &evalContext{})
+	if err != nil {
+		return nil
+	}
+	return applyAffinity(value, column.Affinity)
+}
+
+// Table is a table from sqlite_schema with its columns parsed from the CREATE TABLE text.
+type Table struct {
+	Name          string
+	RootPage      int64
+	SQL           string
+	Columns       []Column
+	RowidAlias    int              //!Index of the INTEGER PRIMARY KEY column, -1 when there is none.
+	Autoincrement bool             //!Rowids are tracked in sqlite_sequence and never reused.
+	RowidAction   string           //!ON CONFLICT action of the INTEGER PRIMARY KEY.
+	Checks        []Check          //!Column CHECK constraints, then table ones, in the order written.
+	ForeignKeys   []*ForeignKey    //!In the order written.
+	Strict        bool             //!Values must have the declared type of their column.
+	Def           *CreateTableStmt //!nil only for sqlite_schema itself.
+}
+
+// Index is an index from sqlite_schema. Columns are the indexed column names in key order,
+// "" for a key that is an expression; Keys has the full key definitions.
+type Index struct {
+	Name     string
+	Table    string
+	RootPage int64
+	SQL      string
+	Columns  []string
+	Keys     []IndexedColumn
+	Unique   bool
+	Where    Expr   //!The condition of a partial index.
+	Action   string //!ON CONFLICT action of the constraint an automatic index enforces.
+}
+
+// Schema is the parsed content of sqlite_schema.
+type Schema struct {
+	Objects []schemaObject
+	Tables  map[string]*Table //!Keyed by lower case name.
+	Indexes []*Index
+}
+
+// readSchemaObjects returns every row of sqlite_schema in rowid order.
+func readSchemaObjects(pager *Pager) ([]schemaObject, error) {
+	//!A new database has no pages, and so no schema, until its first write.
+	if count, err := pager.pageCount(); err != nil || count == 0 {
+		return nil, err
+	}
+	_, rows, err := readTable(pager, 1, map[int64]int64{})
+	if err != nil {
+		return nil, err
+	}
+	var objects []schemaObject
+	for _, row := range rows {
+		if len(row) < 5 {
+			return nil, corruptError(1, 0, "sqlite_schema row has %d columns", len(row))
+		}
+		object := schemaObject{}
+		object.Type = row[0].Text
+		object.Name = row[1].Text
+		object.TblName = row[2].Text
+		object.RootPage = row[3].Int
+		object.SQL = row[4].Text
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// indexColumnsRegex extracts the indexed table and the column list from CREATE INDEX text.
+var indexColumnsRegex = regexp.MustCompile(`(?is)\bON\s+(?:"[^"]+"|\w+)\s*\((.*)\)`)
+
+// loadSchema reads sqlite_schema and parses the tables and indexes it describes.
+func loadSchema(pager *Pager) (*Schema, error) {
+	objects, err := readSchemaObjects(pager)
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{Objects: objects, Tables: map[string]*Table{}}
+	for _, object := range objects {
+		switch object.Type {
+		case "table":
+			if strings.HasPrefix(strings.ToUpper(object.SQL), "CREATE VIRTUAL") {
+				continue
+			}
+			statement, err := parseStatement(object.SQL)
+			if err != nil {
+				return nil, fmt.Errorf("malformed database schema (%s) - %v", object.Name, err)
+			}
+			def, ok := statement.(*CreateTableStmt)
+			if !ok || def.Select != nil {
+				return nil, fmt.Errorf("malformed database schema (%s) - not a CREATE TABLE statement", object.Name)
+			}
+			schema.Tables[strings.ToLower(object.Name)] = tableFromDef(object, def)
+		case "index":
+			index := &Index{Name: object.Name, Table: object.TblName, RootPage: object.RootPage, SQL: object.SQL}
+			if statement, err := parseStatement(object.SQL); err == nil {
+				if def, ok := statement.(*CreateIndexStmt); ok {
+					index.Keys, index.Unique, index.Where = def.Columns, def.Unique, def.Where
+					for _, key := range def.Columns {
+						index.Columns = append(index.Columns, key.Name)
+					}
+					schema.Indexes = append(schema.Indexes, index)
+					continue
+				}
+			}
+			//!Automatic indexes for UNIQUE and PRIMARY KEY constraints have no SQL text.
+			if match := indexColumnsRegex.FindStringSubmatch(object.SQL); match != nil {
+				for _, column := range splitColumnsByComma(match[1]) {
+					fields := strings.Fields(column)
+					if len(fields) > 0 {
+						index.Columns = append(index.Columns, strings.Trim(fields[0], "\"`[]"))
+					}
+				}
+			}
+			schema.Indexes = append(schema.Indexes, index)
+		}
+	}
+	for _, index := range schema.Indexes {
+		if index.SQL == "" {
+			schema.bindAutoIndex(index)
+		}
+	}
+	return schema, nil
+}
+
+// tableFromDef builds a Table from its parsed CREATE TABLE statement.
+func tableFromDef(object schemaObject, def *CreateTableStmt) *Table {
+	table := &Table{Name: object.Name, RootPage: object.RootPage, SQL: object.SQL, RowidAlias: -1, Strict: def.Strict, Def: def}
+	table.ForeignKeys = foreignKeys(def)
+	for i, column := range def.Columns {
+		affinity := typeAffinity(column.Type)
+		//!A STRICT table stores ANY values as given.
+		if def.Strict && strings.EqualFold(column.Type, "ANY") {
+			affinity = AffinityBlob
+		}
+		table.Columns = append(table.Columns, Column{
+			Name: column.Name, Type: column.Type, Affinity: affinity,
+			Default: column.Default, Collate: column.Collate,
+			NotNull: column.NotNull, NotNullAction: column.OnConflict["NOT NULL"],
+		})
+		table.Checks = append(table.Checks, column.Checks...)
+		//!"INTEGER PRIMARY KEY" aliases the rowid, but "INTEGER PRIMARY KEY DESC" does not.
+		if column.PrimaryKey && strings.EqualFold(column.Type, "INTEGER") && !column.PrimaryDesc {
+			table.RowidAlias = i
+			table.Autoincrement = column.Autoincrement
+			table.RowidAction = column.OnConflict["PRIMARY KEY"]
+		}
+	}
+	for _, constraint := range def.Constraints {
+		if constraint.Kind == "PRIMARY KEY" && len(constraint.Columns) == 1 {
+			if i := table.columnIndex(constraint.Columns[0].Name); i >= 0 && strings.EqualFold(table.Columns[i].Type, "INTEGER") {
+				table.RowidAlias = i
+				table.RowidAction = constraint.OnConflict
+			}
+		}
+		if constraint.Kind == "CHECK" {
+			table.Checks = append(table.Checks, constraint.Check)
+		}
+	}
+	if def.WithoutRowid {
+		table.RowidAlias = -1
+	}
+	return table
+}
+
+// uniqueKey is the key of an automatic index with the ON CONFLICT action of its constraint.
+type uniqueKey struct {
+	Columns []IndexedColumn
+	Action  string
+}
+
+// uniqueKeys lists the keys of the automatic indexes SQLite creates for the PRIMARY KEY and
+// UNIQUE constraints of a table, in the order it numbers them.
+func (table *Table) uniqueKeys() []uniqueKey {
+	if table.Def == nil {
+		return nil
+	}
+	var keys []uniqueKey
+	add := func(key []IndexedColumn, action string) {
+		//!A constraint on the same columns as an earlier one shares its index, which takes
+		//!the first ON CONFLICT action given.
+		for i, existing := range keys {
+			if len(existing.Columns) == len(key) {
+				same := true
+				for j := range key {
+					same = same && strings.EqualFold(existing.Columns[j].Name, key[j].Name)
+				}
+				if same {
+					if existing.Action == "" {
+						keys[i].Action = action
+					}
+					return
+				}
+			}
+		}
+		keys = append(keys, uniqueKey{Columns: key, Action: action})
+	}
+	for i, column := range table.Def.Columns {
+		if column.PrimaryKey && i != table.RowidAlias {
+			add([]IndexedColumn{{Name: column.Name}}, column.OnConflict["PRIMARY KEY"])
+		}
+		if column.Unique {
+			add([]IndexedColumn{{Name: column.Name}}, column.OnConflict["UNIQUE"])
+		}
+	}
+	for _, constraint := range table.Def.Constraints {
+		switch constraint.Kind {
+		case "PRIMARY KEY":
+			if len(constraint.Columns) == 1 && table.columnIndex(constraint.Columns[0].Name) == table.RowidAlias {
+				continue
+			}
+			add(constraint.Columns, constraint.OnConflict)
+		case "UNIQUE":
+			add(constraint.Columns, constraint.OnConflict)
+		}
+	}
+	return keys
+}
+
+// bindAutoIndex gives an automatic index, which has no SQL text, the key of the constraint it
+// was created for. Its name ends in the 1-based number of that constraint.
+func (schema *Schema) bindAutoIndex(index *Index) {
+	table := schema.table(index.Table)
+	prefix := "sqlite_autoindex_" + index.Table + "_"
+	if table == nil || !strings.HasPrefix(index.Name, prefix) {
+		return
+	}
+	n, err := strconv.Atoi(index.Name[len(prefix):])
+	keys := table.uniqueKeys()
+	if err != nil || n < 1 || n > len(keys) {
+		return
+	}
+	index.Keys, index.Unique, index.Action = keys[n-1].Columns, true, keys[n-1].Action
+	index.Columns = nil
+	for _, key := range index.Keys {
+		index.Columns = append(index.Columns, key.Name)
+	}
+}
+
+// table looks up a table by name, case-insensitively.
+func (schema *Schema) table(name string) *Table {
+	return schema.Tables[strings.ToLower(name)]
+}
+
+// index looks up an index by name, case-insensitively.
+func (schema *Schema) index(name string) *Index {
+	for _, index := range schema.Indexes {
+		if strings.EqualFold(index.Name, name) {
+			return index
+		}
+	}
+	return nil
+}
+
+// columnIndex returns the position of the named column, or -1.
+func (table *Table) columnIndex(name string) int {
+	for i, column := range table.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexesOn returns the indexes of the named table.
+func (schema *Schema) indexesOn(tableName string) []*Index {
+	var indexes []*Index
+	for _, index := range schema.Indexes {
+		if strings.EqualFold(index.Table, tableName) {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// schemaTable describes sqlite_schema itself, which has no entry of its own.
+var schemaTable = &Table{
+	Name:     "sqlite_schema",
+	RootPage: 1,
+	Columns: []Column{
+		{Name: "type", Type: "text", Affinity: AffinityText},
+		{Name: "name", Type: "text", Affinity: AffinityText},
+		{Name: "tbl_name", Type: "text", Affinity: AffinityText},
+		{Name: "rootpage", Type: "int", Affinity: AffinityInteger},
+		{Name: "sql", Type: "text", Affinity: AffinityText},
+	},
+	RowidAlias: -1,
+}
+
+// lookupTable resolves the table named in a FROM clause.
+func lookupTable(schema *Schema, from *TableRef) (*Table, error) {
+	switch strings.ToLower(from.Name) {
+	case "sqlite_schema", "sqlite_master":
+		return schemaTable, nil
+	}
+	table := schema.table(from.Name)
+	if table == nil {
+		return nil, &ErrNoSuchTable{Name: from.Name}
+	}
+	//!Its rows are in an index b-tree, which would otherwise read as a corrupt table.
+	if table.Def.WithoutRowid {
+		return nil, fmt.Errorf("reading a WITHOUT ROWID table is not supported")
+	}
+	return table, nil
+}
+
+// outputColumn is a result column after "*" has been expanded.
+type outputColumn struct {
+	name string
+	expr Expr
+}
+
+// executeSelect runs a SELECT and returns the result column names and rows.
+func executeSelect(pager *Pager, schema *Schema, stmt *SelectStmt) ([]string, [][]interface{}, error) {
+	var table *Table
+	alias := ""
+	if stmt.From != nil {
+		var err error
+		if table, err = lookupTable(schema, stmt.From); err != nil {
+			return nil, nil, err
+		}
+		alias = stmt.From.Alias
+	}
+
+	//!Expand "*" and bind every column reference before reading any row.
+	var columns []outputColumn
+	for _, resultColumn := range stmt.Columns {
+		if resultColumn.Star {
+			if table == nil {
+				return nil, nil, &ErrSyntax{Pos: -1, Msg: "no tables specified"}
+			}
+			if resultColumn.Table != "" && !tableNameMatches(resultColumn.Table, table, alias) {
+				return nil, nil, &ErrNoSuchTable{Name: resultColumn.Table}
+			}
+			for i, column := range table.Columns {
+				columns = append(columns, outputColumn{column.Name, &ColumnExpr{Name: column.Name, Index: i, Collate: column.Collate}})
+			}
+			continue
+		}
+		if err := bindColumns(resultColumn.Expr, table, alias); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, outputColumn{resultColumnName(resultColumn, table), resultColumn.Expr})
+	}
+
+	if err := bindColumns(stmt.Where, table, alias); err != nil {
+		return nil, nil, err
+	}
+	if aggregates, err := collectAggregates(stmt.Where); err != nil {
+		return nil, nil, err
+	} else if len(aggregates) > 0 {
+		return nil, nil, &ErrSyntax{Pos: aggregates[0].P, Msg: fmt.Sprintf("misuse of aggregate: %s()", aggregates[0].Name)}
+	}
+
+	orderBy, err := resolveOrderBy(stmt.OrderBy, columns, table, alias)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var aggregateCalls []*FuncExpr
+	for _, column := range columns {
+		found, err := collectAggregates(column.expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		aggregateCalls = append(aggregateCalls, found...)
+	}
+	for _, term := range orderBy {
+		found, err := collectAggregates(term.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		aggregateCalls = append(aggregateCalls, found...)
+	}
+	for _, expr := range []Expr{stmt.Where, stmt.Limit, stmt.Offset} {
+		if err := checkFunctions(expr); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, column := range columns {
+		if err := checkFunctions(column.expr); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, term := range orderBy {
+		if err := checkFunctions(term.Expr); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	limit, offset, err := evalLimit(stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+
+	rowids, rows, err := scanTable(pager, schema, table, stmt.Where)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type resultRow struct {
+		values []interface{}
+		keys   []interface{}
+	}
+	var results []resultRow
+	produce := func(ctx *evalContext) error {
+		row := resultRow{values: make([]interface{}, len(columns)), keys: make([]interface{}, len(orderBy))}
+		for i, column := range columns {
+			v, err := evalExpr(column.expr, ctx)
+			if err != nil {
+				return err
+			}
+			row.values[i] = v
+		}
+		for i, term := range orderBy {
+			v, err := evalExpr(term.Expr, ctx)
+			if err != nil {
+				return err
+			}
+			row.keys[i] = v
+		}
+		results = append(results, row)
+		return nil
+	}
+
+	states := make([]*aggregateState, len(aggregateCalls))
+	for i, call := range aggregateCalls {
+		states[i] = newAggregateState(call)
+	}
+	//!Bare columns of an aggregate query take their values from the first row.
+	var firstRow *evalContext
+	for i, values := range rows {
+		ctx := &evalContext{table: table, values: values, rowid: rowids[i], encoding: pager.encoding}
+		if stmt.Where != nil {
+			v, err := evalExpr(stmt.Where, ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok, _ := truth(v); !ok {
+				continue
+			}
+		}
+		if len(aggregateCalls) == 0 {
+			if err := produce(ctx); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if firstRow == nil {
+			firstRow = ctx
+		}
+		for _, state := range states {
+			if err := state.step(ctx); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(aggregateCalls) > 0 {
+		ctx := firstRow
+		if ctx == nil {
+			ctx = &evalContext{table: table, encoding: pager.encoding}
+		}
+		ctx.aggregates = map[*FuncExpr]interface{}{}
+		for _, state := range states {
+			v, err := state.result()
+			if err != nil {
+				return nil, nil, err
+			}
+			ctx.aggregates[state.fn] = v
+		}
+		if err := produce(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if stmt.Distinct {
+		seen := map[string]bool{}
+		var unique []resultRow
+		for _, row := range results {
+			var key strings.Builder
+			for _, v := range row.values {
+				key.WriteString(formatSQLLiteral(v))
+				key.WriteByte(0)
+			}
+			if !seen[key.String()] {
+				seen[key.String()] = true
+				unique = append(unique, row)
+			}
+		}
+		results = unique
+	}
+
+	if len(orderBy) > 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			for k, term := range orderBy {
+				c := compareValues(results[i].keys[k], results[j].keys[k], exprCollation(term.Expr), pager.encoding)
+				if c == 0 {
+					continue
+				}
+				if term.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	if offset > int64(len(results)) {
+		offset = int64(len(results))
+	}
+	results = results[offset:]
+	if limit >= 0 && limit < int64(len(results)) {
+		results = results[:limit]
+	}
+	output := make([][]interface{}, len(results))
+	for i, row := range results {
+		output[i] = row.values
+	}
+	return names, output, nil
+}
+
+// resultColumnName names a bound result column like sqlite3 does: by its alias, by the column
+// it reads, or else by its text as written. The rowid goes by the name of its alias column.
+func resultColumnName(resultColumn ResultColumn, table *Table) string {
+	if resultColumn.Alias != "" {
+		return resultColumn.Alias
+	}
+	column, ok := resultColumn.Expr.(*ColumnExpr)
+	switch {
+	case !ok:
+		return resultColumn.Text
+	case column.Index >= 0:
+		return table.Columns[column.Index].Name
+	case table.RowidAlias >= 0:
+		return table.Columns[table.RowidAlias].Name
+	}
+	return "rowid"
+}
+
+// resolveOrderBy binds the ORDER BY terms. A term that is an integer literal or the alias of
+// a result column stands for that result column.
+func resolveOrderBy(terms []OrderingTerm, columns []outputColumn, table *Table, alias string) ([]OrderingTerm, error) {
+	var resolved []OrderingTerm
+	for i, term := range terms {
+		if literal, ok := term.Expr.(*LiteralExpr); ok {
+			if n, ok := literal.Value.(int64); ok {
+				if n < 1 || n > int64(len(columns)) {
+					return nil, &ErrSyntax{Pos: -1, Msg: fmt.Sprintf("%s ORDER BY term out of range - should be between 1 and %d", ordinal(i+1), len(columns))}
+				}
+				resolved = append(resolved, OrderingTerm{Expr: columns[n-1].expr, Desc: term.Desc})
+				continue
+			}
+		}
+		if column, ok := term.Expr.(*ColumnExpr); ok && column.Table == "" {
+			matched := false
+			for _, output := range columns {
+				if strings.EqualFold(output.name, column.Name) {
+					if _, isColumn := output.expr.(*ColumnExpr); !isColumn || table == nil || table.columnIndex(column.Name) < 0 {
+						resolved = append(resolved, OrderingTerm{Expr: output.expr, Desc: term.Desc})
+						matched = true
+					}
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+		}
+		if err := bindColumns(term.Expr, table, alias); err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, term)
+	}
+	return resolved, nil
+}
+
+func ordinal(n int) string {
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+		return fmt.Sprintf("%dth", n)
+	case n%10 == 1:
+		return fmt.Sprintf("%dst", n)
+	case n%10 == 2:
+		return fmt.Sprintf("%dnd", n)
+	case n%10 == 3:
+		return fmt.Sprintf("%drd", n)
+	}
+	return fmt.Sprintf("%dth", n)
+}
+
+// evalLimit evaluates LIMIT and OFFSET; a negative limit means no limit.
+func evalLimit(stmt *SelectStmt) (int64, int64, error) {
+	limit, offset := int64(-1), int64(0)
+	for _, clause := range []struct {
+		expr Expr
+		into *int64
+	}{{stmt.Limit, &limit}, {stmt.Offset, &offset}} {
+		if clause.expr == nil {
+			continue
+		}
+		if err := bindColumns(clause.expr, nil, ""); err != nil {
+			return 0, 0, err
+		}
+		v, err := evalExpr(clause.expr, &evalContext{})
+		if err != nil {
+			return 0, 0, err
+		}
+		n, ok := applyAffinity(v, AffinityInteger).(int64)
+		if !ok {
+			return 0, 0, fmt.Errorf("datatype mismatch")
+		}
+		*clause.into = n
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset, nil
+}
+
+// scanTable returns the rowids and decoded rows of the table that may satisfy where, using the
+// rowid or an index when where pins a column to a constant. Rows are padded to the table's
+// column count, carry their rowid in the alias column and have REAL affinity applied.
+func scanTable(pager *Pager, schema *Schema, table *Table, where Expr) ([]int64, [][]interface{}, error) {
+	if table == nil {
+		//!A SELECT without FROM produces a single row.
+		return []int64{0}, [][]interface{}{nil}, nil
+	}
+	keys, planned, err := lookupRowids(pager, schema, table, where)
+	if err != nil {
+		return nil, nil, err
+	}
+	if planned && len(keys) == 0 {
+		return nil, nil, nil
+	}
+	rowids, records, err := readTable(pager, table.RootPage, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := make([][]interface{}, len(records))
+	for i, record := range records {
+		rows[i] = tableRow(table, rowids[i], record)
+	}
+	return rowids, rows, nil
+}
+
+// tableRow converts a record of table for the evaluator: padded to the table's column
+// count with the defaults of the missing columns, with the rowid in the alias column and
+// REAL affinity applied.
+func tableRow(table *Table, rowid int64, record []Value) []interface{} {
+	row := valuesInterface(record)
+	for len(row) < len(table.Columns) {
+		row = append(row, table.Columns[len(row)].missingValue())
+	}
+	if table.RowidAlias >= 0 {
+		row[table.RowidAlias] = rowid
+	}
+	for j, column := range table.Columns {
+		if v, ok := row[j].(int64); ok && column.Affinity == AffinityReal {
+			row[j] = float64(v)
+		}
+	}
+	return row
+}
+
+// bindWhere binds the WHERE clause of an UPDATE or DELETE to its table and checks it can be
+// evaluated one row at a time.
+func bindWhere(where Expr, table *Table) error {
+	if err := bindColumns(where, table, ""); err != nil {
+		return err
+	}
+	if aggregates, err := collectAggregates(where); err != nil {
+		return err
+	} else if len(aggregates) > 0 {
+		return &ErrSyntax{Pos: aggregates[0].P, Msg: fmt.Sprintf("misuse of aggregate: %s()", aggregates[0].Name)}
+	}
+	return checkFunctions(where)
+}
+
+// matchRows returns the rowids and rows of the table for which the bound where is true.
+func matchRows(pager *Pager, schema *Schema, table *Table, where Expr) ([]int64, [][]interface{}, error) {
+	rowids, rows, err := scanTable(pager, schema, table, where)
+	if err != nil || where == nil {
+		return rowids, rows, err
+	}
+	var matchedRowids []int64
+	var matchedRows [][]interface{}
+	for i, row := range rows {
+		v, err := evalExpr(where, &evalContext{table: table, values: row, rowid: rowids[i], encoding: pager.encoding})
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok, _ := truth(v); ok {
+			matchedRowids = append(matchedRowids, rowids[i])
+			matchedRows = append(matchedRows, row)
+		}
+	}
+	return matchedRowids, matchedRows, nil
+}
+
+// andTerms splits an expression into the terms ANDed together at its top.
+func andTerms(expr Expr) []Expr {
+	if binary, ok := expr.(*BinaryExpr); ok && binary.Op == "AND" {
+		return append(andTerms(binary.Left), andTerms(binary.Right)...)
+	} else if expr != nil {
+		return []Expr{expr}
+	}
+	return nil
+}
+
+// lookupRowids looks for a "column = constant" term among the ANDed terms of where that can be
+// answered from the rowid or from an index. planned is false when a full scan is needed.
+func lookupRowids(pager *Pager, schema *Schema, table *Table, where Expr) (map[int64]int64, bool, error) {
+	terms := andTerms(where)
+	for _, term := range terms {
+		binary, ok := term.(*BinaryExpr)
+		if !ok || binary.Op != "=" {
+			continue
+		}
+		column, isColumn := binary.Left.(*ColumnExpr)
+		literal, isLiteral := binary.Right.(*LiteralExpr)
+		if !isColumn || !isLiteral {
+			column, isColumn = binary.Right.(*ColumnExpr)
+			literal, isLiteral = binary.Left.(*LiteralExpr)
+		}
+		if !isColumn || !isLiteral || literal.Value == nil {
+			continue
+		}
+
+		if column.Index < 0 || column.Index == table.RowidAlias {
+			keys := map[int64]int64{}
+			switch v := applyAffinity(literal.Value, AffinityNumeric).(type) {
+			case int64:
+				keys[v] = v
+			case float64:
+				if v == math.Trunc(v) && math.Abs(v) < 9.2e18 {
+					keys[int64(v)] = int64(v)
+				}
+			}
+			return keys, true, nil
+		}
+
+		affinity := table.Columns[column.Index].Affinity
+		collation := exprCollation(binary.Left, binary.Right)
+		for _, index := range schema.indexesOn(table.Name) {
+			if usable, err := indexAnswers(index, table, column.Index, collation, terms); err != nil {
+				return nil, false, err
+			} else if !usable {
+				continue
+			}
+			rowids, err := readIndex(pager, index.RootPage, applyAffinity(literal.Value, affinity), collation)
+			if err != nil {
+				return nil, false, err
+			}
+			keys := map[int64]int64{}
+			for _, rowid := range rowids {
+				keys[rowid] = rowid
+			}
+			return keys, true, nil
+		}
+	}
+	return map[int64]int64{}, false, nil
+}
+
+// indexAnswers reports whether the index can list the rows where column equals a constant
+// under collation: its first key must be the column itself, ordered by the same collation,
+// and the ANDed terms of the query must include every term of a partial index's condition,
+// or the index would miss rows.
+func indexAnswers(index *Index, table *Table, column int, collation string, terms []Expr) (bool, error) {
+	if len(index.Keys) == 0 || index.Keys[0].Expr != nil || table.columnIndex(index.Keys[0].Name) != column {
+		return false, nil
+	}
+	keyCollation := index.Keys[0].Collate
+	if keyCollation == "" {
+		keyCollation = table.Columns[column].Collate
+	}
+	binaryName := func(name string) string {
+		if name == "BINARY" {
+			return ""
+		}
+		return name
+	}
+	if binaryName(keyCollation) != binaryName(collation) {
+		return false, nil
+	}
+	if index.Where == nil {
+		return true, nil
+	}
+	if err := bindColumns(index.Where, table, ""); err != nil {
+		return false, err
+	}
+	for _, condition := range andTerms(index.Where) {
+		implied := false
+		for _, term := range terms {
+			if sameExpr(term, condition) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			return false, nil
+		}
+	}
+	return true, nil
+}
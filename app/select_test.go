@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lookupIndexes are index sets a WHERE on the table of lookupDatabase must give the same rows
+// under: none, indexes whose collation or partial condition only answer some lookups, and a
+// BINARY index on the NOCASE column.
+var lookupIndexes = []struct {
+	name string
+	sql  []string
+}{
+	{"no index", nil},
+	{"indexes", []string{"create index tn on t(name)", "create index tv on t(v) where v > 0"}},
+	{"binary index", []string{"create index tb on t(name collate binary)"}},
+}
+
+// lookupDatabase creates a table with a NOCASE column holding 'ABC' and 'abc', then runs the
+// statements creating its indexes.
+func lookupDatabase(t *testing.T, indexes []string) string {
+	t.Helper()
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(id integer primary key, name text collate nocase, v int)",
+		"insert into t values (1, 'ABC', -1), (2, 'abc', 5), (3, 'abd', 7)")
+	runSQL(t, path, indexes...)
+	return path
+}
+
+func TestSelectIndexLookup(t *testing.T) {
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{"name = 'abc'", "1 2"},
+		{"'abc' = name", "1 2"},
+		{"name = 'abc' collate binary", "2"},
+		{"v = -1", "1"},
+		{"v = 5", "2"},
+		{"v = 5 and v > 0", "2"},
+		{"v > 0 and name = 'ABC'", "2"},
+	}
+	for _, indexes := range lookupIndexes {
+		t.Run(indexes.name, func(t *testing.T) {
+			path := lookupDatabase(t, indexes.sql)
+			for _, test := range tests {
+				out := runSQL(t, path, "select id from t where "+test.where)
+				if got := strings.Join(strings.Fields(out), " "); got != test.want {
+					t.Errorf("where %s: got %q, want %q", test.where, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectErrorCodes(t *testing.T) {
+	withoutRowid := newDatabase(t)
+	runSQL(t, withoutRowid, "create table t(a primary key, b) without rowid")
+
+	corrupt := newDatabase(t)
+	runSQL(t, corrupt, "create table t(a)", "insert into t values (1)")
+	data, err := os.ReadFile(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[4096] = 7 //!Not a b-tree page type.
+	if err := os.WriteFile(corrupt, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	notADatabase := filepath.Join(t.TempDir(), "junk.db")
+	if err := os.WriteFile(notADatabase, bytes.Repeat([]byte("junk"), 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		code int
+		tail string //!The end of the message, which shows the result code.
+	}{
+		{"without rowid", withoutRowid, 1, "not supported\n"}, //!Rather than corrupt.
+		{"corrupt", corrupt, resultCorrupt, " (11)\n"},
+		{"not a database", notADatabase, resultNotADB, " (26)\n"},
+	}
+	for _, test := range tests {
+		var code int
+		stderr := captureStderr(t, func() {
+			_, code = runShell(test.path, "", "select * from t")
+		})
+		if code != test.code || !strings.HasSuffix(stderr, test.tail) {
+			t.Errorf("%s: exit code %d, stderr %q, want %d", test.name, code, stderr, test.code)
+		}
+	}
+}
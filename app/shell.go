@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Shell holds the state of one invocation of the program: the database being queried, the
+// output settings and the options controlling how a batch of commands is run.
+type Shell struct {
+	out              io.Writer
+	settings         *OutputSettings
+	databaseFilePath string
+	commands         []string //!Commands given after the database file name.
+	initCommands     []string //!Commands given with -cmd, run before everything else.
+	readOnly         bool
+	bail             bool
+	echo             bool
+	errorCount       int
+	exitStatus       int    //!Exit code for the errors reported so far.
+	statementLine    int    //!Input line the current statement starts on, 0 for command line arguments.
+	mmapSize         int64  //!Set with -mmap, applied when the database is opened.
+	busyTimeout      int64  //!Set with .timeout, applied when the database is opened.
+	vfsName          string //!Set with -vfs.
+	deserialize      bool   //!Load the whole database into memory before using it.
+	pager            *Pager
+	schema           *Schema
+}
+
+// maxStatementRetries bounds how often a statement runs again because another connection
+// changed the database while it waited to write.
+const maxStatementRetries = 10
+
+// errQuit is returned by .quit and .exit to stop processing input.
+var errQuit = errors.New("quit")
+
+func newShell(out io.Writer) *Shell {
+	return &Shell{out: out, settings: defaultOutputSettings()}
+}
+
+// parseArgs reads the options, the database file name and the commands from the command line.
+// Like sqlite3, options may appear before or after the database file name.
+func (shell *Shell) parseArgs(flags *flag.FlagSet, args []string) error {
+	registerOutputFlags(flags, shell.settings)
+	flags.Func("cmd", "run `COMMAND` before reading stdin", func(command string) error {
+		shell.initCommands = append(shell.initCommands, command)
+		return nil
+	})
+	flags.BoolVar(&shell.bail, "bail", false, "stop after hitting an error")
+	flags.BoolVar(&shell.readOnly, "readonly", false, "open the database read-only")
+	flags.BoolVar(&shell.echo, "echo", false, "print commands before execution")
+	flags.Int64Var(&shell.mmapSize, "mmap", 0, "default mmap size set to `N`")
+	flags.StringVar(&shell.vfsName, "vfs", "", "use `NAME` as the default VFS")
+	flags.BoolVar(&shell.deserialize, "deserialize", false, "open the database using sqlite3_deserialize()")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] FILENAME [SQL...]\n", flags.Name())
+		flags.PrintDefaults()
+	}
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		if shell.databaseFilePath == "" {
+			shell.databaseFilePath = args[0]
+		} else {
+			shell.commands = append(shell.commands, args[0])
+		}
+		args = args[1:]
+	}
+	if shell.databaseFilePath == "" {
+		return errors.New("Error: missing database file name")
+	}
+	return nil
+}
+
+// run executes the -cmd commands and then either the commands from the command line or, when
+// there are none, the script read from stdin. It returns the process exit code.
+func (shell *Shell) run(stdin io.Reader) int {
+	for _, command := range shell.initCommands {
+		if err := shell.processArg(command); err != nil {
+			return shell.exitCode(err)
+		}
+	}
+	//!Errors in -cmd commands do not change the exit code.
+	shell.errorCount = 0
+	if len(shell.commands) == 0 {
+		return shell.exitCode(shell.processInput(stdin))
+	}
+	for _, command := range shell.commands {
+		errorCount := shell.errorCount
+		if err := shell.processArg(command); err != nil {
+			return shell.exitCode(err)
+		}
+		//!The first command that fails ends the run.
+		if shell.errorCount > errorCount {
+			break
+		}
+	}
+	return shell.exitCode(nil)
+}
+
+// database returns the pager, opening the database on first use, and the parsed schema. The
+// schema is read again only when the database changed since the previous command.
+func (shell *Shell) database() (*Pager, *Schema, error) {
+	if shell.pager == nil {
+		vfs, err := shell.openVFS()
+		if err != nil {
+			return nil, nil, err
+		}
+		pager, err := openPager(vfs, shell.databaseFilePath, shell.readOnly)
+		if err != nil {
+			return nil, nil, err
+		}
+		shell.pager = pager
+		pager.busyTimeout = shell.busyTimeout
+		if err := pager.setMmapSize(shell.mmapSize); err != nil {
+			return nil, nil, err
+		}
+	}
+	changed, err := shell.pager.refresh()
+	//!Like sqlite3, a database that cannot be read before its schema is loaded fails to prepare.
+	if errors.Is(err, ErrBusy) && shell.schema == nil {
+		return nil, nil, &ErrPrepare{Msg: err.Error(), Err: err}
+	} else if err != nil {
+		return nil, nil, err
+	} else if changed {
+		shell.schema = nil
+	}
+	if shell.schema == nil {
+		schema, err := loadSchema(shell.pager)
+		if err != nil {
+			return nil, nil, err
+		}
+		shell.schema = schema
+	}
+	return shell.pager, shell.schema, nil
+}
+
+// openVFS returns the VFS chosen with -vfs. With -deserialize the database file is first
+// copied into a private in-memory VFS.
+func (shell *Shell) openVFS() (VFS, error) {
+	if !shell.deserialize {
+		return findVFS(shell.vfsName)
+	}
+	data, err := os.ReadFile(shell.databaseFilePath)
+	if err != nil {
+		return nil, &ErrCantOpen{Path: shell.databaseFilePath, Err: err}
+	}
+	vfs := NewMemVFS()
+	vfs.Store(shell.databaseFilePath, data)
+	return vfs, nil
+}
+
+func (shell *Shell) exitCode(err error) int {
+	if shell.pager != nil {
+		shell.pager.Close()
+	}
+	if shell.errorCount > 0 {
+		return shell.exitStatus
+	}
+	if err != nil && err != errQuit {
+		return 1
+	}
+	return 0
+}
+
+// processArg runs one command line argument, which is either a single dot command or any
+// number of ;-separated SQL statements.
+func (shell *Shell) processArg(arg string) error {
+	if strings.HasPrefix(strings.TrimSpace(arg), ".") {
+		return shell.execute(strings.TrimSpace(arg))
+	}
+	shell.statementLine = 0
+	statements, _, rest := splitStatements(arg)
+	if rest = stripSQLComments(rest); rest != "" {
+		statements = append(statements, rest)
+	}
+	for _, statement := range statements {
+		errorCount := shell.errorCount
+		if err := shell.execute(statement); err != nil {
+			return err
+		}
+		//!Like sqlite3, the statements after one that failed are not run.
+		if shell.errorCount > errorCount {
+			return nil
+		}
+	}
+	return nil
+}
+
+// processInput runs a script: dot commands one per line and SQL statements terminated by ';',
+// possibly spanning several lines.
+func (shell *Shell) processInput(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	var pending strings.Builder
+	lineNo, pendingLine := 0, 0 //!pendingLine is the line the pending text starts on.
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if line != "" {
+			lineNo++
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.TrimSpace(pending.String()) == "" && strings.HasPrefix(trimmed, ".") {
+			pending.Reset()
+			shell.statementLine = lineNo
+			if err := shell.execute(trimmed); err != nil {
+				return err
+			}
+		} else if line != "" {
+			if pending.Len() == 0 {
+				pendingLine = lineNo
+			}
+			pending.WriteString(line)
+			text := pending.String()
+			statements, starts, rest := splitStatements(text)
+			for i, statement := range statements {
+				shell.statementLine = pendingLine + strings.Count(text[:starts[i]], "\n")
+				if err := shell.execute(statement); err != nil {
+					return err
+				}
+			}
+			if len(statements) > 0 {
+				pendingLine += strings.Count(text[:len(text)-len(rest)], "\n")
+				pending.Reset()
+				pending.WriteString(rest)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if stripSQLComments(pending.String()) != "" {
+		return shell.report(errors.New("incomplete input"))
+	}
+	return nil
+}
+
+// execute runs one dot command or SQL statement. Errors are reported and counted; only errQuit,
+// and any error when -bail is set, are returned to stop processing.
+func (shell *Shell) execute(command string) error {
+	if shell.echo {
+		fmt.Fprintln(shell.out, command)
+	}
+	if strings.HasPrefix(command, ".") {
+		args := splitDotCommandArgs(command)
+		switch args[0] {
+		case ".quit", ".exit":
+			return errQuit
+		case ".bail":
+			if len(args) != 2 {
+				return shell.report(errors.New("Usage: .bail on|off"))
+			}
+			on, err := parseShellBool(args[1])
+			if err != nil {
+				return shell.report(err)
+			}
+			shell.bail = on
+			return nil
+		case ".echo":
+			if len(args) != 2 {
+				return shell.report(errors.New("Usage: .echo on|off"))
+			}
+			on, err := parseShellBool(args[1])
+			if err != nil {
+				return shell.report(err)
+			}
+			shell.echo = on
+			return nil
+		case ".timeout":
+			//!Like sqlite3, a missing or unreadable value turns waiting off.
+			timeout := int64(0)
+			if len(args) > 1 {
+				timeout, _ = strconv.ParseInt(args[1], 10, 64)
+			}
+			shell.busyTimeout = max(timeout, 0)
+			if shell.pager != nil {
+				shell.pager.busyTimeout = shell.busyTimeout
+			}
+			return nil
+		case ".read":
+			if len(args) != 2 {
+				return shell.report(errors.New("Usage: .read FILE"))
+			}
+			file, err := os.Open(args[1])
+			if err != nil {
+				return shell.report(fmt.Errorf("cannot open \"%s\"", args[1]))
+			}
+			defer file.Close()
+			return shell.processInput(file)
+		}
+	}
+	err := shell.runCommand(command)
+	//!A statement that read the database before another connection changed it runs again.
+	for retries := 0; errors.Is(err, errStaleRead) && retries < maxStatementRetries; retries++ {
+		shell.pager.endRead()
+		err = shell.runCommand(command)
+	}
+	//!Other connections can write again once the statement is done.
+	if shell.pager != nil {
+		shell.pager.endRead()
+	}
+	//!Like sqlite3, a database that cannot be opened ends the run, whatever the command was.
+	var cantOpen *ErrCantOpen
+	if errors.As(err, &cantOpen) {
+		shell.report(err)
+		return err
+	}
+	if err == nil || strings.HasPrefix(command, ".") {
+		return shell.report(err)
+	}
+	return shell.reportStatement(command, err)
+}
+
+// report prints err like sqlite3 does and decides whether processing should stop.
+func (shell *Shell) report(err error) error {
+	if err == nil || err == errQuit {
+		return err
+	}
+	shell.errorCount++
+	shell.exitStatus = 1
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	if shell.bail {
+		return err
+	}
+	return nil
+}
+
+// reportStatement prints the error of an SQL statement the way sqlite3 words it, telling
+// errors found while preparing the statement from those found while running it, and pointing
+// at the offending part of the statement when the position is known.
+func (shell *Shell) reportStatement(statement string, err error) error {
+	shell.errorCount++
+	prepare := isPrepareError(err)
+	//!Like sqlite3, a script exits with 1 but a command line statement with its result code.
+	shell.exitStatus = 1
+	message := err.Error()
+	if code := resultCode(err); code != 1 {
+		message += fmt.Sprintf(" (%d)", code)
+		if shell.statementLine == 0 {
+			shell.exitStatus = code
+		}
+	}
+	switch {
+	case shell.statementLine > 0 && prepare:
+		fmt.Fprintf(os.Stderr, "Parse error near line %d: %s", shell.statementLine, message)
+	case shell.statementLine > 0:
+		fmt.Fprintf(os.Stderr, "Runtime error near line %d: %s", shell.statementLine, message)
+	case prepare:
+		fmt.Fprintf(os.Stderr, "Error: in prepare, %s", message)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: stepping, %s", message)
+	}
+	if shell.statementLine > 0 {
+		statement += ";" //!Scripts show the statement with its terminator.
+	}
+	fmt.Fprintln(os.Stderr, errorContext(statement, errorPosition(err)))
+	if shell.bail {
+		return err
+	}
+	return nil
+}
+
+// errorContext renders the statement with a marker under the byte at offset, keeping it to
+// one line of at most 78 bytes around the offset like the sqlite3 shell.
+func errorContext(statement string, offset int) string {
+	if offset < 0 || offset >= len(statement) {
+		return ""
+	}
+	for offset > 50 {
+		offset--
+		statement = statement[1:]
+		for len(statement) > 0 && statement[0]&0xc0 == 0x80 {
+			statement = statement[1:]
+			offset--
+		}
+	}
+	if len(statement) > 78 {
+		end := 78
+		for end > 0 && statement[end]&0xc0 == 0x80 {
+			end--
+		}
+		statement = statement[:end]
+	}
+	code := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || r == '\r' || r == '\f' || r == '\v' {
+			return ' '
+		}
+		return r
+	}, statement)
+	if offset < 25 {
+		return fmt.Sprintf("\n  %s\n  %s^--- error here", code, strings.Repeat(" ", offset))
+	}
+	return fmt.Sprintf("\n  %s\n  %serror here ---^", code, strings.Repeat(" ", offset-14))
+}
+
+// registerOutputFlags adds the sqlite3 style output options (-mode, -csv, -header, ...).
+// Flags are applied in the order they are given, like the sqlite3 shell does.
+func registerOutputFlags(flags *flag.FlagSet, settings *OutputSettings) {
+	flags.Func("mode", "set output mode", settings.SetMode)
+	for _, name := range outputModeNames() {
+		name := name
+		//!Unlike .mode, the shortcut flags never turn headers on by themselves and only the
+		//!ascii flag changes the row separator.
+		flags.BoolFunc(name, "set output mode to '"+name+"'", func(string) error {
+			headers, rowSeparator := settings.Headers, settings.RowSeparator
+			err := settings.SetMode(name)
+			settings.Headers = headers
+			if name != "ascii" {
+				settings.RowSeparator = rowSeparator
+			}
+			return err
+		})
+	}
+	flags.BoolFunc("header", "turn headers on", func(string) error {
+		settings.Headers, settings.HeadersSet = true, true
+		return nil
+	})
+	flags.BoolFunc("noheader", "turn headers off", func(string) error {
+		settings.Headers, settings.HeadersSet = false, true
+		return nil
+	})
+	flags.Func("separator", "set output column separator", func(sep string) error {
+		settings.ColSeparator = resolveBackslashes(sep)
+		return nil
+	})
+	flags.Func("newline", "set output row separator", func(sep string) error {
+		settings.RowSeparator = resolveBackslashes(sep)
+		return nil
+	})
+	flags.Func("nullvalue", "text string for NULL values", func(text string) error {
+		settings.NullValue = resolveBackslashes(text)
+		return nil
+	})
+}
+
+// runOutputCommand handles the dot commands that change output settings. It reports whether
+// the command was one of them.
+func (shell *Shell) runOutputCommand(command string) (bool, error) {
+	settings := shell.settings
+	args := splitDotCommandArgs(command)
+	switch args[0] {
+	case ".mode":
+		if len(args) < 2 {
+			fmt.Fprintf(shell.out, "current output mode: %s\n", settings.Mode)
+			return true, nil
+		}
+		if err := settings.SetMode(strings.ToLower(args[1])); err != nil {
+			return true, err
+		}
+		if settings.Mode == "insert" {
+			settings.InsertTable = "table"
+			if len(args) > 2 {
+				settings.InsertTable = args[2]
+			}
+		}
+	case ".headers", ".header":
+		if len(args) != 2 {
+			return true, fmt.Errorf("Usage: .headers on|off")
+		}
+		on, err := parseShellBool(args[1])
+		if err != nil {
+			return true, err
+		}
+		settings.Headers, settings.HeadersSet = on, true
+	case ".separator":
+		if len(args) < 2 || len(args) > 3 {
+			return true, fmt.Errorf("Usage: .separator COL ?ROW?")
+		}
+		settings.ColSeparator = resolveBackslashes(args[1])
+		if len(args) == 3 {
+			settings.RowSeparator = resolveBackslashes(args[2])
+		}
+	case ".nullvalue":
+		if len(args) != 2 {
+			return true, fmt.Errorf("Usage: .nullvalue STRING")
+		}
+		settings.NullValue = resolveBackslashes(args[1])
+	case ".width":
+		settings.Widths = nil
+		for _, arg := range args[1:] {
+			width, err := strconv.Atoi(arg)
+			if err != nil {
+				return true, fmt.Errorf("invalid width: %q", arg)
+			}
+			settings.Widths = append(settings.Widths, width)
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// splitDotCommandArgs splits a dot command on whitespace, keeping quoted arguments together.
+func splitDotCommandArgs(command string) []string {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	for _, char := range command {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote = char
+			inArg = true
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// parseShellBool accepts the same spellings as the sqlite3 shell for on/off arguments.
+func parseShellBool(arg string) (bool, error) {
+	switch strings.ToLower(arg) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("ERROR: Not a boolean value: \"%s\". Assuming \"no\".", arg)
+}
+
+// resolveBackslashes expands the C style escapes the sqlite3 shell accepts in separators.
+func resolveBackslashes(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'v':
+			sb.WriteByte('\v')
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// splitStatements splits text into the SQL statements terminated by ';', ignoring semicolons
+// inside string literals, quoted identifiers and comments. starts holds the offset in text
+// where each statement begins and the text after the last terminator is returned as rest.
+func splitStatements(text string) (statements []string, starts []int, rest string) {
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'', '"', '`', '[':
+			closing := text[i]
+			if closing == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(text[i+1:], closing)
+			if end < 0 {
+				return statements, starts, text[start:]
+			}
+			i += end + 1
+		case '-':
+			if i+1 < len(text) && text[i+1] == '-' {
+				end := strings.IndexByte(text[i:], '\n')
+				if end < 0 {
+					return statements, starts, text[start:]
+				}
+				i += end
+			}
+		case '/':
+			if i+1 < len(text) && text[i+1] == '*' {
+				end := strings.Index(text[i+2:], "*/")
+				if end < 0 {
+					return statements, starts, text[start:]
+				}
+				i += end + 3
+			}
+		case ';':
+			if statement := stripSQLComments(text[start:i]); statement != "" {
+				statements = append(statements, statement)
+				starts = append(starts, start+len(text[start:i])-len(strings.TrimLeft(text[start:i], " \t\r\n\f\v")))
+			}
+			start = i + 1
+		}
+	}
+	return statements, starts, text[start:]
+}
+
+// stripSQLComments removes -- and /* */ comments from a statement, leaving quoted text alone.
+func stripSQLComments(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\'' || text[i] == '"':
+			end := strings.IndexByte(text[i+1:], text[i])
+			if end < 0 {
+				sb.WriteString(text[i:])
+				return strings.TrimSpace(sb.String())
+			}
+			sb.WriteString(text[i : i+end+2])
+			i += end + 1
+		case strings.HasPrefix(text[i:], "--"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				return strings.TrimSpace(sb.String())
+			}
+			i += end
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return strings.TrimSpace(sb.String())
+			}
+			i += end + 3
+		default:
+			sb.WriteByte(text[i])
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStderr returns what fn writes to os.Stderr, where the shell reports errors.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestOpenErrorEndsRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "test.db")
+	var code int
+	stderr := captureStderr(t, func() {
+		shell := newShell(io.Discard)
+		shell.databaseFilePath = path
+		code = shell.run(strings.NewReader("select 1;\nselect 2;\n"))
+	})
+	//!Not "Runtime error near line 1", and not a second time for the next statement.
+	want := "Error: unable to open database \"" + path + "\": unable to open database file\n"
+	if stderr != want || code != 1 {
+		t.Errorf("got %q, exit code %d; want %q, exit code 1", stderr, code, want)
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-bogus", "test.db"}, "flag provided but not defined: -bogus"},
+		{[]string{"-mmap", "abc", "test.db"}, `invalid value "abc" for flag -mmap: parse error`},
+		{[]string{"-readonly"}, "Error: missing database file name"},
+		{[]string{"test.db", "-mode", "nonsense"}, "invalid value \"nonsense\" for flag -mode: mode should be one of: "},
+	}
+	for _, test := range tests {
+		//!main parses with ContinueOnError, so that every error reaches its report and exit code 1.
+		flags := flag.NewFlagSet("sqlite", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		err := newShell(io.Discard).parseArgs(flags, test.args)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %q", test.args, err, test.want)
+		}
+	}
+}
+
+func TestDbinfo(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(a)", "create index ta on t(a)", "create table u(b unique)",
+		"insert into t values (1)")
+	//!The counters and the schema size match what sqlite3 reads from the same file.
+	want := `database page size:  4096
+write format:        1
+read format:         1
+reserved bytes:      0
+file change counter: 4
+database page count: 5
+freelist page count: 0
+schema cookie:       3
+schema format:       4
+default cache size:  0
+autovacuum top root: 0
+incremental vacuum:  0
+text encoding:       1 (utf8)
+user version:        0
+application id:      0
+software version:    3050002
+number of tables:    2
+number of indexes:   2
+number of triggers:  0
+number of views:     0
+schema size:         64
+data version         1
+`
+	if got := runSQL(t, path, ".dbinfo"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	path = newDatabase(t)
+	runSQL(t, path, "pragma page_size = 1024", "pragma encoding = 'UTF-16be'", "create table t(a)")
+	got := runSQL(t, path, ".dbinfo")
+	for _, line := range []string{"database page size:  1024\n", "database page count: 2\n", "text encoding:       3 (utf16be)\n"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("UTF-16be database: no %q in\n%s", line, got)
+		}
+	}
+}
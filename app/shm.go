@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// The -shm file holds the wal-index SQLite processes share to find frames in the log without
+// reading it: a header, checkpoint information, and blocks that map frames to page numbers
+// with a hash table for lookups. Every value is in the byte order of the machine.
+const (
+	walIndexVersion     = 3007000
+	walIndexHeaderSize  = 136 //!Two copies of the 48-byte header, then the checkpoint information.
+	walIndexBlockSize   = 32768
+	walIndexBlockFrames = 4096 //!Page numbers in a block, less those that fit after the header in the first one.
+	walIndexFirstFrames = walIndexBlockFrames - walIndexHeaderSize/4
+	walIndexSlots       = 8192 //!Hash table slots in a block.
+	walReaders          = 5    //!Read marks in the checkpoint information.
+	readMarkNotUsed     = 0xffffffff
+)
+
+// Offsets of the bytes of the -shm file that connections lock to coordinate in WAL mode. The
+// lock on read mark i is at walReadLock+i.
+const (
+	walWriteLock      = 120
+	walCheckpointLock = 121
+	walReadLock       = 123
+	walDMSLock        = 128
+)
+
+// readMarkAttempts bounds how often lockReadMark starts over when writers and checkpoints keep
+// changing the log under it, as SQLite's walTryBeginRead does.
+const readMarkAttempts = 100
+
+// nativeBigEndian is whether this machine stores words big-endian, which decides the byte
+// order of the -shm file and of the checksums of a log this implementation starts.
+var nativeBigEndian = binary.NativeEndian.Uint16([]byte{0, 1}) == 1
+
+// walShm is the part of the wal-index that is not derived from the log itself.
+type walShm struct {
+	changes           uint32 //!Bumped by every transaction committed to the log.
+	backfilled        int64  //!Frames a checkpoint copied into the database.
+	backfillAttempted uint32
+	readMarks         [walReaders]uint32
+}
+
+// recoveredShm is the checkpoint state SQLite starts from when it rebuilds a wal-index from the
+// log: nothing is known to be checkpointed.
+func recoveredShm(wal *walIndex) walShm {
+	shm := walShm{backfillAttempted: uint32(len(wal.pages))}
+	for i := 1; i < walReaders; i++ {
+		shm.readMarks[i] = readMarkNotUsed
+	}
+	if len(wal.pages) > 0 {
+		shm.readMarks[1] = uint32(len(wal.pages))
+	}
+	return shm
+}
+
+// readShm takes the checkpoint state from a -shm file that indexes exactly the committed
+// frames of wal, and the recovered state otherwise. The change counter is kept either way, so
+// that other processes notice the next commit.
+func readShm(file File, wal *walIndex) (walShm, error) {
+	shm := recoveredShm(wal)
+	raw := make([]byte, walIndexHeaderSize)
+	if n, err := file.ReadAt(raw, 0); err != nil && !(err == io.EOF && n == len(raw)) {
+		if err == io.EOF {
+			return shm, nil
+		}
+		return shm, err
+	}
+	ne := binary.NativeEndian
+	header := raw[:48]
+	s0, s1 := walChecksum(header[:40], nativeBigEndian, 0, 0)
+	if !bytes.Equal(header, raw[48:96]) || header[12] == 0 || ne.Uint32(header) != walIndexVersion ||
+		s0 != ne.Uint32(header[40:]) || s1 != ne.Uint32(header[44:]) {
+		return shm, nil
+	}
+	shm.changes = ne.Uint32(header[8:])
+	if !wal.valid || ne.Uint32(header[16:]) != uint32(len(wal.pages)) || !bytes.Equal(header[32:40], wal.header[16:24]) {
+		return shm, nil
+	}
+	shm.backfilled = min(int64(ne.Uint32(raw[96:])), int64(len(wal.pages)))
+	for i := range shm.readMarks {
+		shm.readMarks[i] = ne.Uint32(raw[100+4*i:])
+	}
+	shm.backfillAttempted = ne.Uint32(raw[128:])
+	return shm, nil
+}
+
+// walIndexBlock returns which block of the wal-index holds a frame, counting frames from 1,
+// and the number of the last frame of the blocks before it.
+func walIndexBlock(frame int64) (int64, int64) {
+	block := (frame + walIndexBlockFrames - walIndexFirstFrames - 1) / walIndexBlockFrames
+	if block == 0 {
+		return 0, 0
+	}
+	return block, walIndexFirstFrames + (block-1)*walIndexBlockFrames
+}
+
+// writeShm writes the wal-index of wal to the -shm file, where SQLite processes with the
+// database open will find it. The checkpoint information is left alone unless withCheckpoint
+// is set: other connections update their read marks there.
+func (pager *Pager) writeShm(wal *walIndex, withCheckpoint bool) error {
+	if pager.shm == nil {
+		return nil
+	}
+	ne := binary.NativeEndian
+	mxFrame := int64(len(wal.pages))
+	blocks, _ := walIndexBlock(max(mxFrame, 1))
+	buf := make([]byte, (blocks+1)*walIndexBlockSize)
+
+	header := buf[:48]
+	ne.PutUint32(header, walIndexVersion)
+	ne.PutUint32(header[8:], wal.shm.changes)
+	header[12] = 1
+	if wal.bigEndian {
+		header[13] = 1
+	}
+	//!65536 does not fit in 16 bits and is stored as 1.
+	ne.PutUint16(header[14:], uint16(pager.pageSize&0xff00|pager.pageSize>>16))
+	ne.PutUint32(header[16:], uint32(mxFrame))
+	ne.PutUint32(header[20:], uint32(wal.dbSize))
+	ne.PutUint32(header[24:], wal.checksum[0])
+	ne.PutUint32(header[28:], wal.checksum[1])
+	if wal.valid {
+		copy(header[32:40], wal.header[16:24])
+	}
+	s0, s1 := walChecksum(header[:40], nativeBigEndian, 0, 0)
+	ne.PutUint32(header[40:], s0)
+	ne.PutUint32(header[44:], s1)
+	copy(buf[48:96], header)
+
+	ne.PutUint32(buf[96:], uint32(wal.shm.backfilled))
+	for i, mark := range wal.shm.readMarks {
+		ne.PutUint32(buf[100+4*i:], mark)
+	}
+	ne.PutUint32(buf[128:], wal.shm.backfillAttempted)
+
+	for i, pageNo := range wal.pages {
+		frame := int64(i) + 1
+		block, zero := walIndexBlock(frame)
+		base := block * walIndexBlockSize
+		pageNos, hash := base, base+4*walIndexBlockFrames
+		if block == 0 {
+			pageNos += walIndexHeaderSize
+		}
+		slot := frame - zero
+		ne.PutUint32(buf[pageNos+4*(slot-1):], uint32(pageNo))
+		key := int64(uint32(pageNo)*383) & (walIndexSlots - 1)
+		for ne.Uint16(buf[hash+2*key:]) != 0 {
+			key = (key + 1) & (walIndexSlots - 1)
+		}
+		ne.PutUint16(buf[hash+2*key:], uint16(slot))
+	}
+
+	if withCheckpoint {
+		_, err := pager.shm.WriteAt(buf, 0)
+		return err
+	}
+	if _, err := pager.shm.WriteAt(buf[walIndexHeaderSize:], walIndexHeaderSize); err != nil {
+		return err
+	}
+	//!The header goes last, so that a reader never finds it ahead of the page numbers.
+	_, err := pager.shm.WriteAt(buf[:96], 0)
+	return err
+}
+
+// writeShmWord stores one word of the checkpoint information, such as a read mark.
+func (pager *Pager) writeShmWord(offset int64, value uint32) error {
+	if pager.shm == nil {
+		return nil
+	}
+	word := make([]byte, 4)
+	binary.NativeEndian.PutUint32(word, value)
+	_, err := pager.shm.WriteAt(word, offset)
+	return err
+}
+
+// readMarks reads the read marks from the -shm file.
+func (pager *Pager) readMarks() ([walReaders]uint32, error) {
+	var marks [walReaders]uint32
+	raw := make([]byte, 4*walReaders)
+	if n, err := pager.shm.ReadAt(raw, 100); err != nil && !(err == io.EOF && n == len(raw)) {
+		if err == io.EOF {
+			return marks, nil
+		}
+		return marks, err
+	}
+	for i := range marks {
+		marks[i] = binary.NativeEndian.Uint32(raw[4*i:])
+	}
+	return marks, nil
+}
+
+// openShm sets the checkpoint state of wal from the -shm file, opening the file first when
+// this connection has not. The file stays open until closeShm with a shared lock on its DMS
+// byte: like SQLite, a connection that finds nobody else holding that lock is the first to use
+// the wal-index, and rebuilds it from the log before letting others in.
+func (pager *Pager) openShm(wal *walIndex) error {
+	if pager.shm != nil {
+		shm, err := readShm(pager.shm, wal)
+		wal.shm = shm
+		return err
+	}
+	shmPath := pager.path + "-shm"
+	err := ErrReadOnly
+	var file File
+	if !pager.readOnly {
+		file, err = pager.vfs.Open(shmPath, OpenReadWrite|OpenCreate)
+	}
+	if err != nil {
+		wal.shm = recoveredShm(wal)
+		if exists, err := pager.vfs.Exists(shmPath); err != nil || !exists {
+			return err
+		}
+		if file, err = pager.vfs.Open(shmPath, OpenReadOnly); err != nil {
+			return err
+		}
+	}
+	locker, canLock := file.(RangeLocker)
+	first := !pager.readOnly && (!canLock || locker.LockRange(RangeExclusive, walDMSLock, 1) == nil)
+	if canLock && !first {
+		if err := pager.retryBusy(func() error { return locker.LockRange(RangeShared, walDMSLock, 1) }); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	shm, err := readShm(file, wal)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if first && canLock {
+		changes := shm.changes
+		shm = recoveredShm(wal)
+		shm.changes = changes
+	}
+	pager.shm, wal.shm = file, shm
+	if first {
+		if err := pager.writeShm(wal, true); err != nil {
+			pager.closeShm()
+			return err
+		}
+		if canLock {
+			return locker.LockRange(RangeShared, walDMSLock, 1)
+		}
+	}
+	return nil
+}
+
+// closeShm releases the locks this connection holds on the -shm file and closes it.
+func (pager *Pager) closeShm() {
+	if pager.shm == nil {
+		return
+	}
+	pager.unlockReadMark()
+	pager.endWALWrite()
+	if locker, ok := pager.shm.(RangeLocker); ok {
+		locker.LockRange(RangeUnlocked, walDMSLock, 1)
+	}
+	pager.shm.Close()
+	pager.shm = nil
+}
+
+// lockReadMark takes a shared lock on a read mark no greater than the last committed frame of
+// the log, setting one to it when none is, as SQLite's walTryBeginRead does. Checkpoints copy
+// no frame past a locked mark, and the log only restarts once no mark but 0 is locked; holding
+// mark 0 means the whole log is in the database, which is then read alone. When the log
+// changed before the lock was taken, it is read again and the search starts over. It reports
+// whether the log was read again.
+func (pager *Pager) lockReadMark() (bool, error) {
+	locker, ok := pager.shm.(RangeLocker)
+	if !ok {
+		return false, nil
+	}
+	reread := false
+	for attempt := 0; attempt < readMarkAttempts; attempt++ {
+		if attempt > 5 {
+			time.Sleep(time.Duration(min(attempt, 50)) * time.Millisecond)
+		}
+		wal := pager.wal
+		frames := uint32(len(wal.pages))
+		marks, err := pager.readMarks()
+		if err != nil {
+			return reread, err
+		}
+		mark := 0
+		if frames > 0 && wal.shm.backfilled < int64(frames) {
+			mark = -1
+			for i := 1; i < walReaders; i++ {
+				if marks[i] <= frames && (mark < 0 || marks[i] > marks[mark]) {
+					mark = i
+				}
+			}
+			if (mark < 0 || marks[mark] < frames) && !pager.readOnly {
+				for i := 1; i < walReaders; i++ {
+					if locker.LockRange(RangeExclusive, walReadLock+int64(i), 1) != nil {
+						continue
+					}
+					if err := pager.writeShmWord(100+4*int64(i), frames); err != nil {
+						locker.LockRange(RangeUnlocked, walReadLock+int64(i), 1)
+						return reread, err
+					}
+					marks[i], mark = frames, i
+					break
+				}
+			}
+			//!A connection that cannot write the wal-index reads without a mark, as nothing would stop a checkpoint anyway.
+			if mark < 0 && pager.readOnly {
+				return reread, nil
+			}
+		}
+		if mark >= 0 {
+			//!A mark just set is still locked exclusively, and this downgrades it.
+			err := locker.LockRange(RangeShared, walReadLock+int64(mark), 1)
+			if err != nil && !errors.Is(err, ErrBusy) {
+				return reread, err
+			}
+			if err == nil {
+				now, err := pager.readMarks()
+				changed := false
+				if err == nil {
+					changed, err = wal.changed()
+				}
+				if err == nil && !changed && (mark == 0 || now[mark] == marks[mark]) {
+					pager.readMark = mark
+					return reread, nil
+				}
+				locker.LockRange(RangeUnlocked, walReadLock+int64(mark), 1)
+				if err != nil {
+					return reread, err
+				}
+			}
+		}
+		changed, err := pager.loadWAL()
+		if err != nil {
+			return reread, err
+		}
+		reread = reread || changed
+		if pager.wal == nil {
+			return reread, nil
+		}
+	}
+	return reread, ErrBusy
+}
+
+// unlockReadMark releases the read mark taken by lockReadMark.
+func (pager *Pager) unlockReadMark() {
+	if pager.readMark < 0 {
+		return
+	}
+	if locker, ok := pager.shm.(RangeLocker); ok {
+		locker.LockRange(RangeUnlocked, walReadLock+int64(pager.readMark), 1)
+	}
+	pager.readMark = -1
+}
+
+// lockReaders takes exclusive locks on read marks 1 and up, which only succeeds when no other
+// connection reads frames of the log, waiting for them for up to busy_timeout when wait is set.
+// It reports whether it got them; without locking, it always does.
+func (pager *Pager) lockReaders(wait bool) (bool, error) {
+	locker, ok := pager.shm.(RangeLocker)
+	if !ok {
+		return true, nil
+	}
+	lock := func() error { return locker.LockRange(RangeExclusive, walReadLock+1, walReaders-1) }
+	var err error
+	if wait {
+		err = pager.retryBusy(lock)
+	} else {
+		err = lock()
+	}
+	if errors.Is(err, ErrBusy) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockReaders releases the locks taken by lockReaders, keeping the read mark of this
+// connection.
+func (pager *Pager) unlockReaders() error {
+	locker, ok := pager.shm.(RangeLocker)
+	if !ok {
+		return nil
+	}
+	if err := locker.LockRange(RangeUnlocked, walReadLock+1, walReaders-1); err != nil {
+		return err
+	}
+	if pager.readMark > 0 {
+		return locker.LockRange(RangeShared, walReadLock+int64(pager.readMark), 1)
+	}
+	return nil
+}
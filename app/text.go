@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// TextEncoding is the text encoding of a database, stored at offset 56 of the header.
+type TextEncoding uint32
+
+const (
+	EncodingUTF8    TextEncoding = 1
+	EncodingUTF16LE TextEncoding = 2
+	EncodingUTF16BE TextEncoding = 3
+)
+
+// String returns the name PRAGMA encoding reports for the encoding.
+func (enc TextEncoding) String() string {
+	switch enc {
+	case EncodingUTF16LE:
+		return "UTF-16le"
+	case EncodingUTF16BE:
+		return "UTF-16be"
+	}
+	return "UTF-8"
+}
+
+func (enc TextEncoding) byteOrder() binary.ByteOrder {
+	if enc == EncodingUTF16BE {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// decodeText converts text stored in the database encoding to a Go string. Invalid UTF-16,
+// such as an unpaired surrogate or an odd trailing byte, becomes U+FFFD.
+func decodeText(raw []byte, enc TextEncoding) string {
+	if enc != EncodingUTF16LE && enc != EncodingUTF16BE {
+		return string(raw)
+	}
+	order := enc.byteOrder()
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = order.Uint16(raw[2*i:])
+	}
+	text := string(utf16.Decode(units))
+	if len(raw)%2 != 0 {
+		text += string(utf8.RuneError)
+	}
+	return text
+}
+
+// encodeText converts a Go string to the database encoding.
+func encodeText(text string, enc TextEncoding) []byte {
+	if enc != EncodingUTF16LE && enc != EncodingUTF16BE {
+		return []byte(text)
+	}
+	order := enc.byteOrder()
+	units := utf16.Encode([]rune(text))
+	raw := make([]byte, 2*len(units))
+	for i, unit := range units {
+		order.PutUint16(raw[2*i:], unit)
+	}
+	return raw
+}
+
+// compareText orders two strings as the BINARY collation does: by the bytes of their text in
+// the database encoding, so that UTF-16LE text sorts by its little-endian code units.
+func compareText(x string, y string, enc TextEncoding) int {
+	if enc != EncodingUTF16LE && enc != EncodingUTF16BE {
+		return strings.Compare(x, y)
+	}
+	return bytes.Compare(encodeText(x, enc), encodeText(y, enc))
+}
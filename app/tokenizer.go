@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenKind classifies the tokens produced by tokenize.
+type TokenKind int
+
+const (
+	TokenEOF         TokenKind = iota
+	TokenIdent                 //!Bare word: an identifier or a keyword.
+	TokenQuotedIdent           //!"name", [name] or `name`, with the quotes removed.
+	TokenString                //!'text', with the quotes removed and '' unescaped.
+	TokenNumber
+	TokenBlob //!X'...', Text holds the hex digits.
+	TokenVariable
+	TokenOperator
+)
+
+// Token is one lexical token of an SQL statement. Pos and End are the byte offsets of its
+// first character and of the character following it in the statement.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int
+	End  int
+}
+
+// isKeyword reports whether the token is the bare word kw, compared case-insensitively.
+func (t Token) isKeyword(kw string) bool {
+	return t.Kind == TokenIdent && strings.EqualFold(t.Text, kw)
+}
+
+// operators lists the multi-character operators first so the longest match wins.
+var operators = []string{"||", "<<", ">>", "<=", ">=", "==", "!=", "<>", "->>", "->",
+	"(", ")", ",", ";", ".", "*", "/", "%", "+", "-", "<", ">", "=", "&", "|", "~"}
+
+// tokenize splits an SQL statement into tokens, skipping whitespace and comments. The last
+// token is always TokenEOF.
+func tokenize(sql string) ([]Token, error) {
+	var tokens []Token
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'':
+			end := strings.IndexByte(sql[i+2:], '\'')
+			if end < 0 {
+				return nil, &ErrSyntax{Pos: i, Msg: "unrecognized token: \"" + sql[i:] + "\""}
+			}
+			digits := sql[i+2 : i+2+end]
+			if len(digits)%2 != 0 || strings.Trim(digits, "0123456789abcdefABCDEF") != "" {
+				return nil, &ErrSyntax{Pos: i, Msg: "unrecognized token: \"" + sql[i:i+end+3] + "\""}
+			}
+			tokens = append(tokens, Token{TokenBlob, digits, i, i + end + 3})
+			i += end + 3
+		case c == '\'':
+			text, n, ok := scanQuoted(sql[i:], '\'')
+			if !ok {
+				return nil, &ErrSyntax{Pos: i, Msg: "unrecognized token: \"" + sql[i:] + "\""}
+			}
+			tokens = append(tokens, Token{TokenString, text, i, i + n})
+			i += n
+		case c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			text, n, ok := scanQuoted(sql[i:], closing)
+			if !ok {
+				return nil, &ErrSyntax{Pos: i, Msg: "unrecognized token: \"" + sql[i:] + "\""}
+			}
+			tokens = append(tokens, Token{TokenQuotedIdent, text, i, i + n})
+			i += n
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9'):
+			n := scanNumber(sql[i:])
+			if i+n < len(sql) && isIdentChar(sql[i+n:]) {
+				end := i + n
+				for end < len(sql) && isIdentChar(sql[end:]) {
+					end++
+				}
+				return nil, &ErrSyntax{Pos: i, Msg: "unrecognized token: \"" + sql[i:end] + "\""}
+			}
+			tokens = append(tokens, Token{TokenNumber, sql[i : i+n], i, i + n})
+			i += n
+		case c == '?' || c == ':' || c == '@' || c == '$':
+			n := 1
+			for i+n < len(sql) && isIdentChar(sql[i+n:]) {
+				n++
+			}
+			tokens = append(tokens, Token{TokenVariable, sql[i : i+n], i, i + n})
+			i += n
+		case isIdentStart(sql[i:]):
+			n := 0
+			for i+n < len(sql) && isIdentChar(sql[i+n:]) {
+				_, size := utf8.DecodeRuneInString(sql[i+n:])
+				n += size
+			}
+			tokens = append(tokens, Token{TokenIdent, sql[i : i+n], i, i + n})
+			i += n
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(sql[i:], op) {
+					tokens = append(tokens, Token{TokenOperator, op, i, i + len(op)})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				_, size := utf8.DecodeRuneInString(sql[i:])
+				return nil, &ErrSyntax{Pos: i, Msg: "unrecognized token: \"" + sql[i:i+size] + "\""}
+			}
+		}
+	}
+	return append(tokens, Token{TokenEOF, "", len(sql), len(sql)}), nil
+}
+
+// scanQuoted reads a token quoted with quote (whose closing character is closing for '[')
+// where a doubled closing character stands for itself. It returns the unquoted text and the
+// number of bytes consumed.
+func scanQuoted(s string, closing byte) (string, int, bool) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != closing {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if closing != ']' && i+1 < len(s) && s[i+1] == closing {
+			sb.WriteByte(closing)
+			i++
+			continue
+		}
+		return sb.String(), i + 1, true
+	}
+	return "", 0, false
+}
+
+// scanNumber returns the length of the numeric literal at the start of s.
+func scanNumber(s string) int {
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		n := 2
+		for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+			n++
+		}
+		return n
+	}
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	if n < len(s) && s[n] == '.' {
+		n++
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+	}
+	if n < len(s) && (s[n] == 'e' || s[n] == 'E') {
+		m := n + 1
+		if m < len(s) && (s[m] == '+' || s[m] == '-') {
+			m++
+		}
+		if m < len(s) && s[m] >= '0' && s[m] <= '9' {
+			for m < len(s) && s[m] >= '0' && s[m] <= '9' {
+				m++
+			}
+			n = m
+		}
+	}
+	return n
+}
+
+func isIdentStart(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || (r < utf8.RuneSelf && unicode.IsLetter(r)) || r >= utf8.RuneSelf
+}
+
+func isIdentChar(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return isIdentStart(s) || (r >= '0' && r <= '9') || r == '$'
+}
@@ -0,0 +1,9 @@
+package main
+
+// executeTransaction runs BEGIN, COMMIT or ROLLBACK.
+func executeTransaction(pager *Pager, stmt *TransactionStmt) error {
+	if stmt.Kind == "BEGIN" {
+		return pager.beginTransaction(stmt.Mode)
+	}
+	return pager.endTransaction(stmt.Kind == "COMMIT")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// executeUpdate runs an UPDATE. The matching rows are found before any is changed, then
+// rewritten in rowid order in one write transaction. It returns the rows of the RETURNING
+// clause, if any, with their values after the update.
+func executeUpdate(pager *Pager, schema *Schema, stmt *UpdateStmt) ([]string, [][]interface{}, error) {
+	table, err := writableTable(schema, stmt.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, err := assignmentTargets(table, stmt.Set, func(expr Expr) error {
+		return bindColumns(expr, table, "")
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	returning, err := prepareReturning(table, stmt.Returning)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := bindWhere(stmt.Where, table); err != nil {
+		return nil, nil, err
+	}
+	if err := checkForeignKeys(pager, schema, table, assignedColumns(table, targets), true); err != nil {
+		return nil, nil, err
+	}
+	rowids, _, err := matchRows(pager, schema, table, stmt.Where)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := pager.beginWrite(); err != nil {
+		return nil, nil, err
+	}
+	writer := &tableWriter{pager: pager, schema: schema, table: table, returning: returning}
+	for _, rowid := range rowids {
+		//!Rows are read again, as REPLACE may have deleted a row or moved another to its rowid.
+		row, found, err := writer.readRow(rowid)
+		if err == nil && found {
+			err = writer.update(row, rowid, targets, stmt.Set, stmt.OrAction, nil)
+		}
+		if err != nil {
+			return nil, nil, writer.fail(err)
+		}
+	}
+	return writer.result()
+}
+
+// assignmentTargets maps each assignment of an UPDATE or upsert to the column it sets, -1
+// standing for the rowid, and binds the expressions assigned with bind.
+func assignmentTargets(table *Table, set []Assignment, bind func(Expr) error) ([]int, error) {
+	targets := make([]int, len(set))
+	for i, assignment := range set {
+		targets[i] = table.columnIndex(assignment.Column)
+		if targets[i] < 0 {
+			switch strings.ToLower(assignment.Column) {
+			case "rowid", "oid", "_rowid_":
+			default:
+				return nil, &ErrNoSuchColumn{Name: assignment.Column, Pos: -1}
+			}
+		}
+		if err := bind(assignment.Expr); err != nil {
+			return nil, err
+		}
+		if err := checkRowExpr(assignment.Expr); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// checkRowExpr checks that an expression evaluated against one row at a time holds no
+// aggregate and only calls known functions.
+func checkRowExpr(expr Expr) error {
+	if aggregates, err := collectAggregates(expr); err != nil {
+		return err
+	} else if len(aggregates) > 0 {
+		return &ErrSyntax{Pos: aggregates[0].P, Msg: fmt.Sprintf("misuse of aggregate function %s()", aggregates[0].Name)}
+	}
+	return checkFunctions(expr)
+}
+
+// update applies the assignments to one row once the result satisfies the constraints of the
+// table. A row whose rowid changes is deleted and written again under the new one; otherwise
+// its cell is replaced and only the index entries that changed are rewritten. excluded is the
+// row an upsert failed to insert, nil for an UPDATE.
+func (writer *tableWriter) update(old []interface{}, rowid int64, targets []int, set []Assignment, orAction string, excluded *evalContext) error {
+	table := writer.table
+	ctx := &evalContext{table: table, values: old, rowid: rowid, excluded: excluded, encoding: writer.pager.encoding}
+	row := append([]interface{}(nil), old...)
+	var newRowid interface{} = rowid
+	for i, target := range targets {
+		v, err := evalExpr(set[i].Expr, ctx)
+		if err != nil {
+			return err
+		}
+		if target < 0 && table.RowidAlias >= 0 {
+			target = table.RowidAlias
+		}
+		if target < 0 {
+			newRowid = v
+		} else {
+			row[target] = applyAffinity(v, table.Columns[target].Affinity)
+		}
+	}
+	if table.RowidAlias >= 0 {
+		newRowid = row[table.RowidAlias]
+	}
+	id, ok := applyAffinity(newRowid, AffinityInteger).(int64)
+	if !ok {
+		return &ErrResult{Code: resultMismatch, Msg: "datatype mismatch"}
+	}
+	if table.RowidAlias >= 0 {
+		row[table.RowidAlias] = id
+	}
+	if skip, err := writer.checkRow(row, id, orAction); err != nil || skip {
+		return err
+	}
+	if skip, err := writer.claimKeys(row, id, rowid, orAction); err != nil || skip {
+		return err
+	}
+	assigned := assignedColumns(table, targets)
+	if err := writer.foreignKeysBefore(old, row, rowid, assigned); err != nil {
+		return err
+	}
+	if err := writer.rewrite(old, row, rowid, id); err != nil {
+		return err
+	}
+	if err := writer.foreignKeysAfter(old, row, id, assigned); err != nil {
+		return err
+	}
+	return writer.returnRow(row, id)
+}
+
+// rewrite replaces the row old stored under rowid with row stored under id.
+func (writer *tableWriter) rewrite(old []interface{}, row []interface{}, rowid int64, id int64) error {
+	if id != rowid {
+		if err := writer.deleteRow(old, rowid); err != nil {
+			return err
+		}
+		return writer.writeRow(row, id)
+	}
+	for _, index := range writer.schema.indexesOn(writer.table.Name) {
+		oldEntry, oldOk, err := writer.indexEntry(index, old, rowid)
+		if err != nil {
+			return err
+		}
+		newEntry, newOk, err := writer.indexEntry(index, row, rowid)
+		if err != nil {
+			return err
+		}
+		encoding, format := writer.pager.encoding, writer.pager.header.SchemaFormat
+		if oldOk == newOk && bytes.Equal(EncodeRecord(oldEntry, encoding, format), EncodeRecord(newEntry, encoding, format)) {
+			continue
+		}
+		if err := writer.deleteIndexEntry(index, old, rowid); err != nil {
+			return err
+		}
+		if err := writer.insertIndexEntry(index, row, rowid); err != nil {
+			return err
+		}
+	}
+	return writer.writeCell(row, rowid)
+}
+
+// assignedColumns marks the columns the assignment targets of an UPDATE set, the rowid
+// standing for its alias.
+func assignedColumns(table *Table, targets []int) []bool {
+	assigned := make([]bool, len(table.Columns))
+	for _, target := range targets {
+		if target < 0 {
+			target = table.RowidAlias
+		}
+		if target >= 0 {
+			assigned[target] = true
+		}
+	}
+	return assigned
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lookupWheres are WHERE clauses on the table of lookupDatabase with the ids of the rows they
+// match, whatever indexes the table has.
+var lookupWheres = []struct {
+	where string
+	ids   string
+}{
+	{"name = 'abc'", "1 2"},
+	{"name = 'abc' collate binary", "2"},
+	{"v = -1", "1"},
+	{"v = 5 and v > 0", "2"},
+	{"id = 3", "3"},
+}
+
+func TestUpdateIndexLookup(t *testing.T) {
+	for _, indexes := range lookupIndexes {
+		t.Run(indexes.name, func(t *testing.T) {
+			for _, test := range lookupWheres {
+				path := lookupDatabase(t, indexes.sql)
+				runSQL(t, path, "update t set name = name || '!', v = v + 100 where "+test.where)
+				out := runSQL(t, path, "select id from t where name like '%!' and v > 50")
+				if got := strings.Join(strings.Fields(out), " "); got != test.ids {
+					t.Errorf("where %s updated %q, want %q", test.where, got, test.ids)
+				}
+				checkIntegrity(t, path)
+			}
+		})
+	}
+}
+
+func TestDeleteIndexLookup(t *testing.T) {
+	for _, indexes := range lookupIndexes {
+		t.Run(indexes.name, func(t *testing.T) {
+			for _, test := range lookupWheres {
+				path := lookupDatabase(t, indexes.sql)
+				runSQL(t, path, "delete from t where "+test.where)
+				var deleted []string
+				for _, id := range []string{"1", "2", "3"} {
+					if strings.TrimSpace(runSQL(t, path, "select count(*) from t where id = "+id)) == "0" {
+						deleted = append(deleted, id)
+					}
+				}
+				if got := strings.Join(deleted, " "); got != test.ids {
+					t.Errorf("where %s deleted %q, want %q", test.where, got, test.ids)
+				}
+				checkIntegrity(t, path)
+			}
+		})
+	}
+}
+
+func TestUpdateAndDeleteRebalance(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(id integer primary key, s text, n int)", "create index ts on t(s)",
+		"create index tn on t(n)")
+	var values []string
+	for i := 0; i < 600; i++ {
+		values = append(values, fmt.Sprintf("(%d, '%0200d', %d)", i, i, i%7))
+	}
+	runSQL(t, path, "insert into t values "+strings.Join(values, ", "))
+
+	steps := []struct {
+		sql  string
+		want string //!count(*), sum(id) and sum(n) after the statement.
+	}{
+		{"update t set id = id + 1000 where id % 3 = 0", "600|379700|1795"},
+		{"update t set s = s || s where n = 2", "600|379700|1795"},
+		{"delete from t where n = 4", "514|325771|1451"},
+		{"delete from t where id > 1000", "343|103513|964"},
+		{"update t set n = -n", "343|103513|-964"},
+		{"delete from t where id between 100 and 500", "113|34570|-313"},
+		{"delete from t", "0||"},
+	}
+	for _, step := range steps {
+		runSQL(t, path, step.sql)
+		if got := strings.TrimSpace(runSQL(t, path, "select count(*), sum(id), sum(n) from t")); got != step.want {
+			t.Errorf("after %s: count, sum(id), sum(n) = %s, want %s", step.sql, got, step.want)
+		}
+		checkIntegrity(t, path)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// upsertClause is an ON CONFLICT clause of an INSERT bound to its table.
+type upsertClause struct {
+	anyConflict bool   //!The clause has no target and catches the first conflict of the row.
+	index       *Index //!The UNIQUE index the target names, nil for the rowid.
+	targets     []int
+	set         []Assignment //!nil for DO NOTHING.
+	where       Expr
+}
+
+// prepareUpserts binds the ON CONFLICT clauses of an INSERT on table, resolving the target of
+// each to the constraint it names. In the DO UPDATE part, bare and table-qualified columns are
+// those of the existing row and "excluded." ones those of the row that failed to go in.
+func prepareUpserts(schema *Schema, table *Table, upserts []Upsert) ([]upsertClause, error) {
+	bind := func(expr Expr) error {
+		return walkExpr(expr, func(e Expr) error {
+			column, ok := e.(*ColumnExpr)
+			if !ok {
+				return nil
+			}
+			if strings.EqualFold(column.Table, "excluded") {
+				column.Excluded = true
+				return bindColumn(column, table, column.Table)
+			}
+			return bindColumn(column, table, "")
+		})
+	}
+	clauses := make([]upsertClause, len(upserts))
+	for i, upsert := range upserts {
+		clause := &clauses[i]
+		if upsert.Target == nil {
+			clause.anyConflict = true
+		} else {
+			index, err := upsertTarget(schema, table, upsert)
+			if err != nil {
+				return nil, err
+			}
+			clause.index = index
+		}
+		if upsert.Set == nil {
+			continue
+		}
+		var err error
+		if clause.targets, err = assignmentTargets(table, upsert.Set, bind); err != nil {
+			return nil, err
+		}
+		if err := bind(upsert.Where); err != nil {
+			return nil, err
+		}
+		if err := checkRowExpr(upsert.Where); err != nil {
+			return nil, err
+		}
+		clause.set, clause.where = upsert.Set, upsert.Where
+	}
+	return clauses, nil
+}
+
+// upsertTarget finds the constraint the target of an upsert names: the rowid, returned as a
+// nil index, or a UNIQUE index whose keys are the target terms in any order. A term given a
+// collation must have the collation of its key, and a partial index is only named by a target
+// repeating its WHERE clause.
+func upsertTarget(schema *Schema, table *Table, upsert Upsert) (*Index, error) {
+	terms := make([]Expr, len(upsert.Target))
+	for i, column := range upsert.Target {
+		terms[i] = column.Expr
+		if column.Expr == nil {
+			terms[i] = &ColumnExpr{Name: column.Name, P: column.Pos}
+		}
+		if err := bindColumns(terms[i], table, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := bindColumns(upsert.TargetWhere, table, ""); err != nil {
+		return nil, err
+	}
+	if err := checkRowExpr(upsert.TargetWhere); err != nil {
+		return nil, err
+	}
+	if column, ok := terms[0].(*ColumnExpr); ok && len(terms) == 1 && upsert.Target[0].Collate == "" &&
+		(column.Index < 0 || column.Index == table.RowidAlias) {
+		return nil, nil
+	}
+	collation := func(name string) string {
+		if name == "" {
+			return "BINARY"
+		}
+		return strings.ToUpper(name)
+	}
+	for _, index := range schema.indexesOn(table.Name) {
+		if !index.Unique || len(index.Keys) != len(terms) {
+			continue
+		}
+		if index.Where != nil {
+			if err := bindColumns(index.Where, table, ""); err != nil {
+				return nil, err
+			}
+			if upsert.TargetWhere == nil || !sameExpr(upsert.TargetWhere, index.Where) {
+				continue
+			}
+		}
+		matched := true
+		for _, key := range index.Keys {
+			keyExpr, keyCollation := key.Expr, key.Collate
+			if keyExpr == nil {
+				keyExpr = &ColumnExpr{Name: key.Name}
+				if column := table.columnIndex(key.Name); column >= 0 && keyCollation == "" {
+					keyCollation = table.Columns[column].Collate
+				}
+			}
+			if err := bindColumns(keyExpr, table, ""); err != nil {
+				return nil, err
+			}
+			found := false
+			for i, term := range terms {
+				termCollation := upsert.Target[i].Collate
+				if sameExpr(term, keyExpr) && (termCollation == "" || collation(termCollation) == collation(keyCollation)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return index, nil
+		}
+	}
+	return nil, &ErrPrepare{Msg: "ON CONFLICT clause does not match any PRIMARY KEY or UNIQUE constraint"}
+}
+
+// sameExpr reports whether two bound expressions are the same but for where they were written.
+func sameExpr(a Expr, b Expr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	switch x := a.(type) {
+	case *LiteralExpr:
+		y, ok := b.(*LiteralExpr)
+		if !ok {
+			return false
+		}
+		if xb, isBlob := x.Value.([]byte); isBlob {
+			yb, isBlob := y.Value.([]byte)
+			return isBlob && bytes.Equal(xb, yb)
+		}
+		return x.Value == y.Value
+	case *ColumnExpr:
+		y, ok := b.(*ColumnExpr)
+		return ok && x.Index == y.Index && x.Excluded == y.Excluded
+	case *UnaryExpr:
+		y, ok := b.(*UnaryExpr)
+		if !ok || !strings.EqualFold(x.Op, y.Op) {
+			return false
+		}
+	case *BinaryExpr:
+		y, ok := b.(*BinaryExpr)
+		if !ok || !strings.EqualFold(x.Op, y.Op) {
+			return false
+		}
+	case *LikeExpr:
+		y, ok := b.(*LikeExpr)
+		if !ok || !strings.EqualFold(x.Op, y.Op) || x.Not != y.Not {
+			return false
+		}
+	case *InExpr:
+		y, ok := b.(*InExpr)
+		if !ok || x.Not != y.Not {
+			return false
+		}
+	case *BetweenExpr:
+		y, ok := b.(*BetweenExpr)
+		if !ok || x.Not != y.Not {
+			return false
+		}
+	case *FuncExpr:
+		y, ok := b.(*FuncExpr)
+		if !ok || !strings.EqualFold(x.Name, y.Name) || x.Star != y.Star || x.Distinct != y.Distinct {
+			return false
+		}
+	case *CaseExpr:
+		if _, ok := b.(*CaseExpr); !ok {
+			return false
+		}
+	case *CastExpr:
+		y, ok := b.(*CastExpr)
+		if !ok || !strings.EqualFold(x.Type, y.Type) {
+			return false
+		}
+	case *CollateExpr:
+		y, ok := b.(*CollateExpr)
+		if !ok || !strings.EqualFold(x.Collation, y.Collation) {
+			return false
+		}
+	default:
+		return false
+	}
+	aChildren, bChildren := exprChildren(a), exprChildren(b)
+	if len(aChildren) != len(bChildren) {
+		return false
+	}
+	for i := range aChildren {
+		if !sameExpr(aChildren[i], bChildren[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// upsertConflict finds the upsert clause catching a conflict of a row about to be inserted
+// under rowid, and the existing row it conflicts with. Like SQLite, the constraints the
+// clauses name are checked first, in clause order; a clause without a target then catches the
+// first conflict in the order claimKeys checks them.
+func (writer *tableWriter) upsertConflict(row []interface{}, rowid int64) (*upsertClause, int64, bool, error) {
+	for i := range writer.upserts {
+		clause := &writer.upserts[i]
+		if clause.anyConflict {
+			continue
+		}
+		if other, found, err := writer.conflictOver(clause.index, row, rowid); err != nil || found {
+			return clause, other, found, err
+		}
+	}
+	if len(writer.upserts) == 0 || !writer.upserts[len(writer.upserts)-1].anyConflict {
+		return nil, 0, false, nil
+	}
+	clause := &writer.upserts[len(writer.upserts)-1]
+	if other, found, err := writer.conflictOver(nil, row, rowid); err != nil || found {
+		return clause, other, found, err
+	}
+	indexes := writer.schema.indexesOn(writer.table.Name)
+	for i := len(indexes) - 1; i >= 0; i-- {
+		if !indexes[i].Unique {
+			continue
+		}
+		if other, found, err := writer.conflictOver(indexes[i], row, rowid); err != nil || found {
+			return clause, other, found, err
+		}
+	}
+	return nil, 0, false, nil
+}
+
+// upsert resolves the conflict of a row that was to be inserted under rowid with the existing
+// row other as the clause says: by leaving the new row out, or by updating the existing row
+// with the new one as "excluded" when the WHERE clause of the update allows it.
+func (writer *tableWriter) upsert(clause *upsertClause, other int64, row []interface{}, rowid int64) error {
+	if clause.set == nil {
+		return nil
+	}
+	existing, found, err := writer.readRow(other)
+	if err != nil || !found {
+		return err
+	}
+	excluded := &evalContext{table: writer.table, values: row, rowid: rowid, encoding: writer.pager.encoding}
+	if clause.where != nil {
+		value, err := evalExpr(clause.where, &evalContext{table: writer.table, values: existing, rowid: other, excluded: excluded, encoding: writer.pager.encoding})
+		if err != nil {
+			return err
+		}
+		if ok, _ := truth(value); !ok {
+			return nil
+		}
+	}
+	//!The update resolves its own conflicts with ABORT, whatever the INSERT says.
+	return writer.update(existing, other, clause.targets, clause.set, "ABORT", excluded)
+}
@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+func TestUpsert(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string
+		sql   []string
+		want  string
+		code  int
+	}{
+		{"do update with excluded",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"insert into t values ('a', 5), ('b', 2) on conflict(k) do update set n = n + excluded.n",
+				"select * from t order by k"},
+			"a|6 b|2", 0},
+		{"do nothing",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"insert into t values ('a', 5), ('b', 2) on conflict do nothing", "select * from t order by k"},
+			"a|1 b|2", 0},
+		{"do update where",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"insert into t values ('a', 5) on conflict(k) do update set n = excluded.n where excluded.n > t.n",
+				"insert into t values ('a', 3) on conflict(k) do update set n = excluded.n where excluded.n > t.n",
+				"select * from t"},
+			"a|5", 0},
+		{"do nothing for another constraint",
+			[]string{"create table t(id integer primary key, k unique, n)", "insert into t values (1, 'a', 1)"},
+			[]string{"insert into t values (2, 'a', 9) on conflict(id) do nothing"},
+			"", resultConstraint},
+		{"do update for another constraint",
+			[]string{"create table t(id integer primary key, k unique, n)", "insert into t values (1, 'a', 1)"},
+			[]string{"insert into t values (1, 'b', 9) on conflict(k) do update set n = 0"},
+			"", resultConstraint},
+		{"one clause per constraint",
+			[]string{"create table t(id integer primary key, k unique, n)", "insert into t values (1, 'a', 1)"},
+			[]string{"insert into t values (1, 'b', 9) on conflict(id) do update set n = excluded.n on conflict(k) do nothing",
+				"insert into t values (5, 'a', 7) on conflict(id) do update set n = excluded.n on conflict(k) do nothing",
+				"select * from t"},
+			"1|a|9", 0},
+		{"target without a constraint",
+			[]string{"create table t(id integer primary key, k unique, n)", "insert into t values (1, 'a', 1)"},
+			[]string{"insert into t values (1, 'b', 9) on conflict(n) do nothing"},
+			"", 1},
+		{"do update checks constraints",
+			[]string{"create table t(k text primary key, n int check (n < 10))", "insert into t values ('a', 1)"},
+			[]string{"insert into t values ('a', 1) on conflict(k) do update set n = 50"},
+			"", resultConstraint},
+		{"upsert from a select",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"insert into t select 'a', 2 where true on conflict(k) do update set n = excluded.n + t.n",
+				"select * from t"},
+			"a|3", 0},
+	}
+	for _, test := range tests {
+		if got, code := runCase(t, test.setup, test.sql...); got != test.want || code != test.code {
+			t.Errorf("%s: got %q, exit code %d; want %q, %d", test.name, got, code, test.want, test.code)
+		}
+	}
+}
+
+func TestReturning(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string
+		sql   []string
+		want  string
+		code  int
+	}{
+		{"insert returning",
+			[]string{"create table t(k text primary key, n int)"},
+			[]string{"insert into t values ('a', 1), ('b', 2) returning k, n * 10",
+				"insert into t values ('a', 5) on conflict(k) do update set n = 7 returning *"},
+			"a|10 b|20 a|7", 0},
+		{"update and delete returning",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1), ('b', 2)"},
+			[]string{"update t set n = n + 1 returning k, n", "delete from t where k = 'a' returning *",
+				"select * from t"},
+			"a|2 b|3 a|2 b|3", 0},
+		{"returning the rowid",
+			[]string{"create table t(id integer primary key, n int)"},
+			[]string{"insert into t(n) values (3) returning id, rowid, n as v"},
+			"1|1|3", 0},
+		{"nothing to return",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"insert into t values ('a', 2) on conflict do nothing returning *", "select count(*) from t"},
+			"1", 0},
+		{"no row updated",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"update t set n = 2 where k = 'zz' returning *", "select n from t"},
+			"1", 0},
+		{"returning a missing column",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{"insert into t values ('b', 1) returning nosuch"},
+			"", 1},
+		{"returning with headers",
+			[]string{"create table t(k text primary key, n int)", "insert into t values ('a', 1)"},
+			[]string{".headers on", "delete from t returning k as key, n"},
+			"key|n a|1", 0},
+	}
+	for _, test := range tests {
+		if got, code := runCase(t, test.setup, test.sql...); got != test.want || code != test.code {
+			t.Errorf("%s: got %q, exit code %d; want %q, %d", test.name, got, code, test.want, test.code)
+		}
+	}
+}
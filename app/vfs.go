@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// LockLevel is one of SQLite's file lock levels, from no lock up to an exclusive lock.
+type LockLevel int
+
+const (
+	LockNone LockLevel = iota
+	LockShared
+	LockReserved
+	LockPending
+	LockExclusive
+)
+
+// OpenFlags say how a VFS should open a file.
+type OpenFlags int
+
+const (
+	OpenReadOnly OpenFlags = 1 << iota
+	OpenReadWrite
+	OpenCreate //!With OpenReadWrite, create the file when it does not exist.
+)
+
+// File is an open database file. All pager I/O goes through it.
+type File interface {
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Size() (int64, error)
+	Sync() error
+	Truncate(size int64) error
+	Lock(level LockLevel) error       //!Raise the lock to level, or return ErrBusy.
+	Unlock(level LockLevel) error     //!Lower the lock to level, which is LockShared or LockNone.
+	CheckReservedLock() (bool, error) //!Whether any connection holds a RESERVED or higher lock.
+	Close() error
+}
+
+// RangeLock is the state of a lock on a byte range of a file.
+type RangeLock int
+
+const (
+	RangeUnlocked RangeLock = iota
+	RangeShared
+	RangeExclusive
+)
+
+// RangeLocker is implemented by files other processes may have open, to lock byte ranges of
+// them like SQLite does. WAL mode locks parts of the -shm file with it; when the file does not
+// implement it, no other process can be using the database.
+type RangeLocker interface {
+	LockRange(mode RangeLock, offset int64, n int64) error //!Returns ErrBusy rather than waiting.
+}
+
+// VFS opens files by name. It lets the engine read databases that are not on local disk.
+type VFS interface {
+	Open(name string, flags OpenFlags) (File, error)
+	Delete(name string) error
+	Exists(name string) (bool, error)
+}
+
+// ErrReadOnly is returned when writing to a file opened read-only or backed by a read-only
+// source.
+var ErrReadOnly = errors.New("attempt to write a readonly database")
+
+// ErrBusy is returned when a lock cannot be taken because another connection holds a
+// conflicting one.
+var ErrBusy = &ErrResult{Code: resultBusy, Msg: "database is locked"}
+
+var (
+	vfsRegistryMu sync.Mutex
+	vfsRegistry   = map[string]VFS{}
+)
+
+// RegisterVFS makes a VFS available by name, as for the -vfs option.
+func RegisterVFS(name string, vfs VFS) {
+	vfsRegistryMu.Lock()
+	defer vfsRegistryMu.Unlock()
+	vfsRegistry[name] = vfs
+}
+
+// findVFS returns the VFS registered under name; an empty name is the OS file system.
+func findVFS(name string) (VFS, error) {
+	if name == "" {
+		name = "os"
+	}
+	vfsRegistryMu.Lock()
+	defer vfsRegistryMu.Unlock()
+	vfs, ok := vfsRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("no such VFS: \"%s\"", name)
+	}
+	return vfs, nil
+}
+
+func init() {
+	RegisterVFS("os", OSVFS{})
+	RegisterVFS("memdb", NewMemVFS())
+}
+
+// OSVFS opens files on the local file system.
+type OSVFS struct{}
+
+func (OSVFS) Open(name string, flags OpenFlags) (File, error) {
+	mode := os.O_RDONLY
+	if flags&OpenReadWrite != 0 {
+		mode = os.O_RDWR
+		if flags&OpenCreate != 0 {
+			mode |= os.O_CREATE
+		}
+	}
+	f, err := os.OpenFile(name, mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{f: f, readOnly: flags&OpenReadWrite == 0}, nil
+}
+
+func (OSVFS) Delete(name string) error {
+	return os.Remove(name)
+}
+
+func (OSVFS) Exists(name string) (bool, error) {
+	_, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// The bytes of a database file that SQLite takes its locks on, in the page that holds
+// pendingByte: readers lock one of the shared bytes, or all of them to exclude everyone.
+const (
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = 510
+)
+
+// osFile is a File on the local file system. Its locks are the POSIX advisory locks SQLite
+// takes, so that sqlite3 processes and this one can use a database at the same time.
+type osFile struct {
+	f        *os.File
+	readOnly bool
+	lock     LockLevel
+}
+
+func (file *osFile) ReadAt(p []byte, off int64) (int, error) {
+	return file.f.ReadAt(p, off)
+}
+
+func (file *osFile) WriteAt(p []byte, off int64) (int, error) {
+	if file.readOnly {
+		return 0, ErrReadOnly
+	}
+	return file.f.WriteAt(p, off)
+}
+
+func (file *osFile) Size() (int64, error) {
+	info, err := file.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (file *osFile) Sync() error {
+	return file.f.Sync()
+}
+
+func (file *osFile) Truncate(size int64) error {
+	if file.readOnly {
+		return ErrReadOnly
+	}
+	return file.f.Truncate(size)
+}
+
+// Lock raises the lock one level at a time like SQLite: a SHARED lock is a read lock on the
+// shared bytes, taken while holding the pending byte so that it cannot slip in once a writer
+// waits for an EXCLUSIVE lock; RESERVED is a write lock on the reserved byte; PENDING one on
+// the pending byte; and EXCLUSIVE one on the shared bytes. A failure leaves the levels already
+// reached held.
+func (file *osFile) Lock(level LockLevel) error {
+	if file.lock == LockNone && level >= LockShared {
+		if err := lockRange(file.f, RangeShared, pendingByte, 1); err != nil {
+			return err
+		}
+		err := lockRange(file.f, RangeShared, sharedFirst, sharedSize)
+		if unlockErr := lockRange(file.f, RangeUnlocked, pendingByte, 1); err == nil {
+			err = unlockErr
+		}
+		if err != nil {
+			return err
+		}
+		file.lock = LockShared
+	}
+	if file.lock == LockShared && level >= LockReserved {
+		if err := lockRange(file.f, RangeExclusive, reservedByte, 1); err != nil {
+			return err
+		}
+		file.lock = LockReserved
+	}
+	if file.lock == LockReserved && level >= LockPending {
+		if err := lockRange(file.f, RangeExclusive, pendingByte, 1); err != nil {
+			return err
+		}
+		file.lock = LockPending
+	}
+	if file.lock == LockPending && level == LockExclusive {
+		if err := lockRange(file.f, RangeExclusive, sharedFirst, sharedSize); err != nil {
+			return err
+		}
+		file.lock = LockExclusive
+	}
+	return nil
+}
+
+func (file *osFile) Unlock(level LockLevel) error {
+	if level >= file.lock {
+		return nil
+	}
+	if level == LockNone {
+		file.lock = LockNone
+		return lockRange(file.f, RangeUnlocked, 0, 0)
+	}
+	if file.lock == LockExclusive {
+		if err := lockRange(file.f, RangeShared, sharedFirst, sharedSize); err != nil {
+			return err
+		}
+	}
+	file.lock = LockShared
+	return lockRange(file.f, RangeUnlocked, pendingByte, 2)
+}
+
+func (file *osFile) CheckReservedLock() (bool, error) {
+	if file.lock >= LockReserved {
+		return true, nil
+	}
+	return rangeLocked(file.f, reservedByte, 1)
+}
+
+func (file *osFile) LockRange(mode RangeLock, offset int64, n int64) error {
+	return lockRange(file.f, mode, offset, n)
+}
+
+func (file *osFile) Close() error {
+	return file.f.Close()
+}
+
+// MemVFS keeps files as byte slices in memory. Files persist in the MemVFS after they are
+// closed, until they are deleted.
+type MemVFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+}
+
+// memData is the content of one in-memory file, shared by every handle open on it.
+type memData struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func NewMemVFS() *MemVFS {
+	return &MemVFS{files: map[string]*memData{}}
+}
+
+// Store replaces the content of the named file, for example with a database read from
+// elsewhere.
+func (vfs *MemVFS) Store(name string, data []byte) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	vfs.files[name] = &memData{data: data}
+}
+
+func (vfs *MemVFS) Open(name string, flags OpenFlags) (File, error) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	data, ok := vfs.files[name]
+	if !ok {
+		if flags&OpenCreate == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		data = &memData{}
+		vfs.files[name] = data
+	}
+	return &memFile{content: data, readOnly: flags&OpenReadWrite == 0}, nil
+}
+
+func (vfs *MemVFS) Delete(name string) error {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	if _, ok := vfs.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(vfs.files, name)
+	return nil
+}
+
+func (vfs *MemVFS) Exists(name string) (bool, error) {
+	vfs.mu.Lock()
+	defer vfs.mu.Unlock()
+	_, ok := vfs.files[name]
+	return ok, nil
+}
+
+// memFile is a handle on a file of a MemVFS.
+type memFile struct {
+	content  *memData
+	readOnly bool
+	lock     LockLevel
+}
+
+func (file *memFile) ReadAt(p []byte, off int64) (int, error) {
+	file.content.mu.RLock()
+	defer file.content.mu.RUnlock()
+	return readAtBytes(file.content.data, p, off)
+}
+
+func (file *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if file.readOnly {
+		return 0, ErrReadOnly
+	}
+	file.content.mu.Lock()
+	defer file.content.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(file.content.data)) {
+		grown := make([]byte, end)
+		copy(grown, file.content.data)
+		file.content.data = grown
+	}
+	return copy(file.content.data[off:], p), nil
+}
+
+func (file *memFile) Size() (int64, error) {
+	file.content.mu.RLock()
+	defer file.content.mu.RUnlock()
+	return int64(len(file.content.data)), nil
+}
+
+func (file *memFile) Sync() error {
+	return nil
+}
+
+func (file *memFile) Truncate(size int64) error {
+	if file.readOnly {
+		return ErrReadOnly
+	}
+	file.content.mu.Lock()
+	defer file.content.mu.Unlock()
+	if size < int64(len(file.content.data)) {
+		file.content.data = file.content.data[:size:size]
+	} else {
+		grown := make([]byte, size)
+		copy(grown, file.content.data)
+		file.content.data = grown
+	}
+	return nil
+}
+
+func (file *memFile) Lock(level LockLevel) error {
+	if level > file.lock {
+		file.lock = level
+	}
+	return nil
+}
+
+func (file *memFile) Unlock(level LockLevel) error {
+	if level < file.lock {
+		file.lock = level
+	}
+	return nil
+}
+
+func (file *memFile) CheckReservedLock() (bool, error) {
+	return file.lock >= LockReserved, nil
+}
+
+func (file *memFile) Close() error {
+	return nil
+}
+
+// readAtBytes implements io.ReaderAt over a byte slice.
+func readAtBytes(data []byte, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ReaderAtFile is a read-only File over any io.ReaderAt, such as a file of an embed.FS.
+type ReaderAtFile struct {
+	r    io.ReaderAt
+	size int64
+	lock LockLevel
+}
+
+// NewReaderAtFile returns a read-only File reading size bytes from r.
+func NewReaderAtFile(r io.ReaderAt, size int64) *ReaderAtFile {
+	return &ReaderAtFile{r: r, size: size}
+}
+
+func (file *ReaderAtFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= file.size {
+		return 0, io.EOF
+	}
+	if remaining := file.size - off; int64(len(p)) > remaining {
+		n, err := file.r.ReadAt(p[:remaining], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return file.r.ReadAt(p, off)
+}
+
+func (file *ReaderAtFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (file *ReaderAtFile) Size() (int64, error) {
+	return file.size, nil
+}
+
+func (file *ReaderAtFile) Sync() error {
+	return nil
+}
+
+func (file *ReaderAtFile) Truncate(size int64) error {
+	return ErrReadOnly
+}
+
+func (file *ReaderAtFile) Lock(level LockLevel) error {
+	if level > LockShared {
+		return ErrReadOnly
+	}
+	file.lock = level
+	return nil
+}
+
+func (file *ReaderAtFile) Unlock(level LockLevel) error {
+	if level < file.lock {
+		file.lock = level
+	}
+	return nil
+}
+
+func (file *ReaderAtFile) CheckReservedLock() (bool, error) {
+	return false, nil
+}
+
+func (file *ReaderAtFile) Close() error {
+	if closer, ok := file.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// FSVFS opens read-only databases from an fs.FS, such as an embed.FS or a *zip.Reader. Files
+// that do not support random access, like compressed zip entries, are read into memory.
+type FSVFS struct {
+	FS fs.FS
+}
+
+func (vfs FSVFS) Open(name string, flags OpenFlags) (File, error) {
+	if flags&OpenReadWrite != 0 {
+		return nil, ErrReadOnly
+	}
+	f, err := vfs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if r, ok := f.(io.ReaderAt); ok {
+		return NewReaderAtFile(r, info.Size()), nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewReaderAtFile(bytes.NewReader(data), int64(len(data))), nil
+}
+
+func (vfs FSVFS) Delete(name string) error {
+	return ErrReadOnly
+}
+
+func (vfs FSVFS) Exists(name string) (bool, error) {
+	_, err := fs.Stat(vfs.FS, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
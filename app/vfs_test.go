@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemVFS(t *testing.T) {
+	vfs := NewMemVFS()
+	RegisterVFS("mem", vfs)
+	if _, code := runShell("test.db", "mem", "create table t(a integer primary key, b text)", "create index tb on t(b)",
+		"insert into t(b) values ('x'), ('y')", "begin", "insert into t(b) values ('z')", "rollback"); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if out, _ := runShell("test.db", "mem", "select group_concat(b) from t"); out != "x,y\n" {
+		t.Errorf("rows after the rollback: %q", out)
+	}
+	if exists, err := vfs.Exists("test.db-journal"); exists || err != nil {
+		t.Errorf("journal left after the rollback: %v, %v", exists, err)
+	}
+
+	//!The file is an ordinary database.
+	file, err := vfs.Open("test.db", OpenReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, _ := file.Size()
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.db")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	checkIntegrity(t, path)
+
+	//!A read-only handle refuses writes, and reads past the end stop at it.
+	if _, err := file.WriteAt([]byte{1}, 0); err != ErrReadOnly {
+		t.Errorf("WriteAt on a read-only handle: %v", err)
+	}
+	if err := file.Truncate(0); err != ErrReadOnly {
+		t.Errorf("Truncate on a read-only handle: %v", err)
+	}
+	if n, err := file.ReadAt(make([]byte, 10), size-4); n != 4 || err != io.EOF {
+		t.Errorf("ReadAt across the end: %d, %v", n, err)
+	}
+
+	//!Opening creates a file only when asked to, and Delete removes it.
+	if _, err := vfs.Open("other.db", OpenReadWrite); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("opening a missing file: %v", err)
+	}
+	other, err := vfs.Open("other.db", OpenReadWrite|OpenCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.WriteAt([]byte("abc"), 2)
+	other.Truncate(4)
+	got := make([]byte, 4)
+	if _, err := other.ReadAt(got, 0); err != nil || !bytes.Equal(got, []byte("\x00\x00ab")) {
+		t.Errorf("after WriteAt and Truncate: %q, %v", got, err)
+	}
+	if err := vfs.Delete("other.db"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := vfs.Exists("other.db"); exists {
+		t.Error("other.db exists after Delete")
+	}
+	if err := vfs.Delete("other.db"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+}
+
+func TestReaderAtFile(t *testing.T) {
+	//!Only the first size bytes of the reader belong to the file.
+	file := NewReaderAtFile(strings.NewReader("abcdefgh"), 6)
+	p := make([]byte, 4)
+	if n, err := file.ReadAt(p, 4); n != 2 || err != io.EOF || string(p[:n]) != "ef" {
+		t.Errorf("ReadAt across the end: %d, %v, %q", n, err, p[:n])
+	}
+	if n, err := file.ReadAt(p, 6); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end: %d, %v", n, err)
+	}
+	if n, err := file.ReadAt(p, 1); n != 4 || err != nil || string(p) != "bcde" {
+		t.Errorf("ReadAt inside: %d, %v, %q", n, err, p)
+	}
+	if _, err := file.WriteAt(p, 0); err != ErrReadOnly {
+		t.Errorf("WriteAt: %v", err)
+	}
+	if err := file.Lock(LockShared); err != nil {
+		t.Errorf("Lock(SHARED): %v", err)
+	}
+	if err := file.Lock(LockReserved); err != ErrReadOnly {
+		t.Errorf("Lock(RESERVED): %v", err)
+	}
+}
+
+func TestFSVFS(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(a)", "insert into t values (1), (2), (3)")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//!A compressed zip entry cannot be read at an offset, so it is read into memory.
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: "test.db", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipFS, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, fsys := range map[string]fs.FS{"map": fstest.MapFS{"test.db": {Data: data}}, "zip": zipFS} {
+		t.Run(name, func(t *testing.T) {
+			RegisterVFS("fs", FSVFS{FS: fsys})
+			if out, code := runShell("test.db", "fs", "select sum(a) from t"); out != "6\n" || code != 0 {
+				t.Errorf("select: %q, exit code %d", out, code)
+			}
+			var code int
+			captureStderr(t, func() {
+				_, code = runShell("test.db", "fs", "insert into t values (4)")
+			})
+			if code != resultReadOnly {
+				t.Errorf("insert: exit code %d, want %d", code, resultReadOnly)
+			}
+			vfs := FSVFS{FS: fsys}
+			if exists, err := vfs.Exists("missing.db"); exists || err != nil {
+				t.Errorf("Exists(missing.db) = %v, %v", exists, err)
+			}
+			if _, err := vfs.Open("test.db", OpenReadWrite); err != ErrReadOnly {
+				t.Errorf("opening for writing: %v", err)
+			}
+		})
+	}
+}
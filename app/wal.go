@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagic           = 0x377f0682 //!With the low bit set, checksums are computed over big-endian words.
+	walFormatVersion   = 3007000
+)
+
+// walIndex maps pages to the frames of the write-ahead log that hold their newest committed
+// version, like the wal-index SQLite keeps in the -shm file. It is built once per snapshot:
+// frames appended after it was built are not seen until the log is read again.
+type walIndex struct {
+	file      File
+	size      int64           //!Size of the log when it was read.
+	header    []byte          //!The log header as read, to notice a reset by a checkpoint.
+	valid     bool            //!The header is intact and written for this page size.
+	frames    map[int64]int64 //!Page number to the offset of the page content in the log.
+	dbSize    int64           //!Database size in pages as of the last commit, 0 before the first one.
+	pages     []int64         //!Page number of every frame up to the last commit, frame 1 first.
+	checksum  [2]uint32       //!Running checksum as of the last commit frame.
+	bigEndian bool            //!Checksums are computed over big-endian words.
+	shm       walShm          //!Checkpoint state shared through the -shm file.
+}
+
+// walChecksum extends the running checksum (s0, s1) over data, a whole number of 8-byte
+// chunks, as described in the file format.
+func walChecksum(data []byte, bigEndian bool, s0, s1 uint32) (uint32, uint32) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// readWAL indexes the frames of the log up to its last valid commit frame. Like SQLite, it
+// treats a log with a bad header, or written for another page size, as empty, and ignores
+// every frame from the first one whose salt or checksum does not match.
+func readWAL(file File, pageSize int64) (*walIndex, error) {
+	size, err := file.Size()
+	if err != nil {
+		return nil, err
+	}
+	wal := &walIndex{file: file, size: size, frames: map[int64]int64{}}
+	if size < walHeaderSize {
+		return wal, nil
+	}
+	header := make([]byte, walHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	wal.header = header
+	be := binary.BigEndian
+	magic := be.Uint32(header)
+	if magic&^1 != walMagic || be.Uint32(header[4:]) != walFormatVersion || int64(be.Uint32(header[8:])) != pageSize {
+		return wal, nil
+	}
+	bigEndian := magic&1 == 1
+	s0, s1 := walChecksum(header[:24], bigEndian, 0, 0)
+	if s0 != be.Uint32(header[24:]) || s1 != be.Uint32(header[28:]) {
+		return wal, nil
+	}
+	wal.valid, wal.bigEndian = true, bigEndian
+
+	//!Frames of a transaction only become visible once its commit frame is reached.
+	pending := map[int64]int64{}
+	var pendingPages []int64
+	frame := make([]byte, walFrameHeaderSize+pageSize)
+	for offset := int64(walHeaderSize); offset+int64(len(frame)) <= size; offset += int64(len(frame)) {
+		if _, err := file.ReadAt(frame, offset); err != nil {
+			return nil, err
+		}
+		pageNo := int64(be.Uint32(frame))
+		if pageNo == 0 || !bytes.Equal(frame[8:16], header[16:24]) {
+			break
+		}
+		s0, s1 = walChecksum(frame[:8], bigEndian, s0, s1)
+		s0, s1 = walChecksum(frame[walFrameHeaderSize:], bigEndian, s0, s1)
+		if s0 != be.Uint32(frame[16:]) || s1 != be.Uint32(frame[20:]) {
+			break
+		}
+		pending[pageNo] = offset + walFrameHeaderSize
+		pendingPages = append(pendingPages, pageNo)
+		if commitSize := be.Uint32(frame[4:]); commitSize != 0 {
+			for pageNo, frameOffset := range pending {
+				wal.frames[pageNo] = frameOffset
+			}
+			clear(pending)
+			wal.pages = append(wal.pages, pendingPages...)
+			pendingPages = pendingPages[:0]
+			wal.dbSize = int64(commitSize)
+			wal.checksum = [2]uint32{s0, s1}
+		}
+	}
+	return wal, nil
+}
+
+// changed reports whether the log was appended to or reset since it was indexed.
+func (wal *walIndex) changed() (bool, error) {
+	size, err := wal.file.Size()
+	if err != nil {
+		return false, err
+	}
+	if size != wal.size {
+		return true, nil
+	}
+	if size < walHeaderSize {
+		return false, nil
+	}
+	header := make([]byte, walHeaderSize)
+	if _, err := wal.file.ReadAt(header, 0); err != nil {
+		return false, err
+	}
+	return !bytes.Equal(header, wal.header), nil
+}
+
+// frameSize is the size of a frame of the log: its header and a page.
+func (pager *Pager) frameSize() int64 {
+	return walFrameHeaderSize + pager.pageSize
+}
+
+// restartWAL returns the header a log starts over with, so that the frames left in the file
+// no longer pass as part of it. Like SQLite, the checkpoint sequence number and the first salt
+// go up by one from the previous header, and the second salt is new; the first log of a file
+// gets random salts.
+func (pager *Pager) restartWAL(wal *walIndex) []byte {
+	be := binary.BigEndian
+	header := make([]byte, walHeaderSize)
+	magic := uint32(walMagic)
+	if nativeBigEndian {
+		magic |= 1
+	}
+	be.PutUint32(header, magic)
+	be.PutUint32(header[4:], walFormatVersion)
+	be.PutUint32(header[8:], uint32(pager.pageSize))
+	if wal.valid {
+		be.PutUint32(header[12:], be.Uint32(wal.header[12:])+1)
+		be.PutUint32(header[16:], be.Uint32(wal.header[16:])+1)
+	} else {
+		be.PutUint32(header[16:], rand.Uint32())
+	}
+	be.PutUint32(header[20:], rand.Uint32())
+	s0, s1 := walChecksum(header[:24], nativeBigEndian, 0, 0)
+	be.PutUint32(header[24:], s0)
+	be.PutUint32(header[28:], s1)
+	return header
+}
+
+// restartedIndex is the index of the log of wal once it starts over with header.
+func restartedIndex(wal *walIndex, header []byte) *walIndex {
+	be := binary.BigEndian
+	restarted := &walIndex{file: wal.file, size: wal.size, header: header, valid: true, frames: map[int64]int64{}}
+	restarted.bigEndian = be.Uint32(header)&1 == 1
+	restarted.checksum = [2]uint32{be.Uint32(header[24:]), be.Uint32(header[28:])}
+	restarted.shm = recoveredShm(restarted)
+	restarted.shm.changes = wal.shm.changes
+	restarted.shm.readMarks[1] = 0
+	return restarted
+}
+
+// beginWALWrite takes the WAL write lock, which one connection at a time holds while it
+// appends to the log, waiting for up to busy_timeout. Like SQLite, it fails when another
+// connection committed since this one started reading, as the transaction would otherwise be
+// built on pages that are no longer the newest: with ErrBusy in a transaction opened with
+// BEGIN, and with errStaleRead otherwise, so that the statement runs again.
+func (pager *Pager) beginWALWrite() error {
+	if locker, ok := pager.shm.(RangeLocker); ok {
+		if err := pager.retryBusy(func() error { return locker.LockRange(RangeExclusive, walWriteLock, 1) }); err != nil {
+			return err
+		}
+		pager.walWriter = true
+	}
+	changed, err := pager.wal.changed()
+	if err == nil && changed {
+		err = errStaleRead
+		if pager.explicit {
+			err = ErrBusy
+		}
+	}
+	if err != nil {
+		pager.endWALWrite()
+	}
+	return err
+}
+
+// endWALWrite releases the WAL write lock.
+func (pager *Pager) endWALWrite() {
+	if !pager.walWriter {
+		return
+	}
+	if locker, ok := pager.shm.(RangeLocker); ok {
+		locker.LockRange(RangeUnlocked, walWriteLock, 1)
+	}
+	pager.walWriter = false
+}
+
+// writeWAL appends the given pages of the open write transaction to the log as one
+// transaction, the last frame carrying the database size that commits it, and syncs the log.
+// A log that was entirely checkpointed is started over from its beginning instead. The
+// wal-index is then brought up to date, in memory and in the -shm file.
+func (pager *Pager) writeWAL(pageNos []int64) error {
+	wal := pager.wal
+	//!A log that was entirely checkpointed can only start over once nobody reads its frames.
+	restart := len(wal.pages) == 0
+	if !restart && wal.shm.backfilled == int64(len(wal.pages)) {
+		locked, err := pager.lockReaders(false)
+		if err != nil {
+			return err
+		}
+		if locked {
+			defer pager.unlockReaders()
+		}
+		restart = locked
+	}
+	if restart {
+		header := wal.header
+		if !wal.valid || len(wal.pages) > 0 {
+			header = pager.restartWAL(wal)
+		}
+		wal = restartedIndex(wal, header)
+		pager.wal = wal
+		if _, err := wal.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+	}
+	be := binary.BigEndian
+	header := wal.header
+
+	//!The index is only updated once the frames are durable.
+	frames := map[int64]int64{}
+	frame := make([]byte, pager.frameSize())
+	offset := walHeaderSize + int64(len(wal.pages))*pager.frameSize()
+	s0, s1 := wal.checksum[0], wal.checksum[1]
+	for i, pageNo := range pageNos {
+		commitSize := uint32(0)
+		if i == len(pageNos)-1 {
+			commitSize = uint32(pager.dbSize)
+		}
+		be.PutUint32(frame, uint32(pageNo))
+		be.PutUint32(frame[4:], commitSize)
+		copy(frame[8:16], header[16:24])
+		copy(frame[walFrameHeaderSize:], pager.dirty[pageNo])
+		s0, s1 = walChecksum(frame[:8], wal.bigEndian, s0, s1)
+		s0, s1 = walChecksum(frame[walFrameHeaderSize:], wal.bigEndian, s0, s1)
+		be.PutUint32(frame[16:], s0)
+		be.PutUint32(frame[20:], s1)
+		if _, err := wal.file.WriteAt(frame, offset); err != nil {
+			return err
+		}
+		frames[pageNo] = offset + walFrameHeaderSize
+		offset += int64(len(frame))
+	}
+	if err := wal.file.Sync(); err != nil {
+		return err
+	}
+
+	size, err := wal.file.Size()
+	if err != nil {
+		return err
+	}
+	for pageNo, frameOffset := range frames {
+		wal.frames[pageNo] = frameOffset
+	}
+	wal.pages = append(wal.pages, pageNos...)
+	wal.size, wal.dbSize, wal.checksum = size, pager.dbSize, [2]uint32{s0, s1}
+	wal.shm.changes++
+	return pager.writeShm(wal, restart)
+}
+
+// Checkpoint modes, as for PRAGMA wal_checkpoint.
+const (
+	checkpointPassive  = "PASSIVE"
+	checkpointFull     = "FULL"
+	checkpointRestart  = "RESTART"
+	checkpointTruncate = "TRUNCATE"
+)
+
+// checkpoint copies the pages committed to the log into the database file and syncs it, like
+// PRAGMA wal_checkpoint, taking the locks SQLite's checkpoints take. Frames past the read mark
+// of another connection are not copied, as it may still need the pages they replace. FULL,
+// RESTART and TRUNCATE wait for up to busy_timeout for writers and such readers to finish;
+// RESTART and TRUNCATE then also wait until nobody reads the log, and TRUNCATE empties it. It
+// reports whether it could not do all it was asked for, and returns the frames in the log and
+// how many of them are checkpointed: -1 for both when the database is not in WAL mode or the
+// checkpoint could not start.
+func (pager *Pager) checkpoint(mode string) (bool, int64, int64, error) {
+	if pager.dirty != nil || pager.explicit {
+		return false, 0, 0, &ErrResult{Code: resultLocked, Msg: "database table is locked"}
+	}
+	wal := pager.wal
+	if wal == nil {
+		if pager.header.ReadVersion != 2 {
+			return false, -1, -1, nil
+		}
+		return false, 0, 0, nil
+	}
+	if pager.readOnly {
+		return false, 0, 0, ErrReadOnly
+	}
+	locker, shared := pager.shm.(RangeLocker)
+	if shared {
+		if err := locker.LockRange(RangeExclusive, walCheckpointLock, 1); err != nil {
+			if errors.Is(err, ErrBusy) {
+				return true, -1, -1, nil
+			}
+			return false, 0, 0, err
+		}
+		defer locker.LockRange(RangeUnlocked, walCheckpointLock, 1)
+	}
+	//!Only the newest log can be checkpointed.
+	if changed, err := wal.changed(); err != nil || changed {
+		return changed, -1, -1, err
+	}
+	busy := false
+	if mode != checkpointPassive && shared && !pager.walWriter {
+		err := pager.retryBusy(func() error { return locker.LockRange(RangeExclusive, walWriteLock, 1) })
+		if err == nil {
+			defer locker.LockRange(RangeUnlocked, walWriteLock, 1)
+		} else if errors.Is(err, ErrBusy) {
+			mode, busy = checkpointPassive, true
+		} else {
+			return false, 0, 0, err
+		}
+	}
+
+	mxFrame := int64(len(wal.pages))
+	safe := mxFrame
+	if shared && wal.shm.backfilled < mxFrame {
+		marks, err := pager.readMarks()
+		if err != nil {
+			return false, 0, 0, err
+		}
+		for i := 1; i < walReaders; i++ {
+			if i == pager.readMark || int64(marks[i]) >= safe {
+				continue
+			}
+			//!A mark nobody holds can be moved past the frames about to be copied.
+			lock := func() error { return locker.LockRange(RangeExclusive, walReadLock+int64(i), 1) }
+			if mode == checkpointPassive {
+				err = lock()
+			} else {
+				err = pager.retryBusy(lock)
+			}
+			if errors.Is(err, ErrBusy) {
+				safe = int64(marks[i])
+				continue
+			} else if err != nil {
+				return false, 0, 0, err
+			}
+			mark := uint32(readMarkNotUsed)
+			if i == 1 {
+				mark = uint32(safe)
+			}
+			err = pager.writeShmWord(100+4*int64(i), mark)
+			locker.LockRange(RangeUnlocked, walReadLock+int64(i), 1)
+			if err != nil {
+				return false, 0, 0, err
+			}
+		}
+	}
+
+	if wal.shm.backfilled < safe {
+		if err := pager.backfill(wal, safe, mode != checkpointPassive); err != nil {
+			if errors.Is(err, ErrBusy) {
+				return true, mxFrame, wal.shm.backfilled, nil
+			}
+			return false, 0, 0, err
+		}
+	}
+	if mode == checkpointPassive {
+		return busy, mxFrame, wal.shm.backfilled, nil
+	}
+	if wal.shm.backfilled < mxFrame {
+		return true, mxFrame, wal.shm.backfilled, nil
+	}
+	if mode == checkpointFull {
+		return busy, mxFrame, wal.shm.backfilled, nil
+	}
+	if locked, err := pager.lockReaders(true); err != nil || !locked {
+		return !locked, mxFrame, wal.shm.backfilled, err
+	}
+	defer pager.unlockReaders()
+	if mode == checkpointTruncate {
+		if err := wal.file.Truncate(0); err != nil {
+			return false, 0, 0, err
+		}
+		//!The next transaction writes the header the log would have started over with.
+		pager.wal = restartedIndex(wal, pager.restartWAL(wal))
+		pager.wal.size = 0
+		if err := pager.writeShm(pager.wal, true); err != nil {
+			return false, 0, 0, err
+		}
+		//!Nothing is left in the log for this connection to read.
+		if pager.readMark > 0 && shared {
+			pager.readMark = -1
+			if locker.LockRange(RangeShared, walReadLock, 1) == nil {
+				pager.readMark = 0
+			}
+		}
+		return false, 0, 0, nil
+	}
+	return busy, mxFrame, wal.shm.backfilled, nil
+}
+
+// backfill copies the newest version of every page the log holds in frames up to upTo into
+// the database file, and syncs it. Readers holding mark 0 read the database file alone, so the
+// copy waits for them, for up to busy_timeout when wait is set.
+func (pager *Pager) backfill(wal *walIndex, upTo int64, wait bool) error {
+	if locker, ok := pager.shm.(RangeLocker); ok {
+		lock := func() error { return locker.LockRange(RangeExclusive, walReadLock, 1) }
+		var err error
+		if wait {
+			err = pager.retryBusy(lock)
+		} else {
+			err = lock()
+		}
+		if err != nil {
+			return err
+		}
+		defer func() {
+			locker.LockRange(RangeUnlocked, walReadLock, 1)
+			if pager.readMark == 0 {
+				locker.LockRange(RangeShared, walReadLock, 1)
+			}
+		}()
+	}
+	if err := wal.file.Sync(); err != nil {
+		return err
+	}
+	//!Frame upTo commits a transaction, and its header has the database size as of then.
+	frameHeader := make([]byte, walFrameHeaderSize)
+	if _, err := wal.file.ReadAt(frameHeader, walHeaderSize+(upTo-1)*pager.frameSize()); err != nil {
+		return err
+	}
+	dbSize := int64(binary.BigEndian.Uint32(frameHeader[4:]))
+	newest := map[int64]int64{}
+	for i := wal.shm.backfilled; i < upTo; i++ {
+		if pageNo := wal.pages[i]; pageNo <= dbSize {
+			newest[pageNo] = i
+		}
+	}
+	pageNos := make([]int64, 0, len(newest))
+	for pageNo := range newest {
+		pageNos = append(pageNos, pageNo)
+	}
+	slices.Sort(pageNos)
+	data := make([]byte, pager.pageSize)
+	for _, pageNo := range pageNos {
+		offset := walHeaderSize + newest[pageNo]*pager.frameSize() + walFrameHeaderSize
+		if _, err := wal.file.ReadAt(data, offset); err != nil {
+			return err
+		}
+		if _, err := pager.file.WriteAt(data, getPageOffset(pageNo, pager.pageSize)); err != nil {
+			return err
+		}
+	}
+	if upTo == int64(len(wal.pages)) {
+		if err := pager.file.Truncate(dbSize * pager.pageSize); err != nil {
+			return err
+		}
+	}
+	if err := pager.file.Sync(); err != nil {
+		return err
+	}
+	wal.shm.backfilled = upTo
+	wal.shm.backfillAttempted = uint32(upTo)
+	if err := pager.writeShmWord(96, uint32(upTo)); err != nil {
+		return err
+	}
+	if err := pager.writeShmWord(128, uint32(upTo)); err != nil {
+		return err
+	}
+	//!The database file now has the change counter of the log.
+	counter := make([]byte, 4)
+	if _, err := pager.file.ReadAt(counter, 24); err != nil {
+		return err
+	}
+	pager.changeCounter = binary.BigEndian.Uint32(counter)
+	return nil
+}
+
+// journalMode is the journal mode of the database, as PRAGMA journal_mode names it.
+func (pager *Pager) journalMode() string {
+	if pager.header.ReadVersion == 2 {
+		return "wal"
+	}
+	return "delete"
+}
+
+// setJournalMode switches the database between the rollback journal and WAL modes by
+// rewriting the file format versions in the header. Leaving WAL mode checkpoints the whole
+// log and deletes it with the -shm file. Other modes are not supported and leave the mode
+// unchanged. It returns the mode the database is in.
+func (pager *Pager) setJournalMode(mode string) (string, error) {
+	version := byte(1)
+	switch strings.ToLower(mode) {
+	case "wal":
+		version = 2
+	case "delete":
+	default:
+		return pager.journalMode(), nil
+	}
+	if byte(pager.header.ReadVersion) == version {
+		return pager.journalMode(), nil
+	}
+	if pager.dirty != nil || pager.explicit {
+		if version == 2 {
+			return "", fmt.Errorf("cannot change into wal mode from within a transaction")
+		}
+		return "", fmt.Errorf("cannot change out of wal mode from within a transaction")
+	}
+	if version == 1 && pager.wal != nil {
+		//!Like SQLite, the log is only deleted by a connection that has the database to itself.
+		if err := pager.lockDatabase(LockExclusive); err != nil {
+			return "", err
+		}
+		busy, _, _, err := pager.checkpoint(checkpointTruncate)
+		if err == nil && busy {
+			err = ErrBusy
+		}
+		if err != nil {
+			pager.file.Unlock(LockShared)
+			return "", err
+		}
+		pager.closeShm()
+		pager.wal.file.Close()
+		pager.wal = nil
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := pager.vfs.Delete(pager.path + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+	//!The header is then changed through the rollback journal.
+	if err := pager.beginWrite(); err != nil {
+		return "", err
+	}
+	header, err := pager.writablePage(1)
+	if err != nil {
+		pager.rollback()
+		return "", err
+	}
+	header[18], header[19] = version, version
+	if err := pager.commit(); err != nil {
+		return "", err
+	}
+	return pager.journalMode(), nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// walFrame is a frame to put in a log built by walBytes: the page it holds, filled with fill,
+// and the database size it commits, 0 for none.
+type walFrame struct {
+	pageNo int64
+	fill   byte
+	commit uint32
+}
+
+// walBytes builds a log of 512-byte pages holding frames, with checksums over words in the
+// byte order the magic number chooses.
+func walBytes(magic uint32, frames []walFrame) []byte {
+	const pageSize = 512
+	be := binary.BigEndian
+	bigEndian := magic&1 == 1
+	header := make([]byte, walHeaderSize)
+	be.PutUint32(header, magic)
+	be.PutUint32(header[4:], walFormatVersion)
+	be.PutUint32(header[8:], pageSize)
+	be.PutUint32(header[16:], 0x1234)
+	be.PutUint32(header[20:], 0x5678)
+	s0, s1 := walChecksum(header[:24], bigEndian, 0, 0)
+	be.PutUint32(header[24:], s0)
+	be.PutUint32(header[28:], s1)
+	log := header
+	for _, frame := range frames {
+		raw := make([]byte, walFrameHeaderSize+pageSize)
+		be.PutUint32(raw, uint32(frame.pageNo))
+		be.PutUint32(raw[4:], frame.commit)
+		copy(raw[8:16], header[16:24])
+		for i := walFrameHeaderSize; i < len(raw); i++ {
+			raw[i] = frame.fill
+		}
+		s0, s1 = walChecksum(raw[:8], bigEndian, s0, s1)
+		s0, s1 = walChecksum(raw[walFrameHeaderSize:], bigEndian, s0, s1)
+		be.PutUint32(raw[16:], s0)
+		be.PutUint32(raw[20:], s1)
+		log = append(log, raw...)
+	}
+	return log
+}
+
+func TestReadWAL(t *testing.T) {
+	const frameSize = walFrameHeaderSize + 512
+	frames := []walFrame{{2, 'a', 0}, {3, 'b', 3}, {2, 'c', 0}, {4, 'd', 4}}
+	tests := []struct {
+		name   string
+		log    []byte
+		valid  bool
+		pages  int   //!Frames up to the last valid commit.
+		dbSize int64 //!As of that commit.
+		page2  byte  //!Fill of the newest committed version of page 2, 0 when the log has none.
+		change func([]byte) []byte
+	}{
+		{"whole", walBytes(walMagic, frames), true, 4, 4, 'c', nil},
+		{"big-endian checksums", walBytes(walMagic|1, frames), true, 4, 4, 'c', nil},
+		{"torn last frame", walBytes(walMagic, frames), true, 2, 3, 'a', func(log []byte) []byte {
+			return log[:len(log)-100]
+		}},
+		{"bad checksum", walBytes(walMagic, frames), true, 2, 3, 'a', func(log []byte) []byte {
+			log[walHeaderSize+3*frameSize-1] ^= 1 //!The last byte of frame 3, which the commit in frame 4 needs.
+			return log
+		}},
+		{"salt mismatch", walBytes(walMagic, frames), true, 2, 3, 'a', func(log []byte) []byte {
+			log[walHeaderSize+3*frameSize+8] ^= 1 //!The first salt of frame 4.
+			return log
+		}},
+		{"uncommitted tail", walBytes(walMagic, frames[:3]), true, 2, 3, 'a', nil},
+		{"bad header checksum", walBytes(walMagic, frames), false, 0, 0, 0, func(log []byte) []byte {
+			log[12] ^= 1 //!The checkpoint sequence number.
+			return log
+		}},
+		{"other page size", walBytes(walMagic, frames), false, 0, 0, 0, func(log []byte) []byte {
+			binary.BigEndian.PutUint32(log[8:], 1024)
+			return log
+		}},
+		{"short header", walBytes(walMagic, frames)[:walHeaderSize-1], false, 0, 0, 0, nil},
+	}
+	for _, test := range tests {
+		log := test.log
+		if test.change != nil {
+			log = test.change(log)
+		}
+		vfs := NewMemVFS()
+		vfs.Store("test.db-wal", log)
+		file, _ := vfs.Open("test.db-wal", OpenReadOnly)
+		wal, err := readWAL(file, 512)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if wal.valid != test.valid || len(wal.pages) != test.pages || wal.dbSize != test.dbSize {
+			t.Errorf("%s: valid %v, %d frames, size %d; want %v, %d, %d", test.name, wal.valid, len(wal.pages), wal.dbSize, test.valid, test.pages, test.dbSize)
+		}
+		page2 := byte(0)
+		if offset, ok := wal.frames[2]; ok {
+			page2 = log[offset]
+		}
+		if page2 != test.page2 {
+			t.Errorf("%s: page 2 read from a frame filled with %q, want %q", test.name, page2, test.page2)
+		}
+	}
+}
+
+func TestWALTornCommit(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "pragma journal_mode = wal", "create table t(a)", "insert into t values (1)",
+		"insert into t values (2)")
+	data, err := os.ReadFile(path + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//!A crash while the last transaction was written leaves a commit frame that fails its checksum.
+	data[len(data)-10] ^= 0xff
+	if err := os.WriteFile(path+"-wal", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(runSQL(t, path, "select group_concat(a) from t")); got != "1" {
+		t.Errorf("rows with a torn commit frame: %q, want 1", got)
+	}
+	runSQL(t, path, "insert into t values (3)", "pragma wal_checkpoint(truncate)")
+	if got := strings.TrimSpace(runSQL(t, path, "select group_concat(a) from t")); got != "1,3" {
+		t.Errorf("rows after writing over the torn frame: %q, want 1,3", got)
+	}
+	checkIntegrity(t, path)
+}
+
+// openReader starts a read on the database at path through a connection that can write, and
+// so sets a read mark for its own snapshot of the log. The returned function ends it.
+func openReader(t *testing.T, path string) func() {
+	t.Helper()
+	vfs, _ := findVFS("")
+	pager, err := openPager(vfs, path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pager.refresh(); err != nil {
+		pager.Close()
+		t.Fatal(err)
+	}
+	return func() {
+		pager.endRead()
+		pager.Close()
+	}
+}
+
+func TestCheckpointModes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string //!busy|log|checkpointed, and the same after the next write.
+		busy    string //!With another connection reading an older snapshot.
+		wait    string //!The same, with a busy timeout the reader finishes within.
+		walSize int64  //!Of the log after the checkpoint.
+	}{
+		{"passive", "0|4|4 0|1|1", "0|5|4", "0|6|5", 16512}, //!Never waits.
+		{"full", "0|4|4 0|1|1", "1|5|4", "0|6|6", 16512},
+		{"restart", "0|4|4 0|1|1", "1|5|4", "0|6|6", 16512},
+		{"truncate", "0|0|0 0|1|1", "1|5|4", "0|0|0", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			path := newDatabase(t)
+			runSQL(t, path, "pragma journal_mode = wal", "create table t(a)", "insert into t values (1)")
+			//!A complete checkpoint lets the next write start the log over.
+			out := runSQL(t, path, "insert into t values (2)", "pragma wal_checkpoint("+test.mode+")",
+				"insert into t values (3)", "pragma wal_checkpoint(passive)")
+			if got := strings.Join(strings.Fields(out), " "); got != test.want {
+				t.Errorf("checkpoints: %q, want %q", got, test.want)
+			}
+			runSQL(t, path, "pragma wal_checkpoint("+test.mode+")")
+			if info, err := os.Stat(path + "-wal"); err != nil || info.Size() != test.walSize {
+				t.Errorf("log after the checkpoint: %v, %v, want %d bytes", info, err, test.walSize)
+			}
+
+			//!Frames a reader may still need are not copied; all but PASSIVE report that as busy.
+			runSQL(t, path, "pragma wal_checkpoint(truncate)", "insert into t values (4)", "insert into t values (5)",
+				"insert into t values (6)", "insert into t values (7)")
+			done := openReader(t, path)
+			out = runSQL(t, path, "insert into t values (8)", "pragma wal_checkpoint("+test.mode+")")
+			done()
+			if got := strings.TrimSpace(out); got != test.busy {
+				t.Errorf("checkpoint under a reader: %q, want %q", got, test.busy)
+			}
+
+			//!With a busy timeout, it waits for the reader to finish.
+			time.AfterFunc(50*time.Millisecond, openReader(t, path))
+			out = runSQL(t, path, "insert into t values (9)", ".timeout 2000", "pragma wal_checkpoint("+test.mode+")")
+			if got := strings.TrimSpace(out); got != test.wait {
+				t.Errorf("checkpoint waiting for a reader: %q, want %q", got, test.wait)
+			}
+			if got := strings.TrimSpace(runSQL(t, path, "select count(*), sum(a) from t")); got != "9|45" {
+				t.Errorf("rows: %s, want 9|45", got)
+			}
+			runSQL(t, path, "pragma wal_checkpoint(truncate)")
+			checkIntegrity(t, path)
+		})
+	}
+}
+
+func TestAutoCheckpoint(t *testing.T) {
+	tests := []struct {
+		setting string
+		rows    string //!In the database file alone, without the log.
+	}{
+		{"pragma wal_autocheckpoint = 5", "8"},
+		{"pragma wal_autocheckpoint = 0", ""},
+		{"pragma wal_autocheckpoint", ""}, //!The default of 1000 frames is not reached.
+	}
+	for _, test := range tests {
+		path := newDatabase(t)
+		//!Creating the table writes 2 frames and each insert 1, so with a limit of 5 frames the
+		//!third and eighth inserts run a checkpoint.
+		statements := []string{"pragma journal_mode = wal", test.setting, "create table t(a)"}
+		for i := 1; i <= 10; i++ {
+			statements = append(statements, fmt.Sprintf("insert into t values (%d)", i))
+		}
+		runSQL(t, path, statements...)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alone := filepath.Join(t.TempDir(), "alone.db")
+		if err := os.WriteFile(alone, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		captureStderr(t, func() { out, _ = runShell(alone, "", "select count(*) from t") })
+		if got := strings.TrimSpace(out); got != test.rows {
+			t.Errorf("%s: database file holds %q rows, want %q", test.setting, got, test.rows)
+		}
+		if got := strings.TrimSpace(runSQL(t, path, "select count(*) from t")); got != "10" {
+			t.Errorf("%s: %s rows with the log, want 10", test.setting, got)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// writableTable looks up the table an INSERT, UPDATE or DELETE changes.
+func writableTable(schema *Schema, name string) (*Table, error) {
+	table := schema.table(name)
+	if table == nil {
+		if strings.EqualFold(name, "sqlite_schema") || strings.EqualFold(name, "sqlite_master") {
+			return nil, &ErrPrepare{Msg: fmt.Sprintf("table %s may not be modified", name)}
+		}
+		return nil, &ErrNoSuchTable{Name: name}
+	}
+	if table.Def.WithoutRowid {
+		return nil, fmt.Errorf("writing to a WITHOUT ROWID table is not supported")
+	}
+	for _, column := range table.Def.Columns {
+		if column.Generated != nil {
+			return nil, fmt.Errorf("writing to a table with generated columns is not supported")
+		}
+	}
+	return table, nil
+}
+
+// tableWriter writes rows of one table and the entries of its indexes in an open write
+// transaction.
+type tableWriter struct {
+	pager     *Pager
+	schema    *Schema
+	table     *Table
+	sequence  int64           //!Largest rowid given to a row of an AUTOINCREMENT table, to record in sqlite_sequence.
+	dropping  bool            //!The rows are deleted by DROP TABLE, which skips foreign keys it cannot check.
+	upserts   []upsertClause  //!The ON CONFLICT clauses of an INSERT.
+	returning []outputColumn  //!The RETURNING clause of the statement, nil when it has none.
+	returned  [][]interface{} //!The rows the RETURNING clause produced so far.
+}
+
+// insert writes a row, choosing its rowid when rowid is nil, once it satisfies the constraints
+// of the table. orAction is the conflict resolution of the statement, "" when it has none. A
+// conflict caught by an upsert clause is resolved by the clause instead.
+func (writer *tableWriter) insert(row []interface{}, rowid interface{}, orAction string) error {
+	table := writer.table
+	id, ok := rowid.(int64)
+	if !ok {
+		var err error
+		if id, err = writer.nextRowid(); err != nil {
+			return err
+		}
+	}
+	if table.RowidAlias >= 0 {
+		row[table.RowidAlias] = id
+	}
+	//!Like SQLite, the rowid counts for AUTOINCREMENT even when the row is not written.
+	if table.Autoincrement && id > writer.sequence {
+		writer.sequence = id
+	}
+	if skip, err := writer.checkRow(row, id, orAction); err != nil || skip {
+		return err
+	}
+	clause, other, found, err := writer.upsertConflict(row, id)
+	if err != nil {
+		return err
+	}
+	if found {
+		return writer.upsert(clause, other, row, id)
+	}
+	if skip, err := writer.claimKeys(row, id, nil, orAction); err != nil || skip {
+		return err
+	}
+	if err := writer.foreignKeysBefore(nil, row, id, nil); err != nil {
+		return err
+	}
+	if err := writer.writeRow(row, id); err != nil {
+		return err
+	}
+	if err := writer.foreignKeysAfter(nil, row, id, nil); err != nil {
+		return err
+	}
+	return writer.returnRow(row, id)
+}
+
+// violation builds the error for a constraint violation resolved with action.
+func violation(action string, format string, args ...interface{}) error {
+	return &ErrConstraint{Err: &ErrResult{Code: resultConstraint, Msg: fmt.Sprintf(format, args...)}, Action: action}
+}
+
+// conflictAction resolves what happens when a constraint is violated: the OR clause of the
+// statement wins over the ON CONFLICT clause of the constraint, and ABORT is the default.
+func conflictAction(orAction string, constraintAction string) string {
+	switch {
+	case orAction != "":
+		return orAction
+	case constraintAction != "":
+		return constraintAction
+	}
+	return "ABORT"
+}
+
+// checkRow enforces the NOT NULL constraints, the column types of a STRICT table and the CHECK
+// constraints on a row about to be written under rowid, in that order like SQLite. A NULL in a
+// NOT NULL column resolved with REPLACE becomes the column default. skip is true when the row
+// is to be left out under IGNORE.
+func (writer *tableWriter) checkRow(row []interface{}, rowid int64, orAction string) (skip bool, err error) {
+	table := writer.table
+	for i, column := range table.Columns {
+		if !column.NotNull || row[i] != nil || i == table.RowidAlias {
+			continue
+		}
+		action := conflictAction(orAction, column.NotNullAction)
+		if action == "REPLACE" {
+			if row[i], err = columnDefault(column); err != nil {
+				return false, err
+			}
+			if row[i] = applyAffinity(row[i], column.Affinity); row[i] != nil {
+				continue
+			}
+			//!Without a default to use, REPLACE acts as ABORT.
+			action = "ABORT"
+		}
+		if action == "IGNORE" {
+			return true, nil
+		}
+		return false, violation(action, "NOT NULL constraint failed: %s.%s", table.Name, column.Name)
+	}
+	if table.Strict {
+		for i, column := range table.Columns {
+			if stored := strictType(row[i]); !strictAllows(column.Type, stored) {
+				return false, violation("ABORT", "cannot store %s value in %s column %s.%s", stored, strings.ToUpper(column.Type), table.Name, column.Name)
+			}
+		}
+	}
+	ctx := &evalContext{table: table, values: row, rowid: rowid, encoding: writer.pager.encoding}
+	for _, check := range table.Checks {
+		if err := bindColumns(check.Expr, table, ""); err != nil {
+			return false, err
+		}
+		value, err := evalExpr(check.Expr, ctx)
+		if err != nil {
+			return false, err
+		}
+		if ok, notNull := truth(value); !notNull || ok {
+			continue
+		}
+		switch action := conflictAction(orAction, ""); action {
+		case "IGNORE":
+			return true, nil
+		case "REPLACE":
+			return false, violation("ABORT", "CHECK constraint failed: %s", check.Name)
+		default:
+			return false, violation(action, "CHECK constraint failed: %s", check.Name)
+		}
+	}
+	return false, nil
+}
+
+// strictType names the storage class of a value the way STRICT type errors do.
+func strictType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return "INT"
+	case float64:
+		return "REAL"
+	case string:
+		return "TEXT"
+	}
+	return "BLOB"
+}
+
+// strictAllows reports whether a STRICT column of the declared type may hold a value of the
+// storage class stored. Every column may hold NULL; NOT NULL is enforced separately.
+func strictAllows(declaredType string, stored string) bool {
+	switch strings.ToUpper(declaredType) {
+	case "INT", "INTEGER":
+		return stored == "INT" || stored == "NULL"
+	case "REAL", "TEXT", "BLOB":
+		return stored == strings.ToUpper(declaredType) || stored == "NULL"
+	}
+	return true
+}
+
+// claimKeys makes way for a row about to be written under rowid by resolving its conflicts
+// with other rows over the rowid and the UNIQUE indexes of the table. self is the rowid the
+// row had before an UPDATE, nil for an INSERT. Like SQLite, every conflict is resolved before
+// REPLACE deletes any row, so a row that fails or is ignored deletes nothing. skip is true
+// when the row is to be left out under IGNORE.
+func (writer *tableWriter) claimKeys(row []interface{}, rowid int64, self interface{}, orAction string) (skip bool, err error) {
+	table := writer.table
+	var replaced []int64
+	if self != rowid {
+		_, found, err := writer.conflictOver(nil, row, rowid)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			column := "rowid"
+			if table.RowidAlias >= 0 {
+				column = table.Columns[table.RowidAlias].Name
+			}
+			switch action := conflictAction(orAction, table.RowidAction); action {
+			case "IGNORE":
+				return true, nil
+			case "REPLACE":
+				replaced = append(replaced, rowid)
+			default:
+				return false, violation(action, "UNIQUE constraint failed: %s.%s", table.Name, column)
+			}
+		}
+	}
+	//!SQLite checks the newest index first, which decides the one named when a row violates several.
+	indexes := writer.schema.indexesOn(table.Name)
+	for i := len(indexes) - 1; i >= 0; i-- {
+		index := indexes[i]
+		if !index.Unique {
+			continue
+		}
+		other, found, err := writer.conflictOver(index, row, rowid)
+		if err != nil {
+			return false, err
+		}
+		if !found || other == self {
+			continue
+		}
+		switch action := conflictAction(orAction, index.Action); action {
+		case "IGNORE":
+			return true, nil
+		case "REPLACE":
+			if !slices.Contains(replaced, other) {
+				replaced = append(replaced, other)
+			}
+		default:
+			return false, &ErrConstraint{Err: writer.uniqueError(index), Action: action}
+		}
+	}
+	for _, other := range replaced {
+		existing, found, err := writer.readRow(other)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			if err := writer.delete(existing, other); err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// conflictOver finds the row a row about to be written under rowid conflicts with over a
+// UNIQUE index, or over the rowid when index is nil.
+func (writer *tableWriter) conflictOver(index *Index, row []interface{}, rowid int64) (other int64, found bool, err error) {
+	if index == nil {
+		found, err := btreeFind(writer.pager, writer.table.RootPage, rowidComparer(rowid))
+		return rowid, found, err
+	}
+	entry, ok, err := writer.indexEntry(index, row, rowid)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return writer.conflictingRow(index, entry)
+}
+
+// conflictingRow finds the row whose entry in a UNIQUE index has the key of entry. Keys
+// holding a NULL never conflict.
+func (writer *tableWriter) conflictingRow(index *Index, entry []Value) (rowid int64, found bool, err error) {
+	key := entry[:len(entry)-1]
+	if hasNull(key) {
+		return 0, false, nil
+	}
+	record, err := btreeLookup(writer.pager, index.RootPage, indexPrefixComparer(writer.pager, key, writer.indexOrder(index)))
+	if err != nil || record == nil {
+		return 0, false, err
+	}
+	return record[len(record)-1].Int, true, nil
+}
+
+// readRow reads the row with the given rowid, normalized like scanTable returns it. Blobs are
+// copied, as they would otherwise share a page the writes that follow may change.
+func (writer *tableWriter) readRow(rowid int64) ([]interface{}, bool, error) {
+	rowids, records, err := readTable(writer.pager, writer.table.RootPage, map[int64]int64{rowid: rowid})
+	if err != nil || len(records) == 0 {
+		return nil, false, err
+	}
+	row := tableRow(writer.table, rowids[0], records[0])
+	for i, v := range row {
+		if blob, ok := v.([]byte); ok {
+			row[i] = bytes.Clone(blob)
+		}
+	}
+	return row, true, nil
+}
+
+// writeRow stores a new row under rowid and adds its index entries.
+func (writer *tableWriter) writeRow(row []interface{}, rowid int64) error {
+	if err := writer.writeCell(row, rowid); err != nil {
+		return err
+	}
+	for _, index := range writer.schema.indexesOn(writer.table.Name) {
+		if err := writer.insertIndexEntry(index, row, rowid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCell stores a row in the table b-tree, replacing the cell of a row with the same rowid.
+func (writer *tableWriter) writeCell(row []interface{}, rowid int64) error {
+	pager, table := writer.pager, writer.table
+	values := make([]Value, len(row))
+	for i, v := range row {
+		//!The rowid is not stored again in its alias column.
+		if i != table.RowidAlias {
+			values[i] = valueOf(v)
+		}
+	}
+	cell, err := tableLeafCell(pager, rowid, EncodeRecord(values, pager.encoding, pager.header.SchemaFormat))
+	if err != nil {
+		return err
+	}
+	return btreeInsert(pager, table.RootPage, cell, rowidComparer(rowid))
+}
+
+// delete removes a row, doing the foreign key work its removal involves.
+func (writer *tableWriter) delete(row []interface{}, rowid int64) error {
+	if err := writer.foreignKeysBefore(row, nil, rowid, nil); err != nil {
+		return err
+	}
+	if err := writer.deleteRow(row, rowid); err != nil {
+		return err
+	}
+	return writer.foreignKeysAfter(row, nil, rowid, nil)
+}
+
+// deleteRow removes a row and its index entries.
+func (writer *tableWriter) deleteRow(row []interface{}, rowid int64) error {
+	for _, index := range writer.schema.indexesOn(writer.table.Name) {
+		if err := writer.deleteIndexEntry(index, row, rowid); err != nil {
+			return err
+		}
+	}
+	found, err := btreeDelete(writer.pager, writer.table.RootPage, rowidComparer(rowid))
+	if err == nil && !found {
+		err = corruptError(writer.table.RootPage, 0, "row %d of %s is missing", rowid, writer.table.Name)
+	}
+	return err
+}
+
+// maxRowidTries is how many random rowids nextRowid tries, like SQLite, once the largest
+// rowid is taken.
+const maxRowidTries = 100
+
+// nextRowid chooses the rowid of a row inserted without one: one more than the largest rowid
+// in the table, or for AUTOINCREMENT tables ever used in it. Once the largest possible rowid is
+// taken, an AUTOINCREMENT table is full but others look for an unused rowid at random.
+func (writer *tableWriter) nextRowid() (int64, error) {
+	largest, err := maxRowid(writer.pager, writer.table.RootPage)
+	if err != nil {
+		return 0, err
+	}
+	if writer.table.Autoincrement {
+		_, seq, err := writer.sequenceRow()
+		if err != nil {
+			return 0, err
+		}
+		largest = max(largest, seq, writer.sequence)
+	}
+	if largest < math.MaxInt64 {
+		return largest + 1, nil
+	}
+	if !writer.table.Autoincrement {
+		for try := 0; try < maxRowidTries; try++ {
+			rowid := rand.Int64N(math.MaxInt64) + 1
+			found, err := btreeFind(writer.pager, writer.table.RootPage, rowidComparer(rowid))
+			if err != nil {
+				return 0, err
+			}
+			if !found {
+				return rowid, nil
+			}
+		}
+	}
+	return 0, &ErrResult{Code: resultFull, Msg: "database or disk is full"}
+}
+
+// indexEntry builds the record of a row's entry in an index: the key columns, then the
+// rowid. ok is false when the row is left out of a partial index.
+func (writer *tableWriter) indexEntry(index *Index, row []interface{}, rowid int64) (entry []Value, ok bool, err error) {
+	table := writer.table
+	ctx := &evalContext{table: table, values: row, rowid: rowid, encoding: writer.pager.encoding}
+	if table.RowidAlias >= 0 {
+		ctx.values = append([]interface{}(nil), row...)
+		ctx.values[table.RowidAlias] = rowid
+	}
+	if index.Where != nil {
+		if err := bindColumns(index.Where, table, ""); err != nil {
+			return nil, false, err
+		}
+		value, err := evalExpr(index.Where, ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok, _ := truth(value); !ok {
+			return nil, false, nil
+		}
+	}
+	for i, key := range index.Keys {
+		expr := key.Expr
+		if expr == nil {
+			if i >= len(index.Columns) {
+				return nil, false, fmt.Errorf("index %s has an unknown key", index.Name)
+			}
+			expr = &ColumnExpr{Name: index.Columns[i]}
+		}
+		if err := bindColumns(expr, table, ""); err != nil {
+			return nil, false, err
+		}
+		value, err := evalExpr(expr, ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		entry = append(entry, valueOf(value))
+	}
+	if index.Keys == nil {
+		return nil, false, fmt.Errorf("index %s has an unknown key", index.Name)
+	}
+	return append(entry, Value{Type: ValueInteger, Int: rowid}), true, nil
+}
+
+// indexOrder returns how each key column of an index sorts.
+func (writer *tableWriter) indexOrder(index *Index) []indexKeyOrder {
+	order := make([]indexKeyOrder, len(index.Keys))
+	for i, key := range index.Keys {
+		order[i] = indexKeyOrder{collation: key.Collate, desc: key.Desc}
+		if key.Collate == "" && key.Expr == nil {
+			if column := writer.table.columnIndex(key.Name); column >= 0 {
+				order[i].collation = writer.table.Columns[column].Collate
+			}
+		}
+	}
+	return order
+}
+
+// insertIndexEntry adds a row's entry to an index.
+func (writer *tableWriter) insertIndexEntry(index *Index, row []interface{}, rowid int64) error {
+	entry, ok, err := writer.indexEntry(index, row, rowid)
+	if err != nil || !ok {
+		return err
+	}
+	cell, err := indexCell(writer.pager, EncodeRecord(entry, writer.pager.encoding, writer.pager.header.SchemaFormat))
+	if err != nil {
+		return err
+	}
+	return btreeInsert(writer.pager, index.RootPage, cell, indexComparer(writer.pager, entry, writer.indexOrder(index)))
+}
+
+// deleteIndexEntry removes a row's entry from an index.
+func (writer *tableWriter) deleteIndexEntry(index *Index, row []interface{}, rowid int64) error {
+	entry, ok, err := writer.indexEntry(index, row, rowid)
+	if err != nil || !ok {
+		return err
+	}
+	found, err := btreeDelete(writer.pager, index.RootPage, indexComparer(writer.pager, entry, writer.indexOrder(index)))
+	if err == nil && !found {
+		err = corruptError(index.RootPage, 0, "row %d missing from index %s", rowid, index.Name)
+	}
+	return err
+}
+
+// sequenceRow finds the table's row in sqlite_sequence. rowid is 0 when there is none.
+func (writer *tableWriter) sequenceRow() (rowid int64, seq int64, err error) {
+	sequence := writer.schema.table("sqlite_sequence")
+	if sequence == nil {
+		return 0, 0, corruptError(0, 0, "AUTOINCREMENT table %s without sqlite_sequence", writer.table.Name)
+	}
+	rowids, rows, err := readTable(writer.pager, sequence.RootPage, map[int64]int64{})
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, row := range rows {
+		if len(row) >= 2 && row[0].Type == ValueText && strings.EqualFold(row[0].Text, writer.table.Name) {
+			return rowids[i], row[1].Int, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// fail ends the statement after err. A constraint violation resolved with FAIL keeps the
+// changes made before it and one resolved with ROLLBACK undoes the whole transaction; every
+// other error undoes the statement.
+func (writer *tableWriter) fail(err error) error {
+	var violation *ErrConstraint
+	action := "ABORT"
+	if errors.As(err, &violation) {
+		action = violation.Action
+	}
+	switch action {
+	case "FAIL":
+		//!Like SQLite, sqlite_sequence is left as it was.
+		writer.sequence = 0
+		if commitErr := writer.finish(); commitErr != nil {
+			return commitErr
+		}
+	case "ROLLBACK":
+		writer.pager.rollbackTransaction()
+	default:
+		writer.pager.rollback()
+	}
+	return err
+}
+
+// finish records the largest rowid of an AUTOINCREMENT table in sqlite_sequence and commits.
+// A statement leaving foreign key violations behind is undone instead.
+func (writer *tableWriter) finish() error {
+	if err := writer.pager.foreignKeyError(); err != nil {
+		writer.pager.rollback()
+		return err
+	}
+	if writer.sequence > 0 {
+		if err := writer.updateSequence(); err != nil {
+			writer.pager.rollback()
+			return err
+		}
+	}
+	return writer.pager.commit()
+}
+
+func (writer *tableWriter) updateSequence() error {
+	rowid, seq, err := writer.sequenceRow()
+	if err != nil || (rowid != 0 && seq >= writer.sequence) {
+		return err
+	}
+	sequence := writer.schema.table("sqlite_sequence")
+	if rowid == 0 {
+		if rowid, err = maxRowid(writer.pager, sequence.RootPage); err != nil {
+			return err
+		}
+		rowid++
+	}
+	record := EncodeRecord([]Value{{Type: ValueText, Text: writer.table.Name}, {Type: ValueInteger, Int: writer.sequence}}, writer.pager.encoding, writer.pager.header.SchemaFormat)
+	cell, err := tableLeafCell(writer.pager, rowid, record)
+	if err != nil {
+		return err
+	}
+	return btreeInsert(writer.pager, sequence.RootPage, cell, rowidComparer(rowid))
+}
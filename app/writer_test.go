@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNextRowidAfterLargest(t *testing.T) {
+	path := newDatabase(t)
+	runSQL(t, path, "create table t(a)", "create index ta on t(a)",
+		"insert into t(rowid, a) values (9223372036854775807, 'max')")
+	for i := 0; i < 20; i++ {
+		runSQL(t, path, "insert into t values (1), (2)")
+	}
+	if got := strings.TrimSpace(runSQL(t, path, "select count(*), count(distinct rowid), min(rowid) > 0 from t")); got != "41|41|1" {
+		t.Errorf("rows with random rowids: %s, want 41|41|1", got)
+	}
+	checkIntegrity(t, path)
+
+	runSQL(t, path, "create table u(a integer primary key autoincrement, b)", "insert into u values (9223372036854775807, 1)")
+	if _, code := runShell(path, "", "insert into u(b) values (2)"); code != resultFull {
+		t.Errorf("AUTOINCREMENT insert after the largest rowid: exit code %d, want %d", code, resultFull)
+	}
+}